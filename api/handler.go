@@ -2,84 +2,76 @@ package handler
 
 import (
 	"backend/auth"
+	"backend/config"
 	"backend/db"
+	"backend/logging"
+	"backend/router"
+	"backend/tracing"
+	"context"
+	"log/slog"
 	"net/http"
-	"time"
+	"sync"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 )
 
-// Handler exports the function for Vercel
-func Handler(w http.ResponseWriter, r *http.Request) {
-	// Initialize database connection if not already done
-	if db.DB == nil {
-		db.Initialize()
-	}
-
-	// Set up Gin in release mode
-	gin.SetMode(gin.ReleaseMode)
-	router := gin.New() // Use New() instead of Default() to avoid unnecessary middleware
-
-	// CORS middleware
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"https://student-hub-frontend.vercel.app", "http://localhost:3000"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
-		AllowCredentials: true,
-		MaxAge:           30 * 24 * time.Hour,
-	}))
+var (
+	setupMu sync.Mutex
+	engine  *gin.Engine
+)
 
-	// Routes
-	setupRoutes(router)
+// setup loads the configuration and builds the router. It runs once per
+// function instance, and again on the next request if it failed.
+func setup() (*gin.Engine, error) {
+	setupMu.Lock()
+	defer setupMu.Unlock()
 
-	// Serve the request
-	router.ServeHTTP(w, r)
-}
+	if engine != nil {
+		return engine, nil
+	}
 
-// setupRoutes configures all the routes
-func setupRoutes(router *gin.Engine) {
-	// Check route
-	router.GET("/api", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"message": "API is running"})
-	})
+	cfg, err := config.Load("")
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(config.RequireDatabase, config.RequireAuth); err != nil {
+		return nil, err
+	}
 
-	// Authentication Routes
-	router.POST("/api/users", db.CreateUser)
-	router.GET("/api/users", auth.AuthMiddleware(), db.ListUsers)
-	router.GET("/api/users/:id", auth.AuthMiddleware(), db.GetUser)
-	router.DELETE("/api/users/:id", auth.AuthMiddleware(), db.DeleteUser)
-	router.GET("/api/users/:id/posts", auth.AuthMiddleware(), db.GetUserPost)
-	router.PUT("/api/users/:id", auth.AuthMiddleware(), db.UpdateUser)
+	logging.New(cfg.Log)
 
-	// Auth routes
-	router.POST("/api/login", db.Login)
-	router.POST("/api/logout", db.Logout)
-	router.POST("/api/auth/sync", db.SyncToken)
+	// Spans are batched, so a frozen function instance may drop its last few
+	if _, err := tracing.Setup(context.Background(), cfg.Tracing); err != nil {
+		return nil, err
+	}
 
-	// Post routes
-	router.POST("/api/posts", auth.AuthMiddleware(), db.CreatePost)
-	router.GET("/api/posts/:id", db.GetPost)
-	router.GET("/api/posts/category/:category/:pageIndex", auth.AuthMiddleware(), db.ListPostsByCategory)
-	router.PUT("/api/posts/:id", auth.AuthMiddleware(), db.UpdatePost)
-	router.DELETE("/api/posts/:id", auth.AuthMiddleware(), db.DeletePost)
+	// Routes always live under /api on Vercel, and every request comes
+	// through its edge, which sets the client IP in its own header
+	cfg.Server.Prefix = "/api"
+	if cfg.Server.TrustedPlatform == "" && len(cfg.Server.TrustedProxies) == 0 {
+		cfg.Server.TrustedPlatform = "X-Vercel-Forwarded-For"
+	}
 
-	// Tag routes
-	router.GET("/api/tags", db.ListTags)
-	router.GET("/api/tags/:id", db.GetTag)
-	router.GET("/api/posts/:id/tags", auth.AuthMiddleware(), db.ListPostTags)
-	router.POST("/api/posts/:id/tags", auth.AuthMiddleware(), db.CreatePostTag)
-	router.DELETE("/api/posts/:id/tags/:tag_id", auth.AuthMiddleware(), db.DeletePostTag)
+	auth.Configure(cfg.Auth)
+	if err := db.Initialize(cfg); err != nil {
+		return nil, err
+	}
 
-	// Comment routes
-	router.GET("/api/posts/:id/comments", auth.AuthMiddleware(), db.ListPostComments)
-	router.POST("/api/posts/:id/comments", auth.AuthMiddleware(), db.CreateComment)
+	// Set up Gin in release mode
+	gin.SetMode(gin.ReleaseMode)
+	engine = router.New(cfg)
+	return engine, nil
+}
 
-	// Category routes
-	router.GET("/api/categories", db.ListCategories)
-	router.GET("/api/categories/:id", db.GetCategory)
+// Handler exports the function for Vercel
+func Handler(w http.ResponseWriter, r *http.Request) {
+	engine, err := setup()
+	if err != nil {
+		slog.Error("setup failed", "error", err)
+		http.Error(w, "Service unavailable", http.StatusServiceUnavailable)
+		return
+	}
 
-	// Image routes
-	router.POST("/api/cloudinary/upload", db.UploadHandler)
-	router.DELETE("/api/cloudinary/upload/:username", db.DeleteImageHandler)
+	// Serve the request
+	engine.ServeHTTP(w, r)
 }
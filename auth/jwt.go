@@ -1,40 +1,40 @@
 package auth
 
 import (
+	"backend/config"
+	"backend/problem"
 	"fmt"
-	"net/http"
-	"os"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/joho/godotenv"
+	"github.com/google/uuid"
 )
 
-var secretKey string
+// settings holds the configuration passed to Configure
+var settings config.AuthConfig
 
-func init() {
-	err := godotenv.Load()
-	if err != nil {
-		fmt.Println("Warning: No .env file found")
-	}
+// Configure sets the signing secret, token lifetime and cookie options.
+// It must be called before any token is created or verified.
+func Configure(cfg config.AuthConfig) {
+	settings = cfg
+}
 
-	secretKey = os.Getenv("JWT_SECRET")
-	if secretKey == "" {
-		panic("JWT_SECRET is not set")
+// CreateToken generates a JWT token for the user with the given ID that
+// expires after the configured TTL. The subject is the ID rather than the
+// username, since usernames can change hands.
+func CreateToken(userID uuid.UUID) (string, error) {
+	if settings.JWTSecret == "" {
+		return "", fmt.Errorf("auth is not configured")
 	}
-}
 
-// CreateToken generates a JWT token with a longer expiration time
-func CreateToken(username string) (string, error) {
-	// Create token with 30-day expiration
 	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub": username,
-		"exp": time.Now().Add(time.Hour * 24 * 30).Unix(), // 30 days
+		"sub": userID.String(),
+		"exp": time.Now().Add(settings.TokenTTL).Unix(),
 		"iat": time.Now().Unix(),
 	})
 
-	tokenString, err := claims.SignedString([]byte(secretKey))
+	tokenString, err := claims.SignedString([]byte(settings.JWTSecret))
 	if err != nil {
 		return "", err
 	}
@@ -44,11 +44,15 @@ func CreateToken(username string) (string, error) {
 
 // verifyToken verifies and parses a JWT token
 func VerifyToken(tokenString string) (*jwt.Token, error) {
+	if settings.JWTSecret == "" {
+		return nil, fmt.Errorf("auth is not configured")
+	}
+
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(secretKey), nil
+		return []byte(settings.JWTSecret), nil
 	})
 
 	if err != nil {
@@ -62,41 +66,85 @@ func VerifyToken(tokenString string) (*jwt.Token, error) {
 	return token, nil
 }
 
+// SetTokenCookie stores the token in the session cookie for the token lifetime
+func SetTokenCookie(c *gin.Context, tokenString string) {
+	c.SetCookie(
+		"token",                            // name
+		tokenString,                        // value
+		int(settings.TokenTTL/time.Second), // maxAge
+		"/",                                // path
+		settings.CookieDomain,              // domain (empty = current domain)
+		settings.CookieSecure,              // secure
+		true,                               // httpOnly
+	)
+}
+
+// ClearTokenCookie removes the session cookie
+func ClearTokenCookie(c *gin.Context) {
+	c.SetCookie("token", "", -1, "/", settings.CookieDomain, settings.CookieSecure, true)
+}
+
 // AuthMiddleware verifies the JWT token from cookies
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// First try to get token from cookie
-		tokenString, err := c.Cookie("token")
-		if err != nil {
-			// If no cookie, try Authorization header
-			authHeader := c.GetHeader("Authorization")
-			if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
-				tokenString = authHeader[7:]
-			} else {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "No valid authentication token found"})
-				c.Abort()
-				return
-			}
+		tokenString, ok := requestToken(c)
+		if !ok {
+			problem.Respond(c, problem.Unauthenticated)
+			return
 		}
+		authenticate(c, tokenString)
+	}
+}
 
-		// Verify the token
-		token, err := VerifyToken(tokenString)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-			c.Abort()
+// OptionalAuthMiddleware lets requests without a token through signed out.
+// A token that is sent must still be valid.
+func OptionalAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString, ok := requestToken(c)
+		if !ok {
+			c.Next()
 			return
 		}
+		authenticate(c, tokenString)
+	}
+}
+
+// requestToken reads the token from the cookie, or failing that the
+// Authorization header
+func requestToken(c *gin.Context) (string, bool) {
+	if tokenString, err := c.Cookie("token"); err == nil {
+		return tokenString, true
+	}
+	authHeader := c.GetHeader("Authorization")
+	if len(authHeader) > 7 && authHeader[:7] == "Bearer " {
+		return authHeader[7:], true
+	}
+	return "", false
+}
+
+// authenticate verifies tokenString and stores its user ID for the
+// handlers, or responds 401
+func authenticate(c *gin.Context, tokenString string) {
+	token, err := VerifyToken(tokenString)
+	if err != nil {
+		problem.Respond(c, problem.InvalidToken.Wrap(err))
+		return
+	}
 
-		// Extract and validate claims
-		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-			if username, ok := claims["sub"].(string); ok {
-				c.Set("username", username)
-				c.Next()
+	// Extract and validate claims
+	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
+		if sub, ok := claims["sub"].(string); ok {
+			// Tokens issued before subjects were user IDs named a username
+			id, err := uuid.Parse(sub)
+			if err != nil {
+				problem.Respond(c, problem.InvalidToken.WithDetail("Token is from an older version, sign in again"))
 				return
 			}
+			c.Set("user_id", id.String())
+			c.Next()
+			return
 		}
-
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-		c.Abort()
 	}
+
+	problem.Respond(c, problem.InvalidToken.WithDetail("Invalid token claims"))
 }
@@ -0,0 +1,61 @@
+// Package broker fans messages out to subscribers by topic. Messages are
+// hints that something changed, not the change itself: a subscriber that
+// falls behind may miss some, so it should re-read whatever it watches
+// rather than rely on every message arriving.
+package broker
+
+import (
+	"backend/config"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Broker delivers messages published on a topic to that topic's
+// subscribers. Memory is the default; a shared broker reaches subscribers
+// on every instance.
+type Broker interface {
+	Publish(ctx context.Context, topic, message string) error
+	Subscribe(topic string) *Subscription
+	Close() error
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]func(cfg *config.Config) (Broker, error){
+		"memory": func(*config.Config) (Broker, error) { return NewMemory(), nil },
+		"postgres": func(cfg *config.Config) (Broker, error) {
+			return NewPostgres(context.Background(), cfg.Database.URL)
+		},
+	}
+)
+
+// Register makes a broker available under name for STREAM_BROKER
+func Register(name string, open func(cfg *config.Config) (Broker, error)) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[name] = open
+}
+
+// Open creates the broker named by cfg.Stream.Broker
+func Open(cfg *config.Config) (Broker, error) {
+	backendsMu.RLock()
+	open, ok := backends[cfg.Stream.Broker]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown stream broker %q (have %v)", cfg.Stream.Broker, backendNames())
+	}
+	return open(cfg)
+}
+
+func backendNames() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
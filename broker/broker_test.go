@@ -0,0 +1,73 @@
+package broker
+
+import (
+	"backend/config"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestMemoryDeliversByTopic(t *testing.T) {
+	m := NewMemory()
+	defer m.Close()
+	ctx := context.Background()
+
+	a1, a2, b := m.Subscribe("a"), m.Subscribe("a"), m.Subscribe("b")
+	m.Publish(ctx, "a", "hello")
+
+	for i, sub := range []*Subscription{a1, a2} {
+		select {
+		case got := <-sub.C:
+			if got != "hello" {
+				t.Errorf("subscriber %d got %q", i, got)
+			}
+		default:
+			t.Errorf("subscriber %d got nothing", i)
+		}
+	}
+	select {
+	case got := <-b.C:
+		t.Errorf("subscriber of another topic got %q", got)
+	default:
+	}
+
+	// Closing stops delivery and closes the channel; closing again is harmless
+	a1.Close()
+	a1.Close()
+	m.Publish(ctx, "a", "again")
+	if _, ok := <-a1.C; ok {
+		t.Error("closed subscription still receives")
+	}
+	if got := <-a2.C; got != "again" {
+		t.Errorf("remaining subscriber got %q", got)
+	}
+}
+
+func TestMemoryNeverBlocksOnSlowSubscribers(t *testing.T) {
+	m := NewMemory()
+	sub := m.Subscribe("a")
+
+	// Publishing past the buffer drops the newest messages instead of waiting
+	for i := 0; i < 2*subscriptionBuffer; i++ {
+		m.Publish(context.Background(), "a", "poke")
+	}
+	if got := len(sub.C); got != subscriptionBuffer {
+		t.Errorf("buffered %d messages, want %d", got, subscriptionBuffer)
+	}
+
+	// Closing the broker ends its subscriptions, and later ones start closed
+	m.Close()
+	for range sub.C {
+	}
+	if _, ok := <-m.Subscribe("a").C; ok {
+		t.Error("subscription to a closed broker is open")
+	}
+}
+
+func TestOpenUnknownBroker(t *testing.T) {
+	cfg := config.Default()
+	cfg.Stream.Broker = "carrier-pigeon"
+	if _, err := Open(cfg); err == nil || !strings.Contains(err.Error(), "memory") {
+		t.Errorf("Open = %v, want an error listing the known brokers", err)
+	}
+}
@@ -0,0 +1,106 @@
+package broker
+
+import (
+	"context"
+	"sync"
+)
+
+// subscriptionBuffer is how many messages a subscriber may fall behind by
+// before newer ones are dropped for it
+const subscriptionBuffer = 16
+
+// Memory delivers messages to subscribers in this process only
+type Memory struct {
+	mu     sync.Mutex
+	topics map[string]map[*Subscription]struct{}
+	closed bool
+}
+
+// NewMemory creates a broker with no subscribers
+func NewMemory() *Memory {
+	return &Memory{topics: map[string]map[*Subscription]struct{}{}}
+}
+
+// Subscription receives the messages published on one topic on C until
+// it or its broker is closed, which closes C
+type Subscription struct {
+	C <-chan string
+
+	c      chan string
+	topic  string
+	broker *Memory
+}
+
+// Close stops the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	if _, ok := s.broker.topics[s.topic][s]; !ok {
+		return
+	}
+	delete(s.broker.topics[s.topic], s)
+	if len(s.broker.topics[s.topic]) == 0 {
+		delete(s.broker.topics, s.topic)
+	}
+	close(s.c)
+}
+
+func (m *Memory) Publish(_ context.Context, topic, message string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for sub := range m.topics[topic] {
+		send(sub, message)
+	}
+	return nil
+}
+
+// publishAll sends message to every subscriber of every topic
+func (m *Memory) publishAll(message string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, subs := range m.topics {
+		for sub := range subs {
+			send(sub, message)
+		}
+	}
+}
+
+// send never blocks: a subscriber with a full buffer already has messages
+// waiting, so it will look again anyway
+func send(sub *Subscription, message string) {
+	select {
+	case sub.c <- message:
+	default:
+	}
+}
+
+func (m *Memory) Subscribe(topic string) *Subscription {
+	c := make(chan string, subscriptionBuffer)
+	sub := &Subscription{C: c, c: c, topic: topic, broker: m}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		close(c)
+		return sub
+	}
+	if m.topics[topic] == nil {
+		m.topics[topic] = map[*Subscription]struct{}{}
+	}
+	m.topics[topic][sub] = struct{}{}
+	return sub
+}
+
+// Close ends every subscription
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, subs := range m.topics {
+		for sub := range subs {
+			close(sub.c)
+		}
+	}
+	m.topics = map[string]map[*Subscription]struct{}{}
+	m.closed = true
+	return nil
+}
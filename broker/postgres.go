@@ -0,0 +1,133 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// channel is the Postgres notification channel every topic shares
+const channel = "studenthub_broker"
+
+// Postgres delivers messages to subscribers on every instance connected to
+// the same database, with LISTEN/NOTIFY. Each instance holds one
+// connection listening on channel and hands what arrives to its own
+// subscribers.
+type Postgres struct {
+	pool   *pgxpool.Pool
+	local  *Memory
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// notification is the payload of a NOTIFY on channel
+type notification struct {
+	Topic   string `json:"topic"`
+	Message string `json:"message"`
+}
+
+// NewPostgres connects to the database at url and starts listening
+func NewPostgres(ctx context.Context, url string) (*Postgres, error) {
+	poolConfig, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		return nil, fmt.Errorf("parsing stream broker database URL: %w", err)
+	}
+	// One connection listens; the rest publish
+	poolConfig.MaxConns = 4
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("connecting stream broker: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("connecting stream broker: %w", err)
+	}
+
+	listenCtx, cancel := context.WithCancel(context.Background())
+	p := &Postgres{pool: pool, local: NewMemory(), cancel: cancel, done: make(chan struct{})}
+	go p.listen(listenCtx)
+	return p, nil
+}
+
+func (p *Postgres) Publish(ctx context.Context, topic, message string) error {
+	payload, err := json.Marshal(notification{Topic: topic, Message: message})
+	if err != nil {
+		return err
+	}
+	// Our own listener hears this too, which is how local subscribers get it
+	_, err = p.pool.Exec(ctx, "SELECT pg_notify($1, $2)", channel, string(payload))
+	return err
+}
+
+func (p *Postgres) Subscribe(topic string) *Subscription {
+	return p.local.Subscribe(topic)
+}
+
+// Close stops listening, ends every subscription and closes the connections
+func (p *Postgres) Close() error {
+	p.cancel()
+	<-p.done
+	p.local.Close()
+	p.pool.Close()
+	return nil
+}
+
+// listen keeps a connection listening until ctx is cancelled, reconnecting
+// with a growing delay whenever it is lost
+func (p *Postgres) listen(ctx context.Context) {
+	defer close(p.done)
+	delay := time.Second
+	for reconnect := false; ; reconnect = true {
+		listening, err := p.receive(ctx, reconnect)
+		if ctx.Err() != nil {
+			return
+		}
+		if listening {
+			delay = time.Second
+		}
+		slog.Warn("stream broker lost its connection, reconnecting", "error", err, "in", delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, time.Minute)
+	}
+}
+
+// receive listens on one connection until it fails, reporting whether it
+// got as far as listening. Notifications sent while no connection was
+// listening are lost, so after a reconnect every subscriber is told to
+// look again.
+func (p *Postgres) receive(ctx context.Context, reconnect bool) (bool, error) {
+	conn, err := p.pool.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	// A listening connection must not go back to the pool for publishing
+	defer conn.Release()
+	defer conn.Conn().Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+channel); err != nil {
+		return false, err
+	}
+	if reconnect {
+		p.local.publishAll("")
+	}
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return true, err
+		}
+		var msg notification
+		if err := json.Unmarshal([]byte(n.Payload), &msg); err != nil {
+			slog.Warn("stream broker ignored a malformed notification", "error", err)
+			continue
+		}
+		p.local.Publish(ctx, msg.Topic, msg.Message)
+	}
+}
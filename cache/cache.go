@@ -0,0 +1,103 @@
+// Package cache is a read-through cache for rows that are read far more
+// often than they change. Values are stored JSON encoded, first in an
+// in-process LRU and then, when configured, in a shared backend.
+package cache
+
+import (
+	"backend/config"
+	"backend/logging"
+	"backend/metrics"
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Cache looks values up in the local LRU, then the shared store, then
+// loads them. A nil *Cache is valid and always loads.
+type Cache struct {
+	local  *LRU
+	shared Store // Nil without CACHE_BACKEND
+	ttl    time.Duration
+}
+
+// New builds the cache cfg describes, or returns nil when it is disabled
+func New(cfg config.CacheConfig) (*Cache, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	c := &Cache{local: NewLRU(cfg.Size), ttl: cfg.TTL}
+	if cfg.Backend != "" {
+		shared, err := Open(cfg.Backend)
+		if err != nil {
+			return nil, err
+		}
+		c.shared = shared
+	}
+	return c, nil
+}
+
+// Fetch returns the value cached under name and key, calling load and
+// caching its result on a miss. name groups keys for invalidation and
+// labels the hit ratio metric. Errors from the shared store are logged and
+// treated as misses, so an outage only costs database load.
+func Fetch[T any](ctx context.Context, c *Cache, name, key string, load func() (T, error)) (T, error) {
+	if c == nil {
+		return load()
+	}
+	key = name + ":" + key
+
+	var value T
+	if data, ok, _ := c.local.Get(ctx, key); ok && json.Unmarshal(data, &value) == nil {
+		metrics.ObserveCache(name, "local")
+		return value, nil
+	}
+	if c.shared != nil {
+		data, ok, err := c.shared.Get(ctx, key)
+		if err != nil {
+			logging.FromContext(ctx).Warn("cache read failed", "key", key, "error", err)
+		} else if ok && json.Unmarshal(data, &value) == nil {
+			c.local.Set(ctx, key, data, c.ttl)
+			metrics.ObserveCache(name, "shared")
+			return value, nil
+		}
+	}
+
+	metrics.ObserveCache(name, "miss")
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		logging.FromContext(ctx).Warn("cache encode failed", "key", key, "error", err)
+		return value, nil
+	}
+	c.local.Set(ctx, key, data, c.ttl)
+	if c.shared != nil {
+		if err := c.shared.Set(ctx, key, data, c.ttl); err != nil {
+			logging.FromContext(ctx).Warn("cache write failed", "key", key, "error", err)
+		}
+	}
+	return value, nil
+}
+
+// Invalidate drops the entries for keys under name after a write. Other
+// instances drop their local copy when it expires.
+func (c *Cache) Invalidate(ctx context.Context, name string, keys ...string) {
+	if c == nil {
+		return
+	}
+	full := make([]string, len(keys))
+	for i, key := range keys {
+		full[i] = name + ":" + key
+	}
+
+	c.local.Delete(ctx, full...)
+	if c.shared != nil {
+		if err := c.shared.Delete(ctx, full...); err != nil {
+			logging.FromContext(ctx).Error("cache invalidation failed", "keys", full, "error", err)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"backend/config"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	lru := NewLRU(2)
+	lru.Set(ctx, "a", []byte("1"), time.Minute)
+	lru.Set(ctx, "b", []byte("2"), time.Minute)
+	lru.Get(ctx, "a") // b is now the least recently used
+	lru.Set(ctx, "c", []byte("3"), time.Minute)
+
+	if _, ok, _ := lru.Get(ctx, "b"); ok {
+		t.Error("b survived eviction")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok, _ := lru.Get(ctx, key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+	if lru.Len() != 2 {
+		t.Errorf("Len = %d, want 2", lru.Len())
+	}
+
+	lru.Set(ctx, "expired", []byte("4"), -time.Second)
+	if _, ok, _ := lru.Get(ctx, "expired"); ok {
+		t.Error("expired entry was served")
+	}
+	lru.Delete(ctx, "a", "missing")
+	if _, ok, _ := lru.Get(ctx, "a"); ok {
+		t.Error("deleted entry was served")
+	}
+}
+
+// flakyStore is a shared store that fails while down is set
+type flakyStore struct {
+	*LRU
+	down bool
+}
+
+func (s *flakyStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if s.down {
+		return nil, false, errors.New("store is down")
+	}
+	return s.LRU.Get(ctx, key)
+}
+
+func (s *flakyStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if s.down {
+		return errors.New("store is down")
+	}
+	return s.LRU.Set(ctx, key, value, ttl)
+}
+
+func TestFetchReadsThroughBothTiers(t *testing.T) {
+	ctx := context.Background()
+	shared := &flakyStore{LRU: NewLRU(10)}
+	Register("test-shared", func() (Store, error) { return shared, nil })
+
+	cfg := config.CacheConfig{Enabled: true, Size: 10, TTL: time.Minute, Backend: "test-shared"}
+	first, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loads := 0
+	load := func() ([]string, error) {
+		loads++
+		return []string{"general", "events"}, nil
+	}
+	fetch := func(c *Cache) []string {
+		t.Helper()
+		value, err := Fetch(ctx, c, "categories", "all", load)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+
+	fetch(first)
+	fetch(first)
+	// A second instance finds the value in the shared tier
+	if got := fetch(second); len(got) != 2 || got[1] != "events" {
+		t.Errorf("fetch = %v", got)
+	}
+	if loads != 1 {
+		t.Errorf("loads = %d, want 1", loads)
+	}
+
+	first.Invalidate(ctx, "categories", "all")
+	fetch(first)
+	if loads != 2 {
+		t.Errorf("loads after invalidation = %d, want 2", loads)
+	}
+
+	// A failing shared store falls back to the database
+	shared.down = true
+	second.Invalidate(ctx, "categories", "all")
+	fetch(second)
+	if loads != 3 {
+		t.Errorf("loads with the shared store down = %d, want 3", loads)
+	}
+}
+
+func TestFetchWithoutCache(t *testing.T) {
+	c, err := New(config.CacheConfig{Enabled: false})
+	if err != nil || c != nil {
+		t.Fatalf("New(disabled) = %v, %v", c, err)
+	}
+
+	loads := 0
+	for i := 0; i < 2; i++ {
+		Fetch(context.Background(), c, "tags", "all", func() (int, error) {
+			loads++
+			return loads, nil
+		})
+	}
+	if loads != 2 {
+		t.Errorf("loads = %d, want 2", loads)
+	}
+	c.Invalidate(context.Background(), "tags", "all")
+
+	if _, err := New(config.CacheConfig{Enabled: true, Size: 1, TTL: time.Minute, Backend: "nope"}); err == nil {
+		t.Error("unknown backend accepted")
+	}
+
+	// Load errors are returned and not cached
+	c, _ = New(config.CacheConfig{Enabled: true, Size: 1, TTL: time.Minute})
+	failed := errors.New("not found")
+	if _, err := Fetch(context.Background(), c, "posts", "1", func() (int, error) { return 0, failed }); err != failed {
+		t.Errorf("err = %v", err)
+	}
+	if _, ok, _ := c.local.Get(context.Background(), "posts:1"); ok {
+		t.Error("failed load was cached")
+	}
+}
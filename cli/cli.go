@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"backend/config"
+	"backend/db"
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// command is a single CLI subcommand
+type command struct {
+	summary string
+	run     func(args []string) error
+}
+
+var commands = map[string]command{
+	"serve":          {"Start the HTTP server", runServe},
+	"worker":         {"Run background jobs, events and scheduled tasks without serving HTTP", runWorker},
+	"tasks":          {"List scheduled tasks and their recent runs", runTasks},
+	"run-task":       {"Run a scheduled task now", runRunTask},
+	"migrate":        {"Create or update the database schema", runMigrate},
+	"seed":           {"Insert default categories and tags, or a generated dataset", runSeed},
+	"create-admin":   {"Create an admin user or promote an existing one", runCreateAdmin},
+	"reset-password": {"Set a new password for a user", runResetPassword},
+	"export":         {"Dump every table as JSON", runExport},
+}
+
+// Run executes the subcommand named by args[0] and returns the process exit code.
+// With no arguments it starts the server, matching the old `go run main.go` behaviour.
+func Run(args []string) int {
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" || name == "-h" || name == "--help" {
+		usage(os.Stdout)
+		return 0
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage(os.Stderr)
+		return 2
+	}
+
+	if err := cmd.run(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return 1
+	}
+
+	return 0
+}
+
+// usage prints the list of subcommands
+func usage(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "Usage: backend <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-16s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'backend <command> -h' for command flags.")
+}
+
+// newFlagSet creates a flag set that reports errors instead of exiting
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// databaseFlags are the config flags offered by commands that only need the database
+var databaseFlags = []string{"database-url", "db-max-idle-conns", "db-max-open-conns"}
+
+// loadConfig resolves and validates the configuration for a command
+func loadConfig(flags *config.Flags, required ...config.Requirement) (*config.Config, error) {
+	cfg, err := flags.Load()
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(required...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// connect loads the configuration and opens the database for maintenance commands
+func connect(flags *config.Flags) error {
+	cfg, err := loadConfig(flags, config.RequireDatabase)
+	if err != nil {
+		return err
+	}
+	return db.Initialize(cfg)
+}
+
+// readPassword returns the flag value, falling back to the first line of stdin
+// so passwords don't have to appear in shell history
+func readPassword(value string) (string, error) {
+	if value != "" {
+		return value, nil
+	}
+
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" {
+		return "", fmt.Errorf("password is required")
+	}
+	return password, nil
+}
@@ -0,0 +1,449 @@
+package cli
+
+import (
+	"backend/auth"
+	"backend/config"
+	"backend/db"
+	"backend/events"
+	"backend/jobs"
+	"backend/logging"
+	"backend/router"
+	"backend/schedule"
+	"backend/seed"
+	"backend/tracing"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// runServe starts the standalone HTTP server
+func runServe(args []string) error {
+	fs := newFlagSet("serve")
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(flags, config.RequireDatabase, config.RequireAuth)
+	if err != nil {
+		return err
+	}
+
+	logging.New(cfg.Log)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("flushing traces", "error", err)
+		}
+	}()
+
+	if cfg.Server.Release {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	auth.Configure(cfg.Auth)
+	if err := db.Initialize(cfg); err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	// Workers and the scheduler stop once the server has drained and finish
+	// what they are running before the database closes
+	stopBackground, err := startBackground(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	defer stopBackground()
+
+	engine := router.New(cfg)
+	return serve(cfg.Server, engine)
+}
+
+// runWorker runs background jobs and the scheduler without serving HTTP
+func runWorker(args []string) error {
+	fs := newFlagSet("worker")
+	flags := config.RegisterFlags(fs)
+	concurrency := fs.Int("concurrency", 0, "jobs to run at once (default JOBS_WORKERS, at least 1)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(flags, config.RequireDatabase)
+	if err != nil {
+		return err
+	}
+	logging.New(cfg.Log)
+
+	if err := db.Initialize(cfg); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch {
+	case *concurrency > 0:
+		cfg.Jobs.Workers = *concurrency
+	case cfg.Jobs.Workers == 0:
+		cfg.Jobs.Workers = 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("running background jobs", "workers", cfg.Jobs.Workers, "backend", cfg.Jobs.Backend, "scheduler", cfg.Scheduler.Enabled)
+	stopBackground, err := startBackground(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+	stopBackground()
+	slog.Info("workers stopped")
+	return nil
+}
+
+// startBackground starts the job workers, the event dispatcher and, when
+// enabled, the scheduler. The returned function stops them all and waits
+// for them.
+func startBackground(ctx context.Context, cfg *config.Config) (func(), error) {
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		jobs.NewPool(db.Queue(), cfg.Jobs).Run(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		events.NewDispatcher(db.DB, cfg.Events, events.Default).Run(ctx)
+	}()
+
+	if cfg.Scheduler.Enabled {
+		scheduler, err := newScheduler(cfg)
+		if err != nil {
+			cancel()
+			wg.Wait()
+			return nil, err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scheduler.Run(ctx)
+		}()
+	}
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}, nil
+}
+
+// newScheduler builds a scheduler on the open database
+func newScheduler(cfg *config.Config) (*schedule.Scheduler, error) {
+	locker, err := schedule.NewLocker(db.DB)
+	if err != nil {
+		return nil, err
+	}
+	return schedule.New(db.DB, locker, cfg.Scheduler), nil
+}
+
+// serve runs the HTTP server until SIGINT or SIGTERM, then stops accepting
+// connections and waits up to cfg.ShutdownTimeout for in-flight requests
+func serve(cfg config.ServerConfig, handler http.Handler) error {
+	server := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	// Comment streams never finish by themselves, so they are ended as the
+	// drain starts rather than holding it up until the timeout
+	server.RegisterOnShutdown(db.CloseStreams)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("listening", "addr", cfg.Addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	// A second signal during the drain kills the process as usual
+	stop()
+	slog.Info("shutting down, draining in-flight requests", "timeout", cfg.ShutdownTimeout.String())
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("graceful shutdown: %w", err)
+	}
+
+	slog.Info("server stopped")
+	return nil
+}
+
+// runMigrate creates or updates the schema
+func runMigrate(args []string) error {
+	fs := newFlagSet("migrate")
+	flags := config.RegisterFlags(fs, databaseFlags...)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := connect(flags); err != nil {
+		return err
+	}
+	if err := db.Migrate(); err != nil {
+		return err
+	}
+
+	fmt.Println("Schema is up to date")
+	return nil
+}
+
+// runSeed inserts the default categories and tags, plus an optional generated dataset
+func runSeed(args []string) error {
+	fs := newFlagSet("seed")
+	flags := config.RegisterFlags(fs, databaseFlags...)
+	size := fs.String("size", "", "generate a dataset: small, medium or large (default: defaults only)")
+	seedValue := fs.Int64("seed", 1, "random seed; the same seed always produces the same dataset")
+	users := fs.Int("users", -1, "override the number of users")
+	categories := fs.Int("categories", -1, "override the number of categories")
+	tags := fs.Int("tags", -1, "override the number of tags")
+	posts := fs.Int("posts", -1, "override the number of posts")
+	comments := fs.Int("max-comments", -1, "override the maximum comments per post")
+	password := fs.String("password", seed.DefaultPassword, "password for every generated user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := connect(flags); err != nil {
+		return err
+	}
+	if err := db.SeedDefaults(); err != nil {
+		return err
+	}
+	fmt.Println("Default categories and tags are in place")
+
+	if *size == "" {
+		return nil
+	}
+
+	opts, ok := seed.Sizes[*size]
+	if !ok {
+		return fmt.Errorf("unknown size %q (want small, medium or large)", *size)
+	}
+	opts.Seed = *seedValue
+	opts.Password = *password
+	for _, override := range []struct {
+		value  int
+		target *int
+	}{
+		{*users, &opts.Users},
+		{*categories, &opts.Categories},
+		{*tags, &opts.Tags},
+		{*posts, &opts.Posts},
+		{*comments, &opts.MaxCommentsPerPost},
+	} {
+		if override.value >= 0 {
+			*override.target = override.value
+		}
+	}
+
+	dataset, err := seed.Generate(opts)
+	if err != nil {
+		return err
+	}
+
+	summary, err := seed.Write(db.DB, dataset)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Seeded %d users, %d categories, %d tags, %d posts, %d post tags and %d comments (seed %d)\n",
+		summary.Users, summary.Categories, summary.Tags, summary.Posts, summary.PostTags, summary.Comments, opts.Seed)
+	return nil
+}
+
+// runCreateAdmin creates an admin account or promotes an existing user
+func runCreateAdmin(args []string) error {
+	fs := newFlagSet("create-admin")
+	flags := config.RegisterFlags(fs, databaseFlags...)
+	username := fs.String("username", "", "admin username (required)")
+	email := fs.String("email", "", "admin email (required for a new user)")
+	password := fs.String("password", "", "admin password (read from stdin if empty)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" {
+		return fmt.Errorf("-username is required")
+	}
+
+	pw, err := readPassword(*password)
+	if err != nil {
+		return err
+	}
+
+	if err := connect(flags); err != nil {
+		return err
+	}
+	user, err := db.CreateAdmin(*username, *email, pw)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Admin %s (%s) is ready\n", user.Username, user.ID)
+	return nil
+}
+
+// runResetPassword sets a new password for an existing user
+func runResetPassword(args []string) error {
+	fs := newFlagSet("reset-password")
+	flags := config.RegisterFlags(fs, databaseFlags...)
+	username := fs.String("username", "", "username whose password to reset (required)")
+	password := fs.String("password", "", "new password (read from stdin if empty)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *username == "" {
+		return fmt.Errorf("-username is required")
+	}
+
+	pw, err := readPassword(*password)
+	if err != nil {
+		return err
+	}
+
+	if err := connect(flags); err != nil {
+		return err
+	}
+	if err := db.ResetPassword(*username, pw); err != nil {
+		return err
+	}
+
+	fmt.Printf("Password for %s has been reset\n", *username)
+	return nil
+}
+
+// runExport writes every table as JSON to stdout or a file
+func runExport(args []string) error {
+	fs := newFlagSet("export")
+	flags := config.RegisterFlags(fs, databaseFlags...)
+	out := fs.String("out", "", "file to write to (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := connect(flags); err != nil {
+		return err
+	}
+	return db.Export(w)
+}
+
+// runTasks lists the scheduled tasks and their recent runs
+func runTasks(args []string) error {
+	fs := newFlagSet("tasks")
+	flags := config.RegisterFlags(fs, databaseFlags...)
+	task := fs.String("task", "", "only show runs of this task")
+	limit := fs.Int("history", 20, "number of recent runs to show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := connect(flags); err != nil {
+		return err
+	}
+
+	fmt.Println("Tasks:")
+	for _, t := range schedule.Tasks() {
+		fmt.Printf("  %-24s %s (next %s)\n", t.Name, t.Spec, t.Schedule.Next(time.Now()).Format(time.RFC3339))
+	}
+
+	runs, err := schedule.History(context.Background(), db.DB, *task, *limit)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Println("Recent runs:")
+	for _, run := range runs {
+		duration := "running"
+		if run.FinishedAt != nil {
+			duration = run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond).String()
+		}
+		fmt.Printf("  %s  %-24s %-9s %-9s %-10s %s\n",
+			run.StartedAt.Format(time.RFC3339), run.Task, run.Trigger, run.Status, duration, run.Error)
+	}
+	return nil
+}
+
+// runRunTask runs one scheduled task now, recording it as a manual run
+func runRunTask(args []string) error {
+	fs := newFlagSet("run-task")
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: run-task <name> (see the tasks command for names)")
+	}
+
+	cfg, err := loadConfig(flags, config.RequireDatabase)
+	if err != nil {
+		return err
+	}
+	logging.New(cfg.Log)
+	if err := db.Initialize(cfg); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	scheduler, err := newScheduler(cfg)
+	if err != nil {
+		return err
+	}
+	run, err := scheduler.Trigger(context.Background(), fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s finished in %s\n", run.Task, run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,534 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the complete runtime configuration of the backend
+type Config struct {
+	Server      ServerConfig
+	Database    DatabaseConfig
+	Auth        AuthConfig
+	Cloudinary  CloudinaryConfig
+	CORS        CORSConfig
+	Upload      UploadConfig
+	Log         LogConfig
+	Metrics     MetricsConfig
+	Tracing     TracingConfig
+	OpenAPI     OpenAPIConfig
+	RateLimit   RateLimitConfig
+	Idempotency IdempotencyConfig
+	Cache       CacheConfig
+	Jobs        JobsConfig
+	Scheduler   SchedulerConfig
+	Events      EventsConfig
+	Webhooks    WebhooksConfig
+	GraphQL     GraphQLConfig
+	Stream      StreamConfig
+	Feed        FeedConfig
+}
+
+// ServerConfig controls the HTTP listener and router
+type ServerConfig struct {
+	Addr              string        // Address the standalone server listens on
+	Prefix            string        // Path prefix for every route, e.g. "/api"
+	Release           bool          // Run gin in release mode
+	ReadTimeout       time.Duration // Maximum time to read a whole request
+	ReadHeaderTimeout time.Duration // Maximum time to read request headers
+	WriteTimeout      time.Duration // Maximum time to write a response
+	IdleTimeout       time.Duration // How long keep-alive connections stay open
+	ShutdownTimeout   time.Duration // How long to drain in-flight requests on SIGTERM
+	TrustedProxies    []string      // IPs or CIDRs of proxies whose X-Forwarded-For is believed; none by default
+	TrustedPlatform   string        // Header a hosting platform puts the client IP in, e.g. X-Vercel-Forwarded-For
+}
+
+// DatabaseConfig controls the Postgres connection pool
+type DatabaseConfig struct {
+	URL             string
+	MaxIdleConns    int
+	MaxOpenConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// AuthConfig controls JWT issuing and the session cookie
+type AuthConfig struct {
+	JWTSecret    string
+	TokenTTL     time.Duration
+	CookieDomain string
+	CookieSecure bool
+}
+
+// CloudinaryConfig holds the image storage credentials
+type CloudinaryConfig struct {
+	CloudName string
+	APIKey    string
+	APISecret string
+}
+
+// Configured reports whether every Cloudinary credential is set
+func (c CloudinaryConfig) Configured() bool {
+	return c.CloudName != "" && c.APIKey != "" && c.APISecret != ""
+}
+
+// CORSConfig controls cross-origin access
+type CORSConfig struct {
+	AllowOrigins []string
+	MaxAge       time.Duration
+}
+
+// UploadConfig limits image uploads
+type UploadConfig struct {
+	MaxBytes int64
+}
+
+// LogConfig controls structured logging
+type LogConfig struct {
+	Level  string // debug, info, warn or error
+	Format string // json or text
+}
+
+// MetricsConfig controls the Prometheus endpoint, which is off unless
+// enabled since it is public without a token
+type MetricsConfig struct {
+	Enabled bool
+	Token   string // Bearer token scrapers must send; empty leaves /metrics open
+}
+
+// TracingConfig controls OpenTelemetry span export
+type TracingConfig struct {
+	Exporter    string  // none, stdout, file or otlp
+	File        string  // Destination of the file exporter
+	SampleRatio float64 // Fraction of new traces to sample, 0 to 1
+}
+
+// OpenAPIConfig controls checking requests against the OpenAPI document
+type OpenAPIConfig struct {
+	Validate bool // Reject requests that don't match the document with a 400
+}
+
+// IdempotencyConfig controls replaying responses to retried POST requests
+type IdempotencyConfig struct {
+	TTL time.Duration // How long a response is kept for replay
+}
+
+// CacheConfig controls the read-through cache in front of categories, tags
+// and posts. Every instance keeps an in-process LRU; a shared backend, when
+// set, sits behind it so instances can reuse each other's reads.
+type CacheConfig struct {
+	Enabled bool
+	Size    int           // Entries kept in the in-process LRU
+	TTL     time.Duration // How long an entry may be served before it is reloaded
+	Backend string        // Shared backend behind the LRU; empty for none
+}
+
+// JobsConfig controls the background job queue and its workers
+type JobsConfig struct {
+	Backend      string        // "postgres", or "memory" for tests and single-process runs
+	Workers      int           // Jobs run concurrently by serve; 0 leaves them to the worker command
+	PollInterval time.Duration // How often an idle worker checks for new jobs
+	Timeout      time.Duration // Longest a job may run; running jobs twice this old are reclaimed
+	MaxAttempts  int           // Attempts before a job is dead-lettered
+	Backoff      time.Duration // Delay before the first retry, doubled for each one after
+	MaxBackoff   time.Duration // Longest delay between retries
+	Retention    time.Duration // How long succeeded jobs are kept; dead ones stay until retried
+}
+
+// SchedulerConfig controls the maintenance task scheduler
+type SchedulerConfig struct {
+	Enabled bool          // Run the scheduler in serve and worker
+	Tick    time.Duration // How often due tasks are checked for
+	History time.Duration // How long run history is kept
+}
+
+// EventsConfig controls dispatching outbox events to their subscribers
+type EventsConfig struct {
+	PollInterval time.Duration // How often the dispatcher checks for new events when idle
+	Timeout      time.Duration // Longest the subscribers may take over one event
+	MaxAttempts  int           // Attempts before an event is marked failed
+	Backoff      time.Duration // Delay before the first retry, doubled for each one after
+	MaxBackoff   time.Duration // Longest delay between retries
+	Retention    time.Duration // How long dispatched events are kept
+}
+
+// WebhooksConfig controls delivering events to webhook subscribers
+type WebhooksConfig struct {
+	Timeout      time.Duration // Longest a receiver may take to answer
+	MaxAttempts  int           // Attempts before a delivery is marked failed; retries follow JOBS_BACKOFF
+	AllowPrivate bool          // Allow URLs on loopback and private networks, for local development
+}
+
+// GraphQLConfig bounds the queries /graphql accepts
+type GraphQLConfig struct {
+	MaxDepth      int // Deepest nesting of fields in one query
+	MaxComplexity int // Highest cost of one query, with list fields counting ListCost times their fields
+	ListCost      int // Assumed length of a list field when costing a query
+}
+
+// StreamConfig controls the live comment streams
+type StreamConfig struct {
+	Broker    string        // How changes reach the streams: "memory" within one process, "postgres" across instances
+	Heartbeat time.Duration // How often an idle stream is sent a comment to keep proxies from closing it
+	Retention time.Duration // How long changes are kept for clients resuming with Last-Event-ID
+}
+
+// FeedConfig controls how the home feed ranks posts
+type FeedConfig struct {
+	HotDecay time.Duration // How much newer a post may be and still tie with one scoring ten times higher in the hot sort
+}
+
+// RateLimitConfig controls request throttling. Each route group has its own
+// token bucket per client; a zero Rate leaves that group unlimited.
+type RateLimitConfig struct {
+	Enabled bool
+	Backend string // Where buckets are kept; "memory" is per process
+	Signup  Rate   // POST /users, per IP
+	Login   Rate   // POST /login, per IP
+	Write   Rate   // Creating posts, comments and post tags, per user
+	Upload  Rate   // Image uploads, per IP
+}
+
+// Rate allows Requests per Period, in bursts of up to Requests
+type Rate struct {
+	Requests int
+	Period   time.Duration
+}
+
+// ParseRate reads a rate like "10/1m" or "100/1h". "off" and "0" disable the limit.
+func ParseRate(value string) (Rate, error) {
+	value = strings.TrimSpace(value)
+	if value == "off" || value == "0" {
+		return Rate{}, nil
+	}
+
+	requests, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("%q is not a rate like 10/1m", value)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 1 {
+		return Rate{}, fmt.Errorf("%q is not a rate like 10/1m", value)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Rate{}, fmt.Errorf("%q is not a rate like 10/1m", value)
+	}
+	return Rate{Requests: n, Period: d}, nil
+}
+
+// Enabled reports whether the rate limits anything
+func (r Rate) Enabled() bool {
+	return r.Requests > 0 && r.Period > 0
+}
+
+func (r Rate) String() string {
+	if !r.Enabled() {
+		return "off"
+	}
+	return fmt.Sprintf("%d/%s", r.Requests, r.Period)
+}
+
+// Default returns the configuration used when nothing overrides it
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:              ":8080",
+			ReadTimeout:       15 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		Database: DatabaseConfig{
+			MaxIdleConns: 10,
+			MaxOpenConns: 100,
+		},
+		Auth: AuthConfig{
+			TokenTTL:     30 * 24 * time.Hour,
+			CookieSecure: true,
+		},
+		CORS: CORSConfig{
+			AllowOrigins: []string{"https://student-hub-frontend.vercel.app", "http://localhost:3000"},
+			MaxAge:       30 * 24 * time.Hour,
+		},
+		Upload: UploadConfig{
+			MaxBytes: 5 * 1024 * 1024,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			File:        "traces.jsonl",
+			SampleRatio: 1,
+		},
+		Idempotency: IdempotencyConfig{
+			TTL: 24 * time.Hour,
+		},
+		Cache: CacheConfig{
+			Enabled: true,
+			Size:    1000,
+			TTL:     5 * time.Minute,
+		},
+		Jobs: JobsConfig{
+			Backend:      "postgres",
+			Workers:      2,
+			PollInterval: time.Second,
+			Timeout:      5 * time.Minute,
+			MaxAttempts:  5,
+			Backoff:      10 * time.Second,
+			MaxBackoff:   time.Hour,
+			Retention:    7 * 24 * time.Hour,
+		},
+		Scheduler: SchedulerConfig{
+			Enabled: true,
+			Tick:    15 * time.Second,
+			History: 30 * 24 * time.Hour,
+		},
+		Events: EventsConfig{
+			PollInterval: time.Second,
+			Timeout:      time.Minute,
+			MaxAttempts:  10,
+			Backoff:      5 * time.Second,
+			MaxBackoff:   30 * time.Minute,
+			Retention:    7 * 24 * time.Hour,
+		},
+		Webhooks: WebhooksConfig{
+			Timeout:     10 * time.Second,
+			MaxAttempts: 8,
+		},
+		GraphQL: GraphQLConfig{
+			MaxDepth:      10,
+			MaxComplexity: 1000,
+			ListCost:      10,
+		},
+		Stream: StreamConfig{
+			Broker:    "memory",
+			Heartbeat: 15 * time.Second,
+			Retention: 24 * time.Hour,
+		},
+		Feed: FeedConfig{
+			HotDecay: 12*time.Hour + 30*time.Minute,
+		},
+		RateLimit: RateLimitConfig{
+			Enabled: true,
+			Backend: "memory",
+			Signup:  Rate{Requests: 10, Period: time.Hour},
+			Login:   Rate{Requests: 10, Period: time.Minute},
+			Write:   Rate{Requests: 30, Period: time.Minute},
+			Upload:  Rate{Requests: 10, Period: time.Minute},
+		},
+	}
+}
+
+// Requirement names a setting a command cannot run without
+type Requirement int
+
+const (
+	RequireDatabase Requirement = iota // SUPABASE_DATABASE_URL must be set
+	RequireAuth                        // JWT_SECRET must be set
+)
+
+// Validate checks the configuration and reports every problem at once
+func (c *Config) Validate(required ...Requirement) error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, requirement := range required {
+		switch requirement {
+		case RequireDatabase:
+			if c.Database.URL == "" {
+				add("SUPABASE_DATABASE_URL is not set")
+			}
+		case RequireAuth:
+			if c.Auth.JWTSecret == "" {
+				add("JWT_SECRET is not set")
+			}
+		}
+	}
+
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"HTTP_READ_TIMEOUT", c.Server.ReadTimeout},
+		{"HTTP_READ_HEADER_TIMEOUT", c.Server.ReadHeaderTimeout},
+		{"HTTP_WRITE_TIMEOUT", c.Server.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", c.Server.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", c.Server.ShutdownTimeout},
+	} {
+		if timeout.value <= 0 {
+			add("%s must be positive (got %s)", timeout.name, timeout.value)
+		}
+	}
+
+	if c.Database.URL != "" {
+		if u, err := url.Parse(c.Database.URL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+			add("SUPABASE_DATABASE_URL must be a postgres:// URL")
+		}
+	}
+	if c.Database.MaxOpenConns < 1 {
+		add("DB_MAX_OPEN_CONNS must be at least 1 (got %d)", c.Database.MaxOpenConns)
+	}
+	if c.Database.MaxIdleConns < 0 || c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		add("DB_MAX_IDLE_CONNS must be between 0 and DB_MAX_OPEN_CONNS (got %d)", c.Database.MaxIdleConns)
+	}
+	if c.Database.ConnMaxLifetime < 0 {
+		add("DB_CONN_MAX_LIFETIME must not be negative")
+	}
+
+	if c.Auth.TokenTTL <= 0 {
+		add("JWT_TTL must be positive (got %s)", c.Auth.TokenTTL)
+	}
+
+	cld := c.Cloudinary
+	if (cld.CloudName != "" || cld.APIKey != "" || cld.APISecret != "") && !cld.Configured() {
+		add("CLOUD_NAME, CLOUD_API_KEY and CLOUD_API_SECRET must be set together")
+	}
+
+	for _, origin := range c.CORS.AllowOrigins {
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			add("CORS_ALLOW_ORIGINS entry %q is not an origin like https://example.com", origin)
+		}
+	}
+	if c.CORS.MaxAge < 0 {
+		add("CORS_MAX_AGE must not be negative")
+	}
+
+	if c.Upload.MaxBytes < 1 {
+		add("UPLOAD_MAX_BYTES must be positive (got %d)", c.Upload.MaxBytes)
+	}
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		add("LOG_LEVEL must be debug, info, warn or error (got %q)", c.Log.Level)
+	}
+	switch strings.ToLower(c.Log.Format) {
+	case "json", "text":
+	default:
+		add("LOG_FORMAT must be json or text (got %q)", c.Log.Format)
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	case "file":
+		if c.Tracing.File == "" {
+			add("TRACING_FILE must be set for the file exporter")
+		}
+	default:
+		add("TRACING_EXPORTER must be none, stdout, file or otlp (got %q)", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("TRACING_SAMPLE_RATIO must be between 0 and 1 (got %g)", c.Tracing.SampleRatio)
+	}
+
+	if c.Idempotency.TTL <= 0 {
+		add("IDEMPOTENCY_TTL must be positive (got %s)", c.Idempotency.TTL)
+	}
+
+	if c.Cache.Enabled && c.Cache.Size <= 0 {
+		add("CACHE_SIZE must be positive (got %d)", c.Cache.Size)
+	}
+	if c.Cache.Enabled && c.Cache.TTL <= 0 {
+		add("CACHE_TTL must be positive (got %s)", c.Cache.TTL)
+	}
+
+	switch c.Jobs.Backend {
+	case "postgres", "memory":
+	default:
+		add("JOBS_BACKEND must be postgres or memory (got %q)", c.Jobs.Backend)
+	}
+	if c.Jobs.Workers < 0 {
+		add("JOBS_WORKERS must not be negative (got %d)", c.Jobs.Workers)
+	}
+	if c.Jobs.PollInterval <= 0 || c.Jobs.Timeout <= 0 || c.Jobs.Backoff <= 0 || c.Jobs.MaxBackoff < c.Jobs.Backoff {
+		add("JOBS_POLL_INTERVAL, JOBS_TIMEOUT and JOBS_BACKOFF must be positive and JOBS_MAX_BACKOFF at least JOBS_BACKOFF")
+	}
+	if c.Jobs.MaxAttempts < 1 {
+		add("JOBS_MAX_ATTEMPTS must be at least 1 (got %d)", c.Jobs.MaxAttempts)
+	}
+
+	if c.Jobs.Retention <= 0 {
+		add("JOBS_RETENTION must be positive (got %s)", c.Jobs.Retention)
+	}
+	if c.Scheduler.Tick <= 0 || c.Scheduler.History <= 0 {
+		add("SCHEDULER_TICK and SCHEDULER_HISTORY must be positive")
+	}
+	if c.Events.PollInterval <= 0 || c.Events.Timeout <= 0 || c.Events.Backoff <= 0 || c.Events.MaxBackoff < c.Events.Backoff || c.Events.Retention <= 0 {
+		add("EVENTS_POLL_INTERVAL, EVENTS_TIMEOUT, EVENTS_BACKOFF and EVENTS_RETENTION must be positive and EVENTS_MAX_BACKOFF at least EVENTS_BACKOFF")
+	}
+	if c.Events.MaxAttempts < 1 {
+		add("EVENTS_MAX_ATTEMPTS must be at least 1 (got %d)", c.Events.MaxAttempts)
+	}
+	if c.Webhooks.Timeout <= 0 {
+		add("WEBHOOKS_TIMEOUT must be positive (got %s)", c.Webhooks.Timeout)
+	}
+	if c.Webhooks.MaxAttempts < 1 {
+		add("WEBHOOKS_MAX_ATTEMPTS must be at least 1 (got %d)", c.Webhooks.MaxAttempts)
+	}
+
+	if c.GraphQL.MaxDepth < 1 {
+		add("GRAPHQL_MAX_DEPTH must be at least 1 (got %d)", c.GraphQL.MaxDepth)
+	}
+	if c.GraphQL.MaxComplexity < 1 {
+		add("GRAPHQL_MAX_COMPLEXITY must be at least 1 (got %d)", c.GraphQL.MaxComplexity)
+	}
+	if c.GraphQL.ListCost < 1 {
+		add("GRAPHQL_LIST_COST must be at least 1 (got %d)", c.GraphQL.ListCost)
+	}
+
+	if c.Stream.Broker == "" {
+		add("STREAM_BROKER must be set")
+	}
+	if c.Stream.Heartbeat <= 0 || c.Stream.Retention <= 0 {
+		add("STREAM_HEARTBEAT and STREAM_RETENTION must be positive")
+	}
+
+	if c.Feed.HotDecay <= 0 {
+		add("FEED_HOT_DECAY must be positive (got %s)", c.Feed.HotDecay)
+	}
+
+	if c.RateLimit.Enabled && c.RateLimit.Backend == "" {
+		add("RATE_LIMIT_BACKEND must be set when rate limiting is enabled")
+	}
+
+	if c.Server.Prefix != "" && (!strings.HasPrefix(c.Server.Prefix, "/") || strings.HasSuffix(c.Server.Prefix, "/")) {
+		add("API_PREFIX must start with / and not end with one (got %q)", c.Server.Prefix)
+	}
+
+	for _, proxy := range c.Server.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				add("TRUSTED_PROXIES must list IPs or CIDRs (got %q)", proxy)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &Error{Problems: problems}
+	}
+	return nil
+}
+
+// Error lists every configuration problem found while loading or validating
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString("invalid configuration:")
+	for _, problem := range e.Problems {
+		b.WriteString("\n  - ")
+		b.WriteString(problem)
+	}
+	return b.String()
+}
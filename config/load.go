@@ -0,0 +1,328 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// DefaultFile is read when no config file is named; it may be absent
+const DefaultFile = ".env"
+
+// FileEnv names the environment variable that points at a config file
+const FileEnv = "STUDENTHUB_CONFIG"
+
+// setting binds one configuration value to its environment variable and flag
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   setter
+}
+
+// setter parses a value into its field of a Config. Boolean settings may
+// be given as a bare flag.
+type setter struct {
+	parse  func(c *Config, value string) error
+	isBool bool
+}
+
+var settings = []setting{
+	{"STUDENTHUB_ADDR", "addr", "address the server listens on", stringVar(func(c *Config) *string { return &c.Server.Addr })},
+	{"API_PREFIX", "prefix", "path prefix for every route, e.g. /api", stringVar(func(c *Config) *string { return &c.Server.Prefix })},
+	{"GIN_RELEASE", "release", "run gin in release mode", boolVar(func(c *Config) *bool { return &c.Server.Release })},
+	{"HTTP_READ_TIMEOUT", "read-timeout", "maximum time to read a request", durationVar(func(c *Config) *time.Duration { return &c.Server.ReadTimeout })},
+	{"HTTP_READ_HEADER_TIMEOUT", "read-header-timeout", "maximum time to read request headers", durationVar(func(c *Config) *time.Duration { return &c.Server.ReadHeaderTimeout })},
+	{"HTTP_WRITE_TIMEOUT", "write-timeout", "maximum time to write a response", durationVar(func(c *Config) *time.Duration { return &c.Server.WriteTimeout })},
+	{"HTTP_IDLE_TIMEOUT", "idle-timeout", "how long idle keep-alive connections stay open", durationVar(func(c *Config) *time.Duration { return &c.Server.IdleTimeout })},
+	{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long to drain in-flight requests on shutdown", durationVar(func(c *Config) *time.Duration { return &c.Server.ShutdownTimeout })},
+	{"TRUSTED_PROXIES", "trusted-proxies", "comma separated IPs or CIDRs of proxies allowed to set X-Forwarded-For", listVar(func(c *Config) *[]string { return &c.Server.TrustedProxies })},
+	{"TRUSTED_PLATFORM", "trusted-platform", "header the hosting platform puts the client IP in, e.g. CF-Connecting-IP", stringVar(func(c *Config) *string { return &c.Server.TrustedPlatform })},
+
+	{"SUPABASE_DATABASE_URL", "database-url", "Postgres connection URL", stringVar(func(c *Config) *string { return &c.Database.URL })},
+	{"DB_MAX_IDLE_CONNS", "db-max-idle-conns", "maximum idle database connections", intVar(func(c *Config) *int { return &c.Database.MaxIdleConns })},
+	{"DB_MAX_OPEN_CONNS", "db-max-open-conns", "maximum open database connections", intVar(func(c *Config) *int { return &c.Database.MaxOpenConns })},
+	{"DB_CONN_MAX_LIFETIME", "db-conn-max-lifetime", "maximum lifetime of a database connection (0 = forever)", durationVar(func(c *Config) *time.Duration { return &c.Database.ConnMaxLifetime })},
+
+	{"JWT_SECRET", "jwt-secret", "secret used to sign JWTs", stringVar(func(c *Config) *string { return &c.Auth.JWTSecret })},
+	{"JWT_TTL", "jwt-ttl", "lifetime of issued tokens and the session cookie", durationVar(func(c *Config) *time.Duration { return &c.Auth.TokenTTL })},
+	{"COOKIE_DOMAIN", "cookie-domain", "domain of the session cookie (empty = current host)", stringVar(func(c *Config) *string { return &c.Auth.CookieDomain })},
+	{"COOKIE_SECURE", "cookie-secure", "only send the session cookie over HTTPS", boolVar(func(c *Config) *bool { return &c.Auth.CookieSecure })},
+
+	{"CLOUD_NAME", "cloud-name", "Cloudinary cloud name", stringVar(func(c *Config) *string { return &c.Cloudinary.CloudName })},
+	{"CLOUD_API_KEY", "cloud-api-key", "Cloudinary API key", stringVar(func(c *Config) *string { return &c.Cloudinary.APIKey })},
+	{"CLOUD_API_SECRET", "cloud-api-secret", "Cloudinary API secret", stringVar(func(c *Config) *string { return &c.Cloudinary.APISecret })},
+
+	{"CORS_ALLOW_ORIGINS", "cors-allow-origins", "comma separated list of allowed origins", listVar(func(c *Config) *[]string { return &c.CORS.AllowOrigins })},
+	{"CORS_MAX_AGE", "cors-max-age", "how long browsers may cache preflight responses", durationVar(func(c *Config) *time.Duration { return &c.CORS.MaxAge })},
+
+	{"LOG_LEVEL", "log-level", "minimum log level: debug, info, warn or error", stringVar(func(c *Config) *string { return &c.Log.Level })},
+	{"LOG_FORMAT", "log-format", "log output format: json or text", stringVar(func(c *Config) *string { return &c.Log.Format })},
+
+	{"METRICS_ENABLED", "metrics", "serve Prometheus metrics at /metrics", boolVar(func(c *Config) *bool { return &c.Metrics.Enabled })},
+	{"METRICS_TOKEN", "metrics-token", "bearer token required to scrape /metrics (empty = open)", stringVar(func(c *Config) *string { return &c.Metrics.Token })},
+
+	{"TRACING_EXPORTER", "tracing-exporter", "trace exporter: none, stdout, file or otlp", stringVar(func(c *Config) *string { return &c.Tracing.Exporter })},
+	{"TRACING_FILE", "tracing-file", "file written by the file trace exporter", stringVar(func(c *Config) *string { return &c.Tracing.File })},
+	{"TRACING_SAMPLE_RATIO", "tracing-sample-ratio", "fraction of new traces to sample", floatVar(func(c *Config) *float64 { return &c.Tracing.SampleRatio })},
+
+	{"OPENAPI_VALIDATE", "openapi-validate", "reject requests that don't match the OpenAPI document", boolVar(func(c *Config) *bool { return &c.OpenAPI.Validate })},
+
+	{"IDEMPOTENCY_TTL", "idempotency-ttl", "how long responses to POSTs with an Idempotency-Key are replayed", durationVar(func(c *Config) *time.Duration { return &c.Idempotency.TTL })},
+
+	{"CACHE_ENABLED", "cache", "cache categories, tags and posts in front of the database", boolVar(func(c *Config) *bool { return &c.Cache.Enabled })},
+	{"CACHE_SIZE", "cache-size", "entries kept in the in-process cache", intVar(func(c *Config) *int { return &c.Cache.Size })},
+	{"CACHE_TTL", "cache-ttl", "how long a cached entry is served before it is reloaded", durationVar(func(c *Config) *time.Duration { return &c.Cache.TTL })},
+	{"CACHE_BACKEND", "cache-backend", "shared cache behind the in-process one (empty = none)", stringVar(func(c *Config) *string { return &c.Cache.Backend })},
+
+	{"JOBS_BACKEND", "jobs-backend", "where background jobs are queued: postgres or memory", stringVar(func(c *Config) *string { return &c.Jobs.Backend })},
+	{"JOBS_WORKERS", "jobs-workers", "background jobs run concurrently by serve (0 = leave them to the worker command)", intVar(func(c *Config) *int { return &c.Jobs.Workers })},
+	{"JOBS_POLL_INTERVAL", "jobs-poll-interval", "how often an idle worker checks for jobs", durationVar(func(c *Config) *time.Duration { return &c.Jobs.PollInterval })},
+	{"JOBS_TIMEOUT", "jobs-timeout", "longest a background job may run", durationVar(func(c *Config) *time.Duration { return &c.Jobs.Timeout })},
+	{"JOBS_MAX_ATTEMPTS", "jobs-max-attempts", "attempts before a failing job is dead-lettered", intVar(func(c *Config) *int { return &c.Jobs.MaxAttempts })},
+	{"JOBS_BACKOFF", "jobs-backoff", "delay before the first retry, doubled for each one after", durationVar(func(c *Config) *time.Duration { return &c.Jobs.Backoff })},
+	{"JOBS_MAX_BACKOFF", "jobs-max-backoff", "longest delay between retries", durationVar(func(c *Config) *time.Duration { return &c.Jobs.MaxBackoff })},
+
+	{"JOBS_RETENTION", "jobs-retention", "how long succeeded jobs are kept", durationVar(func(c *Config) *time.Duration { return &c.Jobs.Retention })},
+
+	{"SCHEDULER_ENABLED", "scheduler", "run maintenance tasks on their schedules", boolVar(func(c *Config) *bool { return &c.Scheduler.Enabled })},
+	{"SCHEDULER_TICK", "scheduler-tick", "how often the scheduler checks for due tasks", durationVar(func(c *Config) *time.Duration { return &c.Scheduler.Tick })},
+	{"SCHEDULER_HISTORY", "scheduler-history", "how long task run history is kept", durationVar(func(c *Config) *time.Duration { return &c.Scheduler.History })},
+
+	{"EVENTS_POLL_INTERVAL", "events-poll-interval", "how often the event dispatcher checks for new events", durationVar(func(c *Config) *time.Duration { return &c.Events.PollInterval })},
+	{"EVENTS_TIMEOUT", "events-timeout", "longest the subscribers may take over one event", durationVar(func(c *Config) *time.Duration { return &c.Events.Timeout })},
+	{"EVENTS_MAX_ATTEMPTS", "events-max-attempts", "dispatch attempts before an event is marked failed", intVar(func(c *Config) *int { return &c.Events.MaxAttempts })},
+	{"EVENTS_BACKOFF", "events-backoff", "delay before an event is dispatched again, doubled for each retry", durationVar(func(c *Config) *time.Duration { return &c.Events.Backoff })},
+	{"EVENTS_MAX_BACKOFF", "events-max-backoff", "longest delay between event dispatch retries", durationVar(func(c *Config) *time.Duration { return &c.Events.MaxBackoff })},
+	{"EVENTS_RETENTION", "events-retention", "how long dispatched events are kept", durationVar(func(c *Config) *time.Duration { return &c.Events.Retention })},
+
+	{"WEBHOOKS_TIMEOUT", "webhooks-timeout", "longest a webhook receiver may take to answer", durationVar(func(c *Config) *time.Duration { return &c.Webhooks.Timeout })},
+	{"WEBHOOKS_MAX_ATTEMPTS", "webhooks-max-attempts", "attempts before a webhook delivery is marked failed", intVar(func(c *Config) *int { return &c.Webhooks.MaxAttempts })},
+	{"WEBHOOKS_ALLOW_PRIVATE", "webhooks-allow-private", "allow webhook URLs on loopback and private networks", boolVar(func(c *Config) *bool { return &c.Webhooks.AllowPrivate })},
+
+	{"GRAPHQL_MAX_DEPTH", "graphql-max-depth", "deepest field nesting a GraphQL query may have", intVar(func(c *Config) *int { return &c.GraphQL.MaxDepth })},
+	{"GRAPHQL_MAX_COMPLEXITY", "graphql-max-complexity", "highest cost a GraphQL query may have", intVar(func(c *Config) *int { return &c.GraphQL.MaxComplexity })},
+	{"GRAPHQL_LIST_COST", "graphql-list-cost", "assumed length of a list field when costing a GraphQL query", intVar(func(c *Config) *int { return &c.GraphQL.ListCost })},
+
+	{"STREAM_BROKER", "stream-broker", "how comment changes reach live streams: memory or postgres", stringVar(func(c *Config) *string { return &c.Stream.Broker })},
+	{"STREAM_HEARTBEAT", "stream-heartbeat", "how often idle comment streams are sent a heartbeat", durationVar(func(c *Config) *time.Duration { return &c.Stream.Heartbeat })},
+	{"STREAM_RETENTION", "stream-retention", "how long comment changes are kept for resuming streams", durationVar(func(c *Config) *time.Duration { return &c.Stream.Retention })},
+
+	{"FEED_HOT_DECAY", "feed-hot-decay", "how much newer a post may be and tie with one scoring ten times higher in the hot feed", durationVar(func(c *Config) *time.Duration { return &c.Feed.HotDecay })},
+
+	{"RATE_LIMIT_ENABLED", "rate-limit", "throttle signups, logins, writes and uploads", boolVar(func(c *Config) *bool { return &c.RateLimit.Enabled })},
+	{"RATE_LIMIT_BACKEND", "rate-limit-backend", "where rate limit buckets are kept: memory", stringVar(func(c *Config) *string { return &c.RateLimit.Backend })},
+	{"RATE_LIMIT_SIGNUP", "rate-limit-signup", "sign ups allowed per IP, e.g. 10/1h (off = unlimited)", rateVar(func(c *Config) *Rate { return &c.RateLimit.Signup })},
+	{"RATE_LIMIT_LOGIN", "rate-limit-login", "logins allowed per IP, e.g. 10/1m", rateVar(func(c *Config) *Rate { return &c.RateLimit.Login })},
+	{"RATE_LIMIT_WRITE", "rate-limit-write", "posts, comments and tag changes allowed per user, e.g. 30/1m", rateVar(func(c *Config) *Rate { return &c.RateLimit.Write })},
+	{"RATE_LIMIT_UPLOAD", "rate-limit-upload", "image uploads allowed per IP, e.g. 10/1m", rateVar(func(c *Config) *Rate { return &c.RateLimit.Upload })},
+
+	{"UPLOAD_MAX_BYTES", "upload-max-bytes", "largest accepted image upload in bytes", int64Var(func(c *Config) *int64 { return &c.Upload.MaxBytes })},
+}
+
+// Load builds a Config from the defaults, the config file at path (or
+// STUDENTHUB_CONFIG, or .env), and the environment, in increasing priority.
+// Unlike the old godotenv.Load calls it never modifies the process environment.
+func Load(path string) (*Config, error) {
+	return load(path, nil)
+}
+
+func load(path string, overrides map[string]string) (*Config, error) {
+	cfg := Default()
+
+	file, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	resolved := map[string]bool{}
+	for _, s := range settings {
+		value, ok := overrides[s.flag]
+		if !ok {
+			value, ok = os.LookupEnv(s.env)
+		}
+		if !ok {
+			value, ok = file[s.env]
+		}
+		if !ok {
+			continue
+		}
+		resolved[s.flag] = true
+		if err := s.set.parse(cfg, value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", s.env, err))
+		}
+	}
+
+	// Vercel and most PaaS hosts only tell us the port
+	if port := os.Getenv("PORT"); port != "" && !resolved["addr"] {
+		cfg.Server.Addr = ":" + port
+	}
+
+	if len(problems) > 0 {
+		return nil, &Error{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// readFile parses a KEY=value config file. A missing default file is not an error.
+func readFile(path string) (map[string]string, error) {
+	explicit := path != ""
+	if !explicit {
+		path = os.Getenv(FileEnv)
+		explicit = path != ""
+	}
+	if !explicit {
+		path = DefaultFile
+	}
+
+	values, err := godotenv.Read(path)
+	if errors.Is(err, fs.ErrNotExist) && !explicit {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// Flags collects command line overrides registered on a flag set
+type Flags struct {
+	path      string
+	overrides map[string]string
+}
+
+// RegisterFlags adds -config plus a flag for every setting to fs. Pass flag
+// names to register only those settings.
+func RegisterFlags(fs *flag.FlagSet, only ...string) *Flags {
+	f := &Flags{overrides: map[string]string{}}
+	fs.StringVar(&f.path, "config", "", "config file in KEY=value format (default $"+FileEnv+" or "+DefaultFile+")")
+
+	for _, s := range settings {
+		if len(only) > 0 && !contains(only, s.flag) {
+			continue
+		}
+		name := s.flag
+		usage := fmt.Sprintf("%s (env %s)", s.usage, s.env)
+		if s.set.isBool {
+			fs.BoolFunc(name, usage, func(value string) error {
+				f.overrides[name] = value
+				return nil
+			})
+			continue
+		}
+		fs.Func(name, usage, func(value string) error {
+			f.overrides[name] = value
+			return nil
+		})
+	}
+
+	return f
+}
+
+// Load resolves the configuration with the parsed flags taking priority
+func (f *Flags) Load() (*Config, error) {
+	return load(f.path, f.overrides)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func stringVar(field func(*Config) *string) setter {
+	return setter{parse: func(c *Config, value string) error {
+		*field(c) = strings.TrimSpace(value)
+		return nil
+	}}
+}
+
+func boolVar(field func(*Config) *bool) setter {
+	return setter{isBool: true, parse: func(c *Config, value string) error {
+		parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		*field(c) = parsed
+		return nil
+	}}
+}
+
+func intVar(field func(*Config) *int) setter {
+	return setter{parse: func(c *Config, value string) error {
+		parsed, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*field(c) = parsed
+		return nil
+	}}
+}
+
+func int64Var(field func(*Config) *int64) setter {
+	return setter{parse: func(c *Config, value string) error {
+		parsed, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		*field(c) = parsed
+		return nil
+	}}
+}
+
+func floatVar(field func(*Config) *float64) setter {
+	return setter{parse: func(c *Config, value string) error {
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*field(c) = parsed
+		return nil
+	}}
+}
+
+func durationVar(field func(*Config) *time.Duration) setter {
+	return setter{parse: func(c *Config, value string) error {
+		parsed, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not a duration like 30s or 720h", value)
+		}
+		*field(c) = parsed
+		return nil
+	}}
+}
+
+func listVar(field func(*Config) *[]string) setter {
+	return setter{parse: func(c *Config, value string) error {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field(c) = items
+		return nil
+	}}
+}
+
+func rateVar(field func(*Config) *Rate) setter {
+	return setter{parse: func(c *Config, value string) error {
+		parsed, err := ParseRate(value)
+		if err != nil {
+			return err
+		}
+		*field(c) = parsed
+		return nil
+	}}
+}
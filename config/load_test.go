@@ -0,0 +1,33 @@
+package config_test
+
+import (
+	"backend/config"
+	"flag"
+	"io"
+	"testing"
+)
+
+func TestBareBoolFlags(t *testing.T) {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-cache", "-scheduler", "-webhooks-allow-private", "-metrics"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := flags.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Cache.Enabled || !cfg.Scheduler.Enabled || !cfg.Webhooks.AllowPrivate || !cfg.Metrics.Enabled {
+		t.Errorf("cache %v, scheduler %v, webhooks-allow-private %v, metrics %v; want every bare flag on",
+			cfg.Cache.Enabled, cfg.Scheduler.Enabled, cfg.Webhooks.AllowPrivate, cfg.Metrics.Enabled)
+	}
+
+	// Settings that aren't booleans still need a value
+	fs = flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	config.RegisterFlags(fs, "cache-size")
+	if err := fs.Parse([]string{"-cache-size"}); err == nil {
+		t.Error("bare -cache-size was accepted")
+	}
+}
@@ -1,43 +1,88 @@
 package db
 
 import (
+	"backend/config"
+	"backend/metrics"
+	"backend/problem"
+	"backend/tracing"
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"mime/multipart"
-	"os"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"github.com/cloudinary/cloudinary-go/v2/api/admin"
 	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// CloudinaryService holds the Cloudinary client configuration
-type CloudinaryService struct {
-	Cld *cloudinary.Cloudinary
+// ImageStore keeps user avatars. CloudinaryService is the production
+// implementation.
+type ImageStore interface {
+	UploadImage(ctx context.Context, file *multipart.FileHeader, username string) (string, error)
+	DeleteImage(ctx context.Context, username string) error
+	DeleteImageByID(ctx context.Context, publicID string) error
+	ListImages(ctx context.Context) ([]StoredImage, error)
 }
 
-// NewCloudinaryService creates a new Cloudinary service instance
-func NewCloudinaryService() (*CloudinaryService, error) {
+// StoredImage is an avatar in image storage
+type StoredImage struct {
+	PublicID  string
+	CreatedAt time.Time
+}
 
-	err := godotenv.Load()
-	if err != nil {
-		log.Println("Warning: No .env file found. Ensure environment variables are set.")
+// avatarID is the public ID an avatar uploaded for username is stored under.
+// It keeps the name the user had at the time, so after a rename only the
+// user's avatar_url says which image is theirs.
+func avatarID(username string) string {
+	return fmt.Sprintf("avatars/%s_avatar", strings.ReplaceAll(username, " ", "_"))
+}
+
+// imageID is the public ID of the image a delivery URL such as
+// https://res.cloudinary.com/demo/image/upload/v1712/avatars/alice_avatar.jpg
+// points at, or false when it isn't one of ours
+func imageID(url string) (string, bool) {
+	_, id, ok := strings.Cut(url, "/upload/")
+	if !ok {
+		return "", false
 	}
+	id, _, _ = strings.Cut(id, "?")
+	if version, rest, ok := strings.Cut(id, "/"); ok && len(version) > 1 && version[0] == 'v' && strings.Trim(version[1:], "0123456789") == "" {
+		id = rest
+	}
+	if dot := strings.LastIndex(id, "."); dot > strings.LastIndex(id, "/") {
+		id = id[:dot]
+	}
+	return id, strings.HasPrefix(id, "avatars/")
+}
+
+// images is nil when no storage is configured
+var images ImageStore
 
-	// Get Cloudinary credentials from environment variables (this is set in Vercel environment variables)
-	cloudName := os.Getenv("CLOUD_NAME")
-	apiKey := os.Getenv("CLOUD_API_KEY")
-	apiSecret := os.Getenv("CLOUD_API_SECRET")
+// SetImageStore replaces the image storage, e.g. with a fake in tests
+func SetImageStore(store ImageStore) {
+	images = store
+}
+
+// CloudinaryService holds the Cloudinary client configuration
+type CloudinaryService struct {
+	Cld *cloudinary.Cloudinary
+}
 
-	if cloudName == "" || apiKey == "" || apiSecret == "" {
-		return nil, fmt.Errorf("missing required Cloudinary environment variables")
+// NewCloudinaryService creates a new Cloudinary service instance
+func NewCloudinaryService(cfg config.CloudinaryConfig) (*CloudinaryService, error) {
+	if !cfg.Configured() {
+		return nil, fmt.Errorf("missing required Cloudinary configuration")
 	}
 
 	// Create Cloudinary instance
-	cld, err := cloudinary.NewFromParams(cloudName, apiKey, apiSecret)
+	cld, err := cloudinary.NewFromParams(cfg.CloudName, cfg.APIKey, cfg.APISecret)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize Cloudinary: %v", err)
 	}
@@ -46,7 +91,13 @@ func NewCloudinaryService() (*CloudinaryService, error) {
 }
 
 // UploadImage handles the image upload to Cloudinary
-func (s *CloudinaryService) UploadImage(file *multipart.FileHeader, username string) (string, error) {
+func (s *CloudinaryService) UploadImage(ctx context.Context, file *multipart.FileHeader, username string) (url string, err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "cloudinary.upload", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
 	// Open the uploaded file
 	src, err := file.Open()
 	if err != nil {
@@ -54,9 +105,8 @@ func (s *CloudinaryService) UploadImage(file *multipart.FileHeader, username str
 	}
 	defer src.Close()
 
-	// Sanitize username for use in public_id for access from the URL stored in Supabase
-	sanitizedUsername := strings.ReplaceAll(username, " ", "_")
-	publicID := fmt.Sprintf("avatars/%s_avatar", sanitizedUsername)
+	publicID := avatarID(username)
+	span.SetAttributes(attribute.String("cloudinary.public_id", publicID), attribute.Int64("cloudinary.bytes", file.Size))
 
 	// Set upload parameters
 	uploadParams := uploader.UploadParams{
@@ -68,7 +118,9 @@ func (s *CloudinaryService) UploadImage(file *multipart.FileHeader, username str
 	}
 
 	// Upload the file to Cloudinary
-	uploadResult, err := s.Cld.Upload.Upload(context.Background(), src, uploadParams)
+	start := time.Now()
+	uploadResult, err := s.Cld.Upload.Upload(ctx, src, uploadParams)
+	metrics.ObserveCloudinary("upload", start, err)
 	if err != nil {
 		return "", fmt.Errorf("failed to upload image: %v", err)
 	}
@@ -76,14 +128,27 @@ func (s *CloudinaryService) UploadImage(file *multipart.FileHeader, username str
 	return uploadResult.SecureURL, nil
 }
 
-// DeleteImage removes an image from Cloudinary
-func (s *CloudinaryService) DeleteImage(username string) error {
-	publicID := fmt.Sprintf("avatars/%s_avatar", username)
+// DeleteImage removes the avatar uploaded for username from Cloudinary
+func (s *CloudinaryService) DeleteImage(ctx context.Context, username string) error {
+	return s.DeleteImageByID(ctx, avatarID(username))
+}
+
+// DeleteImageByID removes the image with the given public ID from Cloudinary
+func (s *CloudinaryService) DeleteImageByID(ctx context.Context, publicID string) (err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "cloudinary.destroy",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("cloudinary.public_id", publicID)))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
 
 	// Delete the image
-	_, err := s.Cld.Upload.Destroy(context.Background(), uploader.DestroyParams{
+	start := time.Now()
+	_, err = s.Cld.Upload.Destroy(ctx, uploader.DestroyParams{
 		PublicID: publicID,
 	})
+	metrics.ObserveCloudinary("destroy", start, err)
 
 	if err != nil {
 		return fmt.Errorf("failed to delete image: %v", err)
@@ -92,69 +157,116 @@ func (s *CloudinaryService) DeleteImage(username string) error {
 	return nil
 }
 
-// UploadHandler handles the HTTP request for image upload
+// ListImages lists the avatars in Cloudinary, a page of 500 at a time
+func (s *CloudinaryService) ListImages(ctx context.Context) (stored []StoredImage, err error) {
+	ctx, span := tracing.Tracer.Start(ctx, "cloudinary.list", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() {
+		tracing.RecordError(span, err)
+		span.End()
+	}()
+
+	params := admin.AssetsParams{AssetType: api.Image, DeliveryType: "upload", Prefix: "avatars/", MaxResults: 500}
+	for {
+		start := time.Now()
+		result, err := s.Cld.Admin.Assets(ctx, params)
+		metrics.ObserveCloudinary("list", start, err)
+		if err == nil && result.Error.Message != "" {
+			err = errors.New(result.Error.Message)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list images: %v", err)
+		}
+		for _, asset := range result.Assets {
+			stored = append(stored, StoredImage{PublicID: asset.PublicID, CreatedAt: asset.CreatedAt})
+		}
+		if result.NextCursor == "" {
+			return stored, nil
+		}
+		params.NextCursor = result.NextCursor
+	}
+}
+
+// UploadHandler handles the HTTP request for image upload. Unlike avatar
+// deletion it stays synchronous: the client saves the returned URL as the
+// user's avatar_url, and the multipart file only exists for this request.
 func UploadHandler(c *gin.Context) {
-	// Get the Cloudinary service instance
-	cloudinaryService, err := NewCloudinaryService()
-	if err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to initialize Cloudinary: %v", err)})
+	if images == nil {
+		problem.Respond(c, problem.StorageUnavailable)
 		return
 	}
 
 	// Get the file from the request
 	file, err := c.FormFile("file")
 	if err != nil {
-		c.JSON(400, gin.H{"error": "No file uploaded"})
+		problem.Respond(c, problem.FileMissing.Wrap(err))
 		return
 	}
 
 	// Get username from form data
 	username := c.PostForm("username")
 	if username == "" {
-		c.JSON(400, gin.H{"error": "Username is required"})
+		problem.Respond(c, usernameRequired)
 		return
 	}
 
-	// Check file size (e.g., 5MB limit)
-	if file.Size > 5*1024*1024 {
-		c.JSON(400, gin.H{"error": "File size too large (max 5MB)"})
+	// Check file size against the configured limit
+	if file.Size > uploadMaxBytes {
+		problem.Respond(c, problem.FileTooLarge.WithDetail("File size too large (max %s)", formatBytes(uploadMaxBytes)))
 		return
 	}
 
 	// Check file type
 	if !strings.HasPrefix(file.Header.Get("Content-Type"), "image/") {
-		c.JSON(400, gin.H{"error": "File must be an image"})
+		problem.Respond(c, problem.UnsupportedMediaType)
 		return
 	}
 
 	// Upload the image
-	imageURL, err := cloudinaryService.UploadImage(file, username)
+	imageURL, err := images.UploadImage(c.Request.Context(), file, username)
 	if err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to upload image: %v", err)})
+		problem.Respond(c, problem.UploadFailed.Wrap(err))
 		return
 	}
 
-	c.JSON(200, gin.H{"url": imageURL})
+	c.JSON(http.StatusOK, gin.H{"url": imageURL})
 }
 
 // DeleteImageHandler handles the HTTP request for image deletion (same error handling as above)
 func DeleteImageHandler(c *gin.Context) {
 	username := c.Param("username")
 	if username == "" {
-		c.JSON(400, gin.H{"error": "Username is required"})
+		problem.Respond(c, usernameRequired)
 		return
 	}
 
-	cloudinaryService, err := NewCloudinaryService()
-	if err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to initialize Cloudinary: %v", err)})
+	if images == nil {
+		problem.Respond(c, problem.StorageUnavailable)
 		return
 	}
 
-	if err := cloudinaryService.DeleteImage(username); err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to delete image: %v", err)})
+	if err := images.DeleteImage(c.Request.Context(), username); err != nil {
+		problem.Respond(c, problem.DeleteFailed.Wrap(err))
 		return
 	}
 
-	c.JSON(200, gin.H{"message": "Image deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Image deleted successfully"})
+}
+
+// usernameRequired is returned when an image request names no user
+var usernameRequired = problem.ValidationFailed.WithFields(problem.FieldError{
+	Field:   "username",
+	Code:    "required",
+	Message: "Username is required",
+})
+
+// formatBytes renders a byte count the way users expect to read it, e.g. 5MB
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", n>>10)
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
 }
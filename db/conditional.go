@@ -0,0 +1,116 @@
+package db
+
+import (
+	"backend/problem"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// versionTag is the ETag of a single row. It changes whenever updated_at
+// does, whichever view of the row is served. Microseconds are all Postgres
+// keeps, so that is all the tag covers.
+func versionTag(id uuid.UUID, updatedAt time.Time) string {
+	var micros [8]byte
+	binary.BigEndian.PutUint64(micros[:], uint64(updatedAt.UnixMicro()))
+	hash := sha256.New()
+	hash.Write(id[:])
+	hash.Write(micros[:])
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// updateTime is the updated_at written by handlers that return the new
+// ETag, truncated so the stored value and the tag agree
+func updateTime() time.Time {
+	return time.Now().Truncate(time.Microsecond)
+}
+
+// respondCached writes v as JSON tagged with a hash of the body, or 304
+// when the client already has it
+func respondCached(c *gin.Context, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		problem.Respond(c, problem.Internal.Wrap(err))
+		return
+	}
+	sum := sha256.Sum256(body)
+	respondConditional(c, body, `"`+hex.EncodeToString(sum[:16])+`"`, time.Time{})
+}
+
+// respondVersion writes v, the view of the row id last updated at
+// updatedAt, tagged with its version, or 304 when the client already has it
+func respondVersion(c *gin.Context, v interface{}, id uuid.UUID, updatedAt time.Time) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		problem.Respond(c, problem.Internal.Wrap(err))
+		return
+	}
+	respondConditional(c, body, versionTag(id, updatedAt), updatedAt)
+}
+
+// respondConditional writes body with etag and, unless it is zero,
+// lastModified. It answers 304 instead when If-None-Match matches etag or,
+// without If-None-Match, nothing changed since If-Modified-Since.
+func respondConditional(c *gin.Context, body []byte, etag string, lastModified time.Time) {
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(c.Request, etag, lastModified) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
+
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		return matchTag(header, etag, true)
+	}
+	if header := r.Header.Get("If-Modified-Since"); header != "" && !lastModified.IsZero() {
+		since, err := http.ParseTime(header)
+		return err == nil && !lastModified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+// checkIfMatch reports whether an edit may go ahead: either the request
+// has no If-Match header or one of its tags is the current etag
+func checkIfMatch(c *gin.Context, etag string) error {
+	header := c.GetHeader("If-Match")
+	if header == "" || matchTag(header, etag, false) {
+		return nil
+	}
+	return problem.PreconditionFailed
+}
+
+// matchTag reports whether etag is in header, a comma separated list of
+// entity tags or "*". Weak comparison ignores W/ prefixes, strong
+// comparison never matches a weak tag.
+func matchTag(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if candidate == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
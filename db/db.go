@@ -2,438 +2,823 @@ package db
 
 import (
 	"backend/auth"
+	"backend/broker"
+	"backend/cache"
+	"backend/config"
+	"backend/events"
 	"backend/interfaces"
+	"backend/jobs"
+	"backend/logging"
+	"backend/metrics"
+	"backend/problem"
+	"backend/tracing"
+	"backend/webhooks"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
+	"path"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/joho/godotenv"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var DB *gorm.DB
 
+// uploadMaxBytes is set by Initialize for the image handlers
+var uploadMaxBytes int64
+
+// cached sits in front of the category, tag and post reads; nil when
+// caching is disabled
+var cached *cache.Cache
+
+// Cache names, which are also the hit ratio metric labels
+const (
+	cacheCategories = "categories"
+	cacheTags       = "tags"
+	cachePosts      = "posts"
+)
+
 // Initialize initializes the database connection
-func Initialize() {
-	// Load environment variables from the .env file
-	err := godotenv.Load()
+func Initialize(cfg *config.Config) error {
+	conn, err := Open(cfg.Database.URL)
 	if err != nil {
-		log.Println("Warning: No .env file found. Ensure environment variables are set.")
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	// Set up connection pool settings
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+
+	// Connection pool settings
+	sqlDB.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	sqlDB.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	sqlDB.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime)
+	metrics.RegisterDB(sqlDB)
+
+	if err := Use(conn, cfg); err != nil {
+		return err
 	}
+	slog.Info("Successfully connected to the database")
+	return nil
+}
 
-	// Get database connection URL from the environment
-	databaseURL := os.Getenv("SUPABASE_DATABASE_URL")
-	if databaseURL == "" {
-		log.Fatal("Error: SUPABASE_DATABASE_URL not set in environment")
+// Open connects to the Postgres database at url without making it the
+// connection handlers query. Tests use it for a store on a real Postgres.
+func Open(url string) (*gorm.DB, error) {
+	// TranslateError maps unique and foreign key violations to gorm errors
+	dialector := postgresDialector{postgres.Open(url).(*postgres.Dialector)}
+	return gorm.Open(dialector, &gorm.Config{TranslateError: true})
+}
+
+// Use makes conn the connection every handler queries and applies the rest
+// of cfg. Initialize calls it for Postgres; tests pass their own store.
+func Use(conn *gorm.DB, cfg *config.Config) error {
+	// Every query becomes a child span of the request that issued it
+	if err := conn.Use(tracing.GormPlugin{}); err != nil {
+		return fmt.Errorf("failed to install tracing: %w", err)
+	}
+
+	images = nil
+	if cfg.Cloudinary.Configured() {
+		service, err := NewCloudinaryService(cfg.Cloudinary)
+		if err != nil {
+			return err
+		}
+		images = service
 	}
 
-	// Connect to the database using GORM
-	DB, err = gorm.Open(postgres.Open(databaseURL), &gorm.Config{})
+	c, err := cache.New(cfg.Cache)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		return err
 	}
+	cached = c
 
-	log.Println("Successfully connected to the database!")
+	q, err := jobs.Open(cfg.Jobs, conn)
+	if err != nil {
+		return err
+	}
+	queue = q
 
-	// Set up connection pool settings
-	sqlDB, err := DB.DB()
+	b, err := broker.Open(cfg)
 	if err != nil {
-		log.Fatal("Failed to get database instance:", err)
+		return err
 	}
+	CloseStreams()
+	streams = b
+
+	uploadMaxBytes = cfg.Upload.MaxBytes
+	jobRetention = cfg.Jobs.Retention
+	runHistory = cfg.Scheduler.History
+	eventRetention = cfg.Events.Retention
+	webhookSender = webhooks.NewSender(cfg.Webhooks)
+	webhookAttempts = cfg.Webhooks.MaxAttempts
+	graphLimits = cfg.GraphQL
+	streamHeartbeat = cfg.Stream.Heartbeat
+	streamRetention = cfg.Stream.Retention
+	feedHotDecay = cfg.Feed.HotDecay
+	// Unlike job handlers, which only run for their own jobs, this sees
+	// every event, so it is only subscribed once there is a database
+	events.Subscribe(events.All, "webhooks", queueWebhookDeliveries)
+	for _, eventType := range []string{events.TypeCommentCreated, events.TypeCommentUpdated, events.TypeCommentDeleted} {
+		events.Subscribe(eventType, "comment-streams."+eventType, announceCommentChange)
+	}
+	DB = conn
+	return nil
+}
 
-	// Connection pool settings
-	sqlDB.SetMaxIdleConns(10)
-	sqlDB.SetMaxOpenConns(100)
+// requestDB scopes DB to the request context, so queries are cancelled with
+// the request and traced as part of it
+func requestDB(c *gin.Context) *gorm.DB {
+	return DB.WithContext(c.Request.Context())
+}
+
+// viewer loads the signed in user whose ID auth.AuthMiddleware stored
+func viewer(c *gin.Context) (interfaces.User, error) {
+	var user interfaces.User
+	err := requestDB(c).First(&user, "id = ?", c.GetString("user_id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, problem.InvalidToken.WithDetail("The account for this token no longer exists")
+	}
+	if err != nil {
+		return user, problem.Internal.Wrap(err)
+	}
+	return user, nil
+}
+
+// ownAccount refuses changes to the account id by anyone but its owner
+// or an admin
+func ownAccount(me interfaces.User, id uuid.UUID) error {
+	if me.ID != id && me.Role != interfaces.RoleAdmin {
+		return problem.Forbidden.WithDetail("Only the owner of an account can change it")
+	}
+	return nil
+}
+
+// userView picks how much of user the viewer may see: admins and users
+// themselves get the private fields, everyone else the profile
+func userView(viewer, user interfaces.User) interface{} {
+	switch {
+	case viewer.Role == interfaces.RoleAdmin, viewer.ID == user.ID:
+		return interfaces.NewSelfUser(user)
+	default:
+		return interfaces.NewPublicUser(user)
+	}
+}
+
+// userViews shows users as userView does, except that admins get the
+// admin view with each user's post and comment counts
+func userViews(c *gin.Context, me interfaces.User, users []interfaces.User) ([]interface{}, error) {
+	if me.Role != interfaces.RoleAdmin || len(users) == 0 {
+		return interfaces.MapAll(users, func(user interfaces.User) interface{} {
+			return userView(me, user)
+		}), nil
+	}
+
+	ids := interfaces.MapAll(users, func(user interfaces.User) uuid.UUID { return user.ID })
+	posts, err := countByAuthor(requestDB(c), &interfaces.Post{}, ids)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := countByAuthor(requestDB(c), &interfaces.Comment{}, ids)
+	if err != nil {
+		return nil, err
+	}
+	return interfaces.MapAll(users, func(user interfaces.User) interface{} {
+		return interfaces.NewAdminUser(user, posts[user.ID], comments[user.ID])
+	}), nil
+}
+
+// countByAuthor counts the rows of model written by each of the authors
+func countByAuthor(db *gorm.DB, model interface{}, authorIDs []uuid.UUID) (map[uuid.UUID]int64, error) {
+	var rows []struct {
+		AuthorID uuid.UUID
+		Count    int64
+	}
+	if err := db.Model(model).Select("author_id, COUNT(*) AS count").
+		Where("author_id IN ?", authorIDs).Group("author_id").Scan(&rows).Error; err != nil {
+		return nil, dbError(err, nil, nil, nil)
+	}
+	counts := make(map[uuid.UUID]int64, len(rows))
+	for _, row := range rows {
+		counts[row.AuthorID] = row.Count
+	}
+	return counts, nil
 }
 
 // User handlers
 func CreateUser(c *gin.Context) {
-	var newUser interfaces.User
+	var req interfaces.CreateUserRequest
 
-	if err := c.BindJSON(&newUser); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user data"})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newUser.PasswordHash), bcrypt.DefaultCost)
+	hashedPassword, err := hashPassword(req.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error processing password"})
+		problem.Respond(c, problem.Internal.Wrap(err))
 		return
 	}
 
-	newUser.PasswordHash = string(hashedPassword)
+	newUser := interfaces.User{
+		Username:     req.Username,
+		Email:        req.Email,
+		PasswordHash: hashedPassword,
+		AvatarURL:    req.AvatarURL,
+		Role:         interfaces.RoleStudent, // Admins are only created from the CLI
+	}
 
-	if err := DB.Create(&newUser).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating user"})
+	err = requestDB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&newUser).Error; err != nil {
+			return dbError(err, problem.UserNotFound, userConflict(err), nil)
+		}
+		return recordEvent(tx, events.UserRegistered{
+			UserID:    newUser.ID,
+			Username:  newUser.Username,
+			CreatedAt: newUser.CreatedAt,
+		})
+	})
+	if err != nil {
+		problem.Respond(c, err)
 		return
 	}
 
-	newUser.PasswordHash = "" // Don't send password hash back
-	c.JSON(http.StatusCreated, newUser)
+	c.JSON(http.StatusCreated, interfaces.NewSelfUser(newUser))
 }
 
 func ListUsers(c *gin.Context) {
+	me, err := viewer(c)
+	if err != nil {
+		problem.Respond(c, err)
+		return
+	}
+
 	var users []interfaces.User
 	username := c.Query("username")
 
-	query := DB
+	query := requestDB(c)
 	if username != "" {
 		query = query.Where("username = ?", username)
 	}
 
 	if err := query.Find(&users).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving users"})
+		problem.Respond(c, dbError(err, nil, nil, nil))
 		return
 	}
 
-	c.JSON(http.StatusOK, users)
+	views, err := userViews(c, me, users)
+	if err != nil {
+		problem.Respond(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, views)
 }
 
 func GetUser(c *gin.Context) {
-	id := c.Param("id")
-	var user interfaces.User
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	me, err := viewer(c)
+	if err != nil {
+		problem.Respond(c, err)
+		return
+	}
 
-	if err := DB.First(&user, "id = ?", id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	var user interfaces.User
+	if err := requestDB(c).First(&user, "id = ?", id).Error; err != nil {
+		problem.Respond(c, dbError(err, problem.UserNotFound, nil, nil))
 		return
 	}
 
-	c.JSON(http.StatusOK, user)
+	// The tag is for If-Match on UpdateUser. The body depends on who asks,
+	// so it isn't used to answer 304s.
+	views, err := userViews(c, me, []interfaces.User{user})
+	if err != nil {
+		problem.Respond(c, err)
+		return
+	}
+	c.Header("ETag", versionTag(user.ID, user.UpdatedAt))
+	c.JSON(http.StatusOK, views[0])
 }
 
 func GetUserPost(c *gin.Context) {
 	// Get the user ID from the URL parameter
-	userID := c.Param("id")
+	userID, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
 
 	// Query all posts for this user
 	var posts []interfaces.Post
-	if err := DB.Where("author_id = ?", userID).Find(&posts).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Failed to fetch user posts",
-		})
+	if err := requestDB(c).Where("author_id = ?", userID).Find(&posts).Error; err != nil {
+		problem.Respond(c, dbError(err, nil, nil, nil))
 		return
 	}
 
-	c.JSON(http.StatusOK, posts)
+	c.JSON(http.StatusOK, interfaces.MapAll(posts, interfaces.NewPostResponse))
 }
 
 func DeleteUser(c *gin.Context) {
-	id := c.Param("id")
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	me, err := viewer(c)
+	if err != nil {
+		problem.Respond(c, err)
+		return
+	}
+	if err := ownAccount(me, id); err != nil {
+		problem.Respond(c, err)
+		return
+	}
 
-	result := DB.Delete(&interfaces.User{}, id)
+	var user interfaces.User
+	if err := requestDB(c).First(&user, "id = ?", id).Error; err != nil {
+		problem.Respond(c, dbError(err, problem.UserNotFound, nil, nil))
+		return
+	}
+
+	result := requestDB(c).Delete(&user)
+	if result.Error != nil {
+		problem.Respond(c, dbError(result.Error, nil, nil, problem.Conflict.WithDetail("User still has posts or comments")))
+		return
+	}
 	if result.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		problem.Respond(c, problem.UserNotFound)
 		return
 	}
 
+	// Storage cleanup is slow and may fail, so it runs in the background
+	if user.AvatarURL != "" {
+		if _, err := queue.Enqueue(c.Request.Context(), deleteAvatarJob, deleteAvatarPayload{Username: user.Username}); err != nil {
+			logging.From(c).Error("queueing avatar deletion failed", "username", user.Username, "error", err)
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
 }
 
 func Login(c *gin.Context) {
 	var authUser interfaces.AuthenticateUser
 
-	if err := c.BindJSON(&authUser); err != nil {
-		c.JSON(400, gin.H{"error": "Invalid login data"})
+	if !bindJSON(c, &authUser) {
 		return
 	}
 
 	// Find user by username
 	var user interfaces.User
-	result := DB.Where("username = ?", authUser.Username).First(&user)
+	result := requestDB(c).Where("username = ?", authUser.Username).First(&user)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		logging.From(c).Warn("login failed", "username", authUser.Username, "error", result.Error)
+		metrics.ObserveLogin(false)
+		problem.Respond(c, problem.InvalidCredentials)
+		return
+	}
 	if result.Error != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		problem.Respond(c, dbError(result.Error, nil, nil, nil))
 		return
 	}
 
 	// Verify password
 	err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(authUser.PasswordHash))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		logging.From(c).Warn("login failed", "username", authUser.Username, "error", "password mismatch")
+		metrics.ObserveLogin(false)
+		problem.Respond(c, problem.InvalidCredentials)
 		return
 	}
 
 	// Create JWT token
-	tokenString, err := auth.CreateToken(user.Username)
+	tokenString, err := auth.CreateToken(user.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating token"})
+		problem.Respond(c, problem.Internal.Wrap(err))
 		return
 	}
 
 	// Set secure cookie
-	c.SetCookie(
-		"token",     // name
-		tokenString, // value
-		60*60*24*30, // maxAge (30 days in seconds)
-		"/",         // path
-		"",          // domain (empty = current domain)
-		true,        // secure
-		true,        // httpOnly
-	)
+	auth.SetTokenCookie(c, tokenString)
+	metrics.ObserveLogin(true)
 
 	// Also return token in response for client-side storage
 	c.JSON(http.StatusOK, gin.H{
 		"token": tokenString,
-		"user": gin.H{
-			"id":         user.ID,
-			"username":   user.Username,
-			"email":      user.Email,
-			"avatar_url": user.AvatarURL,
-		},
+		"user":  interfaces.NewSelfUser(user),
 	})
 }
 
 func Logout(c *gin.Context) {
 	// Clear the cookie by setting maxAge to -1
-	c.SetCookie(
-		"token", // name
-		"",      // value
-		-1,      // maxAge
-		"/",     // path
-		"",      // domain
-		true,    // secure
-		true,    // httpOnly
-	)
+	auth.ClearTokenCookie(c)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Successfully logged out",
 	})
 }
 
-// Add these functions to your db/db.go file
-
 func SyncToken(c *gin.Context) {
 	var tokenData struct {
-		Token string `json:"token"`
+		Token string `json:"token" binding:"required"`
 	}
 
-	if err := c.BindJSON(&tokenData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token data"})
+	if !bindJSON(c, &tokenData) {
 		return
 	}
 
 	// Verify the token is valid
 	_, err := auth.VerifyToken(tokenData.Token)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		problem.Respond(c, problem.InvalidToken.Wrap(err))
 		return
 	}
 
 	// Set the cookie with the token
-	c.SetCookie(
-		"token",
-		tokenData.Token,
-		60*60*24*30, // 30 days
-		"/",
-		"",
-		true,
-		true,
-	)
+	auth.SetTokenCookie(c, tokenData.Token)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Token synchronized successfully"})
 }
 
 func UpdateUser(c *gin.Context) {
-	id := c.Param("id")
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
 	var req interfaces.UpdateUserRequest
+	if !bindJSON(c, &req) {
+		return
+	}
 
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user data"})
+	me, err := viewer(c)
+	if err != nil {
+		problem.Respond(c, err)
+		return
+	}
+	if err := ownAccount(me, id); err != nil {
+		problem.Respond(c, err)
 		return
 	}
 
-	// Only update specific fields
-	result := DB.Model(&interfaces.User{}).
-		Where("id = ?", id).
-		Updates(map[string]interface{}{
+	var etag string
+	err = requestDB(c).Transaction(func(tx *gorm.DB) error {
+		// Lock the row so no other edit lands between the If-Match check and ours
+		var user interfaces.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "updated_at").First(&user, "id = ?", id).Error; err != nil {
+			return dbError(err, problem.UserNotFound, nil, nil)
+		}
+		if err := checkIfMatch(c, versionTag(user.ID, user.UpdatedAt)); err != nil {
+			return err
+		}
+
+		// Only update specific fields
+		updatedAt := updateTime()
+		if err := tx.Model(&user).Updates(map[string]interface{}{
 			"username":   req.Username,
 			"email":      req.Email,
 			"avatar_url": req.AvatarURL,
-		})
-
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
-		return
-	}
-
-	if result.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			"updated_at": updatedAt,
+		}).Error; err != nil {
+			return dbError(err, nil, userConflict(err), nil)
+		}
+		etag = versionTag(user.ID, updatedAt)
+		return nil
+	})
+	if err != nil {
+		problem.Respond(c, err)
 		return
 	}
 
+	c.Header("ETag", etag)
 	c.JSON(http.StatusOK, gin.H{"message": "User updated successfully"})
 }
 
 // Post handlers
 func CreatePost(c *gin.Context) {
-	var post interfaces.Post
+	var req interfaces.CreatePostRequest
 
-	if err := c.BindJSON(&post); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post data"})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	if err := DB.Create(&post).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating post"})
+	post, err := insertPost(c.Request.Context(), req)
+	if err != nil {
+		problem.Respond(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, post)
+	c.JSON(http.StatusCreated, interfaces.NewPostResponse(post))
+}
+
+// insertPost creates a post and records its event. The GraphQL createPost
+// mutation shares it with CreatePost.
+func insertPost(ctx context.Context, req interfaces.CreatePostRequest) (interfaces.Post, error) {
+	post := interfaces.Post{
+		Title:      req.Title,
+		Content:    req.Content,
+		AuthorID:   req.AuthorID,
+		CategoryID: req.CategoryID,
+	}
+
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&post).Error; err != nil {
+			return dbError(err, nil, problem.Conflict, problem.ReferenceUnknown.WithDetail("author_id or category_id does not exist"))
+		}
+		if err := writePostStats(tx, post.ID); err != nil {
+			return dbError(err, nil, nil, nil)
+		}
+		return recordEvent(tx, events.PostCreated{
+			PostID:     post.ID,
+			AuthorID:   post.AuthorID,
+			CategoryID: post.CategoryID,
+			Title:      post.Title,
+			Content:    post.Content,
+			CreatedAt:  post.CreatedAt,
+		})
+	})
+	return post, err
 }
 
 func GetPost(c *gin.Context) {
-	postID, err := uuid.Parse(c.Param("id"))
+	postID, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	post, err := cache.Fetch(c.Request.Context(), cached, cachePosts, postID.String(), func() (interfaces.Post, error) {
+		var post interfaces.Post
+		err := requestDB(c).First(&post, "id = ?", postID).Error
+		return post, err
+	})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID"})
+		problem.Respond(c, dbError(err, problem.PostNotFound, nil, nil))
 		return
 	}
 
-	var post interfaces.Post
-	if err := DB.First(&post, "id = ?", postID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+	respondVersion(c, interfaces.NewPostResponse(post), post.ID, post.UpdatedAt)
+}
+
+// ListCategoryPosts lists the posts in the named category newest first, a
+// page at a time. Each page ends with the cursor of the next, which
+// carries on from the last post sent, so posts published meanwhile never
+// shift later pages.
+func ListCategoryPosts(c *gin.Context) {
+	params, ok := readPageParams(c)
+	if !ok {
+		return
+	}
+	var after *postCursor
+	if params.cursor != "" {
+		after = &postCursor{}
+		if !decodeCursor(c, params.cursor, after) {
+			return
+		}
+	}
+
+	var category interfaces.Category
+	if err := requestDB(c).Where("name = ?", c.Param("category")).First(&category).Error; err != nil {
+		problem.Respond(c, dbError(err, problem.CategoryNotFound, nil, nil))
+		return
+	}
+
+	posts, next, err := newestPosts(requestDB(c).Where("category_id = ?", category.ID), after, params.limit)
+	if err != nil {
+		problem.Respond(c, dbError(err, nil, nil, nil))
 		return
 	}
+	page := interfaces.Page[interfaces.PostResponse]{
+		Items:      interfaces.MapAll(posts, interfaces.NewPostResponse),
+		NextCursor: next,
+	}
+	if params.includeTotal {
+		var total int64
+		if err := requestDB(c).Model(&interfaces.Post{}).Where("category_id = ?", category.ID).Count(&total).Error; err != nil {
+			problem.Respond(c, dbError(err, nil, nil, nil))
+			return
+		}
+		page.Total = &total
+	}
 
-	c.JSON(http.StatusOK, post)
+	c.JSON(http.StatusOK, page)
 }
 
+// ListPostsByCategory is the offset paginated listing older clients use.
+// Pages shift as posts are published, so it points them at
+// ListCategoryPosts.
 func ListPostsByCategory(c *gin.Context) {
 	category := c.Param("category")
 	pageIndex := c.Param("pageIndex")
 
 	// Convert pageIndex string to int
 	page, err := strconv.Atoi(pageIndex)
+	if err != nil || page < 0 {
+		problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+			Field:   "pageIndex",
+			Code:    "invalid_integer",
+			Message: "must be a non-negative integer",
+		}))
+		return
+	}
+
+	posts, err := categoryPage(c.Request.Context(), category, page)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page index"})
+		problem.Respond(c, err)
 		return
 	}
 
+	c.Header("Deprecation", "true")
+	c.Header("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", path.Dir(c.Request.URL.EscapedPath())))
+	c.JSON(http.StatusOK, interfaces.MapAll(posts, interfaces.NewPostResponse))
+}
+
+// categoryPage lists one page of the named category's posts, newest first
+func categoryPage(ctx context.Context, category string, page int) ([]interfaces.Post, error) {
 	// Define items per page
 	const itemsPerPage = 10
 	offset := page * itemsPerPage
 
 	var categoryRecord interfaces.Category
-	if err := DB.Where("name = ?", category).First(&categoryRecord).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
-		return
+	if err := DB.WithContext(ctx).Where("name = ?", category).First(&categoryRecord).Error; err != nil {
+		return nil, dbError(err, problem.CategoryNotFound, nil, nil)
 	}
 
 	var posts []interfaces.Post
-	if err := DB.Order("created_at DESC").
+	if err := DB.WithContext(ctx).Order("created_at DESC").
 		Where("category_id = ?", categoryRecord.ID).
 		Limit(itemsPerPage). // Limit to 10 items
 		Offset(offset).      // Skip previous pages
 		Find(&posts).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No posts found in this category"})
-		return
+		return nil, dbError(err, nil, nil, nil)
 	}
-
-	c.JSON(http.StatusOK, posts)
+	return posts, nil
 }
 
 func UpdatePost(c *gin.Context) {
-	postID, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID"})
+	postID, ok := parseID(c, "id")
+	if !ok {
 		return
 	}
 
 	var req interfaces.UpdatePostRequest
-	if err := c.BindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post data"})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	// Only update specific fields
-	result := DB.Model(&interfaces.Post{}).
-		Where("id = ?", postID).
-		Updates(map[string]interface{}{
-			"title":   req.Title,
-			"content": req.Content,
-		})
-
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update post"})
-		return
-	}
-
-	if result.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+	etag, err := editPost(c.Request.Context(), postID, req, func(etag string) error {
+		return checkIfMatch(c, etag)
+	})
+	if err != nil {
+		problem.Respond(c, err)
 		return
 	}
 
+	c.Header("ETag", etag)
 	c.JSON(http.StatusOK, gin.H{"message": "Post updated successfully"})
 }
 
-func DeletePost(c *gin.Context) {
-	postID, err := uuid.Parse(c.Param("id"))
+// editPost replaces the title and content of a post and returns its new
+// version tag. check, when not nil, is given the current tag first and
+// can refuse the edit.
+func editPost(ctx context.Context, postID uuid.UUID, req interfaces.UpdatePostRequest, check func(etag string) error) (string, error) {
+	var etag string
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Lock the row so no other edit lands between the If-Match check and ours
+		var post interfaces.Post
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "updated_at").First(&post, "id = ?", postID).Error; err != nil {
+			return dbError(err, problem.PostNotFound, nil, nil)
+		}
+		if check != nil {
+			if err := check(versionTag(post.ID, post.UpdatedAt)); err != nil {
+				return err
+			}
+		}
+
+		// Only update specific fields
+		updatedAt := updateTime()
+		if err := tx.Model(&post).Updates(map[string]interface{}{
+			"title":      req.Title,
+			"content":    req.Content,
+			"updated_at": updatedAt,
+		}).Error; err != nil {
+			return dbError(err, nil, nil, nil)
+		}
+		etag = versionTag(post.ID, updatedAt)
+		return nil
+	})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID"})
+		return "", err
+	}
+	cached.Invalidate(ctx, cachePosts, postID.String())
+	return etag, nil
+}
+
+func DeletePost(c *gin.Context) {
+	postID, ok := parseID(c, "id")
+	if !ok {
 		return
 	}
 
-	result := DB.Delete(&interfaces.Post{}, postID)
-	if result.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Post not found"})
+	if err := removePost(c.Request.Context(), postID); err != nil {
+		problem.Respond(c, err)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Post deleted successfully"})
 }
 
+// removePost deletes a post and records its event
+func removePost(ctx context.Context, postID uuid.UUID) error {
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The event needs the author and category of the post being deleted
+		var post interfaces.Post
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "author_id", "category_id").First(&post, "id = ?", postID).Error; err != nil {
+			return dbError(err, problem.PostNotFound, nil, nil)
+		}
+		if err := tx.Delete(&post).Error; err != nil {
+			return dbError(err, nil, nil, problem.Conflict.WithDetail("Post still has comments or tags"))
+		}
+		if err := writePostStats(tx, post.ID); err != nil {
+			return dbError(err, nil, nil, nil)
+		}
+		return recordEvent(tx, events.PostDeleted{
+			PostID:     post.ID,
+			AuthorID:   post.AuthorID,
+			CategoryID: post.CategoryID,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	cached.Invalidate(ctx, cachePosts, postID.String())
+	return nil
+}
+
 // Tag handlers
 func ListTags(c *gin.Context) {
-	var tags []interfaces.Tag
-
-	if err := DB.Find(&tags).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving tags"})
+	tags, err := allTags(c.Request.Context())
+	if err != nil {
+		problem.Respond(c, dbError(err, nil, nil, nil))
 		return
 	}
 
-	c.JSON(http.StatusOK, tags)
+	respondCached(c, interfaces.MapAll(tags, interfaces.NewTagResponse))
+}
+
+// allTags lists every tag by name. A stable order keeps the ETag stable.
+func allTags(ctx context.Context) ([]interfaces.Tag, error) {
+	return cache.Fetch(ctx, cached, cacheTags, "all", func() ([]interfaces.Tag, error) {
+		var tags []interfaces.Tag
+		err := DB.WithContext(ctx).Order("name").Find(&tags).Error
+		return tags, err
+	})
 }
 
 func GetTag(c *gin.Context) {
-	id := c.Param("id")
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
 
 	var tag interfaces.Tag
-	result := DB.First(&tag, "id = ?", id)
-	if result.Error != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+	if err := requestDB(c).First(&tag, "id = ?", id).Error; err != nil {
+		problem.Respond(c, dbError(err, problem.TagNotFound, nil, nil))
 		return
 	}
 
-	c.JSON(http.StatusOK, tag)
+	c.JSON(http.StatusOK, interfaces.NewTagResponse(tag))
 }
 
 func CreatePostTag(c *gin.Context) {
-	postID, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID"})
+	postID, ok := parseID(c, "id")
+	if !ok {
 		return
 	}
 
-	var tags []interfaces.Tag
-	if err := c.BindJSON(&tags); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag data"})
+	var tags []interfaces.TagRef
+	if !bindJSON(c, &tags) {
 		return
 	}
 
 	var post interfaces.Post
-	if err := DB.First(&post, "id = ?", postID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching post"})
+	if err := requestDB(c).First(&post, "id = ?", postID).Error; err != nil {
+		problem.Respond(c, dbError(err, problem.PostNotFound, nil, nil))
 		return
 	}
 
 	for _, tag := range tags {
 		var tagRecord interfaces.Tag
-		if err := DB.First(&tagRecord, "name = ?", tag.Name).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding tag"})
+		if err := requestDB(c).First(&tagRecord, "name = ?", tag.Name).Error; err != nil {
+			problem.Respond(c, dbError(err, problem.TagUnknown.WithDetail("Tag %q does not exist", tag.Name), nil, nil))
 			return
 		}
 
@@ -443,8 +828,8 @@ func CreatePostTag(c *gin.Context) {
 			TagID:  tagRecord.ID,
 		}
 
-		if err := DB.Create(&postTag).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Error creating post tag: %v", err)})
+		if err := requestDB(c).Create(&postTag).Error; err != nil {
+			problem.Respond(c, dbError(err, nil, problem.PostTagExists.WithDetail("Post already has tag %q", tag.Name), nil))
 			return
 		}
 	}
@@ -453,36 +838,45 @@ func CreatePostTag(c *gin.Context) {
 }
 
 func ListPostTags(c *gin.Context) {
-	postID, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID"})
+	postID, ok := parseID(c, "id")
+	if !ok {
 		return
 	}
 
 	var tags []interfaces.Tag
 
-	if result := DB.Joins("JOIN posts_tags ON posts_tags.tag_id = tags.id").
+	if result := requestDB(c).Joins("JOIN posts_tags ON posts_tags.tag_id = tags.id").
 		Where("posts_tags.post_id = ?", postID).
 		Find(&tags); result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving tags"})
+		problem.Respond(c, dbError(result.Error, nil, nil, nil))
 		return
 	}
 
-	c.JSON(http.StatusOK, tags)
+	c.JSON(http.StatusOK, interfaces.MapAll(tags, interfaces.NewTagResponse))
 }
 
 func DeletePostTag(c *gin.Context) {
-	postID, _ := uuid.Parse(c.Param("id"))
-	tagID, _ := uuid.Parse(c.Param("tag_id"))
+	postID, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	tagID, ok := parseID(c, "tag_id")
+	if !ok {
+		return
+	}
 
 	postTag := interfaces.PostTag{
 		PostID: postID,
 		TagID:  tagID,
 	}
 
-	result := DB.Delete(&postTag)
+	result := requestDB(c).Delete(&postTag)
+	if result.Error != nil {
+		problem.Respond(c, dbError(result.Error, nil, nil, nil))
+		return
+	}
 	if result.RowsAffected == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found on post"})
+		problem.Respond(c, problem.PostTagNotFound)
 		return
 	}
 
@@ -491,74 +885,235 @@ func DeletePostTag(c *gin.Context) {
 
 // Comment handlers
 func ListPostComments(c *gin.Context) {
-	postID, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid post ID"})
+	postID, ok := parseID(c, "id")
+	if !ok {
 		return
 	}
 
 	var comments []interfaces.Comment
-	if err := DB.Where("post_id = ?", postID).Find(&comments).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving comments"})
+	if err := requestDB(c).Where("post_id = ?", postID).Order("created_at, id").Find(&comments).Error; err != nil {
+		problem.Respond(c, dbError(err, nil, nil, nil))
 		return
 	}
 
-	c.JSON(http.StatusOK, comments)
+	respondCached(c, interfaces.MapAll(comments, interfaces.NewCommentResponse))
 }
 
 func CreateComment(c *gin.Context) {
-	postID := c.Param("id")
-	var newComment interfaces.CommentInput
+	postID, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
 
-	// Check if post exists
-	var post interfaces.Post
-	if err := DB.First(&post, "id = ?", postID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error fetching post"})
+	var newComment interfaces.CommentInput
+	if !bindJSON(c, &newComment) {
 		return
 	}
 
-	if err := c.BindJSON(&newComment); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment data"})
+	comment, err := insertComment(c.Request.Context(), postID, newComment)
+	if err != nil {
+		problem.Respond(c, err)
 		return
 	}
 
+	c.JSON(http.StatusCreated, interfaces.NewCommentResponse(comment))
+}
+
+// insertComment adds a comment to a post, records its change and event,
+// and tells the post's open streams
+func insertComment(ctx context.Context, postID uuid.UUID, input interfaces.CommentInput) (interfaces.Comment, error) {
 	comment := interfaces.Comment{
-		Content:  newComment.Content,
-		AuthorID: newComment.AuthorID,
-		PostID:   post.ID,
+		Content:  input.Content,
+		AuthorID: input.AuthorID,
+		PostID:   postID,
+	}
+
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		post, err := lockPost(tx, postID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(&comment).Error; err != nil {
+			return dbError(err, nil, nil, problem.AuthorUnknown)
+		}
+		if err := recordCommentChange(tx, events.TypeCommentCreated, comment); err != nil {
+			return err
+		}
+		if err := writePostStats(tx, post.ID); err != nil {
+			return dbError(err, nil, nil, nil)
+		}
+		return recordEvent(tx, events.CommentCreated{
+			CommentID:  comment.ID,
+			PostID:     post.ID,
+			AuthorID:   comment.AuthorID,
+			CategoryID: post.CategoryID,
+			Content:    comment.Content,
+			CreatedAt:  comment.CreatedAt,
+		})
+	})
+	if err == nil {
+		notifyCommentStreams(ctx, postID, events.TypeCommentCreated)
+	}
+	return comment, err
+}
+
+func UpdateComment(c *gin.Context) {
+	postID, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	commentID, ok := parseID(c, "comment_id")
+	if !ok {
+		return
+	}
+
+	var req interfaces.UpdateCommentRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+
+	me, err := viewer(c)
+	if err != nil {
+		problem.Respond(c, err)
+		return
+	}
+
+	var comment interfaces.Comment
+	err = requestDB(c).Transaction(func(tx *gorm.DB) error {
+		post, err := lockPost(tx, postID)
+		if err != nil {
+			return err
+		}
+		comment, err = ownComment(tx, me, postID, commentID)
+		if err != nil {
+			return err
+		}
+
+		comment.Content = req.Content
+		comment.UpdatedAt = updateTime()
+		if err := tx.Model(&comment).Updates(map[string]interface{}{
+			"content":    comment.Content,
+			"updated_at": comment.UpdatedAt,
+		}).Error; err != nil {
+			return dbError(err, nil, nil, nil)
+		}
+		if err := recordCommentChange(tx, events.TypeCommentUpdated, comment); err != nil {
+			return err
+		}
+		return recordEvent(tx, events.CommentUpdated{
+			CommentID:  comment.ID,
+			PostID:     post.ID,
+			AuthorID:   comment.AuthorID,
+			CategoryID: post.CategoryID,
+			Content:    comment.Content,
+			UpdatedAt:  comment.UpdatedAt,
+		})
+	})
+	if err != nil {
+		problem.Respond(c, err)
+		return
+	}
+	notifyCommentStreams(c.Request.Context(), postID, events.TypeCommentUpdated)
+
+	c.JSON(http.StatusOK, interfaces.NewCommentResponse(comment))
+}
+
+func DeleteComment(c *gin.Context) {
+	postID, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	commentID, ok := parseID(c, "comment_id")
+	if !ok {
+		return
 	}
 
-	if err := DB.Create(&comment).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating comment"})
+	me, err := viewer(c)
+	if err != nil {
+		problem.Respond(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, comment)
+	err = requestDB(c).Transaction(func(tx *gorm.DB) error {
+		post, err := lockPost(tx, postID)
+		if err != nil {
+			return err
+		}
+		comment, err := ownComment(tx, me, postID, commentID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&comment).Error; err != nil {
+			return dbError(err, nil, nil, nil)
+		}
+		if err := recordCommentChange(tx, events.TypeCommentDeleted, comment); err != nil {
+			return err
+		}
+		if err := writePostStats(tx, post.ID); err != nil {
+			return dbError(err, nil, nil, nil)
+		}
+		return recordEvent(tx, events.CommentDeleted{
+			CommentID:  comment.ID,
+			PostID:     post.ID,
+			AuthorID:   comment.AuthorID,
+			CategoryID: post.CategoryID,
+		})
+	})
+	if err != nil {
+		problem.Respond(c, err)
+		return
+	}
+	notifyCommentStreams(c.Request.Context(), postID, events.TypeCommentDeleted)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Comment deleted successfully"})
+}
+
+// ownComment loads a comment on a post for me to change, which only its
+// author or an admin may
+func ownComment(tx *gorm.DB, me interfaces.User, postID, commentID uuid.UUID) (interfaces.Comment, error) {
+	var comment interfaces.Comment
+	if err := tx.First(&comment, "id = ? AND post_id = ?", commentID, postID).Error; err != nil {
+		return comment, dbError(err, problem.CommentNotFound, nil, nil)
+	}
+	if comment.AuthorID != me.ID && me.Role != interfaces.RoleAdmin {
+		return comment, problem.Forbidden.WithDetail("Only the author of a comment can change it")
+	}
+	return comment, nil
 }
 
 // Category handlers
 func ListCategories(c *gin.Context) {
-	var categories []interfaces.Category
-
-	if err := DB.Find(&categories).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error retrieving categories"})
+	categories, err := allCategories(c.Request.Context())
+	if err != nil {
+		problem.Respond(c, dbError(err, nil, nil, nil))
 		return
 	}
 
-	c.JSON(http.StatusOK, categories)
+	respondCached(c, interfaces.MapAll(categories, interfaces.NewCategoryResponse))
+}
+
+// allCategories lists every category by name
+func allCategories(ctx context.Context) ([]interfaces.Category, error) {
+	return cache.Fetch(ctx, cached, cacheCategories, "all", func() ([]interfaces.Category, error) {
+		var categories []interfaces.Category
+		err := DB.WithContext(ctx).Order("name").Find(&categories).Error
+		return categories, err
+	})
 }
 
 func GetCategory(c *gin.Context) {
 	// Get the ID parameter
-	id := c.Param("id")
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
 
 	var category interfaces.Category
-
-	result := DB.First(&category, "id = ?", id)
-	if result.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+	if err := requestDB(c).First(&category, "id = ?", id).Error; err != nil {
+		problem.Respond(c, dbError(err, problem.CategoryNotFound, nil, nil))
 		return
 	}
 
-	c.JSON(http.StatusOK, category)
+	c.JSON(http.StatusOK, interfaces.NewCategoryResponse(category))
 }
@@ -0,0 +1,84 @@
+package db
+
+import (
+	"backend/problem"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// postgresDialector is the Postgres dialector with translated errors that
+// still wrap the driver error, so a unique violation names its constraint
+type postgresDialector struct {
+	*postgres.Dialector
+}
+
+func (d postgresDialector) Translate(err error) error {
+	return KeepCause(d.Dialector.Translate(err), err)
+}
+
+// KeepCause joins an error translated by a dialector with the driver error
+// it came from. errors.Is still matches the GORM error, and the message
+// keeps the driver's detail, such as the name of a violated constraint.
+func KeepCause(translated, cause error) error {
+	if translated == cause {
+		return cause
+	}
+	return fmt.Errorf("%w: %w", translated, cause)
+}
+
+// parseID reads the named path parameter as a UUID, responding with a
+// problem and returning false when it isn't one
+func parseID(c *gin.Context, name string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param(name))
+	if err != nil {
+		problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+			Field:   name,
+			Code:    "invalid_uuid",
+			Message: "must be a UUID",
+		}))
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// dbError maps a GORM error to a problem: missing rows become notFound,
+// unique violations become conflict and foreign key violations become
+// invalidRef. Anything else, or a nil template, is an internal error.
+func dbError(err error, notFound, conflict, invalidRef *problem.Problem) error {
+	var mapped *problem.Problem
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		mapped = notFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		mapped = conflict
+	case errors.Is(err, gorm.ErrForeignKeyViolated):
+		mapped = invalidRef
+	}
+
+	if mapped == nil {
+		return problem.Internal.Wrap(err)
+	}
+	return mapped.Wrap(err)
+}
+
+// emailConstraints are the names the unique constraint on users.email may
+// have: GORM calls it uni_users_email, and tables it created before 1.25.6
+// have Postgres' own users_email_key
+var emailConstraints = []string{"uni_users_email", "users_email_key"}
+
+// userConflict picks the problem for a unique violation on users from the
+// constraint it names, which is the email column's or the username's
+func userConflict(err error) *problem.Problem {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && slices.Contains(emailConstraints, pgErr.ConstraintName) {
+		return problem.EmailTaken
+	}
+	return problem.UsernameTaken
+}
@@ -0,0 +1,17 @@
+package db
+
+import (
+	"backend/events"
+	"backend/problem"
+
+	"gorm.io/gorm"
+)
+
+// recordEvent adds event to the outbox through tx. A failure rolls back
+// the write the event describes.
+func recordEvent(tx *gorm.DB, event events.Event) error {
+	if err := events.Record(tx, event); err != nil {
+		return problem.Internal.Wrap(err)
+	}
+	return nil
+}
@@ -0,0 +1,343 @@
+package db
+
+import (
+	"backend/interfaces"
+	"backend/logging"
+	"backend/problem"
+	"context"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// feedHotDecay is FEED_HOT_DECAY; set by Use
+var feedHotDecay time.Duration
+
+// hotEpoch is when hot scores start counting age from. Only differences
+// between scores matter, so any fixed instant will do.
+var hotEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// statsBatch is how many posts the refresh task recomputes at a time
+const statsBatch = 500
+
+// Feed sort orders
+const (
+	feedNew    = "new"
+	feedTop    = "top"
+	feedHot    = "hot"
+	feedActive = "active"
+)
+
+// feedWindows are how far back the top sort looks; all has no limit
+var feedWindows = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+	"all":   0,
+}
+
+// hotScore ranks a post for the hot sort. Each HotDecay of age costs as
+// much as a tenfold score, and since age is counted from a fixed epoch
+// rather than from now, the score only changes when the post's does.
+func hotScore(score int, created time.Time) float64 {
+	return math.Log10(1+float64(score)) + created.Sub(hotEpoch).Seconds()/feedHotDecay.Seconds()
+}
+
+// refreshPostStats recomputes the stats of the given posts and removes
+// those of posts that no longer exist
+func refreshPostStats(ctx context.Context, postIDs []uuid.UUID) error {
+	if len(postIDs) == 0 {
+		return nil
+	}
+	return DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return writePostStats(tx, postIDs...)
+	})
+}
+
+// writePostStats recomputes the stats of the given posts through db. Post
+// and comment writes call it in their own transaction, so the feed never
+// waits for the event dispatcher or the hourly refresh, neither of which
+// the Vercel handler runs.
+func writePostStats(db *gorm.DB, postIDs ...uuid.UUID) error {
+	var posts []interfaces.Post
+	if err := db.Select("id", "author_id", "created_at").Where("id IN ?", postIDs).Find(&posts).Error; err != nil {
+		return err
+	}
+	var comments []interfaces.Comment
+	if err := db.Select("post_id", "author_id", "created_at").Where("post_id IN ?", postIDs).Find(&comments).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	stats := make(map[uuid.UUID]*interfaces.PostStats, len(posts))
+	authors := make(map[uuid.UUID]uuid.UUID, len(posts))
+	for _, post := range posts {
+		stats[post.ID] = &interfaces.PostStats{
+			PostID:         post.ID,
+			CreatedAt:      post.CreatedAt,
+			LastActivityAt: post.CreatedAt,
+			RefreshedAt:    now,
+		}
+		authors[post.ID] = post.AuthorID
+	}
+	for _, comment := range comments {
+		s, ok := stats[comment.PostID]
+		if !ok {
+			continue
+		}
+		s.CommentCount++
+		if comment.AuthorID != authors[comment.PostID] {
+			s.Score++
+		}
+		if comment.CreatedAt.After(s.LastActivityAt) {
+			s.LastActivityAt = comment.CreatedAt
+		}
+	}
+
+	rows := make([]interfaces.PostStats, 0, len(stats))
+	for _, s := range stats {
+		s.Hot = hotScore(s.Score, s.CreatedAt)
+		rows = append(rows, *s)
+	}
+	if len(rows) > 0 {
+		if err := db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&rows).Error; err != nil {
+			return err
+		}
+	}
+	if len(rows) < len(postIDs) {
+		var gone []uuid.UUID
+		for _, id := range postIDs {
+			if _, ok := stats[id]; !ok {
+				gone = append(gone, id)
+			}
+		}
+		return db.Where("post_id IN ?", gone).Delete(&interfaces.PostStats{}).Error
+	}
+	return nil
+}
+
+// refreshAllPostStats recomputes every post's stats, catching up on missed
+// events and on a changed FEED_HOT_DECAY
+func refreshAllPostStats(ctx context.Context) error {
+	db := DB.WithContext(ctx)
+	refreshed := 0
+	var after *uuid.UUID
+	for {
+		query := db.Model(&interfaces.Post{}).Order("id").Limit(statsBatch)
+		if after != nil {
+			query = query.Where("id > ?", *after)
+		}
+		var ids []uuid.UUID
+		if err := query.Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if err := refreshPostStats(ctx, ids); err != nil {
+			return err
+		}
+		refreshed += len(ids)
+		if len(ids) < statsBatch {
+			break
+		}
+		after = &ids[len(ids)-1]
+	}
+
+	result := db.Where("NOT EXISTS (SELECT 1 FROM posts WHERE posts.id = post_stats.post_id)").Delete(&interfaces.PostStats{})
+	if result.Error != nil {
+		return result.Error
+	}
+	logging.FromContext(ctx).Info("refreshed post stats", "refreshed", refreshed, "deleted", result.RowsAffected)
+	return nil
+}
+
+// feedCursor is the position after a post in one feed order. Since pins
+// the start of the top window, so later pages don't shift as it moves.
+type feedCursor struct {
+	Sort   string     `json:"sort"`
+	Window string     `json:"window,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+	At     time.Time  `json:"at,omitempty"`
+	Score  int        `json:"score,omitempty"`
+	Hot    float64    `json:"hot,omitempty"`
+	ID     uuid.UUID  `json:"id"`
+}
+
+// feedRow is a post with its stats, which new posts may not have yet
+type feedRow struct {
+	interfaces.Post `gorm:"embedded"`
+	CommentCount    *int
+	Score           *int
+	Hot             *float64
+	LastActivityAt  *time.Time
+}
+
+func (r feedRow) response() interfaces.FeedPostResponse {
+	res := interfaces.FeedPostResponse{
+		PostResponse:   interfaces.NewPostResponse(r.Post),
+		LastActivityAt: r.CreatedAt,
+	}
+	if r.CommentCount != nil {
+		res.CommentCount = *r.CommentCount
+	}
+	if r.Score != nil {
+		res.Score = *r.Score
+	}
+	if r.LastActivityAt != nil {
+		res.LastActivityAt = *r.LastActivityAt
+	}
+	return res
+}
+
+// Feed lists posts across every category, optionally narrowed to one
+// category and one tag. Sorted new, posts come newest first; top, by score
+// over a window; hot, by score decayed with age; active, by their latest
+// comment. Every sort but new reads the post stats, which post and comment
+// writes keep current; posts made any other way join those sorts at the
+// next refresh-post-stats run.
+func Feed(c *gin.Context) {
+	params, ok := readPageParams(c)
+	if !ok {
+		return
+	}
+
+	sort := c.DefaultQuery("sort", feedHot)
+	switch sort {
+	case feedNew, feedTop, feedHot, feedActive:
+	default:
+		problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+			Field:   "sort",
+			Code:    "oneof",
+			Message: "must be one of new, top, hot or active",
+		}))
+		return
+	}
+	window := ""
+	if sort == feedTop {
+		window = c.DefaultQuery("window", "week")
+		if _, ok := feedWindows[window]; !ok {
+			problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+				Field:   "window",
+				Code:    "oneof",
+				Message: "must be one of day, week, month, year or all",
+			}))
+			return
+		}
+	}
+
+	var after *feedCursor
+	if params.cursor != "" {
+		after = &feedCursor{}
+		if !decodeCursor(c, params.cursor, after) {
+			return
+		}
+		if after.Sort != sort || after.Window != window {
+			problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+				Field:   "cursor",
+				Code:    "invalid_cursor",
+				Message: "must come from a page with the same sort and window",
+			}))
+			return
+		}
+	}
+
+	// Filters shared by the page and its total
+	filter := func(query *gorm.DB) *gorm.DB { return query }
+	if name := c.Query("category"); name != "" {
+		var category interfaces.Category
+		if err := requestDB(c).Select("id").Where("name = ?", name).First(&category).Error; err != nil {
+			problem.Respond(c, dbError(err, problem.CategoryNotFound, nil, nil))
+			return
+		}
+		filter = func(query *gorm.DB) *gorm.DB { return query.Where("posts.category_id = ?", category.ID) }
+	}
+	if name := c.Query("tag"); name != "" {
+		var tag interfaces.Tag
+		if err := requestDB(c).Select("id").Where("name = ?", name).First(&tag).Error; err != nil {
+			problem.Respond(c, dbError(err, problem.TagNotFound, nil, nil))
+			return
+		}
+		byCategory := filter
+		filter = func(query *gorm.DB) *gorm.DB {
+			return byCategory(query).Where("EXISTS (SELECT 1 FROM posts_tags WHERE posts_tags.post_id = posts.id AND posts_tags.tag_id = ?)", tag.ID)
+		}
+	}
+	var since *time.Time
+	if sort == feedTop && feedWindows[window] > 0 {
+		start := time.Now().Add(-feedWindows[window])
+		if after != nil && after.Since != nil {
+			start = *after.Since
+		}
+		since = &start
+		byTag := filter
+		filter = func(query *gorm.DB) *gorm.DB { return byTag(query).Where("post_stats.created_at >= ?", start) }
+	}
+	join := "JOIN post_stats ON post_stats.post_id = posts.id"
+	if sort == feedNew {
+		join = "LEFT " + join
+	}
+
+	query := filter(requestDB(c).Model(&interfaces.Post{}).Joins(join)).
+		Select("posts.*, post_stats.comment_count, post_stats.score, post_stats.hot, post_stats.last_activity_at")
+	switch sort {
+	case feedNew:
+		if after != nil {
+			query = query.Where("(posts.created_at, posts.id) < (?, ?)", after.At, after.ID)
+		}
+		query = query.Order("posts.created_at DESC, posts.id DESC")
+	case feedTop:
+		if after != nil {
+			query = query.Where("(post_stats.score, post_stats.post_id) < (?, ?)", after.Score, after.ID)
+		}
+		query = query.Order("post_stats.score DESC, post_stats.post_id DESC")
+	case feedHot:
+		if after != nil {
+			query = query.Where("(post_stats.hot, post_stats.post_id) < (?, ?)", after.Hot, after.ID)
+		}
+		query = query.Order("post_stats.hot DESC, post_stats.post_id DESC")
+	case feedActive:
+		if after != nil {
+			query = query.Where("(post_stats.last_activity_at, post_stats.post_id) < (?, ?)", after.At, after.ID)
+		}
+		query = query.Order("post_stats.last_activity_at DESC, post_stats.post_id DESC")
+	}
+
+	// One extra row tells whether there is a next page
+	var rows []feedRow
+	if err := query.Limit(params.limit + 1).Scan(&rows).Error; err != nil {
+		problem.Respond(c, dbError(err, nil, nil, nil))
+		return
+	}
+	page := interfaces.Page[interfaces.FeedPostResponse]{}
+	if len(rows) > params.limit {
+		rows = rows[:params.limit]
+		last := rows[params.limit-1].response()
+		cursor := feedCursor{Sort: sort, Window: window, Since: since, ID: last.ID, Score: last.Score}
+		switch sort {
+		case feedNew:
+			cursor.At = last.CreatedAt
+		case feedActive:
+			cursor.At = last.LastActivityAt
+		case feedHot:
+			cursor.Hot = *rows[params.limit-1].Hot
+		}
+		next := encodeCursor(cursor)
+		page.NextCursor = &next
+	}
+	page.Items = interfaces.MapAll(rows, feedRow.response)
+
+	if params.includeTotal {
+		var total int64
+		if err := filter(requestDB(c).Model(&interfaces.Post{}).Joins(join)).Count(&total).Error; err != nil {
+			problem.Respond(c, dbError(err, nil, nil, nil))
+			return
+		}
+		page.Total = &total
+	}
+
+	c.JSON(http.StatusOK, page)
+}
@@ -0,0 +1,343 @@
+package db
+
+import (
+	"backend/config"
+	"backend/interfaces"
+	"backend/logging"
+	"backend/problem"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+// graphLimits bounds the queries GraphQL runs; set by Use
+var graphLimits config.GraphQLConfig
+
+// graphQLRequest is a query as POSTed to /graphql
+type graphQLRequest struct {
+	Query         string                 `json:"query" binding:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphRequest is what resolvers know about the HTTP request they serve
+type graphRequest struct {
+	c       *gin.Context
+	loaders *loaders
+	me      *interfaces.User
+}
+
+type graphRequestKey struct{}
+
+func graphRequestFrom(ctx context.Context) *graphRequest {
+	return ctx.Value(graphRequestKey{}).(*graphRequest)
+}
+
+// userID is who auth.OptionalAuthMiddleware signed in, or empty
+func (r *graphRequest) userID() string {
+	return r.c.GetString("user_id")
+}
+
+// viewer loads the signed in user once per request
+func (r *graphRequest) viewer() (interfaces.User, error) {
+	if r.me != nil {
+		return *r.me, nil
+	}
+	if r.userID() == "" {
+		return interfaces.User{}, problem.Unauthenticated
+	}
+	me, err := viewer(r.c)
+	if err != nil {
+		return me, err
+	}
+	r.me = &me
+	return me, nil
+}
+
+// GraphQL serves queries over users, posts, comments, tags and categories
+// with GET or POST, and mutations with POST. Documents that don't parse,
+// don't validate or exceed the depth and complexity limits are refused
+// with 400 before anything runs. Mutations count against writeLimit,
+// which runs inline once the operation is known to be one.
+func GraphQL(writeLimit gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, ok := readGraphQLRequest(c)
+		if !ok {
+			return
+		}
+
+		doc, err := parser.Parse(parser.ParseParams{Source: req.Query})
+		if err != nil {
+			respondGraphQLErrors(c, http.StatusBadRequest, gqlerrors.FormatErrors(err))
+			return
+		}
+		if result := graphql.ValidateDocument(&graphSchema, doc, nil); !result.IsValid {
+			respondGraphQLErrors(c, http.StatusBadRequest, result.Errors)
+			return
+		}
+		op, err := selectOperation(doc, req.OperationName)
+		if err == nil {
+			err = checkLimits(doc, op, graphLimits)
+		}
+		if err != nil {
+			formatted := gqlerrors.NewFormattedError(err.Error())
+			var limit *limitError
+			if errors.As(err, &limit) {
+				formatted.Extensions = map[string]interface{}{"code": limit.code, "limit": limit.limit, "actual": limit.actual}
+			}
+			respondGraphQLErrors(c, http.StatusBadRequest, []gqlerrors.FormattedError{formatted})
+			return
+		}
+
+		if op.Operation == ast.OperationTypeMutation {
+			if c.Request.Method != http.MethodPost {
+				c.Header("Allow", http.MethodPost)
+				respondGraphQLErrors(c, http.StatusMethodNotAllowed, []gqlerrors.FormattedError{gqlerrors.NewFormattedError("Mutations must be sent with POST")})
+				return
+			}
+			writeLimit(c)
+			if c.IsAborted() {
+				return
+			}
+		}
+
+		ctx := context.WithValue(c.Request.Context(), graphRequestKey{}, &graphRequest{
+			c:       c,
+			loaders: newLoaders(c.Request.Context()),
+		})
+		result := graphql.Execute(graphql.ExecuteParams{
+			Schema:        graphSchema,
+			AST:           doc,
+			OperationName: req.OperationName,
+			Args:          req.Variables,
+			Context:       ctx,
+		})
+		result.Errors = graphErrors(c, result.Errors)
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// readGraphQLRequest reads the query from the body of a POST or the query
+// string of a GET, responding with a problem when it is missing or
+// malformed
+func readGraphQLRequest(c *gin.Context) (graphQLRequest, bool) {
+	var req graphQLRequest
+	if c.Request.Method == http.MethodPost {
+		return req, bindJSON(c, &req)
+	}
+
+	req.Query = c.Query("query")
+	req.OperationName = c.Query("operationName")
+	if req.Query == "" {
+		problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+			Field:   "query",
+			Code:    "required",
+			Message: "is required",
+		}))
+		return req, false
+	}
+	if variables := c.Query("variables"); variables != "" {
+		if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+			problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+				Field:   "variables",
+				Code:    "invalid_json",
+				Message: "must be a JSON object",
+			}))
+			return req, false
+		}
+	}
+	return req, true
+}
+
+// selectOperation finds the operation Execute will run: the named one, or
+// the only one when no name is given
+func selectOperation(doc *ast.Document, name string) (*ast.OperationDefinition, error) {
+	var found *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			if found != nil {
+				return nil, errors.New("Must provide operation name if query contains multiple operations")
+			}
+			found = op
+		} else if op.Name != nil && op.Name.Value == name {
+			found = op
+		}
+	}
+	if found == nil {
+		if name == "" {
+			return nil, errors.New("Must provide an operation")
+		}
+		return nil, fmt.Errorf("Unknown operation named %q", name)
+	}
+	return found, nil
+}
+
+// limitError is a query refused for its depth or complexity
+type limitError struct {
+	code    string
+	message string
+	limit   int
+	actual  int
+}
+
+func (e *limitError) Error() string {
+	return e.message
+}
+
+// checkLimits measures op and refuses it when it nests deeper than
+// MaxDepth or costs more than MaxComplexity. Every field costs one and
+// the fields below a list cost ListCost times over. Introspection is free.
+func checkLimits(doc *ast.Document, op *ast.OperationDefinition, cfg config.GraphQLConfig) error {
+	m := measurer{fragments: map[string]*ast.FragmentDefinition{}, listCost: cfg.ListCost}
+	for _, def := range doc.Definitions {
+		if fragment, ok := def.(*ast.FragmentDefinition); ok {
+			m.fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	root := graphSchema.QueryType()
+	if op.Operation == ast.OperationTypeMutation {
+		root = graphSchema.MutationType()
+	}
+	depth, cost := m.measure(op.SelectionSet, root)
+	if depth > cfg.MaxDepth {
+		return &limitError{"query_too_deep", fmt.Sprintf("Query is %d levels deep; the limit is %d", depth, cfg.MaxDepth), cfg.MaxDepth, depth}
+	}
+	if cost > cfg.MaxComplexity {
+		return &limitError{"query_too_complex", fmt.Sprintf("Query costs %d; the limit is %d", cost, cfg.MaxComplexity), cfg.MaxComplexity, cost}
+	}
+	return nil
+}
+
+type measurer struct {
+	fragments map[string]*ast.FragmentDefinition
+	listCost  int
+}
+
+// measure returns how deep the selections made on parent nest and what
+// they cost. Validation has already ruled out unknown fields and
+// fragment cycles.
+func (m measurer) measure(set *ast.SelectionSet, parent graphql.Type) (depth, cost int) {
+	if set == nil {
+		return 0, 0
+	}
+	for _, selection := range set.Selections {
+		var d, c int
+		switch selection := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(selection.Name.Value, "__") {
+				continue
+			}
+			var fieldType graphql.Type
+			if object, ok := parent.(*graphql.Object); ok {
+				if field, ok := object.Fields()[selection.Name.Value]; ok {
+					fieldType = field.Type
+				}
+			}
+			d, c = m.measure(selection.SelectionSet, unwrap(fieldType))
+			if isList(fieldType) {
+				c *= m.listCost
+			}
+			d, c = d+1, c+1
+		case *ast.InlineFragment:
+			typ := parent
+			if selection.TypeCondition != nil {
+				typ = graphSchema.Type(selection.TypeCondition.Name.Value)
+			}
+			d, c = m.measure(selection.SelectionSet, typ)
+		case *ast.FragmentSpread:
+			if fragment, ok := m.fragments[selection.Name.Value]; ok {
+				d, c = m.measure(fragment.SelectionSet, graphSchema.Type(fragment.TypeCondition.Name.Value))
+			}
+		}
+		depth = max(depth, d)
+		cost += c
+	}
+	return depth, cost
+}
+
+// unwrap strips the non-null and list wrappers from t
+func unwrap(t graphql.Type) graphql.Type {
+	for {
+		switch wrapper := t.(type) {
+		case *graphql.NonNull:
+			t = wrapper.OfType
+		case *graphql.List:
+			t = wrapper.OfType
+		default:
+			return t
+		}
+	}
+}
+
+func isList(t graphql.Type) bool {
+	if nonNull, ok := t.(*graphql.NonNull); ok {
+		t = nonNull.OfType
+	}
+	_, ok := t.(*graphql.List)
+	return ok
+}
+
+// graphErrors gives errors that came from a problem its code, status and
+// fields as extensions and the message a problem response would have.
+// graphql-go only keeps extensions for errors returned directly by a
+// resolver, so this is done once all of them are collected. Internal
+// errors are logged and their cause is never sent.
+func graphErrors(c *gin.Context, errs []gqlerrors.FormattedError) []gqlerrors.FormattedError {
+	for i, err := range errs {
+		p := problemOf(err)
+		if p == nil {
+			continue
+		}
+		if p.Status >= http.StatusInternalServerError {
+			logging.From(c).Error(p.Title, "code", p.Code, "error", p)
+		}
+		errs[i].Message = p.Title
+		if p.Detail != "" {
+			errs[i].Message = p.Detail
+		}
+		errs[i].Extensions = map[string]interface{}{"code": p.Code, "status": p.Status}
+		if len(p.Fields) > 0 {
+			errs[i].Extensions["fields"] = p.Fields
+		}
+	}
+	return errs
+}
+
+// problemOf finds the problem a GraphQL error was made from, if any
+func problemOf(err error) *problem.Problem {
+	for err != nil {
+		var p *problem.Problem
+		if errors.As(err, &p) {
+			return p
+		}
+		switch e := err.(type) {
+		case gqlerrors.FormattedError:
+			err = e.OriginalError()
+		case *gqlerrors.Error:
+			err = e.OriginalError
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// respondGraphQLErrors answers a request that was refused before it ran,
+// which has no data at all
+func respondGraphQLErrors(c *gin.Context, status int, errs []gqlerrors.FormattedError) {
+	c.AbortWithStatusJSON(status, gin.H{"errors": errs})
+}
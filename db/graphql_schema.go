@@ -0,0 +1,577 @@
+package db
+
+import (
+	"backend/interfaces"
+	"backend/problem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/graphql-go/graphql"
+	"gorm.io/gorm"
+)
+
+// graphSchema is the schema /graphql serves. Fields whose REST route needs
+// a signed in user need one here too, and users are shown as userView
+// would show them.
+var graphSchema graphql.Schema
+
+var (
+	userType     *graphql.Object
+	postType     *graphql.Object
+	commentType  *graphql.Object
+	postPageType *graphql.Object
+	tagType      *graphql.Object
+	categoryType *graphql.Object
+)
+
+func init() {
+	tagType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Tag",
+		Fields: graphql.Fields{
+			"id":        {Type: graphql.NewNonNull(graphql.ID), Resolve: from(func(t interfaces.Tag) interface{} { return t.ID.String() })},
+			"name":      {Type: graphql.NewNonNull(graphql.String), Resolve: from(func(t interfaces.Tag) interface{} { return t.Name })},
+			"createdAt": {Type: graphql.NewNonNull(graphql.DateTime), Resolve: from(func(t interfaces.Tag) interface{} { return t.CreatedAt })},
+		},
+	})
+
+	categoryType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Category",
+		Fields: graphql.Fields{
+			"id":          {Type: graphql.NewNonNull(graphql.ID), Resolve: from(func(c interfaces.Category) interface{} { return c.ID.String() })},
+			"name":        {Type: graphql.NewNonNull(graphql.String), Resolve: from(func(c interfaces.Category) interface{} { return c.Name })},
+			"description": {Type: graphql.NewNonNull(graphql.String), Resolve: from(func(c interfaces.Category) interface{} { return c.Description })},
+			"createdAt":   {Type: graphql.NewNonNull(graphql.DateTime), Resolve: from(func(c interfaces.Category) interface{} { return c.CreatedAt })},
+		},
+	})
+
+	userType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":        {Type: graphql.NewNonNull(graphql.ID), Resolve: from(func(u interfaces.User) interface{} { return u.ID.String() })},
+				"username":  {Type: graphql.NewNonNull(graphql.String), Resolve: from(func(u interfaces.User) interface{} { return u.Username })},
+				"avatarUrl": {Type: graphql.NewNonNull(graphql.String), Resolve: from(func(u interfaces.User) interface{} { return u.AvatarURL })},
+				"createdAt": {Type: graphql.NewNonNull(graphql.DateTime), Resolve: from(func(u interfaces.User) interface{} { return u.CreatedAt })},
+				"email": {
+					Type:        graphql.String,
+					Description: "Only set for yourself, or for anyone when you are an admin",
+					Resolve:     private(func(u interfaces.User) interface{} { return u.Email }),
+				},
+				"role": {
+					Type:        graphql.String,
+					Description: "Only set for yourself, or for anyone when you are an admin",
+					Resolve:     private(func(u interfaces.User) interface{} { return u.Role }),
+				},
+				"updatedAt": {
+					Type:        graphql.DateTime,
+					Description: "Only set for yourself, or for anyone when you are an admin",
+					Resolve:     private(func(u interfaces.User) interface{} { return u.UpdatedAt }),
+				},
+				"posts": {
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(postType))),
+					Resolve: signedIn(func(p graphql.ResolveParams) (interface{}, error) {
+						user := p.Source.(interfaces.User)
+						return many(graphRequestFrom(p.Context).loaders.userPosts.load(user.ID)), nil
+					}),
+				},
+			}
+		}),
+	})
+
+	postType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Post",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":         {Type: graphql.NewNonNull(graphql.ID), Resolve: from(func(p interfaces.Post) interface{} { return p.ID.String() })},
+				"title":      {Type: graphql.NewNonNull(graphql.String), Resolve: from(func(p interfaces.Post) interface{} { return p.Title })},
+				"content":    {Type: graphql.NewNonNull(graphql.String), Resolve: from(func(p interfaces.Post) interface{} { return p.Content })},
+				"authorId":   {Type: graphql.NewNonNull(graphql.ID), Resolve: from(func(p interfaces.Post) interface{} { return p.AuthorID.String() })},
+				"categoryId": {Type: graphql.NewNonNull(graphql.ID), Resolve: from(func(p interfaces.Post) interface{} { return p.CategoryID.String() })},
+				"createdAt":  {Type: graphql.NewNonNull(graphql.DateTime), Resolve: from(func(p interfaces.Post) interface{} { return p.CreatedAt })},
+				"updatedAt":  {Type: graphql.NewNonNull(graphql.DateTime), Resolve: from(func(p interfaces.Post) interface{} { return p.UpdatedAt })},
+				"author": {
+					Type: userType,
+					Resolve: signedIn(func(p graphql.ResolveParams) (interface{}, error) {
+						post := p.Source.(interfaces.Post)
+						return one(graphRequestFrom(p.Context).loaders.users.load(post.AuthorID)), nil
+					}),
+				},
+				"category": {
+					Type: categoryType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						post := p.Source.(interfaces.Post)
+						return one(graphRequestFrom(p.Context).loaders.categories.load(post.CategoryID)), nil
+					},
+				},
+				"tags": {
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(tagType))),
+					Resolve: signedIn(func(p graphql.ResolveParams) (interface{}, error) {
+						post := p.Source.(interfaces.Post)
+						return many(graphRequestFrom(p.Context).loaders.postTags.load(post.ID)), nil
+					}),
+				},
+				"comments": {
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(commentType))),
+					Resolve: signedIn(func(p graphql.ResolveParams) (interface{}, error) {
+						post := p.Source.(interfaces.Post)
+						return many(graphRequestFrom(p.Context).loaders.postComments.load(post.ID)), nil
+					}),
+				},
+			}
+		}),
+	})
+
+	commentType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Comment",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":        {Type: graphql.NewNonNull(graphql.ID), Resolve: from(func(c interfaces.Comment) interface{} { return c.ID.String() })},
+				"content":   {Type: graphql.NewNonNull(graphql.String), Resolve: from(func(c interfaces.Comment) interface{} { return c.Content })},
+				"postId":    {Type: graphql.NewNonNull(graphql.ID), Resolve: from(func(c interfaces.Comment) interface{} { return c.PostID.String() })},
+				"authorId":  {Type: graphql.NewNonNull(graphql.ID), Resolve: from(func(c interfaces.Comment) interface{} { return c.AuthorID.String() })},
+				"createdAt": {Type: graphql.NewNonNull(graphql.DateTime), Resolve: from(func(c interfaces.Comment) interface{} { return c.CreatedAt })},
+				"updatedAt": {Type: graphql.NewNonNull(graphql.DateTime), Resolve: from(func(c interfaces.Comment) interface{} { return c.UpdatedAt })},
+				"author": {
+					Type: userType,
+					Resolve: signedIn(func(p graphql.ResolveParams) (interface{}, error) {
+						comment := p.Source.(interfaces.Comment)
+						return one(graphRequestFrom(p.Context).loaders.users.load(comment.AuthorID)), nil
+					}),
+				},
+				"post": {
+					Type: postType,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						comment := p.Source.(interfaces.Comment)
+						return one(graphRequestFrom(p.Context).loaders.posts.load(comment.PostID)), nil
+					},
+				},
+			}
+		}),
+	})
+
+	postPageType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "PostPage",
+		Fields: graphql.Fields{
+			"items": {
+				Type:    graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(postType))),
+				Resolve: from(func(p interfaces.Page[interfaces.Post]) interface{} { return nonNil(p.Items) }),
+			},
+			"nextCursor": {
+				Type:        graphql.String,
+				Description: "Passed as after to fetch the next page; null on the last page",
+				Resolve: from(func(p interfaces.Page[interfaces.Post]) interface{} {
+					if p.NextCursor == nil {
+						return nil
+					}
+					return *p.NextCursor
+				}),
+			},
+		},
+	})
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query:    queryType(),
+		Mutation: mutationType(),
+	})
+	if err != nil {
+		panic(err)
+	}
+	graphSchema = schema
+}
+
+func queryType() *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"me": {
+				Type: userType,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return graphRequestFrom(p.Context).viewer()
+				},
+			},
+			"user": {
+				Type: userType,
+				Args: graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: signedIn(func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := argID(p, "id")
+					if err != nil {
+						return nil, err
+					}
+					return one(graphRequestFrom(p.Context).loaders.users.load(id)), nil
+				}),
+			},
+			"users": {
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(userType))),
+				Args: graphql.FieldConfigArgument{"username": {Type: graphql.String}},
+				Resolve: signedIn(func(p graphql.ResolveParams) (interface{}, error) {
+					query := DB.WithContext(p.Context)
+					if username, _ := p.Args["username"].(string); username != "" {
+						query = query.Where("username = ?", username)
+					}
+					users := []interfaces.User{}
+					if err := query.Order("username").Find(&users).Error; err != nil {
+						return nil, dbError(err, nil, nil, nil)
+					}
+					return users, nil
+				}),
+			},
+			"post": {
+				Type: postType,
+				Args: graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := argID(p, "id")
+					if err != nil {
+						return nil, err
+					}
+					return one(graphRequestFrom(p.Context).loaders.posts.load(id)), nil
+				},
+			},
+			"posts": {
+				Type:        graphql.NewNonNull(postPageType),
+				Description: "The named category's posts, newest first, a page at a time like GET /posts/category/{category}",
+				Args: graphql.FieldConfigArgument{
+					"category": {Type: graphql.NewNonNull(graphql.String)},
+					"first":    {Type: graphql.Int, DefaultValue: defaultPageLimit},
+					"after":    {Type: graphql.String},
+				},
+				Resolve: signedIn(func(p graphql.ResolveParams) (interface{}, error) {
+					first, _ := p.Args["first"].(int)
+					if first < 1 || first > maxPageLimit {
+						return nil, problem.InvalidParameter.WithFields(problem.FieldError{
+							Field:   "first",
+							Code:    "range",
+							Message: fmt.Sprintf("must be an integer from 1 to %d", maxPageLimit),
+						})
+					}
+					var after *postCursor
+					if cursor, _ := p.Args["after"].(string); cursor != "" {
+						after = &postCursor{}
+						if err := parseCursor(cursor, "after", after); err != nil {
+							return nil, err
+						}
+					}
+
+					db := DB.WithContext(p.Context)
+					var category interfaces.Category
+					if err := db.Where("name = ?", p.Args["category"]).First(&category).Error; err != nil {
+						return nil, dbError(err, problem.CategoryNotFound, nil, nil)
+					}
+					posts, next, err := newestPosts(db.Where("category_id = ?", category.ID), after, first)
+					if err != nil {
+						return nil, dbError(err, nil, nil, nil)
+					}
+					return interfaces.Page[interfaces.Post]{Items: posts, NextCursor: next}, nil
+				}),
+			},
+			"tags": {
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(tagType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					tags, err := allTags(p.Context)
+					if err != nil {
+						return nil, dbError(err, nil, nil, nil)
+					}
+					return nonNil(tags), nil
+				},
+			},
+			"tag": {
+				Type: tagType,
+				Args: graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					var tag interfaces.Tag
+					return find(p, &tag)
+				},
+			},
+			"categories": {
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(categoryType))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					categories, err := allCategories(p.Context)
+					if err != nil {
+						return nil, dbError(err, nil, nil, nil)
+					}
+					return nonNil(categories), nil
+				},
+			},
+			"category": {
+				Type: categoryType,
+				Args: graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := argID(p, "id")
+					if err != nil {
+						return nil, err
+					}
+					return one(graphRequestFrom(p.Context).loaders.categories.load(id)), nil
+				},
+			},
+		},
+	})
+}
+
+func mutationType() *graphql.Object {
+	createPostInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "CreatePostInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"title":      {Type: graphql.NewNonNull(graphql.String)},
+			"content":    {Type: graphql.NewNonNull(graphql.String)},
+			"authorId":   {Type: graphql.NewNonNull(graphql.ID)},
+			"categoryId": {Type: graphql.NewNonNull(graphql.ID)},
+		},
+	})
+	updatePostInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "UpdatePostInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"title":   {Type: graphql.NewNonNull(graphql.String)},
+			"content": {Type: graphql.NewNonNull(graphql.String)},
+		},
+	})
+	createCommentInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "CreateCommentInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"content":  {Type: graphql.NewNonNull(graphql.String)},
+			"authorId": {Type: graphql.NewNonNull(graphql.ID)},
+		},
+	})
+
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createPost": {
+				Type: graphql.NewNonNull(postType),
+				Args: graphql.FieldConfigArgument{"input": {Type: graphql.NewNonNull(createPostInput)}},
+				Resolve: signedIn(func(p graphql.ResolveParams) (interface{}, error) {
+					input := p.Args["input"].(map[string]interface{})
+					ids, err := inputIDs(input, "authorId", "categoryId")
+					if err != nil {
+						return nil, err
+					}
+					req := interfaces.CreatePostRequest{
+						Title:      input["title"].(string),
+						Content:    input["content"].(string),
+						AuthorID:   ids[0],
+						CategoryID: ids[1],
+					}
+					if err := validInput(&req); err != nil {
+						return nil, err
+					}
+					return insertPost(p.Context, req)
+				}),
+			},
+			"updatePost": {
+				Type: graphql.NewNonNull(postType),
+				Args: graphql.FieldConfigArgument{
+					"id":    {Type: graphql.NewNonNull(graphql.ID)},
+					"input": {Type: graphql.NewNonNull(updatePostInput)},
+				},
+				Resolve: signedIn(func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := argID(p, "id")
+					if err != nil {
+						return nil, err
+					}
+					input := p.Args["input"].(map[string]interface{})
+					req := interfaces.UpdatePostRequest{
+						Title:   input["title"].(string),
+						Content: input["content"].(string),
+					}
+					if err := validInput(&req); err != nil {
+						return nil, err
+					}
+					if _, err := editPost(p.Context, id, req, nil); err != nil {
+						return nil, err
+					}
+					var post interfaces.Post
+					if err := DB.WithContext(p.Context).First(&post, "id = ?", id).Error; err != nil {
+						return nil, dbError(err, problem.PostNotFound, nil, nil)
+					}
+					return post, nil
+				}),
+			},
+			"deletePost": {
+				Type:        graphql.NewNonNull(graphql.ID),
+				Description: "Deletes a post and returns its ID",
+				Args:        graphql.FieldConfigArgument{"id": {Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: signedIn(func(p graphql.ResolveParams) (interface{}, error) {
+					id, err := argID(p, "id")
+					if err != nil {
+						return nil, err
+					}
+					if err := removePost(p.Context, id); err != nil {
+						return nil, err
+					}
+					return id.String(), nil
+				}),
+			},
+			"createComment": {
+				Type: graphql.NewNonNull(commentType),
+				Args: graphql.FieldConfigArgument{
+					"postId": {Type: graphql.NewNonNull(graphql.ID)},
+					"input":  {Type: graphql.NewNonNull(createCommentInput)},
+				},
+				Resolve: signedIn(func(p graphql.ResolveParams) (interface{}, error) {
+					postID, err := argID(p, "postId")
+					if err != nil {
+						return nil, err
+					}
+					input := p.Args["input"].(map[string]interface{})
+					ids, err := inputIDs(input, "authorId")
+					if err != nil {
+						return nil, err
+					}
+					req := interfaces.CommentInput{
+						Content:  input["content"].(string),
+						AuthorID: ids[0],
+					}
+					if err := validInput(&req); err != nil {
+						return nil, err
+					}
+					return insertComment(p.Context, postID, req)
+				}),
+			},
+		},
+	})
+}
+
+// from resolves a field from the object it is selected on
+func from[S any](get func(S) interface{}) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		return get(p.Source.(S)), nil
+	}
+}
+
+// private resolves a user field that userView leaves out of the public
+// profile, returning null to anyone else
+func private(get func(interfaces.User) interface{}) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		user := p.Source.(interfaces.User)
+		me, err := graphRequestFrom(p.Context).viewer()
+		if err != nil {
+			return nil, err
+		}
+		if _, public := userView(me, user).(interfaces.PublicUser); public {
+			return nil, nil
+		}
+		return get(user), nil
+	}
+}
+
+// signedIn refuses the field to signed out requests, as AuthMiddleware
+// does for the matching REST route
+func signedIn(resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		if graphRequestFrom(p.Context).userID() == "" {
+			return nil, problem.Unauthenticated
+		}
+		return resolve(p)
+	}
+}
+
+// one adapts a loader thunk to a nullable object field
+func one[V any](get func() (*V, error)) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		value, err := get()
+		if err != nil {
+			return nil, problem.Internal.Wrap(err)
+		}
+		if value == nil {
+			return nil, nil
+		}
+		return *value, nil
+	}
+}
+
+// many adapts a loader thunk to a list field, which is never null
+func many[V any](get func() ([]V, error)) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		values, err := get()
+		if err != nil {
+			return nil, problem.Internal.Wrap(err)
+		}
+		return nonNil(values), nil
+	}
+}
+
+// nonNil turns a nil slice into an empty one, so non-null lists resolve
+func nonNil[V any](values []V) []V {
+	if values == nil {
+		return []V{}
+	}
+	return values
+}
+
+// find loads the row whose ID is the id argument into dest, or returns
+// null when there is none
+func find[V any](p graphql.ResolveParams, dest *V) (interface{}, error) {
+	id, err := argID(p, "id")
+	if err != nil {
+		return nil, err
+	}
+	err = DB.WithContext(p.Context).First(dest, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, dbError(err, nil, nil, nil)
+	}
+	return *dest, nil
+}
+
+// argID reads the named ID argument as a UUID
+func argID(p graphql.ResolveParams, name string) (uuid.UUID, error) {
+	id, ok := graphID(p.Args[name])
+	if !ok {
+		return uuid.Nil, problem.InvalidParameter.WithFields(invalidUUID(name))
+	}
+	return id, nil
+}
+
+// inputIDs reads the named ID fields of an input object as UUIDs,
+// reporting every one that isn't
+func inputIDs(input map[string]interface{}, names ...string) ([]uuid.UUID, error) {
+	ids := make([]uuid.UUID, len(names))
+	var fields []problem.FieldError
+	for i, name := range names {
+		id, ok := graphID(input[name])
+		if !ok {
+			fields = append(fields, invalidUUID(name))
+		}
+		ids[i] = id
+	}
+	if len(fields) > 0 {
+		return nil, problem.ValidationFailed.WithFields(fields...)
+	}
+	return ids, nil
+}
+
+func graphID(value interface{}) (uuid.UUID, bool) {
+	s, _ := value.(string)
+	id, err := uuid.Parse(s)
+	return id, err == nil
+}
+
+func invalidUUID(field string) problem.FieldError {
+	return problem.FieldError{Field: field, Code: "invalid_uuid", Message: "must be a UUID"}
+}
+
+// validInput normalizes and validates a mutation input as bindJSON does a
+// request body, naming fields as the GraphQL input does
+func validInput(v interface{}) error {
+	fields := validate(v)
+	if len(fields) == 0 {
+		return nil
+	}
+	for i := range fields {
+		fields[i].Field = camelCase(fields[i].Field)
+	}
+	return problem.ValidationFailed.WithFields(fields...)
+}
+
+// camelCase turns a JSON field name like author_id into authorId
+func camelCase(name string) string {
+	parts := strings.Split(name, "_")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessTimeout bounds how long /readyz waits for the database
+const readinessTimeout = 2 * time.Second
+
+// Ping checks that the database answers
+func Ping(ctx context.Context) error {
+	if DB == nil {
+		return errors.New("database is not initialized")
+	}
+
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// Close ends the comment streams and releases every pooled database
+// connection
+func Close() error {
+	CloseStreams()
+	if DB == nil {
+		return nil
+	}
+
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// Healthz reports that the process is alive. It never touches dependencies,
+// so a slow database doesn't get the instance restarted.
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz reports whether the instance can serve traffic: the database must
+// answer a ping and image storage must be configured
+func Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	ready := true
+	checks := gin.H{}
+
+	if err := Ping(ctx); err != nil {
+		ready = false
+		checks["database"] = err.Error()
+	} else {
+		checks["database"] = "ok"
+	}
+
+	if images != nil {
+		checks["storage"] = "ok"
+	} else {
+		ready = false
+		checks["storage"] = "Cloudinary credentials are not configured"
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ready", "checks": checks})
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"backend/interfaces"
+	"backend/jobs"
+	"backend/problem"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// queue holds background jobs; Use opens it from cfg.Jobs
+var queue jobs.Queue
+
+// Queue is the job queue workers should drain
+func Queue() jobs.Queue {
+	return queue
+}
+
+// deleteAvatarJob removes a deleted user's avatar from image storage
+const deleteAvatarJob = "avatar.delete"
+
+type deleteAvatarPayload struct {
+	Username string `json:"username"`
+}
+
+func init() {
+	jobs.Handle(deleteAvatarJob, func(ctx context.Context, job interfaces.Job) error {
+		var payload deleteAvatarPayload
+		if err := jobs.Decode(job, &payload); err != nil {
+			return err
+		}
+		if images == nil {
+			return jobs.Permanent(errors.New("image storage is not configured"))
+		}
+		return images.DeleteImage(ctx, payload.Username)
+	})
+}
+
+// RequireAdmin rejects signed in users who aren't admins. It goes after
+// auth.AuthMiddleware.
+func RequireAdmin(c *gin.Context) {
+	me, err := viewer(c)
+	if err != nil {
+		problem.Respond(c, err)
+		return
+	}
+	if me.Role != interfaces.RoleAdmin {
+		problem.Respond(c, problem.Forbidden.WithDetail("Only admins can do this"))
+		return
+	}
+	c.Next()
+}
+
+// maxJobsListed caps GET /admin/jobs
+const maxJobsListed = 200
+
+// Job admin handlers
+func ListJobs(c *gin.Context) {
+	filter := jobs.Filter{
+		Status: c.Query("status"),
+		Kind:   c.Query("kind"),
+		Limit:  50,
+	}
+	switch filter.Status {
+	case "", interfaces.JobQueued, interfaces.JobRunning, interfaces.JobSucceeded, interfaces.JobDead:
+	default:
+		problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+			Field:   "status",
+			Code:    "oneof",
+			Message: "must be one of queued, running, succeeded or dead",
+		}))
+		return
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxJobsListed {
+			problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+				Field:   "limit",
+				Code:    "range",
+				Message: "must be an integer from 1 to 200",
+			}))
+			return
+		}
+		filter.Limit = n
+	}
+
+	list, err := queue.List(c.Request.Context(), filter)
+	if err != nil {
+		problem.Respond(c, dbError(err, nil, nil, nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, interfaces.MapAll(list, interfaces.NewJobResponse))
+}
+
+func GetJob(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	job, err := queue.Get(c.Request.Context(), id)
+	if err != nil {
+		problem.Respond(c, jobError(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, interfaces.NewJobResponse(job))
+}
+
+// RetryJob queues a dead-lettered job again
+func RetryJob(c *gin.Context) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	job, err := queue.Retry(c.Request.Context(), id, time.Now())
+	if err != nil {
+		problem.Respond(c, jobError(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, interfaces.NewJobResponse(job))
+}
+
+// jobError maps queue errors to problems
+func jobError(err error) error {
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		return problem.JobNotFound
+	case errors.Is(err, jobs.ErrNotRetryable):
+		return problem.JobNotRetryable
+	default:
+		return dbError(err, nil, nil, nil)
+	}
+}
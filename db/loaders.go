@@ -0,0 +1,152 @@
+package db
+
+import (
+	"backend/interfaces"
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// loader batches lookups by key for one GraphQL request. load queues the
+// key and returns a thunk; graphql-go resolves a whole level of the
+// response before calling that level's thunks, so the first call fetches
+// every key queued so far in one query and the rest read the results.
+type loader[K comparable, V any] struct {
+	fetch func(keys []K) (map[K]V, error)
+
+	mu     sync.Mutex
+	queued []K
+	values map[K]V
+	errs   map[K]error
+	seen   map[K]bool // Queued or fetched
+}
+
+func newLoader[K comparable, V any](fetch func(keys []K) (map[K]V, error)) *loader[K, V] {
+	return &loader[K, V]{
+		fetch:  fetch,
+		values: map[K]V{},
+		errs:   map[K]error{},
+		seen:   map[K]bool{},
+	}
+}
+
+// load returns a thunk giving the value for key, or the zero value when
+// there is none
+func (l *loader[K, V]) load(key K) func() (V, error) {
+	l.mu.Lock()
+	if !l.seen[key] {
+		l.seen[key] = true
+		l.queued = append(l.queued, key)
+	}
+	l.mu.Unlock()
+
+	return func() (V, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if len(l.queued) > 0 && l.isQueued(key) {
+			l.flush()
+		}
+		return l.values[key], l.errs[key]
+	}
+}
+
+func (l *loader[K, V]) isQueued(key K) bool {
+	for _, queued := range l.queued {
+		if queued == key {
+			return true
+		}
+	}
+	return false
+}
+
+// flush fetches every queued key. A failed fetch fails each of its keys.
+func (l *loader[K, V]) flush() {
+	keys := l.queued
+	l.queued = nil
+	values, err := l.fetch(keys)
+	for _, key := range keys {
+		if err != nil {
+			l.errs[key] = err
+			continue
+		}
+		if value, ok := values[key]; ok {
+			l.values[key] = value
+		}
+	}
+}
+
+// loaders are the batched lookups the GraphQL resolvers share
+type loaders struct {
+	users        *loader[uuid.UUID, *interfaces.User]
+	posts        *loader[uuid.UUID, *interfaces.Post]
+	categories   *loader[uuid.UUID, *interfaces.Category]
+	postTags     *loader[uuid.UUID, []interfaces.Tag]
+	postComments *loader[uuid.UUID, []interfaces.Comment]
+	userPosts    *loader[uuid.UUID, []interfaces.Post]
+}
+
+func newLoaders(ctx context.Context) *loaders {
+	return &loaders{
+		users: newLoader(func(ids []uuid.UUID) (map[uuid.UUID]*interfaces.User, error) {
+			var users []interfaces.User
+			err := DB.WithContext(ctx).Where("id IN ?", ids).Find(&users).Error
+			return byID(users, func(u interfaces.User) uuid.UUID { return u.ID }), err
+		}),
+		posts: newLoader(func(ids []uuid.UUID) (map[uuid.UUID]*interfaces.Post, error) {
+			var posts []interfaces.Post
+			err := DB.WithContext(ctx).Where("id IN ?", ids).Find(&posts).Error
+			return byID(posts, func(p interfaces.Post) uuid.UUID { return p.ID }), err
+		}),
+		categories: newLoader(func(ids []uuid.UUID) (map[uuid.UUID]*interfaces.Category, error) {
+			var categories []interfaces.Category
+			err := DB.WithContext(ctx).Where("id IN ?", ids).Find(&categories).Error
+			return byID(categories, func(c interfaces.Category) uuid.UUID { return c.ID }), err
+		}),
+		postTags: newLoader(func(postIDs []uuid.UUID) (map[uuid.UUID][]interfaces.Tag, error) {
+			var rows []struct {
+				interfaces.Tag `gorm:"embedded"`
+				PostID         uuid.UUID
+			}
+			err := DB.WithContext(ctx).Table("tags").
+				Select("tags.*, posts_tags.post_id").
+				Joins("JOIN posts_tags ON posts_tags.tag_id = tags.id").
+				Where("posts_tags.post_id IN ?", postIDs).
+				Order("tags.name").
+				Scan(&rows).Error
+			tags := map[uuid.UUID][]interfaces.Tag{}
+			for _, row := range rows {
+				tags[row.PostID] = append(tags[row.PostID], row.Tag)
+			}
+			return tags, err
+		}),
+		postComments: newLoader(func(postIDs []uuid.UUID) (map[uuid.UUID][]interfaces.Comment, error) {
+			var comments []interfaces.Comment
+			err := DB.WithContext(ctx).Where("post_id IN ?", postIDs).Order("created_at, id").Find(&comments).Error
+			return groupBy(comments, func(c interfaces.Comment) uuid.UUID { return c.PostID }), err
+		}),
+		userPosts: newLoader(func(authorIDs []uuid.UUID) (map[uuid.UUID][]interfaces.Post, error) {
+			var posts []interfaces.Post
+			err := DB.WithContext(ctx).Where("author_id IN ?", authorIDs).Order("created_at DESC, id").Find(&posts).Error
+			return groupBy(posts, func(p interfaces.Post) uuid.UUID { return p.AuthorID }), err
+		}),
+	}
+}
+
+// byID indexes rows by key
+func byID[V any](rows []V, key func(V) uuid.UUID) map[uuid.UUID]*V {
+	index := make(map[uuid.UUID]*V, len(rows))
+	for i := range rows {
+		index[key(rows[i])] = &rows[i]
+	}
+	return index
+}
+
+// groupBy collects rows under key, keeping their order
+func groupBy[V any](rows []V, key func(V) uuid.UUID) map[uuid.UUID][]V {
+	groups := map[uuid.UUID][]V{}
+	for _, row := range rows {
+		groups[key(row)] = append(groups[key(row)], row)
+	}
+	return groups
+}
@@ -0,0 +1,225 @@
+package db
+
+import (
+	"backend/interfaces"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Models lists every table owned by the backend, in dependency order
+func Models() []interface{} {
+	return []interface{}{
+		&interfaces.User{},
+		&interfaces.Category{},
+		&interfaces.Tag{},
+		&interfaces.Post{},
+		&interfaces.PostTag{},
+		&interfaces.Comment{},
+		&interfaces.CommentChange{},
+		&interfaces.PostStats{},
+		&interfaces.IdempotencyKey{},
+		&interfaces.Job{},
+		&interfaces.ScheduleRun{},
+		&interfaces.OutboxEvent{},
+		&interfaces.OutboxDelivery{},
+		&interfaces.Webhook{},
+		&interfaces.WebhookDelivery{},
+	}
+}
+
+// Migrate creates or updates the schema for every model, and on Postgres
+// the full-text search indexes
+func Migrate() error {
+	if err := DB.AutoMigrate(Models()...); err != nil {
+		return err
+	}
+	if DB.Dialector.Name() != "postgres" {
+		return nil
+	}
+	for _, index := range searchIndexes {
+		if err := DB.Exec(index).Error; err != nil {
+			return fmt.Errorf("creating search index: %w", err)
+		}
+	}
+	return nil
+}
+
+// Default categories and tags created by SeedDefaults
+var (
+	defaultCategories = []interfaces.Category{
+		{Name: "General", Description: "Anything that doesn't fit elsewhere"},
+		{Name: "Academics", Description: "Modules, assignments and exams"},
+		{Name: "Campus Life", Description: "Events, clubs and halls"},
+		{Name: "Careers", Description: "Internships, jobs and interviews"},
+	}
+	defaultTags = []string{"question", "discussion", "announcement", "help", "resources"}
+)
+
+// SeedDefaults creates the baseline categories and tags, skipping any that already exist
+func SeedDefaults() error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		for _, category := range defaultCategories {
+			if err := tx.Where(interfaces.Category{Name: category.Name}).
+				Attrs(interfaces.Category{Description: category.Description}).
+				FirstOrCreate(&category).Error; err != nil {
+				return fmt.Errorf("seeding category %q: %w", category.Name, err)
+			}
+		}
+
+		for _, name := range defaultTags {
+			tag := interfaces.Tag{Name: name}
+			if err := tx.Where(interfaces.Tag{Name: name}).FirstOrCreate(&tag).Error; err != nil {
+				return fmt.Errorf("seeding tag %q: %w", name, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	cached.Invalidate(context.Background(), cacheCategories, "all")
+	cached.Invalidate(context.Background(), cacheTags, "all")
+	return nil
+}
+
+// hashPassword hashes a plain text password for storage
+func hashPassword(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// CreateAdmin creates a user with the admin role, or promotes the existing
+// user with that username and resets their password
+func CreateAdmin(username, email, password string) (*interfaces.User, error) {
+	if username == "" || password == "" {
+		return nil, errors.New("username and password are required")
+	}
+
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("hashing password: %w", err)
+	}
+
+	var user interfaces.User
+	err = DB.Where("username = ?", username).First(&user).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if email == "" {
+			return nil, errors.New("email is required for a new admin")
+		}
+		user = interfaces.User{
+			Username:     username,
+			Email:        email,
+			PasswordHash: hashed,
+			Role:         interfaces.RoleAdmin,
+		}
+		if err := DB.Create(&user).Error; err != nil {
+			return nil, fmt.Errorf("creating admin: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("looking up user: %w", err)
+	default:
+		updates := map[string]interface{}{
+			"password_hash": hashed,
+			"role":          interfaces.RoleAdmin,
+			"updated_at":    time.Now(),
+		}
+		if email != "" {
+			updates["email"] = email
+		}
+		if err := DB.Model(&user).Updates(updates).Error; err != nil {
+			return nil, fmt.Errorf("promoting user: %w", err)
+		}
+	}
+
+	return &user, nil
+}
+
+// ResetPassword replaces the password of the user with the given username
+func ResetPassword(username, password string) error {
+	if password == "" {
+		return errors.New("password is required")
+	}
+
+	hashed, err := hashPassword(password)
+	if err != nil {
+		return fmt.Errorf("hashing password: %w", err)
+	}
+
+	result := DB.Model(&interfaces.User{}).
+		Where("username = ?", username).
+		Updates(map[string]interface{}{
+			"password_hash": hashed,
+			"updated_at":    time.Now(),
+		})
+	if result.Error != nil {
+		return fmt.Errorf("updating password: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("user %q not found", username)
+	}
+
+	return nil
+}
+
+// Snapshot is the document written by Export
+type Snapshot struct {
+	ExportedAt time.Time             `json:"exported_at"`
+	Users      []SnapshotUser        `json:"users"`
+	Categories []interfaces.Category `json:"categories"`
+	Tags       []interfaces.Tag      `json:"tags"`
+	Posts      []interfaces.Post     `json:"posts"`
+	PostTags   []interfaces.PostTag  `json:"posts_tags"`
+	Comments   []interfaces.Comment  `json:"comments"`
+}
+
+// SnapshotUser is a user as exported. Unlike the model, it keeps the
+// email and password hash, so a snapshot can restore the accounts.
+type SnapshotUser struct {
+	interfaces.User
+	Email        string `json:"email"`
+	PasswordHash string `json:"password_hash"`
+}
+
+// Export writes every table as a single JSON document
+func Export(w io.Writer) error {
+	snapshot := Snapshot{ExportedAt: time.Now().UTC()}
+	var users []interfaces.User
+
+	tables := []struct {
+		name string
+		dest interface{}
+	}{
+		{"users", &users},
+		{"categories", &snapshot.Categories},
+		{"tags", &snapshot.Tags},
+		{"posts", &snapshot.Posts},
+		{"posts_tags", &snapshot.PostTags},
+		{"comments", &snapshot.Comments},
+	}
+	for _, table := range tables {
+		if err := DB.Find(table.dest).Error; err != nil {
+			return fmt.Errorf("reading %s: %w", table.name, err)
+		}
+	}
+
+	snapshot.Users = interfaces.MapAll(users, func(user interfaces.User) SnapshotUser {
+		return SnapshotUser{User: user, Email: user.Email, PasswordHash: user.PasswordHash}
+	})
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(snapshot)
+}
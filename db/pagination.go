@@ -0,0 +1,121 @@
+package db
+
+import (
+	"backend/interfaces"
+	"backend/problem"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Page sizes of cursor paginated listings
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// pageParams are the query parameters every cursor paginated listing takes
+type pageParams struct {
+	limit        int
+	cursor       string // As sent; each listing decodes its own kind
+	includeTotal bool
+}
+
+// readPageParams reads limit, cursor and include_total, responding with a
+// problem when one is malformed
+func readPageParams(c *gin.Context) (pageParams, bool) {
+	params := pageParams{limit: defaultPageLimit, cursor: c.Query("cursor")}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageLimit {
+			problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+				Field:   "limit",
+				Code:    "range",
+				Message: fmt.Sprintf("must be an integer from 1 to %d", maxPageLimit),
+			}))
+			return params, false
+		}
+		params.limit = n
+	}
+	if total := c.Query("include_total"); total != "" {
+		include, err := strconv.ParseBool(total)
+		if err != nil {
+			problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+				Field:   "include_total",
+				Code:    "invalid_boolean",
+				Message: "must be true or false",
+			}))
+			return params, false
+		}
+		params.includeTotal = include
+	}
+	return params, true
+}
+
+// encodeCursor makes the position v describes into an opaque cursor
+func encodeCursor(v interface{}) string {
+	encoded, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// decodeCursor reads a cursor made by encodeCursor into v, responding with
+// a problem when it isn't one
+func decodeCursor(c *gin.Context, cursor string, v interface{}) bool {
+	if err := parseCursor(cursor, "cursor", v); err != nil {
+		problem.Respond(c, err)
+		return false
+	}
+	return true
+}
+
+// parseCursor reads a cursor made by encodeCursor into v, returning a
+// problem naming field when it isn't one
+func parseCursor(cursor, field string, v interface{}) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(decoded, v)
+	}
+	if err != nil {
+		return problem.InvalidParameter.WithFields(problem.FieldError{
+			Field:   field,
+			Code:    "invalid_cursor",
+			Message: "must be a next_cursor from an earlier page",
+		})
+	}
+	return nil
+}
+
+// postCursor is the position after a post in newest first order. The ID
+// breaks ties between posts created at the same instant.
+type postCursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	ID        uuid.UUID `json:"id"`
+}
+
+// newestPosts lists up to limit of the posts query matches, newest first,
+// starting after the given position when there is one. It also returns
+// the cursor of the next page, or nil on the last one.
+func newestPosts(query *gorm.DB, after *postCursor, limit int) ([]interfaces.Post, *string, error) {
+	if after != nil {
+		query = query.Where("(created_at, id) < (?, ?)", after.CreatedAt, after.ID)
+	}
+
+	// One extra row tells whether there is a next page
+	var posts []interfaces.Post
+	if err := query.Order("created_at DESC, id DESC").Limit(limit + 1).Find(&posts).Error; err != nil {
+		return nil, nil, err
+	}
+	if len(posts) <= limit {
+		return posts, nil, nil
+	}
+	posts = posts[:limit]
+	last := posts[limit-1]
+	next := encodeCursor(postCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	return posts, &next, nil
+}
@@ -0,0 +1,258 @@
+package db
+
+import (
+	"backend/interfaces"
+	"backend/problem"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxSearchLength is the longest search query accepted, in characters
+const maxSearchLength = 200
+
+// Searched text as Postgres indexes it. Titles weigh more than post
+// content, which weighs more than comments. Queries have to use the same
+// expressions for the indexes to apply.
+const (
+	postDocument    = "setweight(to_tsvector('english', posts.title), 'A') || setweight(to_tsvector('english', posts.content), 'B')"
+	commentDocument = "to_tsvector('english', comments.content)"
+)
+
+// searchIndexes are created by Migrate on Postgres
+var searchIndexes = []string{
+	"CREATE INDEX IF NOT EXISTS idx_posts_search ON posts USING gin ((" + strings.ReplaceAll(postDocument, "posts.", "") + "))",
+	"CREATE INDEX IF NOT EXISTS idx_comments_search ON comments USING gin ((" + strings.ReplaceAll(commentDocument, "comments.", "") + "))",
+}
+
+// Snippets mark matches with private use characters, which survive HTML
+// escaping and are then swapped for <mark> elements
+const (
+	matchStart = "\ue000"
+	matchStop  = "\ue001"
+)
+
+var highlighter = strings.NewReplacer(matchStart, "<mark>", matchStop, "</mark>")
+
+// highlight makes a marked snippet into escaped HTML
+func highlight(snippet string) string {
+	return highlighter.Replace(html.EscapeString(snippet))
+}
+
+// searchHit is a matching post or comment; Body is the text snippets are
+// cut from
+type searchHit struct {
+	Kind       string
+	ID         uuid.UUID
+	PostID     uuid.UUID
+	Title      string
+	AuthorID   uuid.UUID
+	CategoryID uuid.UUID
+	CreatedAt  time.Time
+	Rank       float64
+	Body       string
+	Snippet    string
+}
+
+func (h searchHit) response() interfaces.SearchResult {
+	return interfaces.SearchResult{
+		Type:       h.Kind,
+		ID:         h.ID,
+		PostID:     h.PostID,
+		PostTitle:  h.Title,
+		AuthorID:   h.AuthorID,
+		CategoryID: h.CategoryID,
+		CreatedAt:  h.CreatedAt,
+		Rank:       h.Rank,
+		Snippet:    highlight(h.Snippet),
+	}
+}
+
+// searchCursor is the position after a hit in rank order. The query is
+// kept so a cursor can't continue a different search.
+type searchCursor struct {
+	Query string    `json:"q"`
+	Rank  float64   `json:"rank"`
+	ID    uuid.UUID `json:"id"`
+}
+
+// Search finds posts and comments matching q, best first, with a snippet
+// of each around the matched words. Results can be narrowed to a category,
+// a tag and an author, all by name, and to those created from and before
+// given times. q is a web search query ("quoted phrases", or, -excluded).
+func Search(c *gin.Context) {
+	params, ok := readPageParams(c)
+	if !ok {
+		return
+	}
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" || utf8.RuneCountInString(q) > maxSearchLength {
+		problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+			Field:   "q",
+			Code:    "range",
+			Message: fmt.Sprintf("must be 1 to %d characters", maxSearchLength),
+		}))
+		return
+	}
+	var after *searchCursor
+	if params.cursor != "" {
+		after = &searchCursor{}
+		if !decodeCursor(c, params.cursor, after) {
+			return
+		}
+		if after.Query != q {
+			problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+				Field:   "cursor",
+				Code:    "invalid_cursor",
+				Message: "must come from a page of the same search",
+			}))
+			return
+		}
+	}
+	filter, ok := searchFilter(c)
+	if !ok {
+		return
+	}
+
+	var total int64
+	hits, next, err := searchPage(c, q, filter, after, params.limit)
+	if err == nil && params.includeTotal {
+		err = filter(requestDB(c).Table("(?) AS hits", matchingHits(requestDB(c), q))).Count(&total).Error
+	}
+	if err != nil {
+		problem.Respond(c, dbError(err, nil, nil, nil))
+		return
+	}
+
+	page := interfaces.Page[interfaces.SearchResult]{
+		Items:      interfaces.MapAll(hits, searchHit.response),
+		NextCursor: next,
+	}
+	if params.includeTotal {
+		page.Total = &total
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// searchFilter reads the category, tag, author, from and to parameters
+// into a filter on the hits relation, responding with a problem when one
+// is malformed or names nothing
+func searchFilter(c *gin.Context) (func(*gorm.DB) *gorm.DB, bool) {
+	var conditions []func(*gorm.DB) *gorm.DB
+	if name := c.Query("category"); name != "" {
+		var category interfaces.Category
+		if err := requestDB(c).Select("id").Where("name = ?", name).First(&category).Error; err != nil {
+			problem.Respond(c, dbError(err, problem.CategoryNotFound, nil, nil))
+			return nil, false
+		}
+		conditions = append(conditions, func(query *gorm.DB) *gorm.DB { return query.Where("hits.category_id = ?", category.ID) })
+	}
+	if name := c.Query("tag"); name != "" {
+		var tag interfaces.Tag
+		if err := requestDB(c).Select("id").Where("name = ?", name).First(&tag).Error; err != nil {
+			problem.Respond(c, dbError(err, problem.TagNotFound, nil, nil))
+			return nil, false
+		}
+		conditions = append(conditions, func(query *gorm.DB) *gorm.DB {
+			return query.Where("EXISTS (SELECT 1 FROM posts_tags WHERE posts_tags.post_id = hits.post_id AND posts_tags.tag_id = ?)", tag.ID)
+		})
+	}
+	if name := c.Query("author"); name != "" {
+		var author interfaces.User
+		if err := requestDB(c).Select("id").Where("username = ?", name).First(&author).Error; err != nil {
+			problem.Respond(c, dbError(err, problem.UserNotFound, nil, nil))
+			return nil, false
+		}
+		conditions = append(conditions, func(query *gorm.DB) *gorm.DB { return query.Where("hits.author_id = ?", author.ID) })
+	}
+
+	var from, to time.Time
+	for _, bound := range []struct {
+		name string
+		at   *time.Time
+		sql  string
+	}{
+		{"from", &from, "hits.created_at >= ?"},
+		{"to", &to, "hits.created_at < ?"},
+	} {
+		value := c.Query(bound.name)
+		if value == "" {
+			continue
+		}
+		at, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+				Field:   bound.name,
+				Code:    "invalid_datetime",
+				Message: "must be an RFC 3339 date-time",
+			}))
+			return nil, false
+		}
+		*bound.at = at
+		sql := bound.sql
+		conditions = append(conditions, func(query *gorm.DB) *gorm.DB { return query.Where(sql, at) })
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+			Field:   "to",
+			Code:    "range",
+			Message: "must be after from",
+		}))
+		return nil, false
+	}
+
+	return func(query *gorm.DB) *gorm.DB {
+		for _, condition := range conditions {
+			query = condition(query)
+		}
+		return query
+	}, true
+}
+
+// matchingHits is every post and comment matching q, ranked, as one
+// relation the filters and paging apply to
+func matchingHits(db *gorm.DB, q string) *gorm.DB {
+	return db.Raw(`SELECT 'post' AS kind, posts.id, posts.id AS post_id, posts.title, posts.author_id, posts.category_id, posts.created_at,
+	ts_rank(`+postDocument+`, query) AS rank, posts.content AS body
+FROM posts, websearch_to_tsquery('english', ?) AS query
+WHERE `+postDocument+` @@ query
+UNION ALL
+SELECT 'comment', comments.id, comments.post_id, posts.title, comments.author_id, posts.category_id, comments.created_at,
+	ts_rank(`+commentDocument+`, query), comments.content
+FROM comments JOIN posts ON posts.id = comments.post_id, websearch_to_tsquery('english', ?) AS query
+WHERE `+commentDocument+` @@ query`, q, q)
+}
+
+// searchPage pages through the hits with the full-text indexes, cutting
+// snippets only for the hits on the page, and returns the cursor of the
+// next page when there is one
+func searchPage(c *gin.Context, q string, filter func(*gorm.DB) *gorm.DB, after *searchCursor, limit int) ([]searchHit, *string, error) {
+	db := requestDB(c)
+	ranked := filter(db.Table("(?) AS hits", matchingHits(db, q)))
+	if after != nil {
+		ranked = ranked.Where("(hits.rank, hits.id) < (?, ?)", after.Rank, after.ID)
+	}
+	// One extra row tells whether there is a next page
+	ranked = ranked.Order("hits.rank DESC, hits.id DESC").Limit(limit + 1)
+
+	options := fmt.Sprintf(`StartSel="%s", StopSel="%s", MinWords=15, MaxWords=35, MaxFragments=2, FragmentDelimiter=" … "`, matchStart, matchStop)
+	var hits []searchHit
+	err := db.Table("(?) AS page", ranked).
+		Select("page.*, ts_headline('english', page.body, websearch_to_tsquery('english', ?), ?) AS snippet", q, options).
+		Order("page.rank DESC, page.id DESC").
+		Scan(&hits).Error
+	if err != nil || len(hits) <= limit {
+		return hits, nil, err
+	}
+	hits = hits[:limit]
+	last := hits[limit-1]
+	next := encodeCursor(searchCursor{Query: q, Rank: last.Rank, ID: last.ID})
+	return hits, &next, nil
+}
@@ -0,0 +1,254 @@
+package db
+
+import (
+	"backend/broker"
+	"backend/events"
+	"backend/interfaces"
+	"backend/logging"
+	"backend/problem"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// streams tells open comment streams that a post's comments changed; set by Use
+var streams broker.Broker
+
+// streamHeartbeat is how often an idle stream is sent a comment; set by Use
+var streamHeartbeat time.Duration
+
+// streamRetry is how long browsers wait before reconnecting a dropped stream
+const streamRetry = 3 * time.Second
+
+// streamBatch is how many changes a stream reads at a time
+const streamBatch = 100
+
+// CloseStreams ends every open comment stream. Streams never finish on
+// their own, so the server calls it when it starts draining.
+func CloseStreams() {
+	if streams != nil {
+		streams.Close()
+	}
+}
+
+// commentTopic is the broker topic for changes to the comments of a post
+func commentTopic(postID uuid.UUID) string {
+	return "comments:" + postID.String()
+}
+
+// lockPost locks a post for the rest of tx. Comment writes take it first,
+// so changes to one post's comments commit in the order their IDs were
+// given and a stream that has sent one never finds an older one later.
+func lockPost(tx *gorm.DB, postID uuid.UUID) (interfaces.Post, error) {
+	var post interfaces.Post
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "category_id").First(&post, "id = ?", postID).Error; err != nil {
+		return post, dbError(err, problem.PostNotFound, nil, nil)
+	}
+	return post, nil
+}
+
+// recordCommentChange adds comment, as it is after the change, to its
+// post's change log through tx
+func recordCommentChange(tx *gorm.DB, kind string, comment interfaces.Comment) error {
+	data, err := json.Marshal(interfaces.NewCommentResponse(comment))
+	if err != nil {
+		return problem.Internal.Wrap(err)
+	}
+	if err := tx.Create(&interfaces.CommentChange{
+		PostID:    comment.PostID,
+		CommentID: comment.ID,
+		Kind:      kind,
+		Data:      string(data),
+		CreatedAt: time.Now(),
+	}).Error; err != nil {
+		return problem.Internal.Wrap(err)
+	}
+	return nil
+}
+
+// notifyCommentStreams tells the open streams of a post to look for
+// changes as soon as a comment write commits, without waiting for the
+// event dispatcher, which the Vercel handler never runs. A failed publish
+// is only logged: announceCommentChange repeats it once the event is
+// dispatched, and idle streams look for themselves every heartbeat.
+func notifyCommentStreams(ctx context.Context, postID uuid.UUID, eventType string) {
+	if err := streams.Publish(ctx, commentTopic(postID), eventType); err != nil {
+		logging.FromContext(ctx).Warn("comment stream announcement failed", "post_id", postID, "error", err)
+	}
+}
+
+// announceCommentChange tells the streams of the event's post to look for
+// changes. The change itself was logged with the write, and streams only
+// send changes newer than their last, so repeating an announcement the
+// write already made is harmless.
+func announceCommentChange(ctx context.Context, event interfaces.OutboxEvent) error {
+	var payload struct {
+		PostID uuid.UUID `json:"post_id"`
+	}
+	if err := events.Decode(event, &payload); err != nil {
+		return err
+	}
+	return streams.Publish(ctx, commentTopic(payload.PostID), event.Type)
+}
+
+// StreamComments sends the comments created, edited and deleted on a post
+// as Server-Sent Events named after the comment event types, each with
+// the comment as data and its change as id. A client reconnecting with
+// Last-Event-ID, or lastEventId in the query for clients that can't set
+// headers, first gets every change it missed; one whose changes have been
+// purged gets a reset event and should reload the comments instead.
+func StreamComments(c *gin.Context) {
+	postID, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	lastID, ok := lastEventID(c)
+	if !ok {
+		return
+	}
+
+	var post interfaces.Post
+	if err := requestDB(c).Select("id").First(&post, "id = ?", postID).Error; err != nil {
+		problem.Respond(c, dbError(err, problem.PostNotFound, nil, nil))
+		return
+	}
+
+	// Subscribing before reading the log means nothing committed in
+	// between goes unannounced
+	sub := streams.Subscribe(commentTopic(postID))
+	defer sub.Close()
+
+	reset := false
+	var err error
+	if lastID == 0 {
+		lastID, err = latestCommentChange(c, postID)
+	} else {
+		reset, err = commentChangesPurged(c, lastID)
+		if reset {
+			lastID, err = latestCommentChange(c, postID)
+		}
+	}
+	if err != nil {
+		problem.Respond(c, err)
+		return
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no") // Keep nginx from holding events back
+	c.Status(http.StatusOK)
+
+	// HTTP_WRITE_TIMEOUT is for ordinary responses; a stream lasts as long
+	// as the client stays
+	controller := http.NewResponseController(c.Writer)
+	controller.SetWriteDeadline(time.Time{})
+
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", streamRetry.Milliseconds())
+	if reset {
+		fmt.Fprintf(c.Writer, "event: reset\ndata: {\"post_id\":%q}\n\n", postID)
+	}
+	lastID, err = sendCommentChanges(c, postID, lastID)
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for err == nil {
+		if err = controller.Flush(); err != nil {
+			break
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case _, open := <-sub.C:
+			if !open {
+				return
+			}
+			lastID, err = sendCommentChanges(c, postID, lastID)
+		case <-heartbeat.C:
+			// Announcements can be lost, so idle streams look for themselves too
+			lastID, err = sendCommentChanges(c, postID, lastID)
+			if err == nil {
+				_, err = fmt.Fprint(c.Writer, ": heartbeat\n\n")
+			}
+		}
+	}
+	if c.Request.Context().Err() == nil {
+		logging.From(c).Warn("comment stream ended", "post_id", postID, "error", err)
+	}
+}
+
+// lastEventID reads the change a reconnecting client saw last, or 0 for a
+// new stream
+func lastEventID(c *gin.Context) (int64, bool) {
+	value := c.GetHeader("Last-Event-ID")
+	if value == "" {
+		value = c.Query("lastEventId")
+	}
+	if value == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id < 0 {
+		problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+			Field:   "Last-Event-ID",
+			Code:    "invalid_integer",
+			Message: "must be a non-negative integer",
+		}))
+		return 0, false
+	}
+	return id, true
+}
+
+// latestCommentChange is the ID of the newest change to a post's comments,
+// or 0 when there is none
+func latestCommentChange(c *gin.Context, postID uuid.UUID) (int64, error) {
+	var latest *int64
+	err := requestDB(c).Model(&interfaces.CommentChange{}).Where("post_id = ?", postID).Select("MAX(id)").Scan(&latest).Error
+	if err != nil {
+		return 0, dbError(err, nil, nil, nil)
+	}
+	if latest == nil {
+		return 0, nil
+	}
+	return *latest, nil
+}
+
+// commentChangesPurged reports whether changes after lastID may have been
+// purged. It looks across every post, so it can be wrong in the safe
+// direction only.
+func commentChangesPurged(c *gin.Context, lastID int64) (bool, error) {
+	var oldest *int64
+	if err := requestDB(c).Model(&interfaces.CommentChange{}).Select("MIN(id)").Scan(&oldest).Error; err != nil {
+		return false, dbError(err, nil, nil, nil)
+	}
+	return oldest != nil && *oldest > lastID+1, nil
+}
+
+// sendCommentChanges writes the changes to a post's comments after lastID
+// and returns the ID of the last one written
+func sendCommentChanges(c *gin.Context, postID uuid.UUID, lastID int64) (int64, error) {
+	for {
+		var changes []interfaces.CommentChange
+		if err := requestDB(c).Where("post_id = ? AND id > ?", postID, lastID).Order("id").Limit(streamBatch).Find(&changes).Error; err != nil {
+			return lastID, err
+		}
+		for _, change := range changes {
+			if _, err := fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", change.ID, change.Kind, change.Data); err != nil {
+				return lastID, err
+			}
+			lastID = change.ID
+		}
+		if len(changes) < streamBatch {
+			return lastID, nil
+		}
+	}
+}
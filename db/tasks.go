@@ -0,0 +1,135 @@
+package db
+
+import (
+	"backend/interfaces"
+	"backend/logging"
+	"backend/schedule"
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Retention periods, set by Use from the configuration
+var (
+	jobRetention    time.Duration
+	runHistory      time.Duration
+	eventRetention  time.Duration
+	streamRetention time.Duration
+)
+
+func init() {
+	schedule.Register("purge-idempotency-keys", "@hourly", purgeIdempotencyKeys)
+	schedule.Register("purge-jobs", "15 3 * * *", purgeJobs)
+	schedule.Register("purge-schedule-runs", "45 3 * * *", purgeScheduleRuns)
+	schedule.Register("purge-events", "30 3 * * *", purgeEvents)
+	schedule.Register("purge-comment-changes", "@hourly", purgeCommentChanges)
+	schedule.Register("purge-orphaned-avatars", "0 4 * * *", purgeOrphanedAvatars)
+	schedule.Register("refresh-post-stats", "@hourly", refreshAllPostStats)
+}
+
+// purgeIdempotencyKeys deletes stored responses that can no longer be replayed
+func purgeIdempotencyKeys(ctx context.Context) error {
+	result := DB.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&interfaces.IdempotencyKey{})
+	if result.Error != nil {
+		return result.Error
+	}
+	logging.FromContext(ctx).Info("purged idempotency keys", "deleted", result.RowsAffected)
+	return nil
+}
+
+// purgeJobs deletes succeeded jobs older than JOBS_RETENTION
+func purgeJobs(ctx context.Context) error {
+	deleted, err := queue.Purge(ctx, time.Now().Add(-jobRetention))
+	if err != nil {
+		return err
+	}
+	logging.FromContext(ctx).Info("purged jobs", "deleted", deleted)
+	return nil
+}
+
+// purgeScheduleRuns deletes run history older than SCHEDULER_HISTORY
+func purgeScheduleRuns(ctx context.Context) error {
+	result := DB.WithContext(ctx).
+		Where("started_at < ? AND status <> ?", time.Now().Add(-runHistory), interfaces.RunRunning).
+		Delete(&interfaces.ScheduleRun{})
+	if result.Error != nil {
+		return result.Error
+	}
+	logging.FromContext(ctx).Info("purged schedule runs", "deleted", result.RowsAffected)
+	return nil
+}
+
+// purgeEvents deletes dispatched outbox events older than EVENTS_RETENTION
+// and their deliveries. Failed events stay for inspection.
+func purgeEvents(ctx context.Context) error {
+	var result *gorm.DB
+	err := DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result = tx.Where("status = ? AND dispatched_at < ?", interfaces.EventDispatched, time.Now().Add(-eventRetention)).
+			Delete(&interfaces.OutboxEvent{})
+		if result.Error != nil {
+			return result.Error
+		}
+		return tx.Where("NOT EXISTS (SELECT 1 FROM outbox_events WHERE outbox_events.id = outbox_deliveries.event_id)").
+			Delete(&interfaces.OutboxDelivery{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	logging.FromContext(ctx).Info("purged events", "deleted", result.RowsAffected)
+	return nil
+}
+
+// purgeCommentChanges deletes comment changes older than STREAM_RETENTION.
+// Streams resuming from before them are told to reload instead.
+func purgeCommentChanges(ctx context.Context) error {
+	result := DB.WithContext(ctx).Where("created_at < ?", time.Now().Add(-streamRetention)).Delete(&interfaces.CommentChange{})
+	if result.Error != nil {
+		return result.Error
+	}
+	logging.FromContext(ctx).Info("purged comment changes", "deleted", result.RowsAffected)
+	return nil
+}
+
+// orphanedAvatarAge is how old an avatar no user points at must be before
+// it is deleted. Sign up uploads the avatar before the account exists.
+const orphanedAvatarAge = 24 * time.Hour
+
+// purgeOrphanedAvatars deletes stored avatars that no user's avatar_url
+// points at, e.g. when the deletion job gave up or the user was removed by
+// hand. Avatars are named after the username at upload time, so the URL,
+// not the current username, says whose an image is.
+func purgeOrphanedAvatars(ctx context.Context) error {
+	if images == nil {
+		return nil
+	}
+	stored, err := images.ListImages(ctx)
+	if err != nil {
+		return err
+	}
+
+	var urls []string
+	if err := DB.WithContext(ctx).Model(&interfaces.User{}).Where("avatar_url <> ''").Pluck("avatar_url", &urls).Error; err != nil {
+		return err
+	}
+	referenced := make(map[string]bool, len(urls))
+	for _, url := range urls {
+		if id, ok := imageID(url); ok {
+			referenced[id] = true
+		}
+	}
+
+	cutoff := time.Now().Add(-orphanedAvatarAge)
+	deleted := 0
+	for _, image := range stored {
+		if referenced[image.PublicID] || !image.CreatedAt.Before(cutoff) {
+			continue
+		}
+		if err := images.DeleteImageByID(ctx, image.PublicID); err != nil {
+			return err
+		}
+		deleted++
+	}
+	logging.FromContext(ctx).Info("purged orphaned avatars", "deleted", deleted)
+	return nil
+}
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"backend/events"
+	"backend/problem"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// MaxBodyBytes caps JSON request bodies; the largest valid payload, a post,
+// is well below it
+const MaxBodyBytes = 1 << 20
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// normalizer is implemented by request types that clean up their fields,
+// e.g. trimming whitespace, before they are validated
+type normalizer interface {
+	Normalize()
+}
+
+func init() {
+	engine, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+
+	// Report fields by their JSON name so errors match the request body
+	engine.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+	engine.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return usernamePattern.MatchString(fl.Field().String())
+	})
+	engine.RegisterValidation("event_type", func(fl validator.FieldLevel) bool {
+		return slices.Contains(events.Types(), fl.Field().String())
+	})
+}
+
+// bindJSON decodes the request body into v, normalizes and validates it.
+// It responds with a problem and returns false when the body is malformed
+// or any field is invalid, so handlers never query with bad input.
+func bindJSON(c *gin.Context, v interface{}) bool {
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, MaxBodyBytes)
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			problem.Respond(c, problem.BodyTooLarge.WithDetail("Request body exceeds %d bytes", tooLarge.Limit))
+		case errors.Is(err, io.EOF):
+			problem.Respond(c, problem.InvalidJSON.WithDetail("Request body is empty"))
+		default:
+			problem.Respond(c, problem.InvalidJSON.WithDetail("Request body could not be decoded: %v", err))
+		}
+		return false
+	}
+
+	if fields := validate(v); len(fields) > 0 {
+		problem.Respond(c, problem.ValidationFailed.WithFields(fields...))
+		return false
+	}
+	return true
+}
+
+// validate normalizes and checks v, which may be a struct or a slice of
+// structs. Slice elements are reported as "[i].field".
+func validate(v interface{}) []problem.FieldError {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+
+	if value.Kind() == reflect.Slice {
+		var fields []problem.FieldError
+		for i := 0; i < value.Len(); i++ {
+			for _, field := range validate(value.Index(i).Addr().Interface()) {
+				field.Field = fmt.Sprintf("[%d].%s", i, field.Field)
+				fields = append(fields, field)
+			}
+		}
+		return fields
+	}
+
+	if n, ok := v.(normalizer); ok {
+		n.Normalize()
+	}
+
+	err := binding.Validator.ValidateStruct(v)
+	if err == nil {
+		return nil
+	}
+
+	var invalid validator.ValidationErrors
+	if !errors.As(err, &invalid) {
+		return []problem.FieldError{{Code: "invalid", Message: err.Error()}}
+	}
+
+	fields := make([]problem.FieldError, 0, len(invalid))
+	for _, fe := range invalid {
+		fields = append(fields, problem.FieldError{
+			Field:   fieldPath(fe),
+			Code:    fe.Tag(),
+			Message: validationMessage(fe),
+		})
+	}
+	return fields
+}
+
+// fieldPath drops the struct name from the validator namespace
+func fieldPath(fe validator.FieldError) string {
+	namespace := fe.Namespace()
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		if fe.Kind() == reflect.Slice {
+			return fmt.Sprintf("must have at least %s items", fe.Param())
+		}
+		return fmt.Sprintf("must be at least %s characters", fe.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s characters", fe.Param())
+	case "email":
+		return "must be a valid email address"
+	case "url":
+		return "must be a valid URL"
+	case "http_url":
+		return "must be an http or https URL"
+	case "event_type":
+		return "must be one of " + strings.Join(events.Types(), ", ")
+	case "username":
+		return "may only contain letters, digits, '.', '_' and '-'"
+	default:
+		return fmt.Sprintf("failed the %q rule", fe.Tag())
+	}
+}
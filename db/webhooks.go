@@ -0,0 +1,428 @@
+package db
+
+import (
+	"backend/events"
+	"backend/interfaces"
+	"backend/jobs"
+	"backend/logging"
+	"backend/problem"
+	"backend/webhooks"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Webhook delivery settings, set by Use from the configuration
+var (
+	webhookSender   *webhooks.Sender
+	webhookAttempts int
+)
+
+// deliverWebhookJob sends one webhook delivery
+const deliverWebhookJob = "webhook.deliver"
+
+type deliverWebhookPayload struct {
+	DeliveryID uuid.UUID `json:"delivery_id"`
+}
+
+func init() {
+	jobs.Handle(deliverWebhookJob, deliverWebhook)
+}
+
+// webhookEnvelope is the body every delivery posts
+type webhookEnvelope struct {
+	ID        uuid.UUID       `json:"id"` // The event's, the same on every webhook and replay
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// queueWebhookDeliveries records a delivery of event for every active
+// webhook that wants it and queues a job to send each
+func queueWebhookDeliveries(ctx context.Context, event interfaces.OutboxEvent) error {
+	var hooks []interfaces.Webhook
+	if err := DB.WithContext(ctx).Where("active = ?", true).Find(&hooks).Error; err != nil {
+		return err
+	}
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	// Events about posts and comments carry the post's category
+	var scope struct {
+		CategoryID uuid.UUID `json:"category_id"`
+	}
+	if err := events.Decode(event, &scope); err != nil {
+		return err
+	}
+	body, err := json.Marshal(webhookEnvelope{
+		ID:        event.ID,
+		Type:      event.Type,
+		CreatedAt: event.CreatedAt,
+		Data:      json.RawMessage(event.Payload),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range hooks {
+		if !webhookWants(hook, event.Type, scope.CategoryID) {
+			continue
+		}
+		delivery := interfaces.WebhookDelivery{
+			WebhookID: hook.ID,
+			EventID:   event.ID,
+			EventType: event.Type,
+			Payload:   string(body),
+			Status:    interfaces.DeliveryPending,
+		}
+		// A redelivered event finds its deliveries already recorded
+		result := DB.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&delivery)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			continue
+		}
+		if err := enqueueDelivery(ctx, delivery.ID); err != nil {
+			// Without its job the delivery would never be sent, so drop it
+			// and let the event's retry record it again
+			DB.WithContext(ctx).Delete(&delivery)
+			return err
+		}
+	}
+	return nil
+}
+
+// webhookWants reports whether hook subscribes to events of eventType in
+// categoryID, which is zero for events outside any category
+func webhookWants(hook interfaces.Webhook, eventType string, categoryID uuid.UUID) bool {
+	if !slices.Contains(strings.Split(hook.Events, ","), eventType) {
+		return false
+	}
+	if hook.CategoryIDs == "" {
+		return true
+	}
+	return categoryID != uuid.Nil && slices.Contains(strings.Split(hook.CategoryIDs, ","), categoryID.String())
+}
+
+func enqueueDelivery(ctx context.Context, id uuid.UUID) error {
+	_, err := queue.Enqueue(ctx, deliverWebhookJob, deliverWebhookPayload{DeliveryID: id}, jobs.MaxAttempts(webhookAttempts))
+	return err
+}
+
+// deliverWebhook sends a delivery and logs the attempt on it
+func deliverWebhook(ctx context.Context, job interfaces.Job) error {
+	var payload deliverWebhookPayload
+	if err := jobs.Decode(job, &payload); err != nil {
+		return err
+	}
+
+	var delivery interfaces.WebhookDelivery
+	if err := DB.WithContext(ctx).First(&delivery, "id = ?", payload.DeliveryID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Deleted along with its webhook, which may happen mid-flight
+			return nil
+		}
+		return err
+	}
+	if delivery.Status != interfaces.DeliveryPending {
+		return nil
+	}
+	var hook interfaces.Webhook
+	if err := DB.WithContext(ctx).First(&hook, "id = ?", delivery.WebhookID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	var res webhooks.Response
+	err := errors.New("webhook is disabled")
+	if hook.Active {
+		res, err = webhookSender.Send(ctx, webhooks.Request{
+			URL:        hook.URL,
+			Secret:     hook.Secret,
+			Event:      delivery.EventType,
+			DeliveryID: delivery.ID,
+			Body:       []byte(delivery.Payload),
+		})
+	}
+
+	updates := map[string]interface{}{
+		"attempts":        gorm.Expr("attempts + 1"),
+		"response_status": res.Status,
+		"response_body":   res.Body,
+		"last_error":      "",
+	}
+	switch {
+	case err == nil:
+		updates["status"] = interfaces.DeliverySucceeded
+		updates["delivered_at"] = time.Now()
+	case !hook.Active || res.Status == http.StatusGone || job.Attempts >= job.MaxAttempts:
+		// 410 Gone is a receiver saying it will never accept deliveries
+		updates["status"] = interfaces.DeliveryFailed
+		updates["last_error"] = err.Error()
+		err = jobs.Permanent(err)
+	default:
+		updates["last_error"] = err.Error()
+	}
+	if saveErr := DB.WithContext(ctx).Model(&delivery).Updates(updates).Error; saveErr != nil {
+		logging.FromContext(ctx).Error("logging webhook delivery failed", "delivery_id", delivery.ID, "error", saveErr)
+	}
+	return err
+}
+
+// maxDeliveriesListed caps GET /admin/webhooks/:id/deliveries
+const maxDeliveriesListed = 200
+
+// Webhook admin handlers
+func ListWebhooks(c *gin.Context) {
+	var hooks []interfaces.Webhook
+	if err := requestDB(c).Order("created_at").Find(&hooks).Error; err != nil {
+		problem.Respond(c, dbError(err, nil, nil, nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, interfaces.MapAll(hooks, interfaces.NewWebhookResponse))
+}
+
+func CreateWebhook(c *gin.Context) {
+	var req interfaces.WebhookRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if err := checkCategories(c, req.CategoryIDs); err != nil {
+		problem.Respond(c, err)
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		generated, err := webhooks.NewSecret()
+		if err != nil {
+			problem.Respond(c, problem.Internal.Wrap(err))
+			return
+		}
+		secret = generated
+	}
+	hook := interfaces.Webhook{
+		URL:         req.URL,
+		Secret:      secret,
+		Events:      strings.Join(req.Events, ","),
+		CategoryIDs: joinIDs(req.CategoryIDs),
+		Active:      req.Active == nil || *req.Active,
+	}
+	if err := requestDB(c).Create(&hook).Error; err != nil {
+		problem.Respond(c, dbError(err, nil, nil, nil))
+		return
+	}
+
+	res := interfaces.NewWebhookResponse(hook)
+	res.Secret = secret
+	c.JSON(http.StatusCreated, res)
+}
+
+func GetWebhook(c *gin.Context) {
+	hook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, interfaces.NewWebhookResponse(hook))
+}
+
+// UpdateWebhook replaces a webhook's settings
+func UpdateWebhook(c *gin.Context) {
+	hook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	var req interfaces.WebhookRequest
+	if !bindJSON(c, &req) {
+		return
+	}
+	if err := checkCategories(c, req.CategoryIDs); err != nil {
+		problem.Respond(c, err)
+		return
+	}
+
+	updates := map[string]interface{}{
+		"url":          req.URL,
+		"events":       strings.Join(req.Events, ","),
+		"category_ids": joinIDs(req.CategoryIDs),
+		"active":       req.Active == nil || *req.Active,
+		"updated_at":   updateTime(),
+	}
+	if req.Secret != "" {
+		updates["secret"] = req.Secret
+	}
+	if err := requestDB(c).Model(&hook).Updates(updates).Error; err != nil {
+		problem.Respond(c, dbError(err, nil, nil, nil))
+		return
+	}
+	if err := requestDB(c).First(&hook, "id = ?", hook.ID).Error; err != nil {
+		problem.Respond(c, dbError(err, problem.WebhookNotFound, nil, nil))
+		return
+	}
+
+	res := interfaces.NewWebhookResponse(hook)
+	res.Secret = req.Secret
+	c.JSON(http.StatusOK, res)
+}
+
+// DeleteWebhook removes a webhook and its delivery log
+func DeleteWebhook(c *gin.Context) {
+	hook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	err := requestDB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", hook.ID).Delete(&interfaces.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&hook).Error
+	})
+	if err != nil {
+		problem.Respond(c, dbError(err, nil, nil, nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// ListWebhookDeliveries shows a webhook's delivery log, newest first
+func ListWebhookDeliveries(c *gin.Context) {
+	hook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+
+	query := requestDB(c).Where("webhook_id = ?", hook.ID).Order("created_at DESC").Limit(50)
+	switch status := c.Query("status"); status {
+	case "":
+	case interfaces.DeliveryPending, interfaces.DeliverySucceeded, interfaces.DeliveryFailed:
+		query = query.Where("status = ?", status)
+	default:
+		problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+			Field:   "status",
+			Code:    "oneof",
+			Message: "must be one of pending, succeeded or failed",
+		}))
+		return
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxDeliveriesListed {
+			problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+				Field:   "limit",
+				Code:    "range",
+				Message: "must be an integer from 1 to 200",
+			}))
+			return
+		}
+		query = query.Limit(n)
+	}
+
+	var deliveries []interfaces.WebhookDelivery
+	if err := query.Find(&deliveries).Error; err != nil {
+		problem.Respond(c, dbError(err, nil, nil, nil))
+		return
+	}
+
+	c.JSON(http.StatusOK, interfaces.MapAll(deliveries, interfaces.NewWebhookDeliveryResponse))
+}
+
+// ReplayWebhookDelivery sends a finished delivery again, with the same body
+func ReplayWebhookDelivery(c *gin.Context) {
+	hook, ok := loadWebhook(c)
+	if !ok {
+		return
+	}
+	deliveryID, ok := parseID(c, "delivery_id")
+	if !ok {
+		return
+	}
+
+	var delivery interfaces.WebhookDelivery
+	if err := requestDB(c).First(&delivery, "id = ? AND webhook_id = ?", deliveryID, hook.ID).Error; err != nil {
+		problem.Respond(c, dbError(err, problem.DeliveryNotFound, nil, nil))
+		return
+	}
+
+	// The status only changes back from a finished one, so two replays
+	// can't queue two jobs. The job must see pending, so it is queued after.
+	result := requestDB(c).Model(&interfaces.WebhookDelivery{}).
+		Where("id = ? AND status <> ?", delivery.ID, interfaces.DeliveryPending).
+		Updates(map[string]interface{}{"status": interfaces.DeliveryPending, "updated_at": updateTime()})
+	if result.Error != nil {
+		problem.Respond(c, dbError(result.Error, nil, nil, nil))
+		return
+	}
+	if result.RowsAffected == 0 {
+		problem.Respond(c, problem.DeliveryPending)
+		return
+	}
+	if err := enqueueDelivery(c.Request.Context(), delivery.ID); err != nil {
+		requestDB(c).Model(&delivery).Update("status", delivery.Status)
+		problem.Respond(c, problem.Internal.Wrap(err))
+		return
+	}
+
+	delivery.Status = interfaces.DeliveryPending
+	c.JSON(http.StatusAccepted, interfaces.NewWebhookDeliveryResponse(delivery))
+}
+
+// loadWebhook loads the webhook named by the id parameter, responding with
+// a problem when it can't
+func loadWebhook(c *gin.Context) (interfaces.Webhook, bool) {
+	var hook interfaces.Webhook
+	id, ok := parseID(c, "id")
+	if !ok {
+		return hook, false
+	}
+	if err := requestDB(c).First(&hook, "id = ?", id).Error; err != nil {
+		problem.Respond(c, dbError(err, problem.WebhookNotFound, nil, nil))
+		return hook, false
+	}
+	return hook, true
+}
+
+// checkCategories makes sure every id names a category
+func checkCategories(c *gin.Context, ids []uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	unique := map[uuid.UUID]bool{}
+	for _, id := range ids {
+		unique[id] = true
+	}
+	var count int64
+	if err := requestDB(c).Model(&interfaces.Category{}).Where("id IN ?", ids).Count(&count).Error; err != nil {
+		return dbError(err, nil, nil, nil)
+	}
+	if int(count) != len(unique) {
+		return problem.ReferenceUnknown.WithDetail("category_ids contains an unknown category")
+	}
+	return nil
+}
+
+func joinIDs(ids []uuid.UUID) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = id.String()
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,240 @@
+package events
+
+import (
+	"backend/config"
+	"backend/interfaces"
+	"backend/logging"
+	"backend/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Handler reacts to one event. Events are delivered at least once: a
+// subscriber that fails sees the event again on the retry, and one that
+// succeeded but couldn't be recorded as such may too, so handlers must
+// tolerate duplicates. Subscribers that succeeded aren't called again.
+type Handler func(ctx context.Context, event interfaces.OutboxEvent) error
+
+// All subscribes a handler to every event type
+const All = "*"
+
+type subscriber struct {
+	name    string
+	handler Handler
+}
+
+// Registry maps event types to the subscribers a dispatcher delivers them to
+type Registry struct {
+	mu          sync.RWMutex
+	subscribers map[string][]subscriber
+}
+
+// NewRegistry creates a registry without subscribers
+func NewRegistry() *Registry {
+	return &Registry{subscribers: map[string][]subscriber{}}
+}
+
+// Default is the registry Subscribe adds to and the server dispatches from
+var Default = NewRegistry()
+
+// Subscribe registers handler with Default; see Registry.Subscribe
+func Subscribe(eventType, name string, handler Handler) {
+	Default.Subscribe(eventType, name, handler)
+}
+
+// Subscribe registers handler, under name, for events of eventType or of
+// every type when it is All. Subscribing a name again replaces its
+// handler. The name is what deliveries are recorded under, so it must
+// stay the same across releases.
+func (r *Registry) Subscribe(eventType, name string, handler Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, list := range r.subscribers {
+		for i, sub := range list {
+			if sub.name == name {
+				list[i].handler = handler
+				return
+			}
+		}
+	}
+	r.subscribers[eventType] = append(r.subscribers[eventType], subscriber{name: name, handler: handler})
+}
+
+func (r *Registry) subscribersFor(eventType string) []subscriber {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := append([]subscriber{}, r.subscribers[eventType]...)
+	return append(list, r.subscribers[All]...)
+}
+
+// Dispatcher hands committed outbox events to their subscribers. Any
+// number of instances may run one: each event is claimed by exactly one
+// of them, and events left dispatching for twice the timeout are assumed
+// abandoned and claimed again.
+type Dispatcher struct {
+	db       *gorm.DB
+	cfg      config.EventsConfig
+	registry *Registry
+}
+
+// NewDispatcher creates a dispatcher delivering the outbox in conn to the
+// subscribers in registry
+func NewDispatcher(conn *gorm.DB, cfg config.EventsConfig, registry *Registry) *Dispatcher {
+	return &Dispatcher{db: conn, cfg: cfg, registry: registry}
+}
+
+// Run dispatches events until ctx is cancelled, sleeping for the poll
+// interval whenever the outbox is empty
+func (d *Dispatcher) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		ran, err := d.DispatchNext(ctx)
+		if err != nil {
+			slog.Error("dispatching event failed", "error", err)
+		}
+		if ran && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(d.cfg.PollInterval):
+		}
+	}
+}
+
+// DispatchNext claims the oldest ready event and delivers it, reporting
+// whether there was one. Tests call it to drain the outbox.
+func (d *Dispatcher) DispatchNext(ctx context.Context) (bool, error) {
+	now := time.Now()
+	event, err := d.claim(ctx, now, now.Add(-2*d.cfg.Timeout))
+	if err != nil || event == nil {
+		return false, err
+	}
+
+	// The delivery outlives a shutdown so it can record how it ended
+	ctx = context.WithoutCancel(ctx)
+	logger := slog.Default().With("event_id", event.ID, "type", event.Type, "attempt", event.Attempts)
+	err = d.deliver(logging.WithLogger(ctx, logger), *event)
+
+	updates := map[string]interface{}{"locked_at": nil}
+	status := interfaces.EventDispatched
+	switch {
+	case err == nil:
+		updates["dispatched_at"] = time.Now()
+	case event.Attempts >= d.cfg.MaxAttempts:
+		status = interfaces.EventFailed
+		logger.Error("event failed", "error", err)
+	default:
+		status = interfaces.EventPending
+		updates["next_attempt_at"] = time.Now().Add(d.backoff(event.Attempts))
+		logger.Warn("event delivery failed, retrying", "error", err, "next_attempt_at", updates["next_attempt_at"])
+	}
+	updates["status"] = status
+	if err != nil {
+		updates["last_error"] = err.Error()
+	}
+	metrics.ObserveEvent(event.Type, status)
+
+	// Matching the attempt keeps a dispatcher that overran its lease from
+	// overwriting the one that reclaimed the event
+	return true, d.db.WithContext(ctx).Model(&interfaces.OutboxEvent{}).
+		Where("id = ? AND status = ? AND attempts = ?", event.ID, interfaces.EventDispatching, event.Attempts).
+		Updates(updates).Error
+}
+
+// claim marks the oldest ready event dispatching and returns it, or nil
+// when none is ready
+func (d *Dispatcher) claim(ctx context.Context, now, staleBefore time.Time) (*interfaces.OutboxEvent, error) {
+	var event interfaces.OutboxEvent
+	err := d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("(status = ? AND next_attempt_at <= ?) OR (status = ? AND locked_at < ?)",
+				interfaces.EventPending, now, interfaces.EventDispatching, staleBefore).
+			Order("created_at").
+			Take(&event).Error
+		if err != nil {
+			return err
+		}
+
+		event.Status = interfaces.EventDispatching
+		event.Attempts++
+		event.LockedAt = &now
+		return tx.Model(&event).Updates(map[string]interface{}{
+			"status":    event.Status,
+			"attempts":  event.Attempts,
+			"locked_at": now,
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// deliver calls every subscriber of event that hasn't handled it yet
+// within the timeout, records those that succeed and joins the errors of
+// those that don't
+func (d *Dispatcher) deliver(ctx context.Context, event interfaces.OutboxEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.Timeout)
+	defer cancel()
+
+	var delivered []string
+	if err := d.db.WithContext(ctx).Model(&interfaces.OutboxDelivery{}).
+		Where("event_id = ?", event.ID).Pluck("subscriber", &delivered).Error; err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, sub := range d.registry.subscribersFor(event.Type) {
+		if slices.Contains(delivered, sub.name) {
+			continue
+		}
+		err := call(ctx, sub, event)
+		if err == nil {
+			err = d.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&interfaces.OutboxDelivery{
+				EventID:     event.ID,
+				Subscriber:  sub.name,
+				DeliveredAt: time.Now(),
+			}).Error
+		}
+		if err != nil {
+			logging.FromContext(ctx).Warn("event subscriber failed", "subscriber", sub.name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", sub.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// call runs one subscriber, turning a panic into an error
+func call(ctx context.Context, sub subscriber, event interfaces.OutboxEvent) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("subscriber panicked: %v", r)
+		}
+	}()
+	return sub.handler(ctx, event)
+}
+
+// backoff is the delay after the given failed attempt: Backoff doubled
+// for each earlier attempt, capped at MaxBackoff
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.cfg.Backoff
+	for i := 1; i < attempt && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > d.cfg.MaxBackoff {
+		delay = d.cfg.MaxBackoff
+	}
+	return delay
+}
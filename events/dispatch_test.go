@@ -0,0 +1,155 @@
+package events_test
+
+import (
+	"backend/config"
+	"backend/events"
+	"backend/interfaces"
+	"backend/testutil"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var testConfig = config.EventsConfig{
+	PollInterval: time.Millisecond,
+	Timeout:      time.Minute,
+	MaxAttempts:  3,
+	Backoff:      20 * time.Millisecond,
+	MaxBackoff:   80 * time.Millisecond,
+}
+
+func newStore(t *testing.T) *gorm.DB {
+	store := testutil.NewStore(t)
+	if err := store.AutoMigrate(&interfaces.OutboxEvent{}, &interfaces.OutboxDelivery{}); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// drain dispatches until no event is ready and returns how many ran
+func drain(t *testing.T, dispatcher *events.Dispatcher) int {
+	t.Helper()
+	n := 0
+	for {
+		ran, err := dispatcher.DispatchNext(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ran {
+			return n
+		}
+		n++
+	}
+}
+
+// settle drains until no event is left pending, waiting out backoffs
+func settle(t *testing.T, store *gorm.DB, dispatcher *events.Dispatcher) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		drain(t, dispatcher)
+		var pending int64
+		if err := store.Model(&interfaces.OutboxEvent{}).Where("status = ?", interfaces.EventPending).Count(&pending).Error; err != nil {
+			t.Fatal(err)
+		}
+		if pending == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d events still pending", pending)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestOnlyCommittedEventsAreDispatched(t *testing.T) {
+	var got []events.PostCreated
+	registry := events.NewRegistry()
+	registry.Subscribe(events.TypePostCreated, "test.committed", func(ctx context.Context, event interfaces.OutboxEvent) error {
+		var payload events.PostCreated
+		if err := events.Decode(event, &payload); err != nil {
+			return err
+		}
+		got = append(got, payload)
+		return nil
+	})
+
+	store := newStore(t)
+	committed := events.PostCreated{PostID: uuid.New(), Title: "Kept"}
+	if err := store.Transaction(func(tx *gorm.DB) error {
+		return events.Record(tx, committed)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	store.Transaction(func(tx *gorm.DB) error {
+		events.Record(tx, events.PostCreated{PostID: uuid.New(), Title: "Rolled back"})
+		return errors.New("abort")
+	})
+
+	if n := drain(t, events.NewDispatcher(store, testConfig, registry)); n != 1 {
+		t.Fatalf("dispatched %d events, want 1", n)
+	}
+	if len(got) != 1 || got[0].PostID != committed.PostID || got[0].Title != "Kept" {
+		t.Fatalf("subscriber got %+v, want only the committed event", got)
+	}
+
+	var event interfaces.OutboxEvent
+	store.First(&event)
+	if event.Status != interfaces.EventDispatched || event.DispatchedAt == nil {
+		t.Errorf("event status = %s, dispatched at %v; want dispatched", event.Status, event.DispatchedAt)
+	}
+}
+
+func TestFailingSubscribersRetryThenFail(t *testing.T) {
+	var calls, allCalls int
+	registry := events.NewRegistry()
+	registry.Subscribe(events.TypeUserRegistered, "test.flaky", func(ctx context.Context, event interfaces.OutboxEvent) error {
+		calls++
+		if calls == 1 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	registry.Subscribe(events.TypeCommentCreated, "test.broken", func(ctx context.Context, event interfaces.OutboxEvent) error {
+		panic("boom")
+	})
+	registry.Subscribe(events.All, "test.all", func(ctx context.Context, event interfaces.OutboxEvent) error {
+		allCalls++
+		return nil
+	})
+
+	store := newStore(t)
+	store.Transaction(func(tx *gorm.DB) error {
+		events.Record(tx, events.UserRegistered{UserID: uuid.New(), Username: "alice"})
+		return events.Record(tx, events.CommentCreated{CommentID: uuid.New()})
+	})
+	settle(t, store, events.NewDispatcher(store, testConfig, registry))
+
+	var registered, commented interfaces.OutboxEvent
+	store.First(&registered, "type = ?", events.TypeUserRegistered)
+	store.First(&commented, "type = ?", events.TypeCommentCreated)
+	if registered.Status != interfaces.EventDispatched || registered.Attempts != 2 || calls != 2 {
+		t.Errorf("user.registered: status %s after %d attempts and %d calls, want dispatched after 2",
+			registered.Status, registered.Attempts, calls)
+	}
+	if commented.Status != interfaces.EventFailed || commented.Attempts != testConfig.MaxAttempts {
+		t.Errorf("comment.created: status %s after %d attempts, want failed after %d",
+			commented.Status, commented.Attempts, testConfig.MaxAttempts)
+	}
+	if commented.LastError == "" {
+		t.Error("failed event has no last error")
+	}
+	// Retries only go to the subscribers that failed
+	if allCalls != 2 {
+		t.Errorf("catch-all subscriber called %d times, want once per event", allCalls)
+	}
+	var delivered []string
+	store.Model(&interfaces.OutboxDelivery{}).Where("event_id = ?", commented.ID).Pluck("subscriber", &delivered)
+	if len(delivered) != 1 || delivered[0] != "test.all" {
+		t.Errorf("comment.created delivered to %v, want only test.all", delivered)
+	}
+}
@@ -0,0 +1,120 @@
+// Package events records domain events in an outbox table, in the same
+// transaction as the write they describe, and dispatches them to
+// in-process subscribers once that transaction commits. Handlers only
+// record events; whatever reacts to them subscribes from its own package.
+package events
+
+import (
+	"backend/interfaces"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Event is something that happened, named by its type
+type Event interface {
+	Type() string
+}
+
+// Event types
+const (
+	TypeUserRegistered = "user.registered"
+	TypePostCreated    = "post.created"
+	TypePostDeleted    = "post.deleted"
+	TypeCommentCreated = "comment.created"
+	TypeCommentUpdated = "comment.updated"
+	TypeCommentDeleted = "comment.deleted"
+)
+
+// Types lists every event type
+func Types() []string {
+	return []string{TypeUserRegistered, TypePostCreated, TypePostDeleted, TypeCommentCreated, TypeCommentUpdated, TypeCommentDeleted}
+}
+
+// UserRegistered is recorded when someone signs up
+type UserRegistered struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PostCreated is recorded when a post is published
+type PostCreated struct {
+	PostID     uuid.UUID `json:"post_id"`
+	AuthorID   uuid.UUID `json:"author_id"`
+	CategoryID uuid.UUID `json:"category_id"`
+	Title      string    `json:"title"`
+	Content    string    `json:"content"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// PostDeleted is recorded when a post is removed
+type PostDeleted struct {
+	PostID     uuid.UUID `json:"post_id"`
+	AuthorID   uuid.UUID `json:"author_id"`
+	CategoryID uuid.UUID `json:"category_id"`
+}
+
+// CommentCreated is recorded when a comment is added to a post
+type CommentCreated struct {
+	CommentID  uuid.UUID `json:"comment_id"`
+	PostID     uuid.UUID `json:"post_id"`
+	AuthorID   uuid.UUID `json:"author_id"`
+	CategoryID uuid.UUID `json:"category_id"` // The post's category
+	Content    string    `json:"content"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CommentUpdated is recorded when a comment is edited
+type CommentUpdated struct {
+	CommentID  uuid.UUID `json:"comment_id"`
+	PostID     uuid.UUID `json:"post_id"`
+	AuthorID   uuid.UUID `json:"author_id"`
+	CategoryID uuid.UUID `json:"category_id"` // The post's category
+	Content    string    `json:"content"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// CommentDeleted is recorded when a comment is removed
+type CommentDeleted struct {
+	CommentID  uuid.UUID `json:"comment_id"`
+	PostID     uuid.UUID `json:"post_id"`
+	AuthorID   uuid.UUID `json:"author_id"`
+	CategoryID uuid.UUID `json:"category_id"` // The post's category
+}
+
+func (UserRegistered) Type() string { return TypeUserRegistered }
+func (PostCreated) Type() string    { return TypePostCreated }
+func (PostDeleted) Type() string    { return TypePostDeleted }
+func (CommentCreated) Type() string { return TypeCommentCreated }
+func (CommentUpdated) Type() string { return TypeCommentUpdated }
+func (CommentDeleted) Type() string { return TypeCommentDeleted }
+
+// Record adds event to the outbox through tx, so it is dispatched if and
+// only if the transaction commits
+func Record(tx *gorm.DB, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encoding %s event: %w", event.Type(), err)
+	}
+	now := time.Now()
+	return tx.Create(&interfaces.OutboxEvent{
+		Type:          event.Type(),
+		Payload:       string(payload),
+		Status:        interfaces.EventPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}).Error
+}
+
+// Decode reads the JSON payload of event into v, usually the struct of
+// its type
+func Decode(event interfaces.OutboxEvent, v interface{}) error {
+	if err := json.Unmarshal([]byte(event.Payload), v); err != nil {
+		return fmt.Errorf("decoding %s event: %w", event.Type, err)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+// Package idempotency replays the stored response when a client retries a
+// POST with the same Idempotency-Key header, so a retry after a timeout
+// can't create a second post or comment.
+package idempotency
+
+import (
+	"backend/interfaces"
+	"backend/logging"
+	"backend/problem"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Header is the request header clients put their key in
+	Header = "Idempotency-Key"
+	// ReplayedHeader is set to "true" on replayed responses
+	ReplayedHeader = "Idempotent-Replayed"
+)
+
+// maxKeyLength matches the key column
+const maxKeyLength = 255
+
+// staleAfter is how long a request may hold a key before a retry assumes
+// it crashed and takes the key over
+const staleAfter = time.Minute
+
+// Middleware stores the first response to each key in conn and replays it
+// to retries for ttl. Requests without the header pass straight through;
+// bodies of those with one are buffered, up to maxBody bytes. Keys are
+// scoped to the signed in user, or the client IP when there is none, so it
+// must run after auth.AuthMiddleware on protected routes.
+func Middleware(conn *gorm.DB, ttl time.Duration, maxBody int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(Header)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxKeyLength {
+			problem.Respond(c, problem.InvalidParameter.WithFields(problem.FieldError{
+				Field:   Header,
+				Code:    "max",
+				Message: "must be at most 255 characters",
+			}))
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBody))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				problem.Respond(c, problem.BodyTooLarge.WithDetail("Request body exceeds %d bytes", tooLarge.Limit))
+				return
+			}
+			problem.Respond(c, problem.InvalidJSON.WithDetail("Request body could not be read"))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Postgres keeps microseconds, and created_at identifies this claim later
+		now := time.Now().Truncate(time.Microsecond)
+		record := interfaces.IdempotencyKey{
+			Key:         key,
+			Scope:       scope(c),
+			Fingerprint: fingerprint(c.Request, body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(ttl),
+		}
+
+		claimed, existing, err := claim(c, conn, record)
+		if err != nil {
+			problem.Respond(c, err)
+			return
+		}
+		if !claimed {
+			replay(c, record, existing)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		store(c, conn, record, recorder)
+	}
+}
+
+// claim inserts record unless its key is already taken. It returns the
+// existing row when another request owns the key. Expired rows and rows
+// left behind by a crashed request are replaced.
+func claim(c *gin.Context, conn *gorm.DB, record interfaces.IdempotencyKey) (bool, *interfaces.IdempotencyKey, error) {
+	conn = conn.WithContext(c.Request.Context())
+
+	for attempt := 0; attempt < 2; attempt++ {
+		result := conn.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+		if result.Error != nil {
+			return false, nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			return true, nil, nil
+		}
+
+		var existing interfaces.IdempotencyKey
+		err := conn.First(&existing, "key = ? AND scope = ?", record.Key, record.Scope).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue // Deleted in the meantime
+		}
+		if err != nil {
+			return false, nil, err
+		}
+
+		now := time.Now()
+		abandoned := existing.Status == 0 && now.Sub(existing.CreatedAt) > staleAfter
+		if now.Before(existing.ExpiresAt) && !abandoned {
+			return false, &existing, nil
+		}
+
+		// Only the request that deletes the old row gets to retry the insert
+		result = conn.Where("key = ? AND scope = ? AND created_at = ?", existing.Key, existing.Scope, existing.CreatedAt).
+			Delete(&interfaces.IdempotencyKey{})
+		if result.Error != nil {
+			return false, nil, result.Error
+		}
+	}
+
+	return false, nil, problem.IdempotencyInProgress
+}
+
+// replay answers a retry with the stored response
+func replay(c *gin.Context, record interfaces.IdempotencyKey, existing *interfaces.IdempotencyKey) {
+	switch {
+	case existing.Fingerprint != record.Fingerprint:
+		problem.Respond(c, problem.IdempotencyKeyReused)
+	case existing.Status == 0:
+		c.Header("Retry-After", "1")
+		problem.Respond(c, problem.IdempotencyInProgress)
+	default:
+		c.Header(ReplayedHeader, "true")
+		c.Data(existing.Status, existing.ContentType, []byte(existing.Body))
+		c.Abort()
+	}
+}
+
+// store saves the response for replay. Server errors release the key
+// instead, so a retry runs the request again.
+func store(c *gin.Context, conn *gorm.DB, record interfaces.IdempotencyKey, recorder *responseRecorder) {
+	// The request context may be cancelled once the response is written
+	conn = conn.WithContext(context.WithoutCancel(c.Request.Context()))
+	where := conn.Model(&interfaces.IdempotencyKey{}).
+		Where("key = ? AND scope = ? AND created_at = ?", record.Key, record.Scope, record.CreatedAt)
+
+	var err error
+	if status := recorder.Status(); status >= http.StatusInternalServerError {
+		err = where.Delete(&interfaces.IdempotencyKey{}).Error
+	} else {
+		err = where.Updates(map[string]interface{}{
+			"status":       status,
+			"content_type": recorder.Header().Get("Content-Type"),
+			"body":         recorder.body.String(),
+		}).Error
+	}
+	if err != nil {
+		logging.From(c).Error("saving idempotent response failed", "key", record.Key, "error", err)
+	}
+}
+
+// scope is who a key belongs to. ClientIP only believes forwarding headers
+// from TRUSTED_PROXIES, so anonymous clients can't pick another's scope.
+func scope(c *gin.Context) string {
+	if userID := c.GetString("user_id"); userID != "" {
+		return "user:" + userID
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// fingerprint identifies the request a key was first used for
+func fingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder copies the response body while it is written
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
@@ -1,30 +1,77 @@
 package interfaces
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// User is the storage model of an account. Handlers answer with the
+// response types instead, and the private columns never serialise even if
+// one is passed to c.JSON by mistake.
 type User struct {
 	ID           uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	Username     string    `json:"username" gorm:"type:varchar(50);unique;not null"`
-	Email        string    `json:"email" gorm:"type:varchar(255);unique;not null"`
-	PasswordHash string    `json:"password_hash" gorm:"column:password_hash;type:varchar(255);not null"`
+	Email        string    `json:"-" gorm:"type:varchar(255);unique;not null"`
+	PasswordHash string    `json:"-" gorm:"column:password_hash;type:varchar(255);not null"`
 	CreatedAt    time.Time `json:"created_at" gorm:"type:timestamp with time zone;default:current_timestamp"`
 	UpdatedAt    time.Time `json:"updated_at" gorm:"type:timestamp with time zone;default:current_timestamp"`
 	AvatarURL    string    `json:"avatar_url" gorm:"type:text"`
+	Role         string    `json:"role" gorm:"type:varchar(20);not null;default:'student'"`
+}
+
+// User roles
+const (
+	RoleStudent = "student"
+	RoleAdmin   = "admin"
+)
+
+// CreateUserRequest is the sign up payload. Older clients send the plain
+// text password as password_hash, which is still accepted.
+type CreateUserRequest struct {
+	Username       string `json:"username" binding:"required,min=3,max=50,username"`
+	Email          string `json:"email" binding:"required,max=255,email"`
+	Password       string `json:"password" binding:"required,min=8,max=72"`
+	LegacyPassword string `json:"password_hash" binding:"-"`
+	AvatarURL      string `json:"avatar_url" binding:"omitempty,max=2048,url"`
+}
+
+// Normalize trims whitespace, lowercases the email and falls back to the
+// legacy password field before validation
+func (r *CreateUserRequest) Normalize() {
+	r.Username = strings.TrimSpace(r.Username)
+	r.Email = normalizeEmail(r.Email)
+	r.AvatarURL = strings.TrimSpace(r.AvatarURL)
+	if r.Password == "" {
+		r.Password = r.LegacyPassword
+	}
+	r.LegacyPassword = ""
 }
 
 type UpdateUserRequest struct {
-	Username  string `json:"username"`
-	AvatarURL string `json:"avatar_url"`
-	Email     string `json:"email"`
+	Username  string `json:"username" binding:"required,min=3,max=50,username"`
+	AvatarURL string `json:"avatar_url" binding:"omitempty,max=2048,url"`
+	Email     string `json:"email" binding:"required,max=255,email"`
+}
+
+// Normalize trims whitespace and lowercases the email before validation
+func (r *UpdateUserRequest) Normalize() {
+	r.Username = strings.TrimSpace(r.Username)
+	r.Email = normalizeEmail(r.Email)
+	r.AvatarURL = strings.TrimSpace(r.AvatarURL)
 }
 
+// AuthenticateUser is the login payload. Only the upper bounds are checked so
+// accounts created before the length rules can still sign in.
 type AuthenticateUser struct {
-	Username     string `json:"username" gorm:"type:varchar(50);not null"`
-	PasswordHash string `json:"password" gorm:"column:password_hash;type:varchar(255);not null"`
+	Username     string `json:"username" gorm:"type:varchar(50);not null" binding:"required,max=50"`
+	PasswordHash string `json:"password" gorm:"column:password_hash;type:varchar(255);not null" binding:"required,max=72"`
+}
+
+// Normalize trims whitespace around the username
+func (a *AuthenticateUser) Normalize() {
+	a.Username = strings.TrimSpace(a.Username)
 }
 
 type Category struct {
@@ -34,19 +81,40 @@ type Category struct {
 	CreatedAt   time.Time `json:"created_at" gorm:"type:timestamp with time zone;default:current_timestamp"`
 }
 
+// Post rows are indexed for the category listing, which pages through
+// (created_at, id) newest first
 type Post struct {
-	ID         uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ID         uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid();index:idx_posts_category_created,priority:3,sort:desc"`
 	Title      string    `json:"title" gorm:"type:varchar(255);not null"`
 	Content    string    `json:"content" gorm:"type:text;not null"`
 	AuthorID   uuid.UUID `json:"author_id" gorm:"column:author_id;type:uuid;not null;references:users(id)"`
-	CategoryID uuid.UUID `json:"category_id" gorm:"column:category_id;type:uuid;not null;references:categories(id)"`
-	CreatedAt  time.Time `json:"created_at" gorm:"column:created_at;type:timestamp with time zone;default:current_timestamp"`
+	CategoryID uuid.UUID `json:"category_id" gorm:"column:category_id;type:uuid;not null;references:categories(id);index:idx_posts_category_created,priority:1"`
+	CreatedAt  time.Time `json:"created_at" gorm:"column:created_at;type:timestamp with time zone;default:current_timestamp;index:idx_posts_category_created,priority:2,sort:desc"`
 	UpdatedAt  time.Time `gorm:"type:timestamp with time zone;default:current_timestamp"`
 }
 
+type CreatePostRequest struct {
+	Title      string    `json:"title" binding:"required,max=255"`
+	Content    string    `json:"content" binding:"required,max=20000"`
+	AuthorID   uuid.UUID `json:"author_id" binding:"required"`
+	CategoryID uuid.UUID `json:"category_id" binding:"required"`
+}
+
+// Normalize trims whitespace around the title and content
+func (r *CreatePostRequest) Normalize() {
+	r.Title = strings.TrimSpace(r.Title)
+	r.Content = strings.TrimSpace(r.Content)
+}
+
 type UpdatePostRequest struct {
-	Title   string `json:"title"`
-	Content string `json:"content"`
+	Title   string `json:"title" binding:"required,max=255"`
+	Content string `json:"content" binding:"required,max=20000"`
+}
+
+// Normalize trims whitespace around the title and content
+func (r *UpdatePostRequest) Normalize() {
+	r.Title = strings.TrimSpace(r.Title)
+	r.Content = strings.TrimSpace(r.Content)
 }
 
 type Tag struct {
@@ -55,6 +123,16 @@ type Tag struct {
 	CreatedAt time.Time `json:"created_at" gorm:"type:timestamp with time zone;default:current_timestamp"`
 }
 
+// TagRef names an existing tag to attach to a post
+type TagRef struct {
+	Name string `json:"name" binding:"required,max=50"`
+}
+
+// Normalize trims whitespace around the name
+func (t *TagRef) Normalize() {
+	t.Name = strings.TrimSpace(t.Name)
+}
+
 type PostTag struct {
 	PostID uuid.UUID `gorm:"column:post_id;type:uuid;primary_key;references:posts(id)"`
 	TagID  uuid.UUID `gorm:"column:tag_id;type:uuid;primary_key;references:tags(id)"`
@@ -70,8 +148,187 @@ type Comment struct {
 }
 
 type CommentInput struct {
-	Content  string    `json:"content"`
-	AuthorID uuid.UUID `json:"author_id"`
+	Content  string    `json:"content" binding:"required,max=5000"`
+	AuthorID uuid.UUID `json:"author_id" binding:"required"`
+}
+
+// Normalize trims whitespace around the content
+func (c *CommentInput) Normalize() {
+	c.Content = strings.TrimSpace(c.Content)
+}
+
+type UpdateCommentRequest struct {
+	Content string `json:"content" binding:"required,max=5000"`
+}
+
+// Normalize trims whitespace around the content
+func (r *UpdateCommentRequest) Normalize() {
+	r.Content = strings.TrimSpace(r.Content)
+}
+
+// CommentChange is one comment created, edited or deleted, kept in order
+// so comment streams can replay what a reconnecting client missed
+type CommentChange struct {
+	ID        int64     `gorm:"primaryKey;autoIncrement;index:idx_comment_changes_post,priority:2"` // The SSE event id
+	PostID    uuid.UUID `gorm:"type:uuid;not null;index:idx_comment_changes_post,priority:1"`
+	CommentID uuid.UUID `gorm:"type:uuid;not null"`
+	Kind      string    `gorm:"type:varchar(100);not null"` // The comment event type
+	Data      string    `gorm:"type:text;not null"`         // The comment as JSON, as it was after the change
+	CreatedAt time.Time `gorm:"type:timestamp with time zone;not null;index"`
+}
+
+// PostStats is a post's engagement, precomputed for the feed. Score counts
+// comments by anyone but the post's author; Hot trades score against age.
+// Rows appear shortly after the post does and are deleted with it.
+type PostStats struct {
+	PostID         uuid.UUID `gorm:"type:uuid;primaryKey;index:idx_post_stats_hot,priority:2,sort:desc;index:idx_post_stats_top,priority:2,sort:desc;index:idx_post_stats_active,priority:2,sort:desc"`
+	CommentCount   int       `gorm:"not null;default:0"`
+	Score          int       `gorm:"not null;default:0;index:idx_post_stats_top,priority:1,sort:desc"`
+	Hot            float64   `gorm:"type:double precision;not null;index:idx_post_stats_hot,priority:1,sort:desc"`
+	CreatedAt      time.Time `gorm:"type:timestamp with time zone;not null"` // The post's, for the top window
+	LastActivityAt time.Time `gorm:"type:timestamp with time zone;not null;index:idx_post_stats_active,priority:1,sort:desc"`
+	RefreshedAt    time.Time `gorm:"type:timestamp with time zone;not null"`
+}
+
+// IdempotencyKey remembers the response to a POST sent with an
+// Idempotency-Key header, so a retry gets the same answer
+type IdempotencyKey struct {
+	Key         string    `gorm:"primaryKey;type:varchar(255)"`
+	Scope       string    `gorm:"primaryKey;type:varchar(255)"` // The user, or the client IP when signed out
+	Fingerprint string    `gorm:"type:varchar(64);not null"`    // SHA-256 of method, path and body
+	Status      int       `gorm:"not null;default:0"`           // 0 while the first request is still running
+	ContentType string    `gorm:"type:varchar(255)"`
+	Body        string    `gorm:"type:text"`
+	CreatedAt   time.Time `gorm:"type:timestamp with time zone"`
+	ExpiresAt   time.Time `gorm:"type:timestamp with time zone;not null;index"`
+}
+
+// Job is a unit of background work queued in the jobs table. Failed
+// attempts go back to queued with a later RunAt until MaxAttempts is
+// reached, when the job is dead-lettered.
+type Job struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Kind        string     `json:"kind" gorm:"type:varchar(100);not null;index"`
+	Payload     string     `json:"payload" gorm:"type:text;not null"` // JSON
+	Status      string     `json:"status" gorm:"type:varchar(20);not null;index:idx_jobs_ready,priority:1"`
+	Attempts    int        `json:"attempts" gorm:"not null;default:0"`
+	MaxAttempts int        `json:"max_attempts" gorm:"not null"`
+	RunAt       time.Time  `json:"run_at" gorm:"type:timestamp with time zone;not null;index:idx_jobs_ready,priority:2"`
+	LockedAt    *time.Time `json:"locked_at" gorm:"type:timestamp with time zone"` // When the current attempt started
+	LastError   string     `json:"last_error" gorm:"type:text"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"type:timestamp with time zone;default:current_timestamp"`
+	UpdatedAt   time.Time  `json:"updated_at" gorm:"type:timestamp with time zone;default:current_timestamp"`
+}
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+)
+
+// ScheduleRun is one run of a scheduled task, kept as run history
+type ScheduleRun struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Task       string     `json:"task" gorm:"type:varchar(100);not null;index:idx_schedule_runs_task,priority:1"`
+	Trigger    string     `json:"trigger" gorm:"type:varchar(20);not null"` // schedule or manual
+	Status     string     `json:"status" gorm:"type:varchar(20);not null"`
+	Instance   string     `json:"instance" gorm:"type:varchar(255)"` // Host and process that ran it
+	Error      string     `json:"error" gorm:"type:text"`
+	StartedAt  time.Time  `json:"started_at" gorm:"type:timestamp with time zone;not null;index:idx_schedule_runs_task,priority:2"`
+	FinishedAt *time.Time `json:"finished_at" gorm:"type:timestamp with time zone"`
+}
+
+// Schedule run statuses
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+)
+
+// OutboxEvent is a domain event written in the same transaction as the
+// change it describes and dispatched to subscribers once that commits
+type OutboxEvent struct {
+	ID            uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Type          string     `json:"type" gorm:"type:varchar(100);not null"`
+	Payload       string     `json:"payload" gorm:"type:text;not null"` // JSON
+	Status        string     `json:"status" gorm:"type:varchar(20);not null;index:idx_outbox_events_ready,priority:1"`
+	Attempts      int        `json:"attempts" gorm:"not null;default:0"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" gorm:"type:timestamp with time zone;not null;index:idx_outbox_events_ready,priority:2"`
+	LockedAt      *time.Time `json:"locked_at" gorm:"type:timestamp with time zone"` // When the current dispatch started
+	LastError     string     `json:"last_error" gorm:"type:text"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"type:timestamp with time zone;not null"`
+	DispatchedAt  *time.Time `json:"dispatched_at" gorm:"type:timestamp with time zone"`
+}
+
+// OutboxDelivery records that one subscriber handled an event, so retries
+// of the event skip it
+type OutboxDelivery struct {
+	EventID     uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Subscriber  string    `gorm:"type:varchar(100);primaryKey"`
+	DeliveredAt time.Time `gorm:"type:timestamp with time zone;not null"`
+}
+
+// Outbox event statuses
+const (
+	EventPending     = "pending"
+	EventDispatching = "dispatching"
+	EventDispatched  = "dispatched"
+	EventFailed      = "failed"
+)
+
+// Webhook posts the events it subscribes to to URL, signed with Secret
+type Webhook struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	URL         string    `json:"url" gorm:"type:varchar(2048);not null"`
+	Secret      string    `json:"-" gorm:"type:varchar(255);not null"`
+	Events      string    `json:"events" gorm:"type:text;not null"` // Comma-separated event types
+	CategoryIDs string    `json:"category_ids" gorm:"type:text"`    // Comma-separated; empty matches any category
+	Active      bool      `json:"active" gorm:"not null"`
+	CreatedAt   time.Time `json:"created_at" gorm:"type:timestamp with time zone;default:current_timestamp"`
+	UpdatedAt   time.Time `json:"updated_at" gorm:"type:timestamp with time zone;default:current_timestamp"`
+}
+
+// WebhookDelivery is one event sent, or being sent, to a webhook. Each
+// event reaches a webhook once; replays send the same delivery again.
+type WebhookDelivery struct {
+	ID             uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	WebhookID      uuid.UUID  `json:"webhook_id" gorm:"type:uuid;not null;uniqueIndex:idx_webhook_deliveries_event,priority:1"`
+	EventID        uuid.UUID  `json:"event_id" gorm:"type:uuid;not null;uniqueIndex:idx_webhook_deliveries_event,priority:2"`
+	EventType      string     `json:"event_type" gorm:"type:varchar(100);not null"`
+	Payload        string     `json:"payload" gorm:"type:text;not null"` // The JSON body sent
+	Status         string     `json:"status" gorm:"type:varchar(20);not null"`
+	Attempts       int        `json:"attempts" gorm:"not null;default:0"`
+	ResponseStatus int        `json:"response_status"` // Of the last attempt; 0 if it got no answer
+	ResponseBody   string     `json:"response_body" gorm:"type:text"`
+	LastError      string     `json:"last_error" gorm:"type:text"`
+	CreatedAt      time.Time  `json:"created_at" gorm:"type:timestamp with time zone;default:current_timestamp"`
+	UpdatedAt      time.Time  `json:"updated_at" gorm:"type:timestamp with time zone;default:current_timestamp"`
+	DeliveredAt    *time.Time `json:"delivered_at" gorm:"type:timestamp with time zone"`
+}
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookRequest creates or replaces a webhook. Leaving Secret empty
+// generates one on create and keeps the current one on update.
+type WebhookRequest struct {
+	URL         string      `json:"url" binding:"required,max=2048,http_url"`
+	Events      []string    `json:"events" binding:"required,min=1,dive,event_type"`
+	CategoryIDs []uuid.UUID `json:"category_ids"`
+	Secret      string      `json:"secret" binding:"omitempty,min=16,max=255"`
+	Active      *bool       `json:"active"`
+}
+
+// Normalize trims whitespace around the URL and secret
+func (r *WebhookRequest) Normalize() {
+	r.URL = strings.TrimSpace(r.URL)
+	r.Secret = strings.TrimSpace(r.Secret)
 }
 
 type Tabler interface {
@@ -81,3 +338,7 @@ type Tabler interface {
 func (PostTag) TableName() string {
 	return "posts_tags"
 }
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,272 @@
+package interfaces
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Response types are the only shapes handlers serialise. They are built from
+// the storage models above so new columns never reach clients by accident.
+
+// PublicUser is the profile any signed in user may see
+type PublicUser struct {
+	ID        uuid.UUID `json:"id"`
+	Username  string    `json:"username"`
+	AvatarURL string    `json:"avatar_url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SelfUser is what users see about their own account
+type SelfUser struct {
+	PublicUser
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AdminUser is what admins see about any account: the private fields and
+// how much the user has written, since an account can't be deleted while
+// it still has posts or comments
+type AdminUser struct {
+	SelfUser
+	PostCount    int64 `json:"post_count"`
+	CommentCount int64 `json:"comment_count"`
+}
+
+func NewPublicUser(u User) PublicUser {
+	return PublicUser{
+		ID:        u.ID,
+		Username:  u.Username,
+		AvatarURL: u.AvatarURL,
+		CreatedAt: u.CreatedAt,
+	}
+}
+
+func NewSelfUser(u User) SelfUser {
+	return SelfUser{
+		PublicUser: NewPublicUser(u),
+		Email:      u.Email,
+		Role:       u.Role,
+		UpdatedAt:  u.UpdatedAt,
+	}
+}
+
+func NewAdminUser(u User, postCount, commentCount int64) AdminUser {
+	return AdminUser{SelfUser: NewSelfUser(u), PostCount: postCount, CommentCount: commentCount}
+}
+
+type CategoryResponse struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func NewCategoryResponse(c Category) CategoryResponse {
+	return CategoryResponse{
+		ID:          c.ID,
+		Name:        c.Name,
+		Description: c.Description,
+		CreatedAt:   c.CreatedAt,
+	}
+}
+
+type PostResponse struct {
+	ID         uuid.UUID `json:"id"`
+	Title      string    `json:"title"`
+	Content    string    `json:"content"`
+	AuthorID   uuid.UUID `json:"author_id"`
+	CategoryID uuid.UUID `json:"category_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func NewPostResponse(p Post) PostResponse {
+	return PostResponse{
+		ID:         p.ID,
+		Title:      p.Title,
+		Content:    p.Content,
+		AuthorID:   p.AuthorID,
+		CategoryID: p.CategoryID,
+		CreatedAt:  p.CreatedAt,
+		UpdatedAt:  p.UpdatedAt,
+	}
+}
+
+// FeedPostResponse is a post in the feed, with the engagement it is ranked by
+type FeedPostResponse struct {
+	PostResponse
+	CommentCount   int       `json:"comment_count"`
+	Score          int       `json:"score"`
+	LastActivityAt time.Time `json:"last_activity_at"`
+}
+
+// SearchResult is a post or comment matching a search. Snippet is HTML,
+// escaped, with the matched words in <mark> elements.
+type SearchResult struct {
+	Type       string    `json:"type"` // "post" or "comment"
+	ID         uuid.UUID `json:"id"`
+	PostID     uuid.UUID `json:"post_id"`
+	PostTitle  string    `json:"post_title"`
+	AuthorID   uuid.UUID `json:"author_id"`
+	CategoryID uuid.UUID `json:"category_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	Rank       float64   `json:"rank"`
+	Snippet    string    `json:"snippet"`
+}
+
+type TagResponse struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewTagResponse(t Tag) TagResponse {
+	return TagResponse{
+		ID:        t.ID,
+		Name:      t.Name,
+		CreatedAt: t.CreatedAt,
+	}
+}
+
+type CommentResponse struct {
+	ID        uuid.UUID `json:"id"`
+	Content   string    `json:"content"`
+	PostID    uuid.UUID `json:"post_id"`
+	AuthorID  uuid.UUID `json:"author_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func NewCommentResponse(c Comment) CommentResponse {
+	return CommentResponse{
+		ID:        c.ID,
+		Content:   c.Content,
+		PostID:    c.PostID,
+		AuthorID:  c.AuthorID,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+	}
+}
+
+// Page is one page of a cursor paginated listing. NextCursor fetches the
+// next one and is null on the last; Total is only sent when asked for.
+type Page[T any] struct {
+	Items      []T     `json:"items"`
+	NextCursor *string `json:"next_cursor"`
+	Total      *int64  `json:"total,omitempty"`
+}
+
+// MapAll converts a slice of models with one of the constructors above. It
+// never returns nil, so empty lists encode as [] rather than null.
+func MapAll[M, R any](models []M, convert func(M) R) []R {
+	out := make([]R, 0, len(models))
+	for _, m := range models {
+		out = append(out, convert(m))
+	}
+	return out
+}
+
+// JobResponse is a background job as admins see it
+type JobResponse struct {
+	ID          uuid.UUID       `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+func NewJobResponse(j Job) JobResponse {
+	return JobResponse{
+		ID:          j.ID,
+		Kind:        j.Kind,
+		Payload:     json.RawMessage(j.Payload),
+		Status:      j.Status,
+		Attempts:    j.Attempts,
+		MaxAttempts: j.MaxAttempts,
+		RunAt:       j.RunAt,
+		LastError:   j.LastError,
+		CreatedAt:   j.CreatedAt,
+		UpdatedAt:   j.UpdatedAt,
+	}
+}
+
+// WebhookResponse is a webhook as admins see it. Secret is only set when
+// the webhook is created or its secret is replaced.
+type WebhookResponse struct {
+	ID          uuid.UUID   `json:"id"`
+	URL         string      `json:"url"`
+	Events      []string    `json:"events"`
+	CategoryIDs []uuid.UUID `json:"category_ids"`
+	Active      bool        `json:"active"`
+	Secret      string      `json:"secret,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+func NewWebhookResponse(w Webhook) WebhookResponse {
+	categories := []uuid.UUID{}
+	for _, id := range splitList(w.CategoryIDs) {
+		if parsed, err := uuid.Parse(id); err == nil {
+			categories = append(categories, parsed)
+		}
+	}
+	return WebhookResponse{
+		ID:          w.ID,
+		URL:         w.URL,
+		Events:      splitList(w.Events),
+		CategoryIDs: categories,
+		Active:      w.Active,
+		CreatedAt:   w.CreatedAt,
+		UpdatedAt:   w.UpdatedAt,
+	}
+}
+
+// WebhookDeliveryResponse is one entry of a webhook's delivery log
+type WebhookDeliveryResponse struct {
+	ID             uuid.UUID       `json:"id"`
+	WebhookID      uuid.UUID       `json:"webhook_id"`
+	EventID        uuid.UUID       `json:"event_id"`
+	EventType      string          `json:"event_type"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	ResponseBody   string          `json:"response_body,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+}
+
+func NewWebhookDeliveryResponse(d WebhookDelivery) WebhookDeliveryResponse {
+	return WebhookDeliveryResponse{
+		ID:             d.ID,
+		WebhookID:      d.WebhookID,
+		EventID:        d.EventID,
+		EventType:      d.EventType,
+		Payload:        json.RawMessage(d.Payload),
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		ResponseStatus: d.ResponseStatus,
+		ResponseBody:   d.ResponseBody,
+		LastError:      d.LastError,
+		CreatedAt:      d.CreatedAt,
+		DeliveredAt:    d.DeliveredAt,
+	}
+}
+
+// splitList reads a comma-separated column
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
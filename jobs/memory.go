@@ -0,0 +1,142 @@
+package jobs
+
+import (
+	"backend/interfaces"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryQueue keeps jobs in this process. Jobs are lost on restart, so it
+// suits tests and single-process development.
+type MemoryQueue struct {
+	mu          sync.Mutex
+	jobs        map[uuid.UUID]*interfaces.Job
+	maxAttempts int
+}
+
+// NewMemoryQueue creates an empty queue giving each job maxAttempts unless
+// enqueued with MaxAttempts
+func NewMemoryQueue(maxAttempts int) *MemoryQueue {
+	return &MemoryQueue{jobs: make(map[uuid.UUID]*interfaces.Job), maxAttempts: maxAttempts}
+}
+
+func (q *MemoryQueue) Enqueue(_ context.Context, kind string, payload interface{}, opts ...Option) (interfaces.Job, error) {
+	job, err := newJob(kind, payload, q.maxAttempts, opts)
+	if err != nil {
+		return job, err
+	}
+	job.ID = uuid.New()
+	job.CreatedAt = time.Now()
+	job.UpdatedAt = job.CreatedAt
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs[job.ID] = &job
+	return job, nil
+}
+
+func (q *MemoryQueue) Claim(_ context.Context, now, staleBefore time.Time) (*interfaces.Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var next *interfaces.Job
+	for _, job := range q.jobs {
+		ready := job.Status == interfaces.JobQueued && !job.RunAt.After(now) ||
+			job.Status == interfaces.JobRunning && job.LockedAt.Before(staleBefore)
+		if ready && (next == nil || job.RunAt.Before(next.RunAt)) {
+			next = job
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+
+	next.Status = interfaces.JobRunning
+	next.Attempts++
+	next.LockedAt = &now
+	next.UpdatedAt = now
+	claimed := *next
+	return &claimed, nil
+}
+
+func (q *MemoryQueue) Finish(_ context.Context, job interfaces.Job, outcome Outcome) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stored, ok := q.jobs[job.ID]
+	if !ok || stored.Status != interfaces.JobRunning || stored.Attempts != job.Attempts {
+		return nil
+	}
+	stored.Status = outcome.Status
+	stored.LockedAt = nil
+	stored.LastError = outcome.Error
+	stored.UpdatedAt = time.Now()
+	if outcome.Status == interfaces.JobQueued {
+		stored.RunAt = outcome.RunAt
+	}
+	return nil
+}
+
+func (q *MemoryQueue) Get(_ context.Context, id uuid.UUID) (interfaces.Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return interfaces.Job{}, ErrNotFound
+	}
+	return *job, nil
+}
+
+func (q *MemoryQueue) List(_ context.Context, filter Filter) ([]interfaces.Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	jobs := []interfaces.Job{}
+	for _, job := range q.jobs {
+		if (filter.Status == "" || job.Status == filter.Status) && (filter.Kind == "" || job.Kind == filter.Kind) {
+			jobs = append(jobs, *job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	if filter.Limit > 0 && len(jobs) > filter.Limit {
+		jobs = jobs[:filter.Limit]
+	}
+	return jobs, nil
+}
+
+func (q *MemoryQueue) Retry(_ context.Context, id uuid.UUID, now time.Time) (interfaces.Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return interfaces.Job{}, ErrNotFound
+	}
+	if job.Status != interfaces.JobDead {
+		return *job, ErrNotRetryable
+	}
+	job.Status = interfaces.JobQueued
+	job.Attempts = 0
+	job.RunAt = now
+	job.UpdatedAt = now
+	return *job, nil
+}
+
+func (q *MemoryQueue) Purge(_ context.Context, before time.Time) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var purged int64
+	for id, job := range q.jobs {
+		if job.Status == interfaces.JobSucceeded && job.UpdatedAt.Before(before) {
+			delete(q.jobs, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
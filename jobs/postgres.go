@@ -0,0 +1,134 @@
+package jobs
+
+import (
+	"backend/interfaces"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PostgresQueue keeps jobs in the jobs table. Workers on any number of
+// instances claim jobs with FOR UPDATE SKIP LOCKED, so each job goes to
+// exactly one of them without blocking the others.
+type PostgresQueue struct {
+	db          *gorm.DB
+	maxAttempts int
+}
+
+// NewPostgresQueue queues jobs in conn, giving each maxAttempts unless
+// enqueued with MaxAttempts
+func NewPostgresQueue(conn *gorm.DB, maxAttempts int) *PostgresQueue {
+	return &PostgresQueue{db: conn, maxAttempts: maxAttempts}
+}
+
+func (q *PostgresQueue) Enqueue(ctx context.Context, kind string, payload interface{}, opts ...Option) (interfaces.Job, error) {
+	job, err := newJob(kind, payload, q.maxAttempts, opts)
+	if err != nil {
+		return job, err
+	}
+	err = q.db.WithContext(ctx).Create(&job).Error
+	return job, err
+}
+
+func (q *PostgresQueue) Claim(ctx context.Context, now, staleBefore time.Time) (*interfaces.Job, error) {
+	var job interfaces.Job
+	err := q.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("(status = ? AND run_at <= ?) OR (status = ? AND locked_at < ?)",
+				interfaces.JobQueued, now, interfaces.JobRunning, staleBefore).
+			Order("run_at").
+			Take(&job).Error
+		if err != nil {
+			return err
+		}
+
+		job.Status = interfaces.JobRunning
+		job.Attempts++
+		job.LockedAt = &now
+		return tx.Model(&job).Updates(map[string]interface{}{
+			"status":    job.Status,
+			"attempts":  job.Attempts,
+			"locked_at": now,
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (q *PostgresQueue) Finish(ctx context.Context, job interfaces.Job, outcome Outcome) error {
+	updates := map[string]interface{}{
+		"status":     outcome.Status,
+		"locked_at":  nil,
+		"last_error": outcome.Error,
+	}
+	if outcome.Status == interfaces.JobQueued {
+		updates["run_at"] = outcome.RunAt
+	}
+
+	// Matching the attempt keeps a worker that overran its lease from
+	// overwriting the attempt that reclaimed the job
+	return q.db.WithContext(ctx).Model(&interfaces.Job{}).
+		Where("id = ? AND status = ? AND attempts = ?", job.ID, interfaces.JobRunning, job.Attempts).
+		Updates(updates).Error
+}
+
+func (q *PostgresQueue) Get(ctx context.Context, id uuid.UUID) (interfaces.Job, error) {
+	var job interfaces.Job
+	err := q.db.WithContext(ctx).First(&job, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return job, ErrNotFound
+	}
+	return job, err
+}
+
+func (q *PostgresQueue) List(ctx context.Context, filter Filter) ([]interfaces.Job, error) {
+	query := q.db.WithContext(ctx).Order("created_at DESC")
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.Kind != "" {
+		query = query.Where("kind = ?", filter.Kind)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var jobs []interfaces.Job
+	err := query.Find(&jobs).Error
+	return jobs, err
+}
+
+func (q *PostgresQueue) Retry(ctx context.Context, id uuid.UUID, now time.Time) (interfaces.Job, error) {
+	result := q.db.WithContext(ctx).Model(&interfaces.Job{}).
+		Where("id = ? AND status = ?", id, interfaces.JobDead).
+		Updates(map[string]interface{}{
+			"status":   interfaces.JobQueued,
+			"attempts": 0,
+			"run_at":   now,
+		})
+	if result.Error != nil {
+		return interfaces.Job{}, result.Error
+	}
+
+	job, err := q.Get(ctx, id)
+	if err == nil && result.RowsAffected == 0 {
+		err = ErrNotRetryable
+	}
+	return job, err
+}
+
+func (q *PostgresQueue) Purge(ctx context.Context, before time.Time) (int64, error) {
+	result := q.db.WithContext(ctx).
+		Where("status = ? AND updated_at < ?", interfaces.JobSucceeded, before).
+		Delete(&interfaces.Job{})
+	return result.RowsAffected, result.Error
+}
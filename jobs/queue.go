@@ -0,0 +1,102 @@
+// Package jobs runs work outside request handlers. Handlers enqueue a job
+// by kind with a JSON payload; a Pool of workers claims ready jobs, runs
+// the handler registered for the kind and retries failures with backoff
+// until they are dead-lettered.
+package jobs
+
+import (
+	"backend/config"
+	"backend/interfaces"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Queue stores jobs. PostgresQueue is durable and shared by every
+// instance; MemoryQueue lives and dies with the process.
+type Queue interface {
+	// Enqueue adds a job of kind with payload encoded as JSON
+	Enqueue(ctx context.Context, kind string, payload interface{}, opts ...Option) (interfaces.Job, error)
+	// Claim marks the next ready job running and returns it, or nil when
+	// none is ready. Jobs left running since before staleBefore are
+	// assumed abandoned and claimed again.
+	Claim(ctx context.Context, now, staleBefore time.Time) (*interfaces.Job, error)
+	// Finish records the outcome of the attempt job came from. It does
+	// nothing if the job was reclaimed in the meantime.
+	Finish(ctx context.Context, job interfaces.Job, outcome Outcome) error
+	Get(ctx context.Context, id uuid.UUID) (interfaces.Job, error)
+	List(ctx context.Context, filter Filter) ([]interfaces.Job, error)
+	// Retry queues a dead job again with a fresh set of attempts
+	Retry(ctx context.Context, id uuid.UUID, now time.Time) (interfaces.Job, error)
+	// Purge deletes succeeded jobs last updated before the given time and
+	// returns how many it deleted
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Outcome is how an attempt ended
+type Outcome struct {
+	Status string    // JobSucceeded, JobQueued to retry or JobDead
+	RunAt  time.Time // When a retry may run
+	Error  string
+}
+
+// Filter narrows List. Zero fields match everything.
+type Filter struct {
+	Status string
+	Kind   string
+	Limit  int
+}
+
+// Errors returned by Get and Retry
+var (
+	ErrNotFound     = errors.New("job not found")
+	ErrNotRetryable = errors.New("job is not dead")
+)
+
+// Option adjusts a job being enqueued
+type Option func(*interfaces.Job)
+
+// RunAt delays a job until t
+func RunAt(t time.Time) Option {
+	return func(job *interfaces.Job) { job.RunAt = t }
+}
+
+// MaxAttempts overrides JOBS_MAX_ATTEMPTS for one job
+func MaxAttempts(n int) Option {
+	return func(job *interfaces.Job) { job.MaxAttempts = n }
+}
+
+// Open creates the queue cfg.Backend names. conn is only used by postgres.
+func Open(cfg config.JobsConfig, conn *gorm.DB) (Queue, error) {
+	switch cfg.Backend {
+	case "postgres":
+		return NewPostgresQueue(conn, cfg.MaxAttempts), nil
+	case "memory":
+		return NewMemoryQueue(cfg.MaxAttempts), nil
+	default:
+		return nil, fmt.Errorf("unknown jobs backend %q", cfg.Backend)
+	}
+}
+
+// newJob builds the row Enqueue stores
+func newJob(kind string, payload interface{}, maxAttempts int, opts []Option) (interfaces.Job, error) {
+	data, err := encode(payload)
+	if err != nil {
+		return interfaces.Job{}, fmt.Errorf("encoding %s payload: %w", kind, err)
+	}
+	job := interfaces.Job{
+		Kind:        kind,
+		Payload:     data,
+		Status:      interfaces.JobQueued,
+		MaxAttempts: maxAttempts,
+		RunAt:       time.Now(),
+	}
+	for _, opt := range opts {
+		opt(&job)
+	}
+	return job, nil
+}
@@ -0,0 +1,174 @@
+package jobs_test
+
+import (
+	"backend/config"
+	"backend/interfaces"
+	"backend/jobs"
+	"backend/testutil"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var testConfig = config.JobsConfig{
+	Workers:      1,
+	PollInterval: time.Millisecond,
+	Timeout:      time.Minute,
+	MaxAttempts:  3,
+	Backoff:      20 * time.Millisecond,
+	MaxBackoff:   80 * time.Millisecond,
+}
+
+// queues runs test against every Queue implementation
+func queues(t *testing.T, test func(t *testing.T, queue jobs.Queue)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, jobs.NewMemoryQueue(testConfig.MaxAttempts))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		store := testutil.NewStore(t)
+		if err := store.AutoMigrate(&interfaces.Job{}); err != nil {
+			t.Fatal(err)
+		}
+		test(t, jobs.NewPostgresQueue(store, testConfig.MaxAttempts))
+	})
+}
+
+func TestRetriesThenDeadLetters(t *testing.T) {
+	var calls int
+	jobs.Handle("test.flaky", func(ctx context.Context, job interfaces.Job) error {
+		calls++
+		return errors.New("still failing")
+	})
+
+	queues(t, func(t *testing.T, queue jobs.Queue) {
+		ctx := context.Background()
+		calls = 0
+		job, err := queue.Enqueue(ctx, "test.flaky", map[string]string{"to": "alice"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool := jobs.NewPool(queue, testConfig)
+
+		// Each failure pushes the job back by the doubled backoff
+		for attempt, delay := range []time.Duration{20 * time.Millisecond, 40 * time.Millisecond} {
+			before := time.Now()
+			if ran, err := pool.RunNext(ctx); !ran || err != nil {
+				t.Fatalf("attempt %d: ran = %v, err = %v", attempt+1, ran, err)
+			}
+			if ran, _ := pool.RunNext(ctx); ran {
+				t.Fatalf("attempt %d: job ran again before its backoff", attempt+1)
+			}
+			job, _ = queue.Get(ctx, job.ID)
+			if job.Status != interfaces.JobQueued || job.RunAt.Before(before.Add(delay)) || job.LastError != "still failing" {
+				t.Fatalf("after attempt %d: %+v", attempt+1, job)
+			}
+			time.Sleep(time.Until(job.RunAt))
+		}
+
+		if ran, err := pool.RunNext(ctx); !ran || err != nil {
+			t.Fatalf("last attempt: ran = %v, err = %v", ran, err)
+		}
+		job, _ = queue.Get(ctx, job.ID)
+		if job.Status != interfaces.JobDead || job.Attempts != 3 || calls != 3 {
+			t.Fatalf("after last attempt: %+v, calls = %d", job, calls)
+		}
+
+		dead, err := queue.List(ctx, jobs.Filter{Status: interfaces.JobDead})
+		if err != nil || len(dead) != 1 || dead[0].ID != job.ID {
+			t.Fatalf("dead jobs = %+v, %v", dead, err)
+		}
+
+		// Retrying gives the job a fresh set of attempts
+		job, err = queue.Retry(ctx, job.ID, time.Now())
+		if err != nil || job.Status != interfaces.JobQueued || job.Attempts != 0 {
+			t.Fatalf("retry = %+v, %v", job, err)
+		}
+		if _, err := queue.Retry(ctx, job.ID, time.Now()); !errors.Is(err, jobs.ErrNotRetryable) {
+			t.Errorf("retrying a queued job: err = %v", err)
+		}
+	})
+}
+
+func TestPermanentFailuresAndSuccess(t *testing.T) {
+	var payloads []string
+	jobs.Handle("test.greet", func(ctx context.Context, job interfaces.Job) error {
+		var payload struct{ Name string }
+		if err := jobs.Decode(job, &payload); err != nil {
+			return err
+		}
+		if payload.Name == "" {
+			return jobs.Permanent(errors.New("no name"))
+		}
+		payloads = append(payloads, payload.Name)
+		return nil
+	})
+
+	queues(t, func(t *testing.T, queue jobs.Queue) {
+		ctx := context.Background()
+		payloads = nil
+		ok, _ := queue.Enqueue(ctx, "test.greet", map[string]string{"Name": "alice"})
+		bad, _ := queue.Enqueue(ctx, "test.greet", map[string]string{})
+		unknown, _ := queue.Enqueue(ctx, "test.unknown", nil)
+		later, _ := queue.Enqueue(ctx, "test.greet", map[string]string{"Name": "bob"}, jobs.RunAt(time.Now().Add(time.Hour)))
+
+		pool := jobs.NewPool(queue, testConfig)
+		for i := 0; i < 3; i++ {
+			if ran, err := pool.RunNext(ctx); !ran || err != nil {
+				t.Fatalf("run %d: ran = %v, err = %v", i, ran, err)
+			}
+		}
+		if ran, _ := pool.RunNext(ctx); ran {
+			t.Error("delayed job ran early")
+		}
+
+		want := map[*interfaces.Job]string{
+			&ok:      interfaces.JobSucceeded,
+			&bad:     interfaces.JobDead,
+			&unknown: interfaces.JobDead,
+			&later:   interfaces.JobQueued,
+		}
+		for job, status := range want {
+			got, err := queue.Get(ctx, job.ID)
+			if err != nil || got.Status != status {
+				t.Errorf("%s job %s: status = %q, want %q (%v)", got.Kind, got.Payload, got.Status, status, err)
+			}
+		}
+		if len(payloads) != 1 || payloads[0] != "alice" {
+			t.Errorf("handled = %v", payloads)
+		}
+		if _, err := queue.Get(ctx, [16]byte{1}); !errors.Is(err, jobs.ErrNotFound) {
+			t.Errorf("Get(missing) err = %v", err)
+		}
+	})
+}
+
+func TestAbandonedJobsAreReclaimed(t *testing.T) {
+	queues(t, func(t *testing.T, queue jobs.Queue) {
+		ctx := context.Background()
+		job, _ := queue.Enqueue(ctx, "test.greet", map[string]string{"Name": "carol"})
+
+		now := time.Now()
+		first, err := queue.Claim(ctx, now, now.Add(-time.Minute))
+		if err != nil || first == nil {
+			t.Fatalf("claim = %v, %v", first, err)
+		}
+		if again, _ := queue.Claim(ctx, now, now.Add(-time.Minute)); again != nil {
+			t.Fatal("running job claimed twice")
+		}
+
+		// Once its lease is over another worker takes it
+		later := now.Add(time.Hour)
+		second, err := queue.Claim(ctx, later, later.Add(-time.Minute))
+		if err != nil || second == nil || second.Attempts != 2 {
+			t.Fatalf("reclaim = %+v, %v", second, err)
+		}
+
+		// The first worker finishing late doesn't clobber the second attempt
+		queue.Finish(ctx, *first, jobs.Outcome{Status: interfaces.JobDead, Error: "late"})
+		queue.Finish(ctx, *second, jobs.Outcome{Status: interfaces.JobSucceeded})
+		if got, _ := queue.Get(ctx, job.ID); got.Status != interfaces.JobSucceeded {
+			t.Errorf("status = %q, want succeeded", got.Status)
+		}
+	})
+}
@@ -0,0 +1,178 @@
+package jobs
+
+import (
+	"backend/config"
+	"backend/interfaces"
+	"backend/logging"
+	"backend/metrics"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Handler runs one attempt of a job. Returning an error retries the job
+// later, unless it is wrapped with Permanent.
+type Handler func(ctx context.Context, job interfaces.Job) error
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = map[string]Handler{}
+)
+
+// Handle registers the handler for jobs of kind. Packages register their
+// kinds from init, before any worker starts.
+func Handle(kind string, handler Handler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers[kind] = handler
+}
+
+func handlerFor(kind string) (Handler, bool) {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+	handler, ok := handlers[kind]
+	return handler, ok
+}
+
+// permanentError marks a failure that retrying can't fix
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the job is dead-lettered without further attempts
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Decode reads the JSON payload of job into v
+func Decode(job interfaces.Job, v interface{}) error {
+	if err := json.Unmarshal([]byte(job.Payload), v); err != nil {
+		return Permanent(fmt.Errorf("decoding %s payload: %w", job.Kind, err))
+	}
+	return nil
+}
+
+func encode(payload interface{}) (string, error) {
+	data, err := json.Marshal(payload)
+	return string(data), err
+}
+
+// Pool runs queued jobs on a fixed number of workers
+type Pool struct {
+	queue Queue
+	cfg   config.JobsConfig
+}
+
+// NewPool creates a pool working through queue as cfg describes
+func NewPool(queue Queue, cfg config.JobsConfig) *Pool {
+	return &Pool{queue: queue, cfg: cfg}
+}
+
+// Run starts cfg.Workers workers and blocks until ctx is cancelled and the
+// jobs they were running have finished
+func (p *Pool) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < p.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// work runs jobs back to back, sleeping for the poll interval whenever
+// the queue is empty
+func (p *Pool) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		ran, err := p.RunNext(ctx)
+		if err != nil {
+			slog.Error("claiming job failed", "error", err)
+		}
+		if ran && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(p.cfg.PollInterval):
+		}
+	}
+}
+
+// RunNext claims and runs one ready job, reporting whether there was one.
+// Tests call it to drain a queue without starting workers.
+func (p *Pool) RunNext(ctx context.Context) (bool, error) {
+	now := time.Now()
+	job, err := p.queue.Claim(ctx, now, now.Add(-2*p.cfg.Timeout))
+	if err != nil || job == nil {
+		return false, err
+	}
+
+	// The attempt outlives a shutdown so it can record how it ended
+	ctx = context.WithoutCancel(ctx)
+	logger := slog.Default().With("job_id", job.ID, "kind", job.Kind, "attempt", job.Attempts)
+	start := time.Now()
+	err = p.run(logging.WithLogger(ctx, logger), *job)
+
+	outcome := p.outcome(*job, err, time.Now())
+	switch outcome.Status {
+	case interfaces.JobSucceeded:
+		logger.Info("job succeeded", "duration", time.Since(start))
+	case interfaces.JobQueued:
+		logger.Warn("job failed, retrying", "error", err, "run_at", outcome.RunAt)
+	default:
+		logger.Error("job dead-lettered", "error", err)
+	}
+	metrics.ObserveJob(job.Kind, outcome.Status, start)
+
+	return true, p.queue.Finish(ctx, *job, outcome)
+}
+
+// run calls the handler for job with a timeout, turning panics into errors
+func (p *Pool) run(ctx context.Context, job interfaces.Job) (err error) {
+	handler, ok := handlerFor(job.Kind)
+	if !ok {
+		return Permanent(fmt.Errorf("no handler for job kind %q", job.Kind))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// outcome decides what happens to job after an attempt that returned err
+func (p *Pool) outcome(job interfaces.Job, err error, now time.Time) Outcome {
+	if err == nil {
+		return Outcome{Status: interfaces.JobSucceeded}
+	}
+	var permanent permanentError
+	if errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts {
+		return Outcome{Status: interfaces.JobDead, Error: err.Error()}
+	}
+	return Outcome{Status: interfaces.JobQueued, RunAt: now.Add(p.backoff(job.Attempts)), Error: err.Error()}
+}
+
+// backoff is the delay after the given failed attempt: Backoff doubled
+// for each earlier attempt, capped at MaxBackoff
+func (p *Pool) backoff(attempt int) time.Duration {
+	delay := p.cfg.Backoff
+	for i := 1; i < attempt && delay < p.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.cfg.MaxBackoff {
+		delay = p.cfg.MaxBackoff
+	}
+	return delay
+}
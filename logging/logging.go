@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"backend/config"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type contextKey struct{}
+
+// New builds the process logger and installs it as the slog default, so
+// packages without a request context can log with slog.Info and friends
+func New(cfg config.LogConfig) *slog.Logger {
+	logger := slog.New(newHandler(os.Stderr, cfg))
+	slog.SetDefault(logger)
+	return logger
+}
+
+func newHandler(w io.Writer, cfg config.LogConfig) slog.Handler {
+	opts := &slog.HandlerOptions{Level: parseLevel(cfg.Level)}
+	if strings.EqualFold(cfg.Format, "text") {
+		return slog.NewTextHandler(w, opts)
+	}
+	return slog.NewJSONHandler(w, opts)
+}
+
+// parseLevel maps debug, info, warn and error to slog levels, defaulting to info
+func parseLevel(level string) slog.Level {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(level)); err != nil {
+		return slog.LevelInfo
+	}
+	return parsed
+}
+
+// WithLogger returns a copy of ctx carrying logger
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the request scoped logger, or the default logger
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// From returns the logger for a gin request, tagged with the request ID and,
+// once AuthMiddleware has run, the authenticated user
+func From(c *gin.Context) *slog.Logger {
+	logger := FromContext(c.Request.Context())
+	if userID := c.GetString("user_id"); userID != "" {
+		logger = logger.With("user_id", userID)
+	}
+	return logger
+}
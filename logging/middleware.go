@@ -0,0 +1,85 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey stores the request ID in the gin context
+const requestIDKey = "request_id"
+
+// maxRequestIDLength caps client supplied IDs so they can't flood the logs
+const maxRequestIDLength = 128
+
+// RequestID returns the ID assigned to the current request
+func RequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// Middleware assigns every request an ID, reusing a well formed incoming
+// X-Request-ID, echoes it in the response, and writes one log line per
+// request with its status, latency and user
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+		c.Set(requestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		logger := slog.Default().With("request_id", requestID)
+		if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+			logger = logger.With("trace_id", span.TraceID().String(), "span_id", span.SpanID().String())
+		}
+		c.Request = c.Request.WithContext(WithLogger(c.Request.Context(), logger))
+
+		c.Next()
+
+		status := c.Writer.Status()
+		attrs := []any{
+			"method", c.Request.Method,
+			"route", c.FullPath(),
+			"path", c.Request.URL.Path,
+			"status", status,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"bytes", c.Writer.Size(),
+			"client_ip", c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.Errors())
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+		From(c).Log(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// validRequestID accepts short IDs made of printable ASCII
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,236 @@
+package metrics
+
+import (
+	"backend/problem"
+	"database/sql"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric name
+const namespace = "studenthub"
+
+// Registry holds every StudentHub metric plus the Go runtime and process collectors
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method and route template.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	httpInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "http_requests_in_flight",
+		Help:      "HTTP requests currently being served.",
+	})
+
+	cloudinaryOps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cloudinary_operations_total",
+		Help:      "Cloudinary uploads and deletes by outcome.",
+	}, []string{"operation", "result"})
+
+	cloudinaryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cloudinary_operation_duration_seconds",
+		Help:      "Cloudinary call latency by operation.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10},
+	}, []string{"operation"})
+
+	logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts by result.",
+	}, []string{"result"})
+
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests rejected by the rate limiter by route group.",
+	}, []string{"group"})
+
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Cache lookups by cache and result: local or shared hit, or miss.",
+	}, []string{"cache", "result"})
+
+	jobsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_processed_total",
+		Help:      "Background job attempts by kind and resulting status: succeeded, queued for retry or dead.",
+	}, []string{"kind", "status"})
+
+	jobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Background job attempt latency by kind.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 30, 60, 300},
+	}, []string{"kind"})
+
+	taskRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "scheduled_task_runs_total",
+		Help:      "Scheduled task runs by task and status.",
+	}, []string{"task", "status"})
+
+	taskDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scheduled_task_duration_seconds",
+		Help:      "Scheduled task run latency by task.",
+		Buckets:   []float64{0.01, 0.1, 1, 10, 60, 300, 900},
+	}, []string{"task"})
+
+	eventsDispatched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_dispatched_total",
+		Help:      "Outbox event dispatch attempts by event type and the status they left the event in.",
+	}, []string{"type", "status"})
+
+	cacheHitRatio = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_hit_ratio",
+		Help:      "Fraction of lookups served from either cache tier since the process started.",
+	}, []string{"cache"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests, httpDuration, httpInFlight,
+		cloudinaryOps, cloudinaryDuration,
+		logins, rateLimited,
+		cacheLookups, cacheHitRatio,
+		jobsProcessed, jobDuration,
+		taskRuns, taskDuration,
+		eventsDispatched,
+	)
+
+	// Pre-create the login series so dashboards show zero instead of nothing
+	logins.WithLabelValues("success")
+	logins.WithLabelValues("failure")
+}
+
+// unmatchedRoute labels requests that hit no route, so random paths from
+// scanners can't create unbounded label values
+const unmatchedRoute = "unmatched"
+
+// Middleware records request counts, latencies and in-flight requests by
+// route template (e.g. /posts/:id) rather than raw path
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves the registry in the Prometheus text format. When token is
+// not empty, scrapers must send it as a bearer token.
+func Handler(token string) gin.HandlerFunc {
+	handler := promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+	return func(c *gin.Context) {
+		if token != "" && c.GetHeader("Authorization") != "Bearer "+token {
+			problem.Respond(c, problem.InvalidToken.WithDetail("Invalid metrics token"))
+			return
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// RegisterDB exports the connection pool statistics of sqlDB
+func RegisterDB(sqlDB *sql.DB) {
+	err := Registry.Register(collectors.NewDBStatsCollector(sqlDB, "postgres"))
+	var already prometheus.AlreadyRegisteredError
+	if err != nil && !errors.As(err, &already) {
+		panic(err)
+	}
+}
+
+// ObserveCloudinary records one Cloudinary call and its outcome
+func ObserveCloudinary(operation string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	cloudinaryOps.WithLabelValues(operation, result).Inc()
+	cloudinaryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// ObserveLogin counts a login attempt
+func ObserveLogin(success bool) {
+	if success {
+		logins.WithLabelValues("success").Inc()
+		return
+	}
+	logins.WithLabelValues("failure").Inc()
+}
+
+// ObserveRateLimited records one request rejected by the rate limiter
+func ObserveRateLimited(group string) {
+	rateLimited.WithLabelValues(group).Inc()
+}
+
+// ObserveJob records one background job attempt and the status it left the job in
+func ObserveJob(kind, status string, start time.Time) {
+	jobsProcessed.WithLabelValues(kind, status).Inc()
+	jobDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+}
+
+// ObserveTask records one scheduled task run and how it ended
+func ObserveTask(task, status string, start time.Time) {
+	taskRuns.WithLabelValues(task, status).Inc()
+	taskDuration.WithLabelValues(task).Observe(time.Since(start).Seconds())
+}
+
+// ObserveEvent records one dispatch attempt of an outbox event
+func ObserveEvent(eventType, status string) {
+	eventsDispatched.WithLabelValues(eventType, status).Inc()
+}
+
+// cacheCounts backs cache_hit_ratio, which would otherwise need a query
+// over cache_lookups_total
+var cacheCounts = struct {
+	sync.Mutex
+	hits, total map[string]float64
+}{hits: map[string]float64{}, total: map[string]float64{}}
+
+// ObserveCache records one cache lookup. result is "local" or "shared" for
+// a hit in that tier and "miss" otherwise.
+func ObserveCache(cache, result string) {
+	cacheLookups.WithLabelValues(cache, result).Inc()
+
+	cacheCounts.Lock()
+	defer cacheCounts.Unlock()
+	cacheCounts.total[cache]++
+	if result != "miss" {
+		cacheCounts.hits[cache]++
+	}
+	cacheHitRatio.WithLabelValues(cache).Set(cacheCounts.hits[cache] / cacheCounts.total[cache])
+}
@@ -0,0 +1,82 @@
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gin-gonic/gin"
+)
+
+// spec is the hand-maintained OpenAPI document; update it with every route change
+//
+//go:embed openapi.json
+var spec []byte
+
+// Load parses and validates the embedded document, pointing its server at prefix
+func Load(prefix string) (*openapi3.T, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(spec)
+	if err != nil {
+		return nil, fmt.Errorf("parsing OpenAPI document: %w", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+
+	server := prefix
+	if server == "" {
+		server = "/"
+	}
+	doc.Servers = openapi3.Servers{{URL: server}}
+	return doc, nil
+}
+
+// MustLoad is Load for callers that treat a broken embedded document as a bug
+func MustLoad(prefix string) *openapi3.T {
+	doc, err := Load(prefix)
+	if err != nil {
+		panic(err)
+	}
+	return doc
+}
+
+// SpecHandler serves doc as JSON
+func SpecHandler(doc *openapi3.T) gin.HandlerFunc {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		panic(fmt.Sprintf("encoding OpenAPI document: %v", err))
+	}
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json", body)
+	}
+}
+
+// docsPage renders Swagger UI from a CDN against the served document
+const docsPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>StudentHub API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: %q, dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
+
+// DocsHandler serves an interactive docs UI for the document at specURL
+func DocsHandler(specURL string) gin.HandlerFunc {
+	page := fmt.Sprintf(docsPage, specURL)
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page))
+	}
+}
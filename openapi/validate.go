@@ -0,0 +1,107 @@
+package openapi
+
+import (
+	"backend/problem"
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	// Keep validation errors to one line instead of dumping the whole schema
+	openapi3.SchemaErrorDetailsDisabled = true
+
+	// kin-openapi only checks formats that have been registered
+	openapi3.DefineStringFormat("uuid", `^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	openapi3.DefineStringFormat("email", openapi3.FormatOfStringForEmail)
+}
+
+// ValidationMiddleware rejects requests whose parameters or body don't match
+// doc with a 400. Routes missing from the document pass through untouched, and
+// authentication is left to AuthMiddleware.
+func ValidationMiddleware(doc *openapi3.T) (gin.HandlerFunc, error) {
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &openapi3filter.Options{
+		AuthenticationFunc: func(context.Context, *openapi3filter.AuthenticationInput) error { return nil },
+		MultiError:         true,
+	}
+
+	return func(c *gin.Context) {
+		route, pathParams, err := router.FindRoute(c.Request)
+		if err != nil {
+			// Not a documented operation: let gin answer 404 or 405 as usual
+			c.Next()
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    options,
+		}
+		if err := openapi3filter.ValidateRequest(c.Request.Context(), input); err != nil {
+			problem.Respond(c, problem.ValidationFailed.WithFields(fieldErrors(err)...))
+			return
+		}
+
+		c.Next()
+	}, nil
+}
+
+// fieldErrors flattens kin-openapi validation errors into per-field problems
+func fieldErrors(err error) []problem.FieldError {
+	if multi, ok := err.(openapi3.MultiError); ok {
+		var fields []problem.FieldError
+		for _, e := range multi {
+			fields = append(fields, fieldErrors(e)...)
+		}
+		return fields
+	}
+
+	var requestErr *openapi3filter.RequestError
+	if !errors.As(err, &requestErr) {
+		return []problem.FieldError{{Field: "", Code: "invalid", Message: err.Error()}}
+	}
+
+	// Body errors may hold several schema errors
+	if requestErr.Err != nil {
+		if nested, ok := requestErr.Err.(openapi3.MultiError); ok {
+			var fields []problem.FieldError
+			for _, e := range nested {
+				fields = append(fields, schemaFieldError(requestErr, e))
+			}
+			return fields
+		}
+	}
+	return []problem.FieldError{schemaFieldError(requestErr, requestErr.Err)}
+}
+
+// schemaFieldError describes one failure, naming the parameter or body field
+func schemaFieldError(requestErr *openapi3filter.RequestError, err error) problem.FieldError {
+	field := problem.FieldError{Code: "invalid", Message: requestErr.Error()}
+	if requestErr.Parameter != nil {
+		field.Field = requestErr.Parameter.Name
+	}
+
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		if pointer := schemaErr.JSONPointer(); len(pointer) > 0 && requestErr.Parameter == nil {
+			field.Field = strings.Join(pointer, ".")
+		}
+		field.Code = schemaErr.SchemaField
+		field.Message = schemaErr.Reason
+	} else if err != nil {
+		field.Message = err.Error()
+	}
+	return field
+}
@@ -0,0 +1,57 @@
+package problem
+
+import "net/http"
+
+// Generic problems
+var (
+	Internal              = New(http.StatusInternalServerError, "internal_error", "Internal server error")
+	InvalidJSON           = New(http.StatusBadRequest, "invalid_json", "Request body is not valid JSON")
+	ValidationFailed      = New(http.StatusBadRequest, "validation_failed", "Request has invalid fields")
+	BodyTooLarge          = New(http.StatusRequestEntityTooLarge, "body_too_large", "Request body is too large")
+	InvalidParameter      = New(http.StatusBadRequest, "invalid_parameter", "Invalid path or query parameter")
+	RouteNotFound         = New(http.StatusNotFound, "route_not_found", "No such route")
+	Conflict              = New(http.StatusConflict, "conflict", "Request conflicts with existing data")
+	IdempotencyKeyReused  = New(http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency-Key was already used for a different request")
+	IdempotencyInProgress = New(http.StatusConflict, "idempotency_in_progress", "A request with this Idempotency-Key is still being processed")
+	PreconditionFailed    = New(http.StatusPreconditionFailed, "precondition_failed", "The resource was changed since you last fetched it")
+)
+
+// Authentication problems
+var (
+	Unauthenticated    = New(http.StatusUnauthorized, "unauthenticated", "No valid authentication token found")
+	InvalidToken       = New(http.StatusUnauthorized, "invalid_token", "Invalid or expired token")
+	InvalidCredentials = New(http.StatusUnauthorized, "invalid_credentials", "Invalid credentials")
+	Forbidden          = New(http.StatusForbidden, "forbidden", "You are not allowed to do this")
+	RateLimited        = New(http.StatusTooManyRequests, "rate_limited", "Too many requests")
+)
+
+// Resource problems
+var (
+	UserNotFound     = New(http.StatusNotFound, "user_not_found", "User not found")
+	UsernameTaken    = New(http.StatusConflict, "username_taken", "Username is already taken")
+	EmailTaken       = New(http.StatusConflict, "email_taken", "Email is already registered")
+	PostNotFound     = New(http.StatusNotFound, "post_not_found", "Post not found")
+	CategoryNotFound = New(http.StatusNotFound, "category_not_found", "Category not found")
+	AuthorUnknown    = New(http.StatusUnprocessableEntity, "author_unknown", "Author does not exist")
+	ReferenceUnknown = New(http.StatusUnprocessableEntity, "reference_unknown", "A referenced record does not exist")
+	CommentNotFound  = New(http.StatusNotFound, "comment_not_found", "Comment not found")
+	TagNotFound      = New(http.StatusNotFound, "tag_not_found", "Tag not found")
+	TagUnknown       = New(http.StatusUnprocessableEntity, "tag_unknown", "Tag does not exist")
+	PostTagNotFound  = New(http.StatusNotFound, "post_tag_not_found", "Tag not found on post")
+	PostTagExists    = New(http.StatusConflict, "post_tag_exists", "Tag is already on the post")
+	JobNotFound      = New(http.StatusNotFound, "job_not_found", "Job not found")
+	JobNotRetryable  = New(http.StatusConflict, "job_not_retryable", "Only dead jobs can be retried")
+	WebhookNotFound  = New(http.StatusNotFound, "webhook_not_found", "Webhook not found")
+	DeliveryNotFound = New(http.StatusNotFound, "webhook_delivery_not_found", "Webhook delivery not found")
+	DeliveryPending  = New(http.StatusConflict, "webhook_delivery_pending", "The delivery is still being attempted")
+)
+
+// Image problems
+var (
+	StorageUnavailable   = New(http.StatusServiceUnavailable, "storage_unavailable", "Image storage is not available")
+	FileMissing          = New(http.StatusBadRequest, "file_missing", "No file uploaded")
+	FileTooLarge         = New(http.StatusRequestEntityTooLarge, "file_too_large", "File size too large")
+	UnsupportedMediaType = New(http.StatusUnsupportedMediaType, "unsupported_media_type", "File must be an image")
+	UploadFailed         = New(http.StatusBadGateway, "upload_failed", "Failed to upload image")
+	DeleteFailed         = New(http.StatusBadGateway, "image_delete_failed", "Failed to delete image")
+)
@@ -0,0 +1,132 @@
+package problem
+
+import (
+	"backend/logging"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of RFC 7807 responses
+const ContentType = "application/problem+json"
+
+// typePrefix turns a code into the problem type URI
+const typePrefix = "urn:studenthub:problem:"
+
+// Problem is an API error with a stable, machine-readable code. Values in
+// codes.go are templates; use WithDetail, WithFields or Wrap to specialise
+// one for a single response.
+type Problem struct {
+	Status int
+	Code   string
+	Title  string
+	Detail string
+	Fields []FieldError
+	cause  error
+}
+
+// FieldError describes one invalid field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// New creates a problem template
+func New(status int, code, title string) *Problem {
+	return &Problem{Status: status, Code: code, Title: title}
+}
+
+func (p *Problem) Error() string {
+	if p.cause != nil {
+		return fmt.Sprintf("%s: %v", p.Code, p.cause)
+	}
+	if p.Detail != "" {
+		return fmt.Sprintf("%s: %s", p.Code, p.Detail)
+	}
+	return p.Code
+}
+
+// Unwrap exposes the underlying error recorded with Wrap
+func (p *Problem) Unwrap() error {
+	return p.cause
+}
+
+// Is matches problems by code, so errors.Is(err, problem.PostNotFound) works on copies
+func (p *Problem) Is(target error) bool {
+	var other *Problem
+	return errors.As(target, &other) && other.Code == p.Code
+}
+
+// WithDetail returns a copy carrying a human readable explanation for this occurrence
+func (p *Problem) WithDetail(format string, args ...interface{}) *Problem {
+	copied := *p
+	copied.Detail = fmt.Sprintf(format, args...)
+	return &copied
+}
+
+// WithFields returns a copy listing the invalid fields
+func (p *Problem) WithFields(fields ...FieldError) *Problem {
+	copied := *p
+	copied.Fields = append(append([]FieldError(nil), p.Fields...), fields...)
+	return &copied
+}
+
+// Wrap returns a copy that records err for the server log. The cause is never sent to clients.
+func (p *Problem) Wrap(err error) *Problem {
+	copied := *p
+	copied.cause = err
+	return &copied
+}
+
+// body is the JSON document sent to clients
+type body struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+
+	// Error repeats the message for clients written against the old {"error": "..."} shape
+	Error string `json:"error"`
+}
+
+// Respond writes err as problem+json and aborts the request. Errors that
+// aren't a *Problem become a 500 internal_error; causes and unknown errors
+// are logged server-side only.
+func Respond(c *gin.Context, err error) {
+	var p *Problem
+	if !errors.As(err, &p) {
+		p = Internal.Wrap(err)
+	}
+
+	switch {
+	case p.Status >= http.StatusInternalServerError:
+		logging.From(c).Error(p.Title, "code", p.Code, "error", err)
+	case p.cause != nil:
+		logging.From(c).Debug(p.Title, "code", p.Code, "error", err)
+	}
+
+	message := p.Title
+	if p.Detail != "" {
+		message = p.Detail
+	}
+
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(p.Status, body{
+		Type:      typePrefix + p.Code,
+		Title:     p.Title,
+		Status:    p.Status,
+		Detail:    p.Detail,
+		Instance:  c.Request.URL.Path,
+		Code:      p.Code,
+		RequestID: logging.RequestID(c),
+		Errors:    p.Fields,
+		Error:     message,
+	})
+}
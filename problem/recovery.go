@@ -0,0 +1,30 @@
+package problem
+
+import (
+	"backend/logging"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Recovery turns a panic into a 500 problem and logs it with the stack trace
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if err, ok := recovered.(error); ok && err == http.ErrAbortHandler {
+					panic(recovered)
+				}
+				logging.From(c).Error("panic recovered", "panic", recovered, "stack", string(debug.Stack()))
+				Respond(c, Internal)
+			}
+		}()
+		c.Next()
+	}
+}
+
+// NoRoute answers unknown paths with a route_not_found problem
+func NoRoute(c *gin.Context) {
+	Respond(c, RouteNotFound.WithDetail("%s %s does not exist", c.Request.Method, c.Request.URL.Path))
+}
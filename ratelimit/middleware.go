@@ -0,0 +1,67 @@
+// Package ratelimit throttles clients with token buckets and reports the
+// state of their bucket in RateLimit-* response headers.
+package ratelimit
+
+import (
+	"backend/config"
+	"backend/logging"
+	"backend/metrics"
+	"backend/problem"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// KeyFunc picks the client a request is counted against
+type KeyFunc func(c *gin.Context) string
+
+// ByIP counts requests per client IP
+func ByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// ByUser counts requests per signed in user, falling back to the client IP
+// when auth.AuthMiddleware hasn't run
+func ByUser(c *gin.Context) string {
+	if userID := c.GetString("user_id"); userID != "" {
+		return "user:" + userID
+	}
+	return ByIP(c)
+}
+
+// Middleware limits requests of the named group to rate per key. If the
+// store fails the request is let through, so an outage of a shared store
+// doesn't take the API down with it.
+func Middleware(store Store, group string, rate config.Rate, key KeyFunc) gin.HandlerFunc {
+	policy := strconv.Itoa(rate.Requests) + ";w=" + strconv.Itoa(int(math.Ceil(rate.Period.Seconds())))
+
+	return func(c *gin.Context) {
+		result, err := store.Take(c.Request.Context(), group+":"+key(c), rate, time.Now())
+		if err != nil {
+			logging.From(c).Warn("rate limit store failed", "group", group, "error", err)
+			c.Next()
+			return
+		}
+
+		header := c.Writer.Header()
+		header.Set("RateLimit-Policy", policy)
+		header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", ceilSeconds(result.Reset))
+
+		if !result.Allowed {
+			metrics.ObserveRateLimited(group)
+			header.Set("Retry-After", ceilSeconds(result.RetryAfter))
+			problem.Respond(c, problem.RateLimited.WithDetail("Too many requests, retry in %s seconds", ceilSeconds(result.RetryAfter)))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
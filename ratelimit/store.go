@@ -0,0 +1,135 @@
+package ratelimit
+
+import (
+	"backend/config"
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Result is the state of a bucket after one request tried to take a token
+type Result struct {
+	Allowed    bool
+	Limit      int           // Bucket capacity
+	Remaining  int           // Whole tokens left
+	Reset      time.Duration // Until the bucket is full again
+	RetryAfter time.Duration // Until the next token, when not Allowed
+}
+
+// Store keeps token buckets by key. MemoryStore is the default; a shared
+// store lets several instances enforce one limit.
+type Store interface {
+	Take(ctx context.Context, key string, rate config.Rate, now time.Time) (Result, error)
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]func() (Store, error){
+		"memory": func() (Store, error) { return NewMemoryStore(), nil },
+	}
+)
+
+// Register makes a store available under name for RATE_LIMIT_BACKEND
+func Register(name string, open func() (Store, error)) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[name] = open
+}
+
+// Open creates a store of the named backend
+func Open(name string) (Store, error) {
+	backendsMu.RLock()
+	open, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown rate limit backend %q (have %v)", name, backendNames())
+	}
+	return open()
+}
+
+func backendNames() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// sweepInterval is how often MemoryStore forgets buckets that have refilled
+const sweepInterval = time.Minute
+
+// MemoryStore keeps buckets in this process. Each serverless instance or
+// server replica enforces its own limit.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time // When the bucket will have refilled
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, rate config.Rate, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	capacity := float64(rate.Requests)
+	perSecond := capacity / rate.Period.Seconds()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		s.buckets[key] = b
+	}
+
+	// Refill for the time since the last request
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*perSecond)
+		b.last = now
+	}
+
+	result := Result{Limit: rate.Requests}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - b.tokens) / perSecond)
+	}
+
+	result.Remaining = int(b.tokens)
+	result.Reset = seconds((capacity - b.tokens) / perSecond)
+	b.full = now.Add(result.Reset)
+	return result, nil
+}
+
+// sweep drops buckets that have refilled, which behave like missing ones
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"backend/config"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreTokenBucket(t *testing.T) {
+	store := NewMemoryStore()
+	rate := config.Rate{Requests: 3, Period: 3 * time.Second}
+	now := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+
+	take := func(key string) Result {
+		t.Helper()
+		result, err := store.Take(context.Background(), key, rate, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// The burst is the full bucket
+	for want := 2; want >= 0; want-- {
+		if r := take("a"); !r.Allowed || r.Remaining != want {
+			t.Fatalf("take = %+v, want allowed with %d remaining", r, want)
+		}
+	}
+
+	r := take("a")
+	if r.Allowed || r.RetryAfter != time.Second || r.Reset != 3*time.Second {
+		t.Fatalf("take on empty bucket = %+v", r)
+	}
+
+	// Other keys have their own bucket
+	if r := take("b"); !r.Allowed {
+		t.Fatalf("take for another key = %+v", r)
+	}
+
+	// One token comes back per second
+	now = now.Add(time.Second)
+	if r := take("a"); !r.Allowed || r.Remaining != 0 {
+		t.Fatalf("take after refill = %+v", r)
+	}
+
+	// Buckets never hold more than their capacity
+	now = now.Add(time.Hour)
+	if r := take("a"); r.Remaining != 2 {
+		t.Fatalf("take after a long pause = %+v", r)
+	}
+}
+
+func TestMemoryStoreForgetsFullBuckets(t *testing.T) {
+	store := NewMemoryStore()
+	rate := config.Rate{Requests: 1, Period: time.Second}
+	now := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+
+	store.Take(context.Background(), "a", rate, now)
+	store.Take(context.Background(), "b", rate, now.Add(sweepInterval))
+	if _, ok := store.buckets["a"]; ok {
+		t.Error("refilled bucket was not swept")
+	}
+	if _, ok := store.buckets["b"]; !ok {
+		t.Error("bucket in use was swept")
+	}
+}
+
+func TestOpenUnknownBackend(t *testing.T) {
+	if _, err := Open("nope"); err == nil {
+		t.Error("Open accepted an unknown backend")
+	}
+}
@@ -0,0 +1,124 @@
+package router_test
+
+import (
+	"backend/testutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestLogin(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+
+	res := srv.Do(t, http.MethodPost, "/login", map[string]string{
+		"username": " alice ",
+		"password": testutil.Password,
+	}).ExpectStatus(t, http.StatusOK)
+
+	var body struct {
+		Token string                 `json:"token"`
+		User  map[string]interface{} `json:"user"`
+	}
+	res.Decode(t, &body)
+	if body.Token == "" {
+		t.Fatal("no token in response")
+	}
+	if body.User["id"] != alice.ID.String() || body.User["email"] != alice.Email {
+		t.Errorf("user = %v", body.User)
+	}
+	if _, ok := body.User["password_hash"]; ok {
+		t.Error("login response includes password_hash")
+	}
+
+	cookie, ok := res.Cookie("token")
+	if !ok || cookie.Value != body.Token || !cookie.HttpOnly {
+		t.Errorf("token cookie = %+v", cookie)
+	}
+}
+
+func TestLoginFailures(t *testing.T) {
+	srv := testutil.NewServer(t)
+	srv.CreateUser(t, "alice")
+
+	srv.Do(t, http.MethodPost, "/login", map[string]string{
+		"username": "alice",
+		"password": "wrong-password",
+	}).ExpectProblem(t, http.StatusUnauthorized, "invalid_credentials")
+
+	srv.Do(t, http.MethodPost, "/login", map[string]string{
+		"username": "nobody",
+		"password": testutil.Password,
+	}).ExpectProblem(t, http.StatusUnauthorized, "invalid_credentials")
+
+	srv.Do(t, http.MethodPost, "/login", map[string]string{"username": "alice"}).
+		ExpectProblem(t, http.StatusBadRequest, "validation_failed")
+}
+
+func TestCookieAndBearerAuth(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	token := srv.Token(t, alice)
+	path := "/users/" + alice.ID.String()
+
+	srv.Do(t, http.MethodGet, path, nil, testutil.WithCookie(token)).ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodGet, path, nil, testutil.WithToken(token)).ExpectStatus(t, http.StatusOK)
+
+	srv.Do(t, http.MethodGet, path, nil).ExpectProblem(t, http.StatusUnauthorized, "unauthenticated")
+	srv.Do(t, http.MethodGet, path, nil, testutil.WithToken("garbage")).ExpectProblem(t, http.StatusUnauthorized, "invalid_token")
+	srv.Do(t, http.MethodGet, path, nil, testutil.WithCookie("garbage")).ExpectProblem(t, http.StatusUnauthorized, "invalid_token")
+	srv.Do(t, http.MethodGet, path, nil, testutil.WithHeader("Authorization", "Basic "+token)).
+		ExpectProblem(t, http.StatusUnauthorized, "unauthenticated")
+}
+
+func TestTokenForDeletedAccount(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	token := testutil.WithToken(srv.Token(t, alice))
+
+	srv.Do(t, http.MethodDelete, "/users/"+alice.ID.String(), nil, token).ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodGet, "/users", nil, token).ExpectProblem(t, http.StatusUnauthorized, "invalid_token")
+}
+
+func TestTokenNamingUsername(t *testing.T) {
+	srv := testutil.NewServer(t)
+	srv.CreateUser(t, "alice")
+
+	// Tokens used to name the user by username, which can change hands
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(srv.Config.Auth.JWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Do(t, http.MethodGet, "/users", nil, testutil.WithToken(token)).ExpectProblem(t, http.StatusUnauthorized, "invalid_token")
+}
+
+func TestLogout(t *testing.T) {
+	srv := testutil.NewServer(t)
+
+	res := srv.Do(t, http.MethodPost, "/logout", nil).ExpectStatus(t, http.StatusOK)
+	cookie, ok := res.Cookie("token")
+	if !ok || cookie.Value != "" || cookie.MaxAge >= 0 {
+		t.Errorf("token cookie = %+v, want it cleared", cookie)
+	}
+}
+
+func TestSyncToken(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	token := srv.Token(t, alice)
+
+	res := srv.Do(t, http.MethodPost, "/auth/sync", map[string]string{"token": token}).ExpectStatus(t, http.StatusOK)
+	if cookie, ok := res.Cookie("token"); !ok || cookie.Value != token {
+		t.Errorf("token cookie = %+v", cookie)
+	}
+
+	srv.Do(t, http.MethodPost, "/auth/sync", map[string]string{"token": "garbage"}).
+		ExpectProblem(t, http.StatusUnauthorized, "invalid_token")
+	srv.Do(t, http.MethodPost, "/auth/sync", map[string]string{}).
+		ExpectProblem(t, http.StatusBadRequest, "validation_failed")
+}
@@ -0,0 +1,33 @@
+package router_test
+
+import (
+	"backend/testutil"
+	"net/http"
+	"testing"
+)
+
+func TestCategories(t *testing.T) {
+	srv := testutil.NewServer(t)
+
+	var categories []struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	srv.Do(t, http.MethodGet, "/categories", nil).ExpectStatus(t, http.StatusOK).Decode(t, &categories)
+	if len(categories) != 4 {
+		t.Fatalf("got %d categories, want the 4 defaults", len(categories))
+	}
+
+	var category struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	srv.Do(t, http.MethodGet, "/categories/"+categories[0].ID, nil).ExpectStatus(t, http.StatusOK).Decode(t, &category)
+	if category.Name != categories[0].Name || category.Description == "" {
+		t.Errorf("category = %+v", category)
+	}
+
+	srv.Do(t, http.MethodGet, "/categories/00000000-0000-4000-8000-000000000000", nil).
+		ExpectProblem(t, http.StatusNotFound, "category_not_found")
+	srv.Do(t, http.MethodGet, "/categories/x", nil).ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+}
@@ -0,0 +1,90 @@
+package router_test
+
+import (
+	"backend/testutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestComments(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	bob := srv.CreateUser(t, "bob")
+	post := srv.CreatePost(t, alice, srv.Category(t, "General"), "Question")
+	srv.CreateComment(t, alice, post, "Anyone?")
+	token := testutil.WithToken(srv.Token(t, bob))
+	path := "/posts/" + post.ID.String() + "/comments"
+
+	var created struct {
+		ID       string `json:"id"`
+		Content  string `json:"content"`
+		PostID   string `json:"post_id"`
+		AuthorID string `json:"author_id"`
+	}
+	srv.Do(t, http.MethodPost, path, map[string]string{
+		"content":   "  Me!  ",
+		"author_id": bob.ID.String(),
+	}, token).ExpectStatus(t, http.StatusCreated).Decode(t, &created)
+	if created.Content != "Me!" || created.PostID != post.ID.String() || created.AuthorID != bob.ID.String() {
+		t.Errorf("created = %+v", created)
+	}
+
+	var comments []map[string]interface{}
+	srv.Do(t, http.MethodGet, path, nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &comments)
+	if len(comments) != 2 {
+		t.Errorf("got %d comments, want 2", len(comments))
+	}
+}
+
+func TestCommentErrors(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	post := srv.CreatePost(t, alice, srv.Category(t, "General"), "Question")
+	token := testutil.WithToken(srv.Token(t, alice))
+	path := "/posts/" + post.ID.String() + "/comments"
+
+	srv.Do(t, http.MethodGet, path, nil).ExpectProblem(t, http.StatusUnauthorized, "unauthenticated")
+	srv.Do(t, http.MethodPost, path, map[string]string{
+		"content":   strings.Repeat("x", 5001),
+		"author_id": alice.ID.String(),
+	}, token).ExpectProblem(t, http.StatusBadRequest, "validation_failed")
+	srv.Do(t, http.MethodPost, "/posts/00000000-0000-4000-8000-000000000000/comments", map[string]string{
+		"content":   "Hello",
+		"author_id": alice.ID.String(),
+	}, token).ExpectProblem(t, http.StatusNotFound, "post_not_found")
+	srv.Do(t, http.MethodGet, "/posts/x/comments", nil, token).ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+}
+
+func TestEditingComments(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	bob := srv.CreateUser(t, "bob")
+	admin := srv.CreateAdmin(t, "root")
+	general := srv.Category(t, "General")
+	post := srv.CreatePost(t, alice, general, "Question")
+	other := srv.CreatePost(t, alice, general, "Another")
+	comment := srv.CreateComment(t, bob, post, "Me!")
+	path := "/posts/" + post.ID.String() + "/comments/" + comment.ID.String()
+	bobToken := testutil.WithToken(srv.Token(t, bob))
+
+	var edited struct {
+		Content string `json:"content"`
+	}
+	srv.Do(t, http.MethodPut, path, map[string]string{"content": "  Me too!  "}, bobToken).
+		ExpectStatus(t, http.StatusOK).Decode(t, &edited)
+	if edited.Content != "Me too!" {
+		t.Errorf("edited content = %q", edited.Content)
+	}
+
+	// Only the author or an admin may change a comment, through its own post
+	srv.Do(t, http.MethodPut, path, map[string]string{"content": "Hijacked"}, testutil.WithToken(srv.Token(t, alice))).
+		ExpectProblem(t, http.StatusForbidden, "forbidden")
+	srv.Do(t, http.MethodDelete, "/posts/"+other.ID.String()+"/comments/"+comment.ID.String(), nil, bobToken).
+		ExpectProblem(t, http.StatusNotFound, "comment_not_found")
+	srv.Do(t, http.MethodPut, path, map[string]string{"content": ""}, bobToken).
+		ExpectProblem(t, http.StatusBadRequest, "validation_failed")
+
+	srv.Do(t, http.MethodDelete, path, nil, testutil.WithToken(srv.Token(t, admin))).ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodDelete, path, nil, bobToken).ExpectProblem(t, http.StatusNotFound, "comment_not_found")
+}
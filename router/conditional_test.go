@@ -0,0 +1,108 @@
+package router_test
+
+import (
+	"backend/interfaces"
+	"backend/testutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestConditionalGetPost(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	post := srv.CreatePost(t, alice, srv.Category(t, "General"), "Hello")
+	path := "/posts/" + post.ID.String()
+
+	res := srv.Do(t, http.MethodGet, path, nil).ExpectStatus(t, http.StatusOK)
+	etag := res.Header().Get("ETag")
+	lastModified := res.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("ETag = %q, Last-Modified = %q", etag, lastModified)
+	}
+
+	res = srv.Do(t, http.MethodGet, path, nil, testutil.WithHeader("If-None-Match", `"other", `+etag)).
+		ExpectStatus(t, http.StatusNotModified)
+	if res.Body.Len() != 0 {
+		t.Errorf("304 body = %q", res.Body)
+	}
+	srv.Do(t, http.MethodGet, path, nil, testutil.WithHeader("If-Modified-Since", lastModified)).
+		ExpectStatus(t, http.StatusNotModified)
+	srv.Do(t, http.MethodGet, path, nil, testutil.WithHeader("If-Modified-Since", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))).
+		ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodGet, path, nil, testutil.WithHeader("If-None-Match", `"stale"`)).
+		ExpectStatus(t, http.StatusOK)
+}
+
+func TestUpdatePostIfMatch(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	post := srv.CreatePost(t, alice, srv.Category(t, "General"), "Hello")
+	token := testutil.WithToken(srv.Token(t, alice))
+	path := "/posts/" + post.ID.String()
+	etag := srv.Do(t, http.MethodGet, path, nil).ExpectStatus(t, http.StatusOK).Header().Get("ETag")
+
+	// The first editor wins and gets the new version back
+	res := srv.Do(t, http.MethodPut, path, map[string]string{"title": "First", "content": "Edit"}, token,
+		testutil.WithHeader("If-Match", etag)).ExpectStatus(t, http.StatusOK)
+	updated := res.Header().Get("ETag")
+	if updated == "" || updated == etag {
+		t.Fatalf("ETag after update = %q, before %q", updated, etag)
+	}
+	if got := srv.Do(t, http.MethodGet, path, nil).Header().Get("ETag"); got != updated {
+		t.Errorf("GET ETag = %q, update returned %q", got, updated)
+	}
+
+	// The second editor still holds the old version
+	srv.Do(t, http.MethodPut, path, map[string]string{"title": "Second", "content": "Edit"}, token,
+		testutil.WithHeader("If-Match", etag)).ExpectProblem(t, http.StatusPreconditionFailed, "precondition_failed")
+	var stored interfaces.Post
+	srv.DB.First(&stored, "id = ?", post.ID)
+	if stored.Title != "First" {
+		t.Errorf("title = %q, want First", stored.Title)
+	}
+
+	srv.Do(t, http.MethodPut, path, map[string]string{"title": "Third", "content": "Edit"}, token,
+		testutil.WithHeader("If-Match", "*")).ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodPut, path, map[string]string{"title": "Fourth", "content": "Edit"}, token).
+		ExpectStatus(t, http.StatusOK)
+}
+
+func TestUpdateUserIfMatch(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	token := testutil.WithToken(srv.Token(t, alice))
+	path := "/users/" + alice.ID.String()
+	etag := srv.Do(t, http.MethodGet, path, nil, token).ExpectStatus(t, http.StatusOK).Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("GET /users/:id sent no ETag")
+	}
+
+	update := map[string]string{"username": "alice", "email": "alice@example.org"}
+	srv.Do(t, http.MethodPut, path, update, token, testutil.WithHeader("If-Match", etag)).ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodPut, path, update, token, testutil.WithHeader("If-Match", etag)).
+		ExpectProblem(t, http.StatusPreconditionFailed, "precondition_failed")
+}
+
+func TestConditionalLists(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	post := srv.CreatePost(t, alice, srv.Category(t, "General"), "Hello")
+	token := testutil.WithToken(srv.Token(t, alice))
+	comments := "/posts/" + post.ID.String() + "/comments"
+
+	for _, path := range []string{"/tags", "/categories", comments} {
+		etag := srv.Do(t, http.MethodGet, path, nil, token).ExpectStatus(t, http.StatusOK).Header().Get("ETag")
+		if etag == "" {
+			t.Fatalf("%s sent no ETag", path)
+		}
+		srv.Do(t, http.MethodGet, path, nil, token, testutil.WithHeader("If-None-Match", etag)).
+			ExpectStatus(t, http.StatusNotModified)
+	}
+
+	// A new comment changes the list, so the old tag no longer matches
+	etag := srv.Do(t, http.MethodGet, comments, nil, token).Header().Get("ETag")
+	srv.CreateComment(t, alice, post, "First!")
+	srv.Do(t, http.MethodGet, comments, nil, token, testutil.WithHeader("If-None-Match", etag)).
+		ExpectStatus(t, http.StatusOK)
+}
@@ -0,0 +1,123 @@
+package router_test
+
+import (
+	"backend/events"
+	"backend/interfaces"
+	"backend/testutil"
+	"context"
+	"net/http"
+	"testing"
+)
+
+// recordEvents subscribes to every event and returns what was delivered
+func recordEvents(t *testing.T) *[]interfaces.OutboxEvent {
+	var delivered []interfaces.OutboxEvent
+	events.Subscribe(events.All, "test.recorder", func(ctx context.Context, event interfaces.OutboxEvent) error {
+		delivered = append(delivered, event)
+		return nil
+	})
+	t.Cleanup(func() {
+		events.Subscribe(events.All, "test.recorder", func(context.Context, interfaces.OutboxEvent) error { return nil })
+	})
+	return &delivered
+}
+
+func TestWritesRecordEvents(t *testing.T) {
+	srv := testutil.NewServer(t)
+	delivered := recordEvents(t)
+	general := srv.Category(t, "General")
+
+	var user struct {
+		ID string `json:"id"`
+	}
+	srv.Do(t, http.MethodPost, "/users", map[string]string{
+		"username": "alice",
+		"email":    "alice@example.edu",
+		"password": testutil.Password,
+	}).ExpectStatus(t, http.StatusCreated).Decode(t, &user)
+	var alice interfaces.User
+	srv.DB.First(&alice, "username = ?", "alice")
+	token := testutil.WithToken(srv.Token(t, alice))
+
+	var post postBody
+	srv.Do(t, http.MethodPost, "/posts", map[string]string{
+		"title":       "Hello",
+		"content":     "First post",
+		"author_id":   user.ID,
+		"category_id": general.ID.String(),
+	}, token).ExpectStatus(t, http.StatusCreated).Decode(t, &post)
+	var comment struct {
+		ID string `json:"id"`
+	}
+	srv.Do(t, http.MethodPost, "/posts/"+post.ID+"/comments", map[string]string{
+		"content":   "Nice",
+		"author_id": user.ID,
+	}, token).ExpectStatus(t, http.StatusCreated).Decode(t, &comment)
+	srv.Do(t, http.MethodPut, "/posts/"+post.ID+"/comments/"+comment.ID, map[string]string{
+		"content": "Very nice",
+	}, token).ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodDelete, "/posts/"+post.ID+"/comments/"+comment.ID, nil, token).ExpectStatus(t, http.StatusOK)
+
+	doomed := srv.CreatePost(t, alice, general, "Short lived")
+	srv.Do(t, http.MethodDelete, "/posts/"+doomed.ID.String(), nil, token).ExpectStatus(t, http.StatusOK)
+
+	// Nothing is delivered inside the request
+	if len(*delivered) != 0 {
+		t.Fatalf("%d events delivered before dispatch", len(*delivered))
+	}
+	if n := srv.DispatchEvents(t); n != 6 {
+		t.Fatalf("dispatched %d events, want 6", n)
+	}
+
+	types := map[string]interfaces.OutboxEvent{}
+	for _, event := range *delivered {
+		types[event.Type] = event
+	}
+	for _, want := range events.Types() {
+		if _, ok := types[want]; !ok {
+			t.Errorf("no %s event delivered", want)
+		}
+	}
+
+	var created events.CommentCreated
+	if err := events.Decode(types[events.TypeCommentCreated], &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.PostID.String() != post.ID || created.CategoryID != general.ID || created.Content != "Nice" {
+		t.Errorf("comment.created = %+v", created)
+	}
+	var updated events.CommentUpdated
+	if err := events.Decode(types[events.TypeCommentUpdated], &updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.CommentID.String() != comment.ID || updated.Content != "Very nice" {
+		t.Errorf("comment.updated = %+v", updated)
+	}
+	var deleted events.PostDeleted
+	if err := events.Decode(types[events.TypePostDeleted], &deleted); err != nil {
+		t.Fatal(err)
+	}
+	if deleted.PostID != doomed.ID || deleted.AuthorID != alice.ID || deleted.CategoryID != general.ID {
+		t.Errorf("post.deleted = %+v", deleted)
+	}
+}
+
+func TestFailedWritesRecordNoEvents(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	token := testutil.WithToken(srv.Token(t, alice))
+
+	srv.Do(t, http.MethodPost, "/users", map[string]string{
+		"username": "alice",
+		"email":    "other@example.edu",
+		"password": testutil.Password,
+	}).ExpectProblem(t, http.StatusConflict, "username_taken")
+	srv.Do(t, http.MethodDelete, "/posts/00000000-0000-4000-8000-000000000000", nil, token).
+		ExpectProblem(t, http.StatusNotFound, "post_not_found")
+
+	var count int64
+	srv.DB.Model(&interfaces.OutboxEvent{}).Count(&count)
+	if count != 0 {
+		t.Errorf("outbox has %d events, want none", count)
+	}
+}
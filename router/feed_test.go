@@ -0,0 +1,177 @@
+package router_test
+
+import (
+	"backend/interfaces"
+	"backend/schedule"
+	"backend/testutil"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type feedPage struct {
+	Items []struct {
+		postBody
+		CommentCount   int       `json:"comment_count"`
+		Score          int       `json:"score"`
+		LastActivityAt time.Time `json:"last_activity_at"`
+	} `json:"items"`
+	NextCursor *string `json:"next_cursor"`
+	Total      *int64  `json:"total"`
+}
+
+func (p feedPage) titles() []string {
+	titles := []string{}
+	for _, item := range p.Items {
+		titles = append(titles, item.Title)
+	}
+	return titles
+}
+
+func refreshPostStats(t *testing.T, srv *testutil.Server) {
+	t.Helper()
+	scheduler := schedule.New(srv.DB, schedule.NewMemoryLocker(), srv.Config.Scheduler)
+	if _, err := scheduler.Trigger(context.Background(), "refresh-post-stats"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFeed(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	bob := srv.CreateUser(t, "bob")
+	token := testutil.WithToken(srv.Token(t, alice))
+	now := time.Now()
+	backdate := func(model interface{}, age time.Duration) {
+		srv.DB.Model(model).Update("created_at", now.Add(-age))
+	}
+
+	// Popular three days ago, a little popular today, and busy only with
+	// its own author, who doesn't count towards the score
+	old := srv.CreatePost(t, alice, srv.Category(t, "General"), "Old")
+	backdate(&old, 72*time.Hour)
+	for i := 0; i < 3; i++ {
+		backdate(srv.CreateComment(t, bob, old, "Agreed"), 70*time.Hour)
+	}
+	recent := srv.CreatePost(t, alice, srv.Category(t, "Careers"), "Recent")
+	backdate(&recent, 2*time.Hour)
+	backdate(srv.CreateComment(t, bob, recent, "Nice"), 2*time.Hour)
+	srv.TagPost(t, recent, srv.Tag(t, "question"))
+	chatty := srv.CreatePost(t, alice, srv.Category(t, "General"), "Chatty")
+	backdate(&chatty, 3*time.Hour)
+	srv.CreateComment(t, alice, chatty, "Anyone?")
+	srv.CreateComment(t, alice, chatty, "Hello?")
+	refreshPostStats(t, srv)
+
+	// Published since the refresh, so only the new sort has it
+	srv.CreatePost(t, alice, srv.Category(t, "General"), "Fresh")
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{"sort=new", []string{"Fresh", "Recent", "Chatty", "Old"}},
+		{"sort=top", []string{"Old", "Recent", "Chatty"}},
+		{"sort=top&window=day", []string{"Recent", "Chatty"}},
+		{"", []string{"Recent", "Chatty", "Old"}},
+		{"sort=active", []string{"Chatty", "Recent", "Old"}},
+		{"sort=new&category=General", []string{"Fresh", "Chatty", "Old"}},
+		{"sort=top&tag=question", []string{"Recent"}},
+		{"sort=new&category=General&tag=question", []string{}},
+	} {
+		var page feedPage
+		srv.Do(t, http.MethodGet, "/feed?"+tc.query, nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &page)
+		if got := page.titles(); len(got) != len(tc.want) || len(got) > 0 && got[0] != tc.want[0] || len(got) > 1 && got[len(got)-1] != tc.want[len(tc.want)-1] {
+			t.Errorf("/feed?%s listed %v, want %v", tc.query, got, tc.want)
+		}
+	}
+
+	var top feedPage
+	srv.Do(t, http.MethodGet, "/feed?sort=top&window=all&limit=1&include_total=true", nil, token).
+		ExpectStatus(t, http.StatusOK).Decode(t, &top)
+	if item := top.Items[0]; item.Title != "Old" || item.Score != 3 || item.CommentCount != 3 || top.Total == nil || *top.Total != 3 {
+		t.Errorf("top post = %+v, total %v", item, top.Total)
+	}
+
+	// Every sort pages through each post exactly once
+	for _, sort := range []string{"new", "top", "hot", "active"} {
+		seen := map[string]bool{}
+		for query := "/feed?limit=1&sort=" + sort; query != ""; {
+			var page feedPage
+			srv.Do(t, http.MethodGet, query, nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &page)
+			for _, title := range page.titles() {
+				if seen[title] {
+					t.Errorf("%s listed %q twice", sort, title)
+				}
+				seen[title] = true
+			}
+			query = ""
+			if page.NextCursor != nil {
+				query = "/feed?limit=1&sort=" + sort + "&cursor=" + *page.NextCursor
+			}
+		}
+		if want := map[bool]int{true: 4, false: 3}[sort == "new"]; len(seen) != want {
+			t.Errorf("%s listed %v", sort, seen)
+		}
+	}
+
+	var hot feedPage
+	srv.Do(t, http.MethodGet, "/feed?limit=1", nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &hot)
+	srv.Do(t, http.MethodGet, "/feed?sort=new&cursor="+*hot.NextCursor, nil, token).
+		ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+	for _, query := range []string{"sort=best", "sort=top&window=decade", "limit=0", "cursor=nope"} {
+		srv.Do(t, http.MethodGet, "/feed?"+query, nil, token).ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+	}
+	srv.Do(t, http.MethodGet, "/feed?category=Nope", nil, token).ExpectProblem(t, http.StatusNotFound, "category_not_found")
+	srv.Do(t, http.MethodGet, "/feed?tag=nope", nil, token).ExpectProblem(t, http.StatusNotFound, "tag_not_found")
+	srv.Do(t, http.MethodGet, "/feed", nil).ExpectProblem(t, http.StatusUnauthorized, "unauthenticated")
+}
+
+// Writes keep the stats current themselves, so nothing here dispatches
+// events or runs the refresh task
+func TestFeedFollowsWrites(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	bob := srv.CreateUser(t, "bob")
+	aliceToken := testutil.WithToken(srv.Token(t, alice))
+	bobToken := testutil.WithToken(srv.Token(t, bob))
+
+	var post postBody
+	srv.Do(t, http.MethodPost, "/posts", map[string]string{
+		"title":       "Hello",
+		"content":     "First post",
+		"author_id":   alice.ID.String(),
+		"category_id": srv.Category(t, "General").ID.String(),
+	}, aliceToken).ExpectStatus(t, http.StatusCreated).Decode(t, &post)
+	var page feedPage
+	srv.Do(t, http.MethodGet, "/feed", nil, aliceToken).ExpectStatus(t, http.StatusOK).Decode(t, &page)
+	if len(page.Items) != 1 || page.Items[0].Score != 0 {
+		t.Fatalf("feed after post = %+v", page)
+	}
+
+	var comment struct {
+		ID string `json:"id"`
+	}
+	srv.Do(t, http.MethodPost, "/posts/"+post.ID+"/comments", map[string]string{
+		"content":   "Welcome",
+		"author_id": bob.ID.String(),
+	}, bobToken).ExpectStatus(t, http.StatusCreated).Decode(t, &comment)
+	srv.Do(t, http.MethodGet, "/feed?sort=top", nil, aliceToken).ExpectStatus(t, http.StatusOK).Decode(t, &page)
+	if len(page.Items) != 1 || page.Items[0].Score != 1 || page.Items[0].CommentCount != 1 {
+		t.Fatalf("feed after comment = %+v", page)
+	}
+
+	srv.Do(t, http.MethodDelete, "/posts/"+post.ID+"/comments/"+comment.ID, nil, bobToken).ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodGet, "/feed?sort=active", nil, aliceToken).ExpectStatus(t, http.StatusOK).Decode(t, &page)
+	if len(page.Items) != 1 || page.Items[0].Score != 0 || page.Items[0].CommentCount != 0 {
+		t.Fatalf("feed after deleting the comment = %+v", page)
+	}
+
+	srv.Do(t, http.MethodDelete, "/posts/"+post.ID, nil, aliceToken).ExpectStatus(t, http.StatusOK)
+	var count int64
+	srv.DB.Model(&interfaces.PostStats{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d post stats left after the post was deleted", count)
+	}
+}
@@ -0,0 +1,386 @@
+package router_test
+
+import (
+	"backend/config"
+	"backend/interfaces"
+	"backend/testutil"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// graphQLResult is a decoded /graphql response
+type graphQLResult struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Path       []interface{}          `json:"path"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+// codes lists the extension codes of the errors, in order
+func (r graphQLResult) codes() []string {
+	codes := make([]string, 0, len(r.Errors))
+	for _, e := range r.Errors {
+		code, _ := e.Extensions["code"].(string)
+		codes = append(codes, code)
+	}
+	return codes
+}
+
+// graphQL POSTs query with variables and decodes the response, which must
+// have the given status
+func graphQL(t *testing.T, srv *testutil.Server, status int, query string, variables map[string]interface{}, opts ...testutil.Option) graphQLResult {
+	t.Helper()
+	var result graphQLResult
+	srv.Do(t, http.MethodPost, "/graphql", map[string]interface{}{
+		"query":     query,
+		"variables": variables,
+	}, opts...).ExpectStatus(t, status).Decode(t, &result)
+	return result
+}
+
+// countQueries counts the SELECTs run on srv's store from now on
+func countQueries(t *testing.T, srv *testutil.Server) *atomic.Int64 {
+	var n atomic.Int64
+	name := "test:count_queries:" + t.Name()
+	if err := srv.DB.Callback().Query().Before("gorm:query").Register(name, func(*gorm.DB) { n.Add(1) }); err != nil {
+		t.Fatalf("registering query counter: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.DB.Callback().Query().Remove(name) })
+	return &n
+}
+
+func TestGraphQLBatchesLookups(t *testing.T) {
+	srv := testutil.NewServer(t)
+	general := srv.Category(t, "General")
+	token := testutil.WithToken(srv.Token(t, srv.CreateUser(t, "reader")))
+	queries := countQueries(t, srv)
+
+	const feed = `{
+		posts(category: "General") {
+			items {
+				title
+				author { username }
+				category { name }
+				tags { name }
+				comments { content author { username } }
+			}
+		}
+	}`
+
+	addPosts := func(from, to int) {
+		for i := from; i < to; i++ {
+			author := srv.CreateUser(t, fmt.Sprintf("author%d", i))
+			commenter := srv.CreateUser(t, fmt.Sprintf("commenter%d", i))
+			post := srv.CreatePost(t, author, general, fmt.Sprintf("Post %d", i))
+			srv.TagPost(t, post, srv.Tag(t, "question"))
+			srv.CreateComment(t, commenter, post, "First")
+			srv.CreateComment(t, author, post, "Thanks")
+		}
+	}
+
+	run := func(wantPosts int) int64 {
+		queries.Store(0)
+		result := graphQL(t, srv, http.StatusOK, feed, nil, token)
+		if len(result.Errors) > 0 {
+			t.Fatalf("errors: %+v", result.Errors)
+		}
+		var data struct {
+			Posts struct {
+				Items []struct {
+					Title    string
+					Author   struct{ Username string }
+					Tags     []struct{ Name string }
+					Comments []struct {
+						Author struct{ Username string }
+					}
+				}
+			}
+		}
+		if err := json.Unmarshal(result.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		if len(data.Posts.Items) != wantPosts {
+			t.Fatalf("got %d posts, want %d", len(data.Posts.Items), wantPosts)
+		}
+		for _, post := range data.Posts.Items {
+			if !strings.HasPrefix(post.Author.Username, "author") || len(post.Tags) != 1 || len(post.Comments) != 2 {
+				t.Errorf("post = %+v", post)
+			}
+		}
+		return queries.Load()
+	}
+
+	addPosts(0, 2)
+	few := run(2)
+	addPosts(2, 6)
+	many := run(6)
+	if many != few {
+		t.Errorf("6 posts took %d queries, 2 posts took %d; lookups should be batched", many, few)
+	}
+	// The category, its posts, then one batch each of authors, categories,
+	// tags, comments and comment authors
+	if few > 7 {
+		t.Errorf("feed took %d queries, want at most 7", few)
+	}
+}
+
+func TestGraphQLPostsPages(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	token := testutil.WithToken(srv.Token(t, alice))
+	general := srv.Category(t, "General")
+	var want []string
+	for i := 0; i < 5; i++ {
+		post := srv.CreatePost(t, alice, general, fmt.Sprintf("Post %d", i))
+		want = append([]string{post.ID.String()}, want...)
+	}
+
+	const query = `query($after: String) {
+		posts(category: "General", first: 2, after: $after) { items { id } nextCursor }
+	}`
+	var got []string
+	vars := map[string]interface{}{}
+	for {
+		result := graphQL(t, srv, http.StatusOK, query, vars, token)
+		if len(result.Errors) > 0 {
+			t.Fatalf("errors: %+v", result.Errors)
+		}
+		var data struct {
+			Posts struct {
+				Items      []struct{ ID string }
+				NextCursor *string
+			}
+		}
+		if err := json.Unmarshal(result.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range data.Posts.Items {
+			got = append(got, item.ID)
+		}
+		if data.Posts.NextCursor == nil {
+			break
+		}
+		// A post published meanwhile doesn't shift later pages
+		srv.CreatePost(t, alice, general, "Newer")
+		vars["after"] = *data.Posts.NextCursor
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("paged through %v, want %v", got, want)
+	}
+
+	for _, tc := range []struct {
+		query string
+		code  string
+	}{
+		{`{ posts(category: "General", first: 0) { nextCursor } }`, "invalid_parameter"},
+		{`{ posts(category: "General", after: "nope") { nextCursor } }`, "invalid_parameter"},
+		{`{ posts(category: "Nope") { nextCursor } }`, "category_not_found"},
+	} {
+		result := graphQL(t, srv, http.StatusOK, tc.query, nil, token)
+		if codes := result.codes(); len(codes) != 1 || codes[0] != tc.code {
+			t.Errorf("%s: codes %v, want %s", tc.query, codes, tc.code)
+		}
+	}
+}
+
+func TestGraphQLFollowsRESTAuthorization(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	bob := srv.CreateUser(t, "bob")
+	admin := srv.CreateAdmin(t, "admin")
+	post := srv.CreatePost(t, alice, srv.Category(t, "General"), "Hello")
+	vars := map[string]interface{}{"id": post.ID.String()}
+
+	// Posts and categories are public, authors are not
+	result := graphQL(t, srv, http.StatusOK, `query($id: ID!) { post(id: $id) { title category { name } author { username } } }`, vars)
+	if got := result.codes(); len(got) != 1 || got[0] != "unauthenticated" {
+		t.Errorf("signed out error codes = %v, want [unauthenticated]", got)
+	}
+	if !strings.Contains(string(result.Data), `"title":"Hello"`) || !strings.Contains(string(result.Data), `"author":null`) {
+		t.Errorf("signed out data = %s", result.Data)
+	}
+	graphQL(t, srv, http.StatusOK, `{ me { username } }`, nil)
+
+	// A bad token is refused outright rather than treated as signed out
+	srv.Do(t, http.MethodPost, "/graphql", map[string]string{"query": "{ tags { name } }"}, testutil.WithToken("nope")).
+		ExpectProblem(t, http.StatusUnauthorized, "invalid_token")
+
+	// Private fields follow userView
+	const query = `query($id: ID!) { post(id: $id) { author { username email role } } }`
+	emails := map[string]string{}
+	for _, viewer := range []interfaces.User{alice, bob, admin} {
+		result := graphQL(t, srv, http.StatusOK, query, vars, testutil.WithToken(srv.Token(t, viewer)))
+		var data struct {
+			Post struct {
+				Author struct{ Email *string }
+			}
+		}
+		if err := json.Unmarshal(result.Data, &data); err != nil || len(result.Errors) > 0 {
+			t.Fatalf("%s: %s %+v", viewer.Username, result.Data, result.Errors)
+		}
+		if data.Post.Author.Email != nil {
+			emails[viewer.Username] = *data.Post.Author.Email
+		}
+	}
+	if emails["alice"] != alice.Email || emails["admin"] != alice.Email {
+		t.Errorf("alice and admin should see the email: %v", emails)
+	}
+	if _, ok := emails["bob"]; ok {
+		t.Errorf("bob should not see alice's email")
+	}
+
+	// Mutations need a signed in user
+	result = graphQL(t, srv, http.StatusOK, `mutation($id: ID!) { deletePost(id: $id) }`, vars)
+	if got := result.codes(); len(got) != 1 || got[0] != "unauthenticated" {
+		t.Errorf("signed out mutation error codes = %v", got)
+	}
+	var count int64
+	srv.DB.Model(&interfaces.Post{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d posts after a refused delete, want 1", count)
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	srv := testutil.NewServer(t)
+	delivered := recordEvents(t)
+	alice := srv.CreateUser(t, "alice")
+	general := srv.Category(t, "General")
+	token := testutil.WithToken(srv.Token(t, alice))
+
+	result := graphQL(t, srv, http.StatusOK, `mutation($input: CreatePostInput!) {
+		createPost(input: $input) { id title author { username } category { name } }
+	}`, map[string]interface{}{"input": map[string]interface{}{
+		"title":      "  Hello  ",
+		"content":    "World",
+		"authorId":   alice.ID.String(),
+		"categoryId": general.ID.String(),
+	}}, token)
+	var created struct {
+		CreatePost struct {
+			ID     string
+			Title  string
+			Author struct{ Username string }
+		}
+	}
+	if err := json.Unmarshal(result.Data, &created); err != nil || len(result.Errors) > 0 {
+		t.Fatalf("createPost: %s %+v", result.Data, result.Errors)
+	}
+	if created.CreatePost.Title != "Hello" || created.CreatePost.Author.Username != "alice" {
+		t.Errorf("created = %+v", created)
+	}
+	postID := created.CreatePost.ID
+
+	result = graphQL(t, srv, http.StatusOK, `mutation($id: ID!, $post: ID!) {
+		updatePost(id: $id, input: {title: "Edited", content: "Again"}) { title }
+		createComment(postId: $post, input: {content: "Nice", authorId: "`+alice.ID.String()+`"}) { content post { title } }
+	}`, map[string]interface{}{"id": postID, "post": postID}, token)
+	if len(result.Errors) > 0 || !strings.Contains(string(result.Data), `"post":{"title":"Edited"}`) {
+		t.Fatalf("update and comment: %s %+v", result.Data, result.Errors)
+	}
+
+	// REST sees the edit, so the cached post was invalidated
+	var post struct{ Title string }
+	srv.Do(t, http.MethodGet, "/posts/"+postID, nil).ExpectStatus(t, http.StatusOK).Decode(t, &post)
+	if post.Title != "Edited" {
+		t.Errorf("REST title = %q, want Edited", post.Title)
+	}
+
+	// Inputs are validated like request bodies
+	result = graphQL(t, srv, http.StatusOK, `mutation { createPost(input: {title: "`+strings.Repeat("x", 256)+`", content: "x", authorId: "nope", categoryId: "`+general.ID.String()+`"}) { id } }`, nil, token)
+	if got := result.codes(); len(got) != 1 || got[0] != "validation_failed" {
+		t.Fatalf("invalid input error codes = %v", got)
+	}
+	if fields := fmt.Sprint(result.Errors[0].Extensions["fields"]); !strings.Contains(fields, "authorId") {
+		t.Errorf("fields = %s, want authorId", fields)
+	}
+	result = graphQL(t, srv, http.StatusOK, `mutation { createPost(input: {title: "`+strings.Repeat("x", 256)+`", content: "x", authorId: "`+alice.ID.String()+`", categoryId: "`+general.ID.String()+`"}) { id } }`, nil, token)
+	if fields := fmt.Sprint(result.Errors[0].Extensions["fields"]); !strings.Contains(fields, "title") {
+		t.Errorf("fields = %s, want title", fields)
+	}
+
+	doomed := srv.CreatePost(t, alice, general, "Short lived").ID.String()
+	result = graphQL(t, srv, http.StatusOK, `mutation($id: ID!) { deletePost(id: $id) }`, map[string]interface{}{"id": doomed}, token)
+	if len(result.Errors) > 0 || string(result.Data) != `{"deletePost":"`+doomed+`"}` {
+		t.Errorf("deletePost: %s %+v", result.Data, result.Errors)
+	}
+
+	// Mutations record the same events as their REST routes
+	srv.DispatchEvents(t)
+	var types []string
+	for _, event := range *delivered {
+		types = append(types, event.Type)
+	}
+	if got := strings.Join(types, ","); got != "post.created,comment.created,post.deleted" {
+		t.Errorf("events = %s", got)
+	}
+
+	// Mutations can't be sent with GET
+	srv.Do(t, http.MethodGet, "/graphql?query="+url.QueryEscape(`mutation { deletePost(id: "`+postID+`") }`), nil, token).
+		ExpectStatus(t, http.StatusMethodNotAllowed)
+}
+
+func TestGraphQLLimits(t *testing.T) {
+	srv := testutil.NewServer(t, func(cfg *config.Config) {
+		cfg.GraphQL.MaxDepth = 4
+		cfg.GraphQL.MaxComplexity = 150
+	})
+	token := testutil.WithToken(srv.Token(t, srv.CreateUser(t, "alice")))
+
+	refused := func(query, code string) {
+		t.Helper()
+		result := graphQL(t, srv, http.StatusBadRequest, query, nil, token)
+		if got := result.codes(); len(got) != 1 || got[0] != code {
+			t.Errorf("%s: codes = %v, want [%s]", query, got, code)
+		}
+		if string(result.Data) != "" {
+			t.Errorf("%s: data = %s, want none", query, result.Data)
+		}
+	}
+	refused(`{ tags { name } categories { name } me { posts { comments { post { title } } } } }`, "query_too_deep")
+	refused(`{ me { posts { comments { id content } tags { name } } } }`, "query_too_complex")
+	// Fragments count as if they were written out
+	refused(`{ me { ...posts } } fragment posts on User { posts { comments { post { title } } } }`, "query_too_deep")
+
+	graphQL(t, srv, http.StatusOK, `{ me { posts { title tags { name } } } }`, nil, token)
+	graphQL(t, srv, http.StatusOK, `{ __schema { types { name fields { name type { name ofType { name ofType { name } } } } } } }`, nil)
+
+	// Documents that don't parse or validate never run
+	graphQL(t, srv, http.StatusBadRequest, `{ tags { name `, nil)
+	graphQL(t, srv, http.StatusBadRequest, `{ tags { colour } }`, nil)
+	graphQL(t, srv, http.StatusBadRequest, `query A { tags { name } } query B { me { username } }`, nil)
+	srv.Do(t, http.MethodPost, "/graphql", map[string]string{}).ExpectProblem(t, http.StatusBadRequest, "validation_failed")
+}
+
+func TestGraphQLMutationsUseWriteLimit(t *testing.T) {
+	srv := testutil.NewServer(t, func(cfg *config.Config) {
+		cfg.RateLimit.Write = config.Rate{Requests: 1, Period: time.Minute}
+	})
+	alice := srv.CreateUser(t, "alice")
+	post := srv.CreatePost(t, alice, srv.Category(t, "General"), "Hello")
+	token := testutil.WithToken(srv.Token(t, alice))
+	comment := map[string]interface{}{
+		"post":  post.ID.String(),
+		"input": map[string]interface{}{"content": "Hi", "authorId": alice.ID.String()},
+	}
+	const mutation = `mutation($post: ID!, $input: CreateCommentInput!) { createComment(postId: $post, input: $input) { id } }`
+
+	graphQL(t, srv, http.StatusOK, mutation, comment, token)
+	srv.Do(t, http.MethodPost, "/graphql", map[string]interface{}{"query": mutation, "variables": comment}, token).
+		ExpectProblem(t, http.StatusTooManyRequests, "rate_limited")
+
+	// Queries don't count
+	for i := 0; i < 3; i++ {
+		graphQL(t, srv, http.StatusOK, `{ tags { name } }`, nil, token)
+	}
+}
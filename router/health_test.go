@@ -0,0 +1,56 @@
+package router_test
+
+import (
+	"backend/config"
+	"backend/testutil"
+	"net/http"
+	"testing"
+)
+
+func TestStatusRoute(t *testing.T) {
+	srv := testutil.NewServer(t)
+
+	var body struct {
+		Message string `json:"message"`
+	}
+	srv.Do(t, http.MethodGet, "/", nil).ExpectStatus(t, http.StatusOK).Decode(t, &body)
+	if body.Message != "API is running" {
+		t.Errorf("message = %q", body.Message)
+	}
+}
+
+func TestProbes(t *testing.T) {
+	srv := testutil.NewServer(t)
+
+	srv.Do(t, http.MethodGet, "/healthz", nil).ExpectStatus(t, http.StatusOK)
+
+	var readiness struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	srv.Do(t, http.MethodGet, "/readyz", nil).ExpectStatus(t, http.StatusOK).Decode(t, &readiness)
+	if readiness.Status != "ready" || readiness.Checks["database"] != "ok" || readiness.Checks["storage"] != "ok" {
+		t.Errorf("readiness = %+v", readiness)
+	}
+}
+
+func TestMetricsRoute(t *testing.T) {
+	// Off unless enabled, since it is public without a token
+	testutil.NewServer(t).Do(t, http.MethodGet, "/metrics", nil).ExpectProblem(t, http.StatusNotFound, "route_not_found")
+
+	srv := testutil.NewServer(t, func(cfg *config.Config) {
+		cfg.Metrics.Enabled = true
+		cfg.Metrics.Token = "scraper"
+	})
+	srv.Do(t, http.MethodGet, "/metrics", nil).ExpectProblem(t, http.StatusUnauthorized, "invalid_token")
+	srv.Do(t, http.MethodGet, "/metrics", nil, testutil.WithToken("scraper")).ExpectStatus(t, http.StatusOK)
+}
+
+func TestUnknownRoute(t *testing.T) {
+	srv := testutil.NewServer(t)
+
+	p := srv.Do(t, http.MethodGet, "/nope", nil).ExpectProblem(t, http.StatusNotFound, "route_not_found")
+	if p.RequestID == "" {
+		t.Error("problem has no request_id")
+	}
+}
@@ -0,0 +1,114 @@
+package router_test
+
+import (
+	"backend/interfaces"
+	"backend/schedule"
+	"backend/testutil"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotentCreatePost(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	general := srv.Category(t, "General")
+	token := testutil.WithToken(srv.Token(t, alice))
+	key := testutil.WithHeader("Idempotency-Key", "post-1")
+	payload := map[string]string{
+		"title":       "Hello",
+		"content":     "First post",
+		"author_id":   alice.ID.String(),
+		"category_id": general.ID.String(),
+	}
+
+	var first, second postBody
+	res := srv.Do(t, http.MethodPost, "/posts", payload, token, key).ExpectStatus(t, http.StatusCreated)
+	res.Decode(t, &first)
+	if res.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("first response marked as replayed")
+	}
+
+	res = srv.Do(t, http.MethodPost, "/posts", payload, token, key).ExpectStatus(t, http.StatusCreated)
+	res.Decode(t, &second)
+	if res.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("retry not marked as replayed")
+	}
+	if second.ID != first.ID {
+		t.Errorf("retry created post %s, first was %s", second.ID, first.ID)
+	}
+
+	var count int64
+	srv.DB.Model(&interfaces.Post{}).Count(&count)
+	if count != 1 {
+		t.Errorf("posts = %d, want 1", count)
+	}
+
+	payload["title"] = "Something else"
+	srv.Do(t, http.MethodPost, "/posts", payload, token, key).
+		ExpectProblem(t, http.StatusUnprocessableEntity, "idempotency_key_reused")
+
+	// Keys belong to the user who sent them
+	bob := srv.CreateUser(t, "bob")
+	payload["author_id"] = bob.ID.String()
+	srv.Do(t, http.MethodPost, "/posts", payload, testutil.WithToken(srv.Token(t, bob)), key).
+		ExpectStatus(t, http.StatusCreated)
+}
+
+func TestIdempotencyKeyErrors(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	post := srv.CreatePost(t, alice, srv.Category(t, "General"), "Hello")
+	token := testutil.WithToken(srv.Token(t, alice))
+	path := "/posts/" + post.ID.String() + "/comments"
+
+	srv.Do(t, http.MethodPost, path, map[string]string{"content": "hi", "author_id": alice.ID.String()},
+		token, testutil.WithHeader("Idempotency-Key", strings.Repeat("k", 256))).
+		ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+
+	// Bodies are capped before they are buffered for the fingerprint
+	srv.Do(t, http.MethodPost, path, map[string]string{"content": strings.Repeat("x", 1<<20), "author_id": alice.ID.String()},
+		token, testutil.WithHeader("Idempotency-Key", "huge")).
+		ExpectProblem(t, http.StatusRequestEntityTooLarge, "body_too_large")
+
+	// Client errors are replayed too, the request is not run again
+	key := testutil.WithHeader("Idempotency-Key", "comment-1")
+	body := map[string]string{"author_id": alice.ID.String()}
+	srv.Do(t, http.MethodPost, path, body, token, key).ExpectProblem(t, http.StatusBadRequest, "validation_failed")
+	res := srv.Do(t, http.MethodPost, path, body, token, key)
+	res.ExpectProblem(t, http.StatusBadRequest, "validation_failed")
+	if res.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("error response not replayed")
+	}
+}
+
+func TestPurgeIdempotencyKeysTask(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	token := testutil.WithToken(srv.Token(t, alice))
+	payload := map[string]string{
+		"title":       "Hello",
+		"content":     "First post",
+		"author_id":   alice.ID.String(),
+		"category_id": srv.Category(t, "General").ID.String(),
+	}
+	srv.Do(t, http.MethodPost, "/posts", payload, token, testutil.WithHeader("Idempotency-Key", "old")).
+		ExpectStatus(t, http.StatusCreated)
+	srv.Do(t, http.MethodPost, "/posts", payload, token, testutil.WithHeader("Idempotency-Key", "new")).
+		ExpectStatus(t, http.StatusCreated)
+	srv.DB.Model(&interfaces.IdempotencyKey{}).Where("key = ?", "old").
+		Update("expires_at", time.Now().Add(-time.Minute))
+
+	scheduler := schedule.New(srv.DB, schedule.NewMemoryLocker(), srv.Config.Scheduler)
+	if _, err := scheduler.Trigger(context.Background(), "purge-idempotency-keys"); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []interfaces.IdempotencyKey
+	srv.DB.Find(&keys)
+	if len(keys) != 1 || keys[0].Key != "new" {
+		t.Errorf("keys after purge = %+v, want only the unexpired one", keys)
+	}
+}
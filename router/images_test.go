@@ -0,0 +1,88 @@
+package router_test
+
+import (
+	"backend/config"
+	"backend/schedule"
+	"backend/testutil"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func avatar(data []byte) []testutil.File {
+	return []testutil.File{{Field: "file", Name: "avatar.png", ContentType: "image/png", Data: data}}
+}
+
+func TestUploadAndDeleteImage(t *testing.T) {
+	srv := testutil.NewServer(t)
+	data := []byte("\x89PNG fake image")
+
+	var body struct {
+		URL string `json:"url"`
+	}
+	srv.Upload(t, "/cloudinary/upload", map[string]string{"username": "alice"}, avatar(data)).
+		ExpectStatus(t, http.StatusOK).
+		Decode(t, &body)
+	if body.URL != srv.Images.URL("alice") {
+		t.Errorf("url = %q", body.URL)
+	}
+	if stored, ok := srv.Images.Get("alice"); !ok || !bytes.Equal(stored, data) {
+		t.Errorf("stored image = %q, %v", stored, ok)
+	}
+
+	srv.Do(t, http.MethodDelete, "/cloudinary/upload/alice", nil).ExpectStatus(t, http.StatusOK)
+	if _, ok := srv.Images.Get("alice"); ok {
+		t.Error("image still stored after delete")
+	}
+}
+
+func TestUploadErrors(t *testing.T) {
+	srv := testutil.NewServer(t, func(cfg *config.Config) {
+		cfg.Upload.MaxBytes = 16
+	})
+	fields := map[string]string{"username": "alice"}
+
+	srv.Upload(t, "/cloudinary/upload", fields, nil).ExpectProblem(t, http.StatusBadRequest, "file_missing")
+	srv.Upload(t, "/cloudinary/upload", nil, avatar([]byte("x"))).ExpectProblem(t, http.StatusBadRequest, "validation_failed")
+	srv.Upload(t, "/cloudinary/upload", fields, avatar(make([]byte, 17))).
+		ExpectProblem(t, http.StatusRequestEntityTooLarge, "file_too_large")
+	srv.Upload(t, "/cloudinary/upload", fields, []testutil.File{{Field: "file", Name: "notes.txt", ContentType: "text/plain", Data: []byte("x")}}).
+		ExpectProblem(t, http.StatusUnsupportedMediaType, "unsupported_media_type")
+
+	srv.Images.Err = errors.New("storage is down")
+	srv.Upload(t, "/cloudinary/upload", fields, avatar([]byte("x"))).ExpectProblem(t, http.StatusBadGateway, "upload_failed")
+	srv.Do(t, http.MethodDelete, "/cloudinary/upload/alice", nil).ExpectProblem(t, http.StatusBadGateway, "image_delete_failed")
+}
+
+func TestPurgeOrphanedAvatarsTask(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	for _, username := range []string{"alice", "ghost", "newcomer"} {
+		srv.Upload(t, "/cloudinary/upload", map[string]string{"username": username}, avatar([]byte("x"))).
+			ExpectStatus(t, http.StatusOK)
+		srv.Images.Backdate(username, 48*time.Hour)
+	}
+	srv.Images.Backdate("newcomer", 0)
+
+	// The avatar keeps the name alice had when she uploaded it
+	srv.Do(t, http.MethodPut, "/users/"+alice.ID.String(), map[string]string{
+		"username":   "alice2",
+		"email":      alice.Email,
+		"avatar_url": srv.Images.URL("alice"),
+	}, testutil.WithToken(srv.Token(t, alice))).ExpectStatus(t, http.StatusOK)
+
+	scheduler := schedule.New(srv.DB, schedule.NewMemoryLocker(), srv.Config.Scheduler)
+	if _, err := scheduler.Trigger(context.Background(), "purge-orphaned-avatars"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Avatars uploaded moments ago may be for an account still signing up
+	for username, want := range map[string]bool{"alice": true, "ghost": false, "newcomer": true} {
+		if _, ok := srv.Images.Get(username); ok != want {
+			t.Errorf("%s's avatar kept = %v, want %v", username, ok, want)
+		}
+	}
+}
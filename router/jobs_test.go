@@ -0,0 +1,100 @@
+package router_test
+
+import (
+	"backend/config"
+	"backend/interfaces"
+	"backend/testutil"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type jobBody struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	Status    string `json:"status"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+}
+
+func TestDeleteUserQueuesAvatarCleanup(t *testing.T) {
+	srv := testutil.NewServer(t)
+	admin := srv.CreateAdmin(t, "root")
+	bob := srv.CreateUser(t, "bob")
+	srv.Upload(t, "/cloudinary/upload", map[string]string{"username": "bob"}, avatar([]byte("png"))).
+		ExpectStatus(t, http.StatusOK)
+	srv.DB.Model(&bob).Update("avatar_url", srv.Images.URL("bob"))
+
+	token := testutil.WithToken(srv.Token(t, admin))
+	srv.Do(t, http.MethodDelete, "/users/"+bob.ID.String(), nil, token).ExpectStatus(t, http.StatusOK)
+
+	// The avatar outlives the request and goes once the job runs
+	if _, ok := srv.Images.Get("bob"); !ok {
+		t.Fatal("avatar deleted inside the request")
+	}
+	if n := srv.RunJobs(t); n != 1 {
+		t.Fatalf("ran %d jobs, want 1", n)
+	}
+	if _, ok := srv.Images.Get("bob"); ok {
+		t.Error("avatar still stored after the job ran")
+	}
+
+	var jobs []jobBody
+	srv.Do(t, http.MethodGet, "/admin/jobs?status=succeeded", nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &jobs)
+	if len(jobs) != 1 || jobs[0].Kind != "avatar.delete" || jobs[0].Attempts != 1 {
+		t.Errorf("succeeded jobs = %+v", jobs)
+	}
+}
+
+func TestJobsAdminEndpoints(t *testing.T) {
+	srv := testutil.NewServer(t, func(cfg *config.Config) {
+		cfg.Jobs.MaxAttempts = 2
+		cfg.Jobs.Backoff = time.Millisecond
+		cfg.Jobs.MaxBackoff = time.Millisecond
+	})
+	admin := srv.CreateAdmin(t, "root")
+	alice := srv.CreateUser(t, "alice")
+	srv.DB.Model(&alice).Update("avatar_url", srv.Images.URL("alice"))
+	token := testutil.WithToken(srv.Token(t, admin))
+
+	srv.Do(t, http.MethodGet, "/admin/jobs", nil).ExpectProblem(t, http.StatusUnauthorized, "unauthenticated")
+	srv.Do(t, http.MethodGet, "/admin/jobs", nil, testutil.WithToken(srv.Token(t, alice))).
+		ExpectProblem(t, http.StatusForbidden, "forbidden")
+	srv.Do(t, http.MethodGet, "/admin/jobs?status=lost", nil, token).ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+	srv.Do(t, http.MethodGet, "/admin/jobs?limit=0", nil, token).ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+
+	// Storage is down for every attempt, so the job ends up dead
+	srv.Images.Err = errors.New("storage is down")
+	srv.Do(t, http.MethodDelete, "/users/"+alice.ID.String(), nil, token).ExpectStatus(t, http.StatusOK)
+	srv.RunJobs(t)
+	time.Sleep(2 * time.Millisecond)
+	srv.RunJobs(t)
+
+	var dead []jobBody
+	srv.Do(t, http.MethodGet, "/admin/jobs?status=dead&kind=avatar.delete", nil, token).
+		ExpectStatus(t, http.StatusOK).Decode(t, &dead)
+	if len(dead) != 1 || dead[0].Attempts != 2 || dead[0].LastError != "storage is down" {
+		t.Fatalf("dead jobs = %+v", dead)
+	}
+	path := "/admin/jobs/" + dead[0].ID
+
+	// Once storage is back an admin retries it by hand
+	srv.Images.Err = nil
+	var retried jobBody
+	srv.Do(t, http.MethodPost, path+"/retry", nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &retried)
+	if retried.Status != interfaces.JobQueued || retried.Attempts != 0 {
+		t.Errorf("retried = %+v", retried)
+	}
+	srv.Do(t, http.MethodPost, path+"/retry", nil, token).ExpectProblem(t, http.StatusConflict, "job_not_retryable")
+
+	srv.RunJobs(t)
+	var job jobBody
+	srv.Do(t, http.MethodGet, path, nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &job)
+	if job.Status != interfaces.JobSucceeded {
+		t.Errorf("job after retry = %+v", job)
+	}
+
+	srv.Do(t, http.MethodGet, "/admin/jobs/00000000-0000-0000-0000-000000000001", nil, token).
+		ExpectProblem(t, http.StatusNotFound, "job_not_found")
+}
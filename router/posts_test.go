@@ -0,0 +1,186 @@
+package router_test
+
+import (
+	"backend/interfaces"
+	"backend/testutil"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type postBody struct {
+	ID         string    `json:"id"`
+	Title      string    `json:"title"`
+	Content    string    `json:"content"`
+	AuthorID   string    `json:"author_id"`
+	CategoryID string    `json:"category_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func TestPostCRUD(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	general := srv.Category(t, "General")
+	token := testutil.WithToken(srv.Token(t, alice))
+
+	var created postBody
+	srv.Do(t, http.MethodPost, "/posts", map[string]string{
+		"title":       "  Hello  ",
+		"content":     "First post",
+		"author_id":   alice.ID.String(),
+		"category_id": general.ID.String(),
+	}, token).ExpectStatus(t, http.StatusCreated).Decode(t, &created)
+	if created.ID == "" || created.Title != "Hello" || created.AuthorID != alice.ID.String() {
+		t.Fatalf("created = %+v", created)
+	}
+	path := "/posts/" + created.ID
+
+	var fetched postBody
+	srv.Do(t, http.MethodGet, path, nil).ExpectStatus(t, http.StatusOK).Decode(t, &fetched)
+	if fetched.ID != created.ID || fetched.Content != "First post" {
+		t.Errorf("fetched = %+v", fetched)
+	}
+
+	srv.Do(t, http.MethodPut, path, map[string]string{"title": "Hello again", "content": "Edited"}, token).
+		ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodGet, path, nil).ExpectStatus(t, http.StatusOK).Decode(t, &fetched)
+	if fetched.Title != "Hello again" || fetched.Content != "Edited" {
+		t.Errorf("after update = %+v", fetched)
+	}
+
+	srv.Do(t, http.MethodDelete, path, nil, token).ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodGet, path, nil).ExpectProblem(t, http.StatusNotFound, "post_not_found")
+	srv.Do(t, http.MethodDelete, path, nil, token).ExpectProblem(t, http.StatusNotFound, "post_not_found")
+}
+
+func TestPostErrors(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	token := testutil.WithToken(srv.Token(t, alice))
+	missing := "/posts/00000000-0000-4000-8000-000000000000"
+
+	srv.Do(t, http.MethodPost, "/posts", map[string]string{"title": "x"}).
+		ExpectProblem(t, http.StatusUnauthorized, "unauthenticated")
+
+	p := srv.Do(t, http.MethodPost, "/posts", map[string]string{"title": "   "}, token).
+		ExpectProblem(t, http.StatusBadRequest, "validation_failed")
+	if got := fmt.Sprint(p.Fields()); got != "[title content author_id category_id]" {
+		t.Errorf("fields = %s", got)
+	}
+
+	srv.Do(t, http.MethodGet, "/posts/123", nil).ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+	srv.Do(t, http.MethodGet, missing, nil).ExpectProblem(t, http.StatusNotFound, "post_not_found")
+	srv.Do(t, http.MethodPut, missing, map[string]string{"title": "a", "content": "b"}, token).
+		ExpectProblem(t, http.StatusNotFound, "post_not_found")
+	srv.Do(t, http.MethodPut, missing, map[string]string{"title": "a"}, token).
+		ExpectProblem(t, http.StatusBadRequest, "validation_failed")
+}
+
+func TestListPostsByCategory(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	general := srv.Category(t, "General")
+	careers := srv.Category(t, "Careers")
+	token := testutil.WithToken(srv.Token(t, alice))
+
+	for i := 0; i < 12; i++ {
+		srv.CreatePost(t, alice, general, fmt.Sprintf("Post %d", i))
+	}
+	srv.CreatePost(t, alice, careers, "Elsewhere")
+
+	var first, second, beyond []postBody
+	srv.Do(t, http.MethodGet, "/posts/category/General/0", nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &first)
+	srv.Do(t, http.MethodGet, "/posts/category/General/1", nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &second)
+	srv.Do(t, http.MethodGet, "/posts/category/General/2", nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &beyond)
+
+	if len(first) != 10 || len(second) != 2 || len(beyond) != 0 {
+		t.Fatalf("page sizes = %d, %d, %d; want 10, 2, 0", len(first), len(second), len(beyond))
+	}
+	if first[0].Title != "Post 11" || second[1].Title != "Post 0" {
+		t.Errorf("pages are not newest first: %q ... %q", first[0].Title, second[1].Title)
+	}
+	for _, post := range append(first, second...) {
+		if post.CategoryID != general.ID.String() {
+			t.Errorf("post %q is in category %s", post.Title, post.CategoryID)
+		}
+	}
+
+	res := srv.Do(t, http.MethodGet, "/posts/category/General/0", nil, token)
+	if res.Header().Get("Deprecation") != "true" || res.Header().Get("Link") != `</posts/category/General>; rel="successor-version"` {
+		t.Errorf("offset listing headers = %v", res.Header())
+	}
+
+	srv.Do(t, http.MethodGet, "/posts/category/General/-1", nil, token).ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+	srv.Do(t, http.MethodGet, "/posts/category/General/x", nil, token).ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+	srv.Do(t, http.MethodGet, "/posts/category/Nope/0", nil, token).ExpectProblem(t, http.StatusNotFound, "category_not_found")
+}
+
+type postPage struct {
+	Items      []postBody `json:"items"`
+	NextCursor *string    `json:"next_cursor"`
+	Total      *int64     `json:"total"`
+}
+
+func TestListCategoryPosts(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	general := srv.Category(t, "General")
+	token := testutil.WithToken(srv.Token(t, alice))
+
+	// Two posts share a timestamp so the ID has to break the tie
+	for i := 0; i < 5; i++ {
+		srv.CreatePost(t, alice, general, fmt.Sprintf("Post %d", i))
+	}
+	srv.CreatePost(t, alice, srv.Category(t, "Careers"), "Elsewhere")
+	var tied []interfaces.Post
+	srv.DB.Where("title IN ?", []string{"Post 1", "Post 2"}).Find(&tied)
+	srv.DB.Model(&tied[1]).Update("created_at", tied[0].CreatedAt)
+
+	var first postPage
+	srv.Do(t, http.MethodGet, "/posts/category/General?limit=2&include_total=true", nil, token).
+		ExpectStatus(t, http.StatusOK).Decode(t, &first)
+	if len(first.Items) != 2 || first.Items[0].Title != "Post 4" || first.NextCursor == nil || first.Total == nil || *first.Total != 5 {
+		t.Fatalf("first page = %+v", first)
+	}
+
+	// A post published while paging doesn't shift the pages after it
+	srv.CreatePost(t, alice, general, "Latecomer")
+
+	seen := map[string]bool{}
+	for _, post := range first.Items {
+		seen[post.Title] = true
+	}
+	for cursor := first.NextCursor; cursor != nil; {
+		var page postPage
+		srv.Do(t, http.MethodGet, "/posts/category/General?limit=2&cursor="+*cursor, nil, token).
+			ExpectStatus(t, http.StatusOK).Decode(t, &page)
+		if page.Total != nil {
+			t.Errorf("total sent without include_total")
+		}
+		for _, post := range page.Items {
+			if seen[post.Title] {
+				t.Errorf("%q listed twice", post.Title)
+			}
+			seen[post.Title] = true
+		}
+		cursor = page.NextCursor
+	}
+	if len(seen) != 5 || seen["Latecomer"] || seen["Elsewhere"] {
+		t.Errorf("listed %v, want Post 0 to Post 4", seen)
+	}
+
+	// An empty category is an empty page, not an error
+	var empty postPage
+	srv.Do(t, http.MethodGet, "/posts/category/Academics", nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &empty)
+	if len(empty.Items) != 0 || empty.NextCursor != nil {
+		t.Errorf("empty category = %+v", empty)
+	}
+
+	for _, query := range []string{"limit=0", "limit=101", "limit=x", "cursor=nope", "include_total=maybe"} {
+		srv.Do(t, http.MethodGet, "/posts/category/General?"+query, nil, token).
+			ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+	}
+	srv.Do(t, http.MethodGet, "/posts/category/Nope", nil, token).ExpectProblem(t, http.StatusNotFound, "category_not_found")
+}
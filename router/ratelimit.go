@@ -0,0 +1,46 @@
+package router
+
+import (
+	"backend/config"
+	"backend/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimits holds the limiter of each throttled route group. Groups that
+// are switched off get a middleware that lets everything through.
+type rateLimits struct {
+	signup gin.HandlerFunc
+	login  gin.HandlerFunc
+	write  gin.HandlerFunc
+	upload gin.HandlerFunc
+}
+
+func newRateLimits(cfg config.RateLimitConfig) (rateLimits, error) {
+	if !cfg.Enabled {
+		return rateLimits{signup: unlimited, login: unlimited, write: unlimited, upload: unlimited}, nil
+	}
+
+	store, err := ratelimit.Open(cfg.Backend)
+	if err != nil {
+		return rateLimits{}, err
+	}
+
+	limit := func(group string, rate config.Rate, key ratelimit.KeyFunc) gin.HandlerFunc {
+		if !rate.Enabled() {
+			return unlimited
+		}
+		return ratelimit.Middleware(store, group, rate, key)
+	}
+
+	return rateLimits{
+		signup: limit("signup", cfg.Signup, ratelimit.ByIP),
+		login:  limit("login", cfg.Login, ratelimit.ByIP),
+		write:  limit("write", cfg.Write, ratelimit.ByUser),
+		upload: limit("upload", cfg.Upload, ratelimit.ByIP),
+	}, nil
+}
+
+func unlimited(c *gin.Context) {
+	c.Next()
+}
@@ -0,0 +1,102 @@
+package router_test
+
+import (
+	"backend/config"
+	"backend/interfaces"
+	"backend/testutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLoginRateLimit(t *testing.T) {
+	srv := testutil.NewServer(t, func(cfg *config.Config) {
+		cfg.RateLimit.Login = config.Rate{Requests: 2, Period: time.Minute}
+	})
+	creds := map[string]string{"username": "nobody", "password": "whatever"}
+
+	res := srv.Do(t, http.MethodPost, "/login", creds).ExpectStatus(t, http.StatusUnauthorized)
+	if got := res.Header().Get("RateLimit-Remaining"); got != "1" {
+		t.Errorf("RateLimit-Remaining = %q, want 1", got)
+	}
+	if got := res.Header().Get("RateLimit-Policy"); got != "2;w=60" {
+		t.Errorf("RateLimit-Policy = %q", got)
+	}
+	srv.Do(t, http.MethodPost, "/login", creds).ExpectStatus(t, http.StatusUnauthorized)
+
+	res = srv.Do(t, http.MethodPost, "/login", creds)
+	res.ExpectProblem(t, http.StatusTooManyRequests, "rate_limited")
+	if got := res.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %q, want 30", got)
+	}
+	if got := res.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("RateLimit-Remaining = %q, want 0", got)
+	}
+
+	// Other clients are unaffected
+	srv.Do(t, http.MethodPost, "/login", creds, testutil.WithRemoteAddr("192.0.2.99:1234")).
+		ExpectStatus(t, http.StatusUnauthorized)
+
+	// Unthrottled routes carry no headers
+	if got := srv.Do(t, http.MethodGet, "/tags", nil).Header().Get("RateLimit-Limit"); got != "" {
+		t.Errorf("GET /tags has RateLimit-Limit %q", got)
+	}
+}
+
+func TestRateLimitIgnoresUntrustedForwarding(t *testing.T) {
+	srv := testutil.NewServer(t, func(cfg *config.Config) {
+		cfg.RateLimit.Login = config.Rate{Requests: 2, Period: time.Minute}
+	})
+	creds := map[string]string{"username": "nobody", "password": "whatever"}
+	login := func(forwardedFor string) *testutil.Response {
+		return srv.Do(t, http.MethodPost, "/login", creds, testutil.WithHeader("X-Forwarded-For", forwardedFor))
+	}
+
+	// No proxy is trusted, so a made up X-Forwarded-For doesn't buy a new bucket
+	login("203.0.113.1").ExpectStatus(t, http.StatusUnauthorized)
+	login("203.0.113.2").ExpectStatus(t, http.StatusUnauthorized)
+	login("203.0.113.3").ExpectProblem(t, http.StatusTooManyRequests, "rate_limited")
+
+	// Behind a trusted proxy, each forwarded client gets its own bucket
+	srv = testutil.NewServer(t, func(cfg *config.Config) {
+		cfg.RateLimit.Login = config.Rate{Requests: 1, Period: time.Minute}
+		cfg.Server.TrustedProxies = []string{"192.0.2.0/24"}
+	})
+	login("203.0.113.1").ExpectStatus(t, http.StatusUnauthorized)
+	login("203.0.113.1").ExpectProblem(t, http.StatusTooManyRequests, "rate_limited")
+	login("203.0.113.2").ExpectStatus(t, http.StatusUnauthorized)
+}
+
+func TestWriteRateLimitIsPerUser(t *testing.T) {
+	srv := testutil.NewServer(t, func(cfg *config.Config) {
+		cfg.RateLimit.Write = config.Rate{Requests: 1, Period: time.Minute}
+	})
+	alice := srv.CreateUser(t, "alice")
+	bob := srv.CreateUser(t, "bob")
+	post := srv.CreatePost(t, alice, srv.Category(t, "General"), "Question")
+	path := "/posts/" + post.ID.String() + "/comments"
+
+	comment := func(author interfaces.User) *testutil.Response {
+		body := map[string]string{"content": "hi", "author_id": author.ID.String()}
+		return srv.Do(t, http.MethodPost, path, body, testutil.WithToken(srv.Token(t, author)))
+	}
+
+	comment(alice).ExpectStatus(t, http.StatusCreated)
+	comment(alice).ExpectProblem(t, http.StatusTooManyRequests, "rate_limited")
+	comment(bob).ExpectStatus(t, http.StatusCreated)
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	srv := testutil.NewServer(t, func(cfg *config.Config) {
+		cfg.RateLimit.Enabled = false
+		cfg.RateLimit.Login = config.Rate{Requests: 1, Period: time.Hour}
+	})
+	creds := map[string]string{"username": "nobody", "password": "whatever"}
+
+	for i := 0; i < 3; i++ {
+		res := srv.Do(t, http.MethodPost, "/login", creds).ExpectStatus(t, http.StatusUnauthorized)
+		if got := res.Header().Get("RateLimit-Limit"); got != "" {
+			t.Fatalf("RateLimit-Limit = %q with rate limiting disabled", got)
+		}
+	}
+}
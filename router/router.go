@@ -0,0 +1,159 @@
+package router
+
+import (
+	"backend/auth"
+	"backend/config"
+	"backend/db"
+	"backend/idempotency"
+	"backend/logging"
+	"backend/metrics"
+	"backend/openapi"
+	"backend/problem"
+	"backend/tracing"
+	"net/http"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+// New builds a gin engine with CORS and every API route registered under
+// cfg.Server.Prefix. The standalone server defaults to no prefix, the Vercel
+// handler uses "/api".
+func New(cfg *config.Config, middleware ...gin.HandlerFunc) *gin.Engine {
+	router := gin.New()
+
+	// ClientIP keys the rate limits, so only believe forwarding headers
+	// from proxies we were told about; otherwise it is the peer address
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		panic(err)
+	}
+	router.TrustedPlatform = cfg.Server.TrustedPlatform
+
+	// Tracing goes first so the request span covers everything, including the log line
+	if cfg.Tracing.Exporter != "none" {
+		router.Use(otelgin.Middleware(tracing.ServiceName))
+	}
+	router.Use(logging.Middleware(), problem.Recovery())
+	if cfg.Metrics.Enabled {
+		router.Use(metrics.Middleware())
+	}
+	router.Use(middleware...)
+
+	doc := openapi.MustLoad(cfg.Server.Prefix)
+
+	// CORS middleware
+	router.Use(cors.New(cors.Config{
+		AllowOrigins:     cfg.CORS.AllowOrigins,
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "If-Match", "If-None-Match", "If-Modified-Since", idempotency.Header},
+		ExposeHeaders:    []string{"RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After", "ETag", "Last-Modified", idempotency.ReplayedHeader, "Deprecation", "Link"},
+		AllowCredentials: true,
+		MaxAge:           cfg.CORS.MaxAge,
+	}))
+
+	// Request validation runs after CORS so preflight requests are never rejected
+	if cfg.OpenAPI.Validate {
+		validate, err := openapi.ValidationMiddleware(doc)
+		if err != nil {
+			panic(err)
+		}
+		router.Use(validate)
+	}
+
+	limits, err := newRateLimits(cfg.RateLimit)
+	if err != nil {
+		panic(err)
+	}
+
+	group := router.Group(cfg.Server.Prefix)
+	if cfg.Metrics.Enabled {
+		group.GET("/metrics", metrics.Handler(cfg.Metrics.Token))
+	}
+
+	// API reference
+	group.GET("/openapi.json", openapi.SpecHandler(doc))
+	group.GET("/docs", openapi.DocsHandler(cfg.Server.Prefix+"/openapi.json"))
+
+	setupRoutes(group, limits, idempotency.Middleware(db.DB, cfg.Idempotency.TTL, db.MaxBodyBytes))
+
+	router.NoRoute(problem.NoRoute)
+
+	return router
+}
+
+// setupRoutes configures all the routes. idempotent goes after auth so
+// keys are scoped to the signed in user.
+func setupRoutes(router *gin.RouterGroup, limits rateLimits, idempotent gin.HandlerFunc) {
+	// Check route
+	router.GET("", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "API is running"})
+	})
+
+	// Liveness and readiness probes
+	router.GET("/healthz", db.Healthz)
+	router.GET("/readyz", db.Readyz)
+
+	// Authentication Routes
+	router.POST("/users", limits.signup, idempotent, db.CreateUser)
+	router.GET("/users", auth.AuthMiddleware(), db.ListUsers)
+	router.GET("/users/:id", auth.AuthMiddleware(), db.GetUser)
+	router.DELETE("/users/:id", auth.AuthMiddleware(), db.DeleteUser)
+	router.GET("/users/:id/posts", auth.AuthMiddleware(), db.GetUserPost)
+	router.PUT("/users/:id", auth.AuthMiddleware(), db.UpdateUser)
+
+	// Auth routes
+	router.POST("/login", limits.login, db.Login)
+	router.POST("/logout", db.Logout)
+	router.POST("/auth/sync", db.SyncToken)
+
+	// Post routes
+	router.POST("/posts", auth.AuthMiddleware(), limits.write, idempotent, db.CreatePost)
+	router.GET("/posts/:id", db.GetPost)
+	router.GET("/posts/category/:category", auth.AuthMiddleware(), db.ListCategoryPosts)
+	router.GET("/posts/category/:category/:pageIndex", auth.AuthMiddleware(), db.ListPostsByCategory)
+	router.GET("/feed", auth.AuthMiddleware(), db.Feed)
+	router.GET("/search", auth.AuthMiddleware(), db.Search)
+	router.PUT("/posts/:id", auth.AuthMiddleware(), db.UpdatePost)
+	router.DELETE("/posts/:id", auth.AuthMiddleware(), db.DeletePost)
+
+	// Tag routes
+	router.GET("/tags", db.ListTags)
+	router.GET("/tags/:id", db.GetTag)
+	router.GET("/posts/:id/tags", auth.AuthMiddleware(), db.ListPostTags)
+	router.POST("/posts/:id/tags", auth.AuthMiddleware(), limits.write, idempotent, db.CreatePostTag)
+	router.DELETE("/posts/:id/tags/:tag_id", auth.AuthMiddleware(), db.DeletePostTag)
+
+	// Comment routes
+	router.GET("/posts/:id/comments", auth.AuthMiddleware(), db.ListPostComments)
+	router.POST("/posts/:id/comments", auth.AuthMiddleware(), limits.write, idempotent, db.CreateComment)
+	router.PUT("/posts/:id/comments/:comment_id", auth.AuthMiddleware(), limits.write, db.UpdateComment)
+	router.DELETE("/posts/:id/comments/:comment_id", auth.AuthMiddleware(), limits.write, db.DeleteComment)
+	router.GET("/posts/:id/comments/stream", auth.AuthMiddleware(), db.StreamComments)
+
+	// Category routes
+	router.GET("/categories", db.ListCategories)
+	router.GET("/categories/:id", db.GetCategory)
+
+	// GraphQL over the same data; mutations count against the write limit
+	graphQL := db.GraphQL(limits.write)
+	router.GET("/graphql", auth.OptionalAuthMiddleware(), graphQL)
+	router.POST("/graphql", auth.OptionalAuthMiddleware(), graphQL)
+
+	// Admin routes
+	admin := router.Group("/admin", auth.AuthMiddleware(), db.RequireAdmin)
+	admin.GET("/jobs", db.ListJobs)
+	admin.GET("/jobs/:id", db.GetJob)
+	admin.POST("/jobs/:id/retry", db.RetryJob)
+	admin.GET("/webhooks", db.ListWebhooks)
+	admin.POST("/webhooks", db.CreateWebhook)
+	admin.GET("/webhooks/:id", db.GetWebhook)
+	admin.PUT("/webhooks/:id", db.UpdateWebhook)
+	admin.DELETE("/webhooks/:id", db.DeleteWebhook)
+	admin.GET("/webhooks/:id/deliveries", db.ListWebhookDeliveries)
+	admin.POST("/webhooks/:id/deliveries/:delivery_id/replay", db.ReplayWebhookDelivery)
+
+	// Image routes
+	router.POST("/cloudinary/upload", limits.upload, db.UploadHandler)
+	router.DELETE("/cloudinary/upload/:username", db.DeleteImageHandler)
+}
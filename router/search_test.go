@@ -0,0 +1,170 @@
+package router_test
+
+import (
+	"backend/testutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type searchPage struct {
+	Items []struct {
+		Type      string  `json:"type"`
+		ID        string  `json:"id"`
+		PostID    string  `json:"post_id"`
+		PostTitle string  `json:"post_title"`
+		Rank      float64 `json:"rank"`
+		Snippet   string  `json:"snippet"`
+	} `json:"items"`
+	NextCursor *string `json:"next_cursor"`
+	Total      *int64  `json:"total"`
+}
+
+func TestSearch(t *testing.T) {
+	srv := testutil.NewServer(t)
+	testSearch(t, srv)
+
+	// The test store keeps the tag-like word ts_headline would drop, so
+	// this is where escaping shows
+	var page searchPage
+	srv.Do(t, http.MethodGet, "/search?q=revising", nil, testutil.WithToken(srv.Token(t, srv.CreateUser(t, "carol")))).
+		ExpectStatus(t, http.StatusOK).Decode(t, &page)
+	if len(page.Items) != 1 || page.Items[0].Snippet != "Anyone <mark>revising</mark> for the calculus &lt;exam&gt;?" {
+		t.Errorf("search for revising = %+v, want the escaped content with the word marked", page)
+	}
+}
+
+// TestSearchPostgres runs the search against Postgres full-text search
+// itself, covering what the test store only approximates: stemming, web
+// search syntax and ts_headline cutting fragments from long text
+func TestSearchPostgres(t *testing.T) {
+	srv := testutil.NewServer(t, testutil.OnPostgres(t))
+	testSearch(t, srv)
+
+	carol := srv.CreateUser(t, "carol")
+	token := testutil.WithToken(srv.Token(t, carol))
+	search := func(q string) searchPage {
+		t.Helper()
+		var page searchPage
+		srv.Do(t, http.MethodGet, "/search?"+url.Values{"q": {q}}.Encode(), nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &page)
+		return page
+	}
+
+	for _, tc := range []struct {
+		q    string
+		want int
+	}{
+		{"helps", 1},
+		{"calculus -papers", 2},
+		{"chemistry or papers", 1},
+		{`"past papers"`, 1},
+		{`"papers past"`, 0},
+	} {
+		if got := search(tc.q); len(got.Items) != tc.want {
+			t.Errorf("search for %s: got %d hits, want %d", tc.q, len(got.Items), tc.want)
+		}
+	}
+
+	long := srv.CreatePost(t, carol, srv.Category(t, "Academics"), "Revision timetable")
+	content := strings.Repeat("Week by week plan for the spring term. ", 5) + "Then integrals, " + strings.Repeat("and then past exam questions under timed conditions. ", 4)
+	srv.DB.Model(&long).Update("content", content)
+	page := search("integral")
+	if len(page.Items) != 1 {
+		t.Fatalf("search for integral = %+v", page)
+	}
+	snippet := page.Items[0].Snippet
+	if !strings.Contains(snippet, "<mark>integrals</mark>") || len(strings.Fields(snippet)) > 35 {
+		t.Errorf("snippet %q, want at most 35 words around the marked match", snippet)
+	}
+}
+
+// testSearch checks ranking, snippets, filters and paging on srv's store
+func testSearch(t *testing.T, srv *testutil.Server) {
+	t.Helper()
+	alice := srv.CreateUser(t, "alice")
+	bob := srv.CreateUser(t, "bob")
+	token := testutil.WithToken(srv.Token(t, alice))
+
+	// A match in a title outranks one in content, which outranks a comment
+	titled := srv.CreatePost(t, alice, srv.Category(t, "Academics"), "Calculus exam tips")
+	srv.TagPost(t, titled, srv.Tag(t, "help"))
+	mentioned := srv.CreatePost(t, bob, srv.Category(t, "General"), "Study groups")
+	srv.DB.Model(&mentioned).Update("content", "Anyone revising for the calculus <exam>?")
+	srv.CreateComment(t, bob, titled, "The calculus past papers helped me")
+	srv.CreatePost(t, alice, srv.Category(t, "General"), "Unrelated")
+
+	search := func(query url.Values) searchPage {
+		t.Helper()
+		var page searchPage
+		srv.Do(t, http.MethodGet, "/search?"+query.Encode(), nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &page)
+		return page
+	}
+
+	page := search(url.Values{"q": {"Calculus"}, "include_total": {"true"}})
+	if len(page.Items) != 3 || page.Total == nil || *page.Total != 3 {
+		t.Fatalf("search for calculus = %+v", page)
+	}
+	if first := page.Items[0]; first.Type != "post" || first.PostTitle != "Calculus exam tips" {
+		t.Errorf("first hit = %+v, want the post titled with the word", first)
+	}
+	if second := page.Items[1]; second.Type != "post" || !strings.HasPrefix(second.Snippet, "Anyone revising for the <mark>calculus</mark>") ||
+		strings.Contains(strings.NewReplacer("<mark>", "", "</mark>", "").Replace(second.Snippet), "<") {
+		t.Errorf("second hit = %+v, want the escaped content with the word marked", second)
+	}
+	if third := page.Items[2]; third.Type != "comment" || third.PostID != titled.ID.String() || third.PostTitle != "Calculus exam tips" {
+		t.Errorf("third hit = %+v, want the comment", third)
+	}
+	if page.Items[0].Rank <= page.Items[1].Rank || page.Items[1].Rank <= page.Items[2].Rank {
+		t.Errorf("ranks %v, %v, %v aren't descending", page.Items[0].Rank, page.Items[1].Rank, page.Items[2].Rank)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		query url.Values
+		want  int
+	}{
+		{"every word", url.Values{"q": {"calculus papers"}}, 1},
+		{"category", url.Values{"q": {"calculus"}, "category": {"General"}}, 1},
+		{"tag", url.Values{"q": {"calculus"}, "tag": {"help"}}, 2},
+		{"author", url.Values{"q": {"calculus"}, "author": {"bob"}}, 2},
+		{"from", url.Values{"q": {"calculus"}, "from": {time.Now().Add(time.Hour).Format(time.RFC3339)}}, 0},
+		{"to", url.Values{"q": {"calculus"}, "to": {time.Now().Add(time.Hour).Format(time.RFC3339)}}, 3},
+		{"no match", url.Values{"q": {"chemistry"}}, 0},
+	} {
+		if got := search(tc.query); len(got.Items) != tc.want {
+			t.Errorf("%s: got %d hits, want %d", tc.name, len(got.Items), tc.want)
+		}
+	}
+
+	// Paging one at a time visits every hit once, in rank order
+	var ids []string
+	for query := (url.Values{"q": {"calculus"}, "limit": {"1"}}); ; {
+		page := search(query)
+		for _, item := range page.Items {
+			ids = append(ids, item.ID)
+		}
+		if page.NextCursor == nil {
+			break
+		}
+		query.Set("cursor", *page.NextCursor)
+	}
+	if len(ids) != 3 || ids[0] != titled.ID.String() || ids[1] != mentioned.ID.String() {
+		t.Errorf("paged through %v", ids)
+	}
+
+	first := search(url.Values{"q": {"calculus"}, "limit": {"1"}})
+	for _, query := range []url.Values{
+		{"q": {""}},
+		{"q": {"exam"}, "cursor": {*first.NextCursor}},
+		{"q": {"calculus"}, "from": {"yesterday"}},
+		{"q": {"calculus"}, "from": {"2024-02-01T00:00:00Z"}, "to": {"2024-01-01T00:00:00Z"}},
+	} {
+		srv.Do(t, http.MethodGet, "/search?"+query.Encode(), nil, token).ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+	}
+	srv.Do(t, http.MethodGet, "/search?q=calculus&category=Nope", nil, token).ExpectProblem(t, http.StatusNotFound, "category_not_found")
+	srv.Do(t, http.MethodGet, "/search?q=calculus&tag=nope", nil, token).ExpectProblem(t, http.StatusNotFound, "tag_not_found")
+	srv.Do(t, http.MethodGet, "/search?q=calculus&author=nobody", nil, token).ExpectProblem(t, http.StatusNotFound, "user_not_found")
+	srv.Do(t, http.MethodGet, "/search?q=calculus", nil).ExpectProblem(t, http.StatusUnauthorized, "unauthenticated")
+}
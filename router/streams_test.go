@@ -0,0 +1,222 @@
+package router_test
+
+import (
+	"backend/config"
+	"backend/interfaces"
+	"backend/testutil"
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent is one event read from a stream; comments come through with
+// only Comment set
+type sseEvent struct {
+	ID      string
+	Event   string
+	Data    string
+	Comment string
+}
+
+// openStream starts streaming the comments of post over a real connection,
+// since a recorder only returns once the handler does
+func openStream(t *testing.T, srv *testutil.Server, post interfaces.Post, token string, header map[string]string) <-chan sseEvent {
+	t.Helper()
+	server := httptest.NewServer(srv.Handler)
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/posts/"+post.ID.String()+"/comments/stream", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("stream answered %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+
+	received := make(chan sseEvent, 100)
+	go func() {
+		defer res.Body.Close()
+		scanner := bufio.NewScanner(res.Body)
+		var event sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				if event != (sseEvent{}) {
+					received <- event
+				}
+				event = sseEvent{}
+			case strings.HasPrefix(line, ":"):
+				event.Comment = strings.TrimSpace(line[1:])
+			default:
+				field, value, _ := strings.Cut(line, ": ")
+				switch field {
+				case "id":
+					event.ID = value
+				case "event":
+					event.Event = value
+				case "data":
+					event.Data = value
+				}
+			}
+		}
+	}()
+	return received
+}
+
+// nextEvent waits for the next event that isn't a heartbeat or retry hint
+func nextEvent(t *testing.T, received <-chan sseEvent) sseEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-received:
+			if event.Event != "" {
+				return event
+			}
+		case <-timeout:
+			t.Fatal("no event within 5s")
+		}
+	}
+}
+
+func TestCommentStream(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	post := srv.CreatePost(t, alice, srv.Category(t, "General"), "Question")
+	token := srv.Token(t, alice)
+	path := "/posts/" + post.ID.String() + "/comments"
+
+	// Comments from before the stream opened aren't sent again
+	srv.Do(t, http.MethodPost, path, map[string]string{"content": "Earlier", "author_id": alice.ID.String()},
+		testutil.WithToken(token)).ExpectStatus(t, http.StatusCreated)
+	srv.DispatchEvents(t)
+	stream := openStream(t, srv, post, token, nil)
+
+	var comment struct {
+		ID string `json:"id"`
+	}
+	srv.Do(t, http.MethodPost, path, map[string]string{"content": "Hello", "author_id": alice.ID.String()},
+		testutil.WithToken(token)).ExpectStatus(t, http.StatusCreated).Decode(t, &comment)
+	srv.Do(t, http.MethodPut, path+"/"+comment.ID, map[string]string{"content": "Hello again"},
+		testutil.WithToken(token)).ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodDelete, path+"/"+comment.ID, nil, testutil.WithToken(token)).ExpectStatus(t, http.StatusOK)
+
+	// Writes announce themselves, so nothing waits for the event dispatcher
+
+	var lastID int
+	for _, want := range []struct{ event, content string }{
+		{"comment.created", "Hello"},
+		{"comment.updated", "Hello again"},
+		{"comment.deleted", "Hello again"},
+	} {
+		event := nextEvent(t, stream)
+		var data struct {
+			ID      string `json:"id"`
+			Content string `json:"content"`
+		}
+		if err := json.Unmarshal([]byte(event.Data), &data); err != nil {
+			t.Fatalf("%s data %q: %v", event.Event, event.Data, err)
+		}
+		if event.Event != want.event || data.ID != comment.ID || data.Content != want.content {
+			t.Errorf("got %s %+v, want %s with %q", event.Event, data, want.event, want.content)
+		}
+		id, err := strconv.Atoi(event.ID)
+		if err != nil || id <= lastID {
+			t.Errorf("%s has id %q after %d", event.Event, event.ID, lastID)
+		}
+		lastID = id
+	}
+}
+
+func TestCommentStreamResumes(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	post := srv.CreatePost(t, alice, srv.Category(t, "General"), "Question")
+	token := srv.Token(t, alice)
+	for _, content := range []string{"One", "Two", "Three"} {
+		srv.Do(t, http.MethodPost, "/posts/"+post.ID.String()+"/comments", map[string]string{
+			"content":   content,
+			"author_id": alice.ID.String(),
+		}, testutil.WithToken(token)).ExpectStatus(t, http.StatusCreated)
+	}
+	var changes []interfaces.CommentChange
+	srv.DB.Order("id").Find(&changes)
+	if len(changes) != 3 {
+		t.Fatalf("logged %d changes, want 3", len(changes))
+	}
+
+	// Whatever came after Last-Event-ID is replayed without any announcement
+	stream := openStream(t, srv, post, token, map[string]string{"Last-Event-ID": strconv.FormatInt(changes[0].ID, 10)})
+	for _, want := range changes[1:] {
+		if event := nextEvent(t, stream); event.ID != strconv.FormatInt(want.ID, 10) || event.Data != want.Data {
+			t.Errorf("replayed %+v, want change %d", event, want.ID)
+		}
+	}
+
+	// Resuming from before purged changes asks the client to reload
+	srv.DB.Delete(&changes[0])
+	srv.DB.Delete(&changes[1])
+	stream = openStream(t, srv, post, token, map[string]string{"Last-Event-ID": strconv.FormatInt(changes[0].ID, 10)})
+	if event := nextEvent(t, stream); event.Event != "reset" {
+		t.Errorf("got %+v, want a reset", event)
+	}
+}
+
+func TestCommentStreamHeartbeat(t *testing.T) {
+	srv := testutil.NewServer(t, func(cfg *config.Config) {
+		cfg.Stream.Heartbeat = 50 * time.Millisecond
+	})
+	alice := srv.CreateUser(t, "alice")
+	post := srv.CreatePost(t, alice, srv.Category(t, "General"), "Question")
+	token := srv.Token(t, alice)
+	stream := openStream(t, srv, post, token, nil)
+
+	timeout := time.After(5 * time.Second)
+	for heartbeat := false; !heartbeat; {
+		select {
+		case event := <-stream:
+			heartbeat = event.Comment == "heartbeat"
+		case <-timeout:
+			t.Fatal("no heartbeat within 5s")
+		}
+	}
+
+	// Heartbeats also pick up changes whose announcement never came
+	srv.Do(t, http.MethodPost, "/posts/"+post.ID.String()+"/comments", map[string]string{
+		"content":   "Unannounced",
+		"author_id": alice.ID.String(),
+	}, testutil.WithToken(token)).ExpectStatus(t, http.StatusCreated)
+	if event := nextEvent(t, stream); event.Event != "comment.created" || !strings.Contains(event.Data, "Unannounced") {
+		t.Errorf("got %+v, want the new comment", event)
+	}
+}
+
+func TestCommentStreamErrors(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	post := srv.CreatePost(t, alice, srv.Category(t, "General"), "Question")
+	token := testutil.WithToken(srv.Token(t, alice))
+	path := "/posts/" + post.ID.String() + "/comments/stream"
+
+	srv.Do(t, http.MethodGet, path, nil).ExpectProblem(t, http.StatusUnauthorized, "unauthenticated")
+	srv.Do(t, http.MethodGet, "/posts/00000000-0000-4000-8000-000000000000/comments/stream", nil, token).
+		ExpectProblem(t, http.StatusNotFound, "post_not_found")
+	srv.Do(t, http.MethodGet, path, nil, token, testutil.WithHeader("Last-Event-ID", "yesterday")).
+		ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+	srv.Do(t, http.MethodGet, path+"?lastEventId=-1", nil, token).
+		ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+}
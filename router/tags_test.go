@@ -0,0 +1,70 @@
+package router_test
+
+import (
+	"backend/testutil"
+	"net/http"
+	"testing"
+)
+
+type tagBody struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestListAndGetTags(t *testing.T) {
+	srv := testutil.NewServer(t)
+
+	var tags []tagBody
+	srv.Do(t, http.MethodGet, "/tags", nil).ExpectStatus(t, http.StatusOK).Decode(t, &tags)
+	if len(tags) != 5 {
+		t.Fatalf("got %d tags, want the 5 defaults", len(tags))
+	}
+
+	var tag tagBody
+	srv.Do(t, http.MethodGet, "/tags/"+tags[0].ID, nil).ExpectStatus(t, http.StatusOK).Decode(t, &tag)
+	if tag != tags[0] {
+		t.Errorf("tag = %+v, want %+v", tag, tags[0])
+	}
+
+	srv.Do(t, http.MethodGet, "/tags/00000000-0000-4000-8000-000000000000", nil).ExpectProblem(t, http.StatusNotFound, "tag_not_found")
+	srv.Do(t, http.MethodGet, "/tags/x", nil).ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+}
+
+func TestPostTags(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	post := srv.CreatePost(t, alice, srv.Category(t, "Academics"), "Exam tips")
+	token := testutil.WithToken(srv.Token(t, alice))
+	path := "/posts/" + post.ID.String() + "/tags"
+
+	srv.Do(t, http.MethodPost, path, []map[string]string{{"name": "help"}, {"name": " resources "}}, token).
+		ExpectStatus(t, http.StatusCreated)
+
+	var tags []tagBody
+	srv.Do(t, http.MethodGet, path, nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &tags)
+	if len(tags) != 2 {
+		t.Fatalf("post has %d tags, want 2", len(tags))
+	}
+
+	srv.Do(t, http.MethodPost, path, []map[string]string{{"name": "help"}}, token).
+		ExpectProblem(t, http.StatusConflict, "post_tag_exists")
+	srv.Do(t, http.MethodPost, path, []map[string]string{{"name": "nonexistent"}}, token).
+		ExpectProblem(t, http.StatusUnprocessableEntity, "tag_unknown")
+	p := srv.Do(t, http.MethodPost, path, []map[string]string{{"name": "help"}, {"name": ""}}, token).
+		ExpectProblem(t, http.StatusBadRequest, "validation_failed")
+	if fields := p.Fields(); len(fields) != 1 || fields[0] != "[1].name" {
+		t.Errorf("fields = %v", fields)
+	}
+	srv.Do(t, http.MethodPost, "/posts/00000000-0000-4000-8000-000000000000/tags", []map[string]string{{"name": "help"}}, token).
+		ExpectProblem(t, http.StatusNotFound, "post_not_found")
+
+	help := srv.Tag(t, "help")
+	srv.Do(t, http.MethodDelete, path+"/"+help.ID.String(), nil, token).ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodDelete, path+"/"+help.ID.String(), nil, token).ExpectProblem(t, http.StatusNotFound, "post_tag_not_found")
+	srv.Do(t, http.MethodDelete, path+"/x", nil, token).ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+
+	srv.Do(t, http.MethodGet, path, nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &tags)
+	if len(tags) != 1 || tags[0].Name != "resources" {
+		t.Errorf("tags after delete = %+v", tags)
+	}
+}
@@ -0,0 +1,312 @@
+package router_test
+
+import (
+	"backend/db"
+	"backend/testutil"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSignUp(t *testing.T) {
+	srv := testutil.NewServer(t)
+
+	res := srv.Do(t, http.MethodPost, "/users", map[string]string{
+		"username": "  alice ",
+		"email":    " Alice@Example.EDU",
+		"password": testutil.Password,
+	}).ExpectStatus(t, http.StatusCreated)
+
+	var user map[string]interface{}
+	res.Decode(t, &user)
+	if user["username"] != "alice" || user["email"] != "alice@example.edu" || user["role"] != "student" {
+		t.Errorf("user = %v", user)
+	}
+	if _, ok := user["password_hash"]; ok {
+		t.Error("sign up response includes password_hash")
+	}
+
+	// The new account can log in
+	srv.Do(t, http.MethodPost, "/login", map[string]string{
+		"username": "alice",
+		"password": testutil.Password,
+	}).ExpectStatus(t, http.StatusOK)
+}
+
+func TestSignUpAcceptsLegacyPasswordField(t *testing.T) {
+	srv := testutil.NewServer(t)
+
+	srv.Do(t, http.MethodPost, "/users", map[string]string{
+		"username":      "bob",
+		"email":         "bob@example.edu",
+		"password_hash": testutil.Password,
+	}).ExpectStatus(t, http.StatusCreated)
+
+	srv.Do(t, http.MethodPost, "/login", map[string]string{
+		"username": "bob",
+		"password": testutil.Password,
+	}).ExpectStatus(t, http.StatusOK)
+}
+
+func TestSignUpValidation(t *testing.T) {
+	srv := testutil.NewServer(t)
+
+	p := srv.Do(t, http.MethodPost, "/users", map[string]string{
+		"username":   "a b",
+		"email":      "not-an-email",
+		"password":   "short",
+		"avatar_url": "nope",
+	}).ExpectProblem(t, http.StatusBadRequest, "validation_failed")
+
+	want := []string{"username", "email", "password", "avatar_url"}
+	if got := p.Fields(); !reflect.DeepEqual(got, want) {
+		t.Errorf("fields = %v, want %v", got, want)
+	}
+
+	srv.Do(t, http.MethodPost, "/users", "{").ExpectProblem(t, http.StatusBadRequest, "invalid_json")
+	srv.Do(t, http.MethodPost, "/users", "").ExpectProblem(t, http.StatusBadRequest, "invalid_json")
+
+	huge := `{"username":"` + strings.Repeat("a", 2<<20) + `"}`
+	srv.Do(t, http.MethodPost, "/users", huge).ExpectProblem(t, http.StatusRequestEntityTooLarge, "body_too_large")
+}
+
+func TestSignUpDuplicate(t *testing.T) {
+	srv := testutil.NewServer(t)
+	srv.CreateUser(t, "alice")
+
+	srv.Do(t, http.MethodPost, "/users", map[string]string{
+		"username": "alice",
+		"email":    "other@example.edu",
+		"password": testutil.Password,
+	}).ExpectProblem(t, http.StatusConflict, "username_taken")
+	srv.Do(t, http.MethodPost, "/users", map[string]string{
+		"username": "alice2",
+		"email":    "alice@example.edu",
+		"password": testutil.Password,
+	}).ExpectProblem(t, http.StatusConflict, "email_taken")
+}
+
+func TestUserViews(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	bob := srv.CreateUser(t, "bob")
+	admin := srv.CreateAdmin(t, "root")
+	post := srv.CreatePost(t, alice, srv.Category(t, "General"), "Hello")
+	srv.CreateComment(t, alice, post, "First")
+	srv.CreateComment(t, alice, post, "Second")
+
+	tests := []struct {
+		name       string
+		viewer     string
+		wantEmail  bool
+		wantCounts bool
+	}{
+		{"other student", srv.Token(t, bob), false, false},
+		{"self", srv.Token(t, alice), true, false},
+		{"admin", srv.Token(t, admin), true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var user map[string]interface{}
+			srv.Do(t, http.MethodGet, "/users/"+alice.ID.String(), nil, testutil.WithToken(tt.viewer)).
+				ExpectStatus(t, http.StatusOK).
+				Decode(t, &user)
+
+			if user["username"] != "alice" {
+				t.Errorf("username = %v", user["username"])
+			}
+			if _, ok := user["email"]; ok != tt.wantEmail {
+				t.Errorf("email present = %v, want %v", ok, tt.wantEmail)
+			}
+			if _, ok := user["post_count"]; ok != tt.wantCounts {
+				t.Errorf("post_count present = %v, want %v", ok, tt.wantCounts)
+			}
+			if tt.wantCounts && (user["post_count"] != 1.0 || user["comment_count"] != 2.0) {
+				t.Errorf("counts = %v posts, %v comments, want 1 and 2", user["post_count"], user["comment_count"])
+			}
+			if _, ok := user["password_hash"]; ok {
+				t.Error("response includes password_hash")
+			}
+		})
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	srv.CreateUser(t, "bob")
+	token := srv.Token(t, alice)
+
+	var users []map[string]interface{}
+	srv.Do(t, http.MethodGet, "/users", nil, testutil.WithToken(token)).ExpectStatus(t, http.StatusOK).Decode(t, &users)
+	if len(users) != 2 {
+		t.Fatalf("got %d users, want 2", len(users))
+	}
+	for _, user := range users {
+		_, hasEmail := user["email"]
+		if self := user["username"] == "alice"; hasEmail != self {
+			t.Errorf("user %v: email present = %v", user["username"], hasEmail)
+		}
+	}
+
+	srv.Do(t, http.MethodGet, "/users?username=bob", nil, testutil.WithToken(token)).ExpectStatus(t, http.StatusOK).Decode(t, &users)
+	if len(users) != 1 || users[0]["username"] != "bob" {
+		t.Errorf("filtered users = %v", users)
+	}
+}
+
+func TestGetUserErrors(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	token := testutil.WithToken(srv.Token(t, alice))
+
+	srv.Do(t, http.MethodGet, "/users/"+alice.ID.String(), nil).ExpectProblem(t, http.StatusUnauthorized, "unauthenticated")
+	srv.Do(t, http.MethodGet, "/users/not-a-uuid", nil, token).ExpectProblem(t, http.StatusBadRequest, "invalid_parameter")
+	srv.Do(t, http.MethodGet, "/users/00000000-0000-4000-8000-000000000000", nil, token).ExpectProblem(t, http.StatusNotFound, "user_not_found")
+}
+
+func TestUpdateUser(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	bob := srv.CreateUser(t, "bob")
+	token := testutil.WithToken(srv.Token(t, alice))
+	path := "/users/" + alice.ID.String()
+
+	srv.Do(t, http.MethodPut, path, map[string]string{
+		"username":   "alice",
+		"email":      "ALICE@uni.edu",
+		"avatar_url": "https://images.test/alice.png",
+	}, token).ExpectStatus(t, http.StatusOK)
+
+	var user map[string]interface{}
+	srv.Do(t, http.MethodGet, path, nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &user)
+	if user["email"] != "alice@uni.edu" || user["avatar_url"] != "https://images.test/alice.png" {
+		t.Errorf("user = %v", user)
+	}
+
+	srv.Do(t, http.MethodPut, path, map[string]string{
+		"username": "bob",
+		"email":    "alice@uni.edu",
+	}, token).ExpectProblem(t, http.StatusConflict, "username_taken")
+	srv.Do(t, http.MethodPut, path, map[string]string{
+		"username": "alice",
+		"email":    "bob@example.edu",
+	}, token).ExpectProblem(t, http.StatusConflict, "email_taken")
+
+	srv.Do(t, http.MethodPut, path, map[string]string{"email": "alice@uni.edu"}, token).
+		ExpectProblem(t, http.StatusBadRequest, "validation_failed")
+
+	srv.Do(t, http.MethodPut, "/users/"+bob.ID.String(), map[string]string{
+		"username": "bobby",
+		"email":    "bob@example.edu",
+	}, token).ExpectProblem(t, http.StatusForbidden, "forbidden")
+
+	admin := testutil.WithToken(srv.Token(t, srv.CreateAdmin(t, "root")))
+	srv.Do(t, http.MethodPut, "/users/"+bob.ID.String(), map[string]string{
+		"username": "bobby",
+		"email":    "bob@example.edu",
+	}, admin).ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodPut, "/users/00000000-0000-4000-8000-000000000000", map[string]string{
+		"username": "ghost",
+		"email":    "ghost@uni.edu",
+	}, admin).ExpectProblem(t, http.StatusNotFound, "user_not_found")
+}
+
+func TestDeleteUser(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	bob := srv.CreateUser(t, "bob")
+	token := testutil.WithToken(srv.Token(t, alice))
+	admin := testutil.WithToken(srv.Token(t, srv.CreateAdmin(t, "root")))
+
+	// Only the owner or an admin may delete an account
+	srv.Do(t, http.MethodDelete, "/users/"+bob.ID.String(), nil, token).ExpectProblem(t, http.StatusForbidden, "forbidden")
+	srv.Do(t, http.MethodDelete, "/users/"+bob.ID.String(), nil, admin).ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodGet, "/users/"+bob.ID.String(), nil, token).ExpectProblem(t, http.StatusNotFound, "user_not_found")
+	srv.Do(t, http.MethodDelete, "/users/"+bob.ID.String(), nil, admin).ExpectProblem(t, http.StatusNotFound, "user_not_found")
+	srv.Do(t, http.MethodDelete, "/users/"+alice.ID.String(), nil, token).ExpectStatus(t, http.StatusOK)
+}
+
+// A token names its user by ID, so taking over another account's old
+// username doesn't take over the account
+func TestRenameDoesNotTakeOverAccounts(t *testing.T) {
+	srv := testutil.NewServer(t)
+	mallory := srv.CreateUser(t, "mallory")
+	root := srv.CreateAdmin(t, "root")
+	token := testutil.WithToken(srv.Token(t, mallory))
+	adminToken := testutil.WithToken(srv.Token(t, root))
+
+	srv.Do(t, http.MethodPut, "/users/"+root.ID.String(), map[string]string{
+		"username": "mallory2",
+		"email":    "root@example.edu",
+	}, token).ExpectProblem(t, http.StatusForbidden, "forbidden")
+
+	// Even if the admin renames themselves and mallory takes the old name,
+	// both tokens keep pointing at their own accounts
+	srv.Do(t, http.MethodPut, "/users/"+mallory.ID.String(), map[string]string{
+		"username": "mallory2",
+		"email":    "mallory@example.edu",
+	}, token).ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodPut, "/users/"+root.ID.String(), map[string]string{
+		"username": "root2",
+		"email":    "root@example.edu",
+	}, adminToken).ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodPut, "/users/"+mallory.ID.String(), map[string]string{
+		"username": "root",
+		"email":    "mallory@example.edu",
+	}, token).ExpectStatus(t, http.StatusOK)
+
+	srv.Do(t, http.MethodGet, "/admin/jobs", nil, token).ExpectProblem(t, http.StatusForbidden, "forbidden")
+	srv.Do(t, http.MethodGet, "/admin/jobs", nil, adminToken).ExpectStatus(t, http.StatusOK)
+}
+
+func TestListUserPosts(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+	bob := srv.CreateUser(t, "bob")
+	general := srv.Category(t, "General")
+	srv.CreatePost(t, alice, general, "First")
+	srv.CreatePost(t, alice, general, "Second")
+	srv.CreatePost(t, bob, general, "Other")
+
+	var posts []struct {
+		Title    string `json:"title"`
+		AuthorID string `json:"author_id"`
+	}
+	srv.Do(t, http.MethodGet, "/users/"+alice.ID.String()+"/posts", nil, testutil.WithToken(srv.Token(t, bob))).
+		ExpectStatus(t, http.StatusOK).
+		Decode(t, &posts)
+	if len(posts) != 2 {
+		t.Fatalf("got %d posts, want 2", len(posts))
+	}
+	for _, post := range posts {
+		if post.AuthorID != alice.ID.String() {
+			t.Errorf("post %q by %s", post.Title, post.AuthorID)
+		}
+	}
+}
+
+func TestExportKeepsCredentials(t *testing.T) {
+	srv := testutil.NewServer(t)
+	alice := srv.CreateUser(t, "alice")
+
+	var out bytes.Buffer
+	if err := db.Export(&out); err != nil {
+		t.Fatal(err)
+	}
+	var snapshot struct {
+		Users []map[string]interface{} `json:"users"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Users) != 1 {
+		t.Fatalf("exported %d users, want 1", len(snapshot.Users))
+	}
+	if user := snapshot.Users[0]; user["username"] != "alice" || user["email"] != alice.Email || user["password_hash"] != alice.PasswordHash {
+		t.Errorf("exported user = %v", user)
+	}
+}
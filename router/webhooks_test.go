@@ -0,0 +1,268 @@
+package router_test
+
+import (
+	"backend/config"
+	"backend/interfaces"
+	"backend/testutil"
+	"backend/webhooks"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookBody struct {
+	ID          string   `json:"id"`
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	CategoryIDs []string `json:"category_ids"`
+	Active      bool     `json:"active"`
+	Secret      string   `json:"secret"`
+}
+
+type deliveryBody struct {
+	ID             string `json:"id"`
+	EventType      string `json:"event_type"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	ResponseStatus int    `json:"response_status"`
+	LastError      string `json:"last_error"`
+}
+
+// receiver is a local webhook endpoint that answers with status and keeps
+// every request it gets
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   int
+	requests []receivedWebhook
+}
+
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func newReceiver(t *testing.T) *receiver {
+	r := &receiver{status: http.StatusOK}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, receivedWebhook{header: req.Header, body: body})
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) answer(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *receiver) received() []receivedWebhook {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]receivedWebhook{}, r.requests...)
+}
+
+// newWebhookServer allows loopback receivers and retries failed
+// deliveries almost at once
+func newWebhookServer(t *testing.T) *testutil.Server {
+	return testutil.NewServer(t, func(cfg *config.Config) {
+		cfg.Webhooks.AllowPrivate = true
+		cfg.Webhooks.MaxAttempts = 2
+		cfg.Jobs.Backoff = time.Millisecond
+		cfg.Jobs.MaxBackoff = time.Millisecond
+	})
+}
+
+// deliver dispatches pending events and runs the delivery jobs, waiting
+// out retry backoff until nothing is left
+func deliver(t *testing.T, srv *testutil.Server) {
+	t.Helper()
+	srv.DispatchEvents(t)
+	for i := 0; i < 10; i++ {
+		srv.RunJobs(t)
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebhookDeliversMatchingEvents(t *testing.T) {
+	srv := newWebhookServer(t)
+	rcv := newReceiver(t)
+	admin := srv.CreateAdmin(t, "root")
+	token := testutil.WithToken(srv.Token(t, admin))
+	general := srv.Category(t, "General")
+	other := srv.Category(t, "Careers")
+
+	var hook webhookBody
+	srv.Do(t, http.MethodPost, "/admin/webhooks", map[string]interface{}{
+		"url":          rcv.URL,
+		"events":       []string{"post.created"},
+		"category_ids": []string{general.ID.String()},
+	}, token).ExpectStatus(t, http.StatusCreated).Decode(t, &hook)
+	if hook.Secret == "" || !hook.Active {
+		t.Fatalf("created webhook = %+v, want an active webhook with a generated secret", hook)
+	}
+
+	alice := srv.CreateUser(t, "alice")
+	aliceToken := testutil.WithToken(srv.Token(t, alice))
+	var post postBody
+	for _, category := range []interfaces.Category{general, other} {
+		srv.Do(t, http.MethodPost, "/posts", map[string]string{
+			"title":       "In " + category.Name,
+			"content":     "Hello",
+			"author_id":   alice.ID.String(),
+			"category_id": category.ID.String(),
+		}, aliceToken).ExpectStatus(t, http.StatusCreated).Decode(t, &post)
+	}
+	deliver(t, srv)
+
+	// Only the post in General matches the filter
+	got := rcv.received()
+	if len(got) != 1 {
+		t.Fatalf("receiver got %d deliveries, want 1", len(got))
+	}
+	if err := webhooks.Verify(hook.Secret, got[0].header.Get(webhooks.SignatureHeader), got[0].body, time.Minute, time.Now()); err != nil {
+		t.Errorf("signature: %v", err)
+	}
+	if got[0].header.Get(webhooks.EventHeader) != "post.created" {
+		t.Errorf("event header = %q", got[0].header.Get(webhooks.EventHeader))
+	}
+	var envelope struct {
+		Type string `json:"type"`
+		Data struct {
+			Title      string `json:"title"`
+			CategoryID string `json:"category_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(got[0].body, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Type != "post.created" || envelope.Data.Title != "In General" || envelope.Data.CategoryID != general.ID.String() {
+		t.Errorf("delivered %s", got[0].body)
+	}
+
+	var log []deliveryBody
+	srv.Do(t, http.MethodGet, "/admin/webhooks/"+hook.ID+"/deliveries", nil, token).
+		ExpectStatus(t, http.StatusOK).Decode(t, &log)
+	if len(log) != 1 || log[0].Status != "succeeded" || log[0].ResponseStatus != http.StatusOK || log[0].Attempts != 1 {
+		t.Errorf("delivery log = %+v", log)
+	}
+	if got[0].header.Get(webhooks.DeliveryHeader) != log[0].ID {
+		t.Errorf("delivery header = %q, want %s", got[0].header.Get(webhooks.DeliveryHeader), log[0].ID)
+	}
+}
+
+func TestWebhookRetriesThenReplays(t *testing.T) {
+	srv := newWebhookServer(t)
+	rcv := newReceiver(t)
+	rcv.answer(http.StatusInternalServerError)
+	admin := srv.CreateAdmin(t, "root")
+	token := testutil.WithToken(srv.Token(t, admin))
+
+	var hook webhookBody
+	srv.Do(t, http.MethodPost, "/admin/webhooks", map[string]interface{}{
+		"url":    rcv.URL,
+		"events": []string{"user.registered"},
+		"secret": "a-long-enough-secret",
+	}, token).ExpectStatus(t, http.StatusCreated).Decode(t, &hook)
+
+	srv.Do(t, http.MethodPost, "/users", map[string]string{
+		"username": "alice",
+		"email":    "alice@example.edu",
+		"password": testutil.Password,
+	}).ExpectStatus(t, http.StatusCreated)
+	deliver(t, srv)
+
+	if n := len(rcv.received()); n != 2 {
+		t.Fatalf("receiver got %d attempts, want 2", n)
+	}
+	path := "/admin/webhooks/" + hook.ID + "/deliveries"
+	var log []deliveryBody
+	srv.Do(t, http.MethodGet, path+"?status=failed", nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &log)
+	if len(log) != 1 || log[0].Attempts != 2 || log[0].ResponseStatus != http.StatusInternalServerError || log[0].LastError == "" {
+		t.Fatalf("delivery log = %+v, want one failed delivery after 2 attempts", log)
+	}
+
+	rcv.answer(http.StatusNoContent)
+	var replayed deliveryBody
+	srv.Do(t, http.MethodPost, path+"/"+log[0].ID+"/replay", nil, token).
+		ExpectStatus(t, http.StatusAccepted).Decode(t, &replayed)
+	if replayed.Status != "pending" {
+		t.Errorf("replayed status = %s, want pending", replayed.Status)
+	}
+	srv.Do(t, http.MethodPost, path+"/"+log[0].ID+"/replay", nil, token).
+		ExpectProblem(t, http.StatusConflict, "webhook_delivery_pending")
+	deliver(t, srv)
+
+	got := rcv.received()
+	if len(got) != 3 {
+		t.Fatalf("receiver got %d attempts, want 3", len(got))
+	}
+	if string(got[2].body) != string(got[0].body) {
+		t.Errorf("replay sent %s, first attempt sent %s", got[2].body, got[0].body)
+	}
+	srv.Do(t, http.MethodGet, path, nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &log)
+	if len(log) != 1 || log[0].Status != "succeeded" || log[0].Attempts != 3 {
+		t.Errorf("delivery log after replay = %+v", log)
+	}
+}
+
+func TestWebhookManagement(t *testing.T) {
+	srv := newWebhookServer(t)
+	admin := srv.CreateAdmin(t, "root")
+	token := testutil.WithToken(srv.Token(t, admin))
+
+	bob := srv.CreateUser(t, "bob")
+	srv.Do(t, http.MethodGet, "/admin/webhooks", nil, testutil.WithToken(srv.Token(t, bob))).
+		ExpectProblem(t, http.StatusForbidden, "forbidden")
+
+	p := srv.Do(t, http.MethodPost, "/admin/webhooks", map[string]interface{}{
+		"url":    "ftp://example.com/hook",
+		"events": []string{"post.exploded"},
+		"secret": "short",
+	}, token).ExpectProblem(t, http.StatusBadRequest, "validation_failed")
+	if fields := p.Fields(); len(fields) != 3 {
+		t.Errorf("invalid fields = %v, want url, events[0] and secret", fields)
+	}
+	srv.Do(t, http.MethodPost, "/admin/webhooks", map[string]interface{}{
+		"url":          "https://example.com/hook",
+		"events":       []string{"post.created"},
+		"category_ids": []string{"00000000-0000-4000-8000-000000000000"},
+	}, token).ExpectProblem(t, http.StatusUnprocessableEntity, "reference_unknown")
+
+	var hook webhookBody
+	srv.Do(t, http.MethodPost, "/admin/webhooks", map[string]interface{}{
+		"url":    "https://example.com/hook",
+		"events": []string{"post.created", "post.deleted"},
+		"active": false,
+	}, token).ExpectStatus(t, http.StatusCreated).Decode(t, &hook)
+	if hook.Active {
+		t.Error("webhook created active despite active: false")
+	}
+
+	var updated webhookBody
+	srv.Do(t, http.MethodPut, "/admin/webhooks/"+hook.ID, map[string]interface{}{
+		"url":    "https://example.com/other",
+		"events": []string{"comment.created"},
+	}, token).ExpectStatus(t, http.StatusOK).Decode(t, &updated)
+	if updated.URL != "https://example.com/other" || len(updated.Events) != 1 || !updated.Active || updated.Secret != "" {
+		t.Errorf("updated webhook = %+v", updated)
+	}
+
+	var list []webhookBody
+	srv.Do(t, http.MethodGet, "/admin/webhooks", nil, token).ExpectStatus(t, http.StatusOK).Decode(t, &list)
+	if len(list) != 1 || list[0].Secret != "" {
+		t.Errorf("webhooks = %+v, want one without its secret", list)
+	}
+
+	srv.Do(t, http.MethodDelete, "/admin/webhooks/"+hook.ID, nil, token).ExpectStatus(t, http.StatusOK)
+	srv.Do(t, http.MethodGet, "/admin/webhooks/"+hook.ID, nil, token).ExpectProblem(t, http.StatusNotFound, "webhook_not_found")
+}
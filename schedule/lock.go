@@ -0,0 +1,105 @@
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// Locker hands out named locks that at most one holder has at a time
+type Locker interface {
+	// TryLock takes the lock named key if it is free, returning nil if
+	// someone else holds it
+	TryLock(ctx context.Context, key string) (Lock, error)
+}
+
+// Lock is a held lock
+type Lock interface {
+	// Held reports whether the lock is still ours. A Postgres lock is lost
+	// with the connection holding it.
+	Held(ctx context.Context) bool
+	Release()
+}
+
+// NewLocker returns advisory locks on Postgres and process-local locks on
+// any other database, which only has one process talking to it
+func NewLocker(conn *gorm.DB) (Locker, error) {
+	if conn.Dialector.Name() != "postgres" {
+		return NewMemoryLocker(), nil
+	}
+	sqlDB, err := conn.DB()
+	if err != nil {
+		return nil, err
+	}
+	return &PostgresLocker{db: sqlDB}, nil
+}
+
+// PostgresLocker uses session-level advisory locks. Each held lock pins a
+// pooled connection, since the lock belongs to the session that took it.
+type PostgresLocker struct {
+	db *sql.DB
+}
+
+func (l *PostgresLocker) TryLock(ctx context.Context, key string) (Lock, error) {
+	conn, err := l.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", key).Scan(&ok); err != nil || !ok {
+		conn.Close()
+		return nil, err
+	}
+	return &postgresLock{conn: conn, key: key}, nil
+}
+
+type postgresLock struct {
+	conn *sql.Conn
+	key  string
+}
+
+func (l *postgresLock) Held(ctx context.Context) bool {
+	return l.conn.PingContext(ctx) == nil
+}
+
+func (l *postgresLock) Release() {
+	l.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", l.key)
+	l.conn.Close()
+}
+
+// MemoryLocker keeps locks in this process. Schedulers sharing one
+// MemoryLocker elect a leader between themselves, which tests rely on.
+type MemoryLocker struct {
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{held: map[string]bool{}}
+}
+
+func (l *MemoryLocker) TryLock(_ context.Context, key string) (Lock, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[key] {
+		return nil, nil
+	}
+	l.held[key] = true
+	return &memoryLock{locker: l, key: key}, nil
+}
+
+type memoryLock struct {
+	locker *MemoryLocker
+	key    string
+}
+
+func (l *memoryLock) Held(context.Context) bool { return true }
+
+func (l *memoryLock) Release() {
+	l.locker.mu.Lock()
+	defer l.locker.mu.Unlock()
+	delete(l.locker.held, l.key)
+}
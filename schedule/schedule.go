@@ -0,0 +1,271 @@
+// Package schedule runs maintenance tasks on cron-style schedules. Every
+// serve and worker process runs a Scheduler, but only the one holding the
+// leader lock (a Postgres advisory lock) starts scheduled runs, and a task
+// lock keeps a manual run from overlapping a scheduled one.
+package schedule
+
+import (
+	"backend/config"
+	"backend/interfaces"
+	"backend/logging"
+	"backend/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Task is work run on a schedule
+type Task struct {
+	Name     string
+	Spec     string // The schedule as registered, e.g. "@hourly"
+	Schedule Schedule
+	Run      func(ctx context.Context) error
+}
+
+var (
+	tasksMu sync.RWMutex
+	tasks   = map[string]Task{}
+)
+
+// Register adds a task that runs on spec (see Parse). Packages register
+// their tasks from init, so an invalid spec panics.
+func Register(name, spec string, run func(ctx context.Context) error) {
+	schedule, err := Parse(spec)
+	if err != nil {
+		panic(fmt.Sprintf("registering task %s: %v", name, err))
+	}
+
+	tasksMu.Lock()
+	defer tasksMu.Unlock()
+	tasks[name] = Task{Name: name, Spec: spec, Schedule: schedule, Run: run}
+}
+
+// Tasks lists the registered tasks by name
+func Tasks() []Task {
+	tasksMu.RLock()
+	defer tasksMu.RUnlock()
+	list := make([]Task, 0, len(tasks))
+	for _, task := range tasks {
+		list = append(list, task)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func lookup(name string) (Task, bool) {
+	tasksMu.RLock()
+	defer tasksMu.RUnlock()
+	task, ok := tasks[name]
+	return task, ok
+}
+
+// Errors returned by Trigger
+var (
+	ErrUnknownTask = errors.New("unknown task")
+	ErrBusy        = errors.New("task is already running")
+)
+
+// Run triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// leaderKey is the advisory lock held by the instance that schedules runs
+const leaderKey = "studenthub:scheduler"
+
+// Scheduler starts registered tasks when they are due and records each
+// run in the schedule_runs table
+type Scheduler struct {
+	db       *gorm.DB
+	locker   Locker
+	tick     time.Duration
+	instance string
+
+	leader Lock                 // Nil unless this instance is the leader
+	next   map[string]time.Time // When each task runs next, while leader
+	wg     sync.WaitGroup       // Scheduled runs in progress
+}
+
+// New creates a scheduler recording runs in conn and electing its leader
+// through locker
+func New(conn *gorm.DB, locker Locker, cfg config.SchedulerConfig) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		db:       conn,
+		locker:   locker,
+		tick:     cfg.Tick,
+		instance: fmt.Sprintf("%s/%d", host, os.Getpid()),
+	}
+}
+
+// Run calls Tick every interval until ctx is cancelled, then waits for
+// the runs it started and gives up leadership
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.tick)
+	defer ticker.Stop()
+
+	s.Tick(ctx, time.Now())
+	for {
+		select {
+		case <-ctx.Done():
+			s.wg.Wait()
+			s.resign()
+			return
+		case now := <-ticker.C:
+			s.Tick(ctx, now)
+		}
+	}
+}
+
+// Tick is one scheduling round at now: it tries to become leader if no
+// instance is, and as leader starts every task that is due. Runs happen
+// in the background; Wait blocks until they finish.
+func (s *Scheduler) Tick(ctx context.Context, now time.Time) {
+	if s.leader != nil && !s.leader.Held(ctx) {
+		slog.Warn("lost scheduler leadership")
+		s.resign()
+	}
+	if s.leader == nil {
+		lock, err := s.locker.TryLock(ctx, leaderKey)
+		if err != nil {
+			slog.Error("scheduler leader election failed", "error", err)
+			return
+		}
+		if lock == nil {
+			return
+		}
+		slog.Info("became scheduler leader", "instance", s.instance)
+		s.leader = lock
+		s.next = map[string]time.Time{}
+	}
+
+	for _, task := range Tasks() {
+		next, ok := s.next[task.Name]
+		if !ok {
+			// Runs missed while another instance led, or before any did, are skipped
+			s.next[task.Name] = task.Schedule.Next(now)
+			continue
+		}
+		if next.IsZero() || now.Before(next) {
+			continue
+		}
+
+		s.next[task.Name] = task.Schedule.Next(now)
+		s.wg.Add(1)
+		go func(task Task) {
+			defer s.wg.Done()
+			if _, err := s.execute(ctx, task, TriggerSchedule); errors.Is(err, ErrBusy) {
+				slog.Warn("skipping scheduled run, task is still running", "task", task.Name)
+			}
+		}(task)
+	}
+}
+
+// Wait blocks until the scheduled runs started so far have finished
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// IsLeader reports whether this instance starts scheduled runs
+func (s *Scheduler) IsLeader() bool {
+	return s.leader != nil
+}
+
+// NextRun is when the task named name runs next, as far as this instance
+// knows. It is zero unless this instance is the leader.
+func (s *Scheduler) NextRun(name string) time.Time {
+	return s.next[name]
+}
+
+func (s *Scheduler) resign() {
+	if s.leader != nil {
+		s.leader.Release()
+		s.leader = nil
+		s.next = nil
+	}
+}
+
+// Trigger runs the named task now, whichever instance leads, and returns
+// the recorded run. The error is the task's own when it fails.
+func (s *Scheduler) Trigger(ctx context.Context, name string) (interfaces.ScheduleRun, error) {
+	task, ok := lookup(name)
+	if !ok {
+		return interfaces.ScheduleRun{}, fmt.Errorf("%w %q", ErrUnknownTask, name)
+	}
+	return s.execute(ctx, task, TriggerManual)
+}
+
+// History lists the most recent runs recorded in conn, of one task unless
+// task is empty
+func History(ctx context.Context, conn *gorm.DB, task string, limit int) ([]interfaces.ScheduleRun, error) {
+	query := conn.WithContext(ctx).Order("started_at DESC").Limit(limit)
+	if task != "" {
+		query = query.Where("task = ?", task)
+	}
+	var runs []interfaces.ScheduleRun
+	err := query.Find(&runs).Error
+	return runs, err
+}
+
+// execute runs task under its lock and records the run
+func (s *Scheduler) execute(ctx context.Context, task Task, trigger string) (interfaces.ScheduleRun, error) {
+	lock, err := s.locker.TryLock(ctx, "studenthub:task:"+task.Name)
+	if err != nil {
+		return interfaces.ScheduleRun{}, err
+	}
+	if lock == nil {
+		return interfaces.ScheduleRun{}, ErrBusy
+	}
+	defer lock.Release()
+
+	// The record is finished even if shutdown cancels the task
+	record := s.db.WithContext(context.WithoutCancel(ctx))
+	run := interfaces.ScheduleRun{
+		Task:      task.Name,
+		Trigger:   trigger,
+		Status:    interfaces.RunRunning,
+		Instance:  s.instance,
+		StartedAt: time.Now(),
+	}
+	if err := record.Create(&run).Error; err != nil {
+		return run, fmt.Errorf("recording run of %s: %w", task.Name, err)
+	}
+
+	logger := slog.Default().With("task", task.Name, "trigger", trigger, "run_id", run.ID)
+	err = runTask(logging.WithLogger(ctx, logger), task)
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Status = interfaces.RunSucceeded
+	if err != nil {
+		run.Status = interfaces.RunFailed
+		run.Error = err.Error()
+		logger.Error("scheduled task failed", "error", err)
+	} else {
+		logger.Info("scheduled task finished", "duration", finished.Sub(run.StartedAt))
+	}
+	metrics.ObserveTask(task.Name, run.Status, run.StartedAt)
+
+	if saveErr := record.Save(&run).Error; saveErr != nil {
+		logger.Error("recording task result failed", "error", saveErr)
+	}
+	return run, err
+}
+
+// runTask calls the task, turning a panic into an error
+func runTask(ctx context.Context, task Task) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("task panicked: %v", r)
+		}
+	}()
+	return task.Run(ctx)
+}
@@ -0,0 +1,143 @@
+package schedule_test
+
+import (
+	"backend/config"
+	"backend/interfaces"
+	"backend/schedule"
+	"backend/testutil"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var testConfig = config.SchedulerConfig{Enabled: true, Tick: time.Second}
+
+func newStore(t *testing.T) *gorm.DB {
+	store := testutil.NewStore(t)
+	if err := store.AutoMigrate(&interfaces.ScheduleRun{}); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestOnlyTheLeaderRunsTasks(t *testing.T) {
+	var calls atomic.Int32
+	schedule.Register("test.leader", "@hourly", func(ctx context.Context) error {
+		calls.Add(1)
+		return nil
+	})
+
+	ctx := context.Background()
+	store := newStore(t)
+	locker := schedule.NewMemoryLocker()
+	first := schedule.New(store, locker, testConfig)
+	second := schedule.New(store, locker, testConfig)
+
+	now := time.Date(2026, 3, 14, 10, 20, 0, 0, time.UTC)
+	first.Tick(ctx, now)
+	second.Tick(ctx, now)
+	if !first.IsLeader() || second.IsLeader() {
+		t.Fatalf("leaders: first %v, second %v; want only the first", first.IsLeader(), second.IsLeader())
+	}
+	next := first.NextRun("test.leader")
+	if want := time.Date(2026, 3, 14, 11, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Fatalf("NextRun = %v, want %v", next, want)
+	}
+
+	// The first sighting only schedules; nothing runs until the time comes
+	first.Tick(ctx, next.Add(-time.Second))
+	first.Wait()
+	if calls.Load() != 0 {
+		t.Fatalf("task ran %d times before it was due", calls.Load())
+	}
+
+	for _, s := range []*schedule.Scheduler{first, second} {
+		s.Tick(ctx, next)
+		s.Wait()
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("task ran %d times, want 1", calls.Load())
+	}
+
+	runs, err := schedule.History(ctx, store, "test.leader", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Trigger != schedule.TriggerSchedule || runs[0].Status != interfaces.RunSucceeded || runs[0].FinishedAt == nil {
+		t.Fatalf("history = %+v, want one succeeded scheduled run", runs)
+	}
+}
+
+func TestTriggerRecordsFailures(t *testing.T) {
+	schedule.Register("test.failing", "@daily", func(ctx context.Context) error {
+		return errors.New("disk full")
+	})
+	schedule.Register("test.panicking", "@daily", func(ctx context.Context) error {
+		panic("boom")
+	})
+
+	ctx := context.Background()
+	store := newStore(t)
+	scheduler := schedule.New(store, schedule.NewMemoryLocker(), testConfig)
+
+	run, err := scheduler.Trigger(ctx, "test.failing")
+	if err == nil || err.Error() != "disk full" {
+		t.Fatalf("Trigger error = %v, want the task's error", err)
+	}
+	if run.Status != interfaces.RunFailed || run.Error != "disk full" || run.Trigger != schedule.TriggerManual {
+		t.Fatalf("run = %+v, want a failed manual run", run)
+	}
+
+	if _, err := scheduler.Trigger(ctx, "test.panicking"); err == nil {
+		t.Fatal("a panicking task succeeded")
+	}
+
+	runs, err := schedule.History(ctx, store, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("history has %d runs, want 2", len(runs))
+	}
+	for _, run := range runs {
+		if run.Status != interfaces.RunFailed {
+			t.Errorf("%s run status = %s, want %s", run.Task, run.Status, interfaces.RunFailed)
+		}
+	}
+}
+
+func TestTriggerRejectsUnknownAndBusyTasks(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	schedule.Register("test.slow", "@daily", func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+
+	ctx := context.Background()
+	store := newStore(t)
+	scheduler := schedule.New(store, schedule.NewMemoryLocker(), testConfig)
+
+	if _, err := scheduler.Trigger(ctx, "test.missing"); !errors.Is(err, schedule.ErrUnknownTask) {
+		t.Fatalf("Trigger of a missing task: %v, want ErrUnknownTask", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := scheduler.Trigger(ctx, "test.slow")
+		done <- err
+	}()
+	<-started
+	if _, err := scheduler.Trigger(ctx, "test.slow"); !errors.Is(err, schedule.ErrBusy) {
+		t.Fatalf("overlapping Trigger: %v, want ErrBusy", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,174 @@
+package seed
+
+import (
+	"backend/interfaces"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Options controls the size and shape of a generated dataset
+type Options struct {
+	Seed               int64  // Same seed and options always produce the same dataset
+	Users              int    // Number of users
+	Categories         int    // Number of categories, capped at the built-in list
+	Tags               int    // Number of tags, capped at the built-in list
+	Posts              int    // Number of posts
+	MaxTagsPerPost     int    // Each post gets between 0 and this many tags
+	MaxCommentsPerPost int    // Each post gets between 0 and this many comments
+	Password           string // Plain text password shared by every generated user
+}
+
+// Sizes are named presets for Options
+var Sizes = map[string]Options{
+	"small":  {Users: 10, Categories: 4, Tags: 10, Posts: 50, MaxTagsPerPost: 3, MaxCommentsPerPost: 5},
+	"medium": {Users: 100, Categories: 8, Tags: 20, Posts: 1000, MaxTagsPerPost: 4, MaxCommentsPerPost: 10},
+	"large":  {Users: 1000, Categories: 10, Tags: 25, Posts: 20000, MaxTagsPerPost: 5, MaxCommentsPerPost: 20},
+}
+
+// DefaultPassword is used when Options.Password is empty
+const DefaultPassword = "password123"
+
+// epoch anchors generated timestamps so they don't depend on the wall clock
+var epoch = time.Date(2024, time.January, 8, 9, 0, 0, 0, time.UTC)
+
+// Dataset is a generated set of rows ready to be written
+type Dataset struct {
+	Users      []interfaces.User
+	Categories []interfaces.Category
+	Tags       []interfaces.Tag
+	Posts      []interfaces.Post
+	PostTags   []interfaces.PostTag
+	Comments   []interfaces.Comment
+}
+
+// generator wraps a seeded random source
+type generator struct {
+	rng *rand.Rand
+}
+
+// id returns a random (version 4) UUID drawn from the seeded source
+func (g *generator) id() uuid.UUID {
+	id, err := uuid.NewRandomFromReader(g.rng)
+	if err != nil {
+		// rand.Rand never fails to read
+		panic(err)
+	}
+	return id
+}
+
+func (g *generator) pick(words []string) string {
+	return words[g.rng.Intn(len(words))]
+}
+
+// after returns a time up to maxOffset after start
+func (g *generator) after(start time.Time, maxOffset time.Duration) time.Time {
+	return start.Add(time.Duration(g.rng.Int63n(int64(maxOffset)))).Truncate(time.Second)
+}
+
+// Generate builds a dataset from opts. Apart from password hashes, which are
+// salted, the result is identical for identical options.
+func Generate(opts Options) (*Dataset, error) {
+	if opts.Users < 1 && opts.Posts > 0 {
+		return nil, fmt.Errorf("posts need at least one user")
+	}
+	if opts.Categories < 1 && opts.Posts > 0 {
+		return nil, fmt.Errorf("posts need at least one category")
+	}
+
+	password := opts.Password
+	if password == "" {
+		password = DefaultPassword
+	}
+
+	// Hash once: bcrypt is deliberately slow and every user shares the password
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("hashing password: %w", err)
+	}
+
+	g := &generator{rng: rand.New(rand.NewSource(opts.Seed))}
+	ds := &Dataset{}
+
+	for i := 0; i < opts.Users; i++ {
+		first, last := g.pick(firstNames), g.pick(lastNames)
+		username := fmt.Sprintf("%s_%s%d", first, last, i+1)
+		createdAt := g.after(epoch, 90*24*time.Hour)
+		ds.Users = append(ds.Users, interfaces.User{
+			ID:           g.id(),
+			Username:     username,
+			Email:        fmt.Sprintf("%s@example.edu", username),
+			PasswordHash: string(hash),
+			Role:         interfaces.RoleStudent,
+			CreatedAt:    createdAt,
+			UpdatedAt:    createdAt,
+		})
+	}
+
+	for _, category := range categoryNames[:min(opts.Categories, len(categoryNames))] {
+		ds.Categories = append(ds.Categories, interfaces.Category{
+			ID:          g.id(),
+			Name:        category.name,
+			Description: category.description,
+			CreatedAt:   epoch,
+		})
+	}
+
+	for _, name := range tagNames[:min(opts.Tags, len(tagNames))] {
+		ds.Tags = append(ds.Tags, interfaces.Tag{ID: g.id(), Name: name, CreatedAt: epoch})
+	}
+
+	for i := 0; i < opts.Posts; i++ {
+		author := ds.Users[g.rng.Intn(len(ds.Users))]
+		createdAt := g.after(author.CreatedAt, 180*24*time.Hour)
+		post := interfaces.Post{
+			ID:         g.id(),
+			Title:      fmt.Sprintf(g.pick(titleTemplates), g.pick(subjects)),
+			Content:    g.paragraph(2 + g.rng.Intn(4)),
+			AuthorID:   author.ID,
+			CategoryID: ds.Categories[g.rng.Intn(len(ds.Categories))].ID,
+			CreatedAt:  createdAt,
+			UpdatedAt:  createdAt,
+		}
+		ds.Posts = append(ds.Posts, post)
+
+		if len(ds.Tags) > 0 && opts.MaxTagsPerPost > 0 {
+			for _, j := range g.rng.Perm(len(ds.Tags))[:g.rng.Intn(min(opts.MaxTagsPerPost, len(ds.Tags))+1)] {
+				ds.PostTags = append(ds.PostTags, interfaces.PostTag{PostID: post.ID, TagID: ds.Tags[j].ID})
+			}
+		}
+
+		// Replies form a thread: each one lands after the previous
+		commentedAt := createdAt
+		comments := 0
+		if opts.MaxCommentsPerPost > 0 {
+			comments = g.rng.Intn(opts.MaxCommentsPerPost + 1)
+		}
+		for j := 0; j < comments; j++ {
+			commentedAt = g.after(commentedAt, 48*time.Hour)
+			ds.Comments = append(ds.Comments, interfaces.Comment{
+				ID:        g.id(),
+				Content:   g.pick(replies),
+				PostID:    post.ID,
+				AuthorID:  ds.Users[g.rng.Intn(len(ds.Users))].ID,
+				CreatedAt: commentedAt,
+				UpdatedAt: commentedAt,
+			})
+		}
+	}
+
+	return ds, nil
+}
+
+// paragraph joins n random sentences
+func (g *generator) paragraph(n int) string {
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = g.pick(sentences)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,76 @@
+package seed
+
+// Word lists used to build realistic looking forum content
+var (
+	firstNames = []string{
+		"alex", "bella", "chen", "daniel", "emma", "farah", "gabriel", "hana", "isaac", "jia",
+		"kumar", "lena", "marcus", "nadia", "oliver", "priya", "quinn", "rachel", "sean", "tara",
+		"umar", "vivian", "wei", "xavier", "yuki", "zara",
+	}
+	lastNames = []string{
+		"tan", "lim", "ng", "wong", "lee", "singh", "kumar", "ong", "goh", "chua",
+		"smith", "nguyen", "garcia", "khan", "ito", "park", "rahman", "teo", "yeo", "koh",
+	}
+
+	categoryNames = []struct{ name, description string }{
+		{"General", "Anything that doesn't fit elsewhere"},
+		{"Academics", "Modules, assignments and exams"},
+		{"Campus Life", "Events, clubs and halls"},
+		{"Careers", "Internships, jobs and interviews"},
+		{"Housing", "Finding rooms, roommates and hostels"},
+		{"Marketplace", "Buying and selling textbooks and gear"},
+		{"Tech Help", "Laptops, software and campus Wi-Fi"},
+		{"Sports", "Teams, gyms and matches"},
+		{"Study Groups", "Find people to revise with"},
+		{"Announcements", "Official news from student societies"},
+	}
+	tagNames = []string{
+		"question", "discussion", "announcement", "help", "resources", "exam", "assignment",
+		"internship", "event", "urgent", "advice", "review", "notes", "project", "freshman",
+		"finals", "group-work", "lab", "lecture", "tutorial", "scholarship", "exchange",
+		"hackathon", "volunteer", "lost-and-found",
+	}
+
+	subjects = []string{
+		"CS2030", "MA1521", "the data structures module", "linear algebra", "the library",
+		"hall dinner", "the career fair", "my group project", "the midterm", "recess week",
+		"the shuttle bus", "the new canteen", "orientation camp", "the capstone project",
+		"the internship portal", "finals revision", "the gym", "the hackathon",
+	}
+	titleTemplates = []string{
+		"Any tips for %s?",
+		"Thoughts on %s",
+		"Looking for help with %s",
+		"Is anyone else struggling with %s?",
+		"Guide: surviving %s",
+		"Question about %s",
+		"%s is better than expected",
+		"Where do I start with %s?",
+	}
+	sentences = []string{
+		"I have been trying to figure this out for a week now.",
+		"The lecture notes only cover the basics.",
+		"Would really appreciate any pointers from seniors.",
+		"Here is what worked for me last semester.",
+		"Office hours are on Thursday afternoons if anyone wants to join.",
+		"The tutorial questions were much harder than the examples.",
+		"I put together a summary sheet that might help.",
+		"Not sure if this is the right category for this.",
+		"Happy to share my notes if there's interest.",
+		"The deadline was moved to next Friday.",
+		"Does anyone know whether this counts towards the final grade?",
+		"Starting a study group in the library, all welcome.",
+	}
+	replies = []string{
+		"Thanks, this is really helpful!",
+		"Same problem here, following this thread.",
+		"Try the past year papers, they are very similar.",
+		"I asked the TA and they said it is fine.",
+		"Count me in for the study group.",
+		"The recorded lecture explains it better than the slides.",
+		"I disagree, it depends a lot on the tutor.",
+		"Could you share the link?",
+		"+1, would love the summary sheet too.",
+		"This saved my weekend, thank you.",
+	}
+)
@@ -0,0 +1,124 @@
+package seed
+
+import (
+	"backend/interfaces"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// batchSize keeps each INSERT well under Postgres' parameter limit
+const batchSize = 500
+
+// Summary counts the rows a Write inserted
+type Summary struct {
+	Users, Categories, Tags, Posts, PostTags, Comments int64
+}
+
+// Write stores ds in a single transaction. Users, categories and tags that
+// already exist under the same unique name are reused, and rows whose IDs are
+// already present are skipped, so writing the same dataset twice is a no-op.
+func Write(conn *gorm.DB, ds *Dataset) (Summary, error) {
+	var summary Summary
+
+	err := conn.Transaction(func(tx *gorm.DB) error {
+		users, n, err := upsertByName(tx, ds.Users, "username", func(u *interfaces.User) (*uuid.UUID, string) { return &u.ID, u.Username })
+		if err != nil {
+			return fmt.Errorf("writing users: %w", err)
+		}
+		summary.Users = n
+
+		categories, n, err := upsertByName(tx, ds.Categories, "name", func(c *interfaces.Category) (*uuid.UUID, string) { return &c.ID, c.Name })
+		if err != nil {
+			return fmt.Errorf("writing categories: %w", err)
+		}
+		summary.Categories = n
+
+		tags, n, err := upsertByName(tx, ds.Tags, "name", func(t *interfaces.Tag) (*uuid.UUID, string) { return &t.ID, t.Name })
+		if err != nil {
+			return fmt.Errorf("writing tags: %w", err)
+		}
+		summary.Tags = n
+
+		posts := make([]interfaces.Post, len(ds.Posts))
+		for i, post := range ds.Posts {
+			post.AuthorID = users[post.AuthorID]
+			post.CategoryID = categories[post.CategoryID]
+			posts[i] = post
+		}
+		if summary.Posts, err = insertNew(tx, posts); err != nil {
+			return fmt.Errorf("writing posts: %w", err)
+		}
+
+		postTags := make([]interfaces.PostTag, len(ds.PostTags))
+		for i, postTag := range ds.PostTags {
+			postTag.TagID = tags[postTag.TagID]
+			postTags[i] = postTag
+		}
+		if summary.PostTags, err = insertNew(tx, postTags); err != nil {
+			return fmt.Errorf("writing post tags: %w", err)
+		}
+
+		comments := make([]interfaces.Comment, len(ds.Comments))
+		for i, comment := range ds.Comments {
+			comment.AuthorID = users[comment.AuthorID]
+			comments[i] = comment
+		}
+		if summary.Comments, err = insertNew(tx, comments); err != nil {
+			return fmt.Errorf("writing comments: %w", err)
+		}
+
+		return nil
+	})
+
+	return summary, err
+}
+
+// upsertByName inserts rows whose unique name is not taken yet and returns a
+// map from generated IDs to the IDs actually stored
+func upsertByName[T any](tx *gorm.DB, rows []T, column string, key func(*T) (*uuid.UUID, string)) (map[uuid.UUID]uuid.UUID, int64, error) {
+	ids := make(map[uuid.UUID]uuid.UUID, len(rows))
+	if len(rows) == 0 {
+		return ids, 0, nil
+	}
+
+	names := make([]string, len(rows))
+	for i := range rows {
+		_, names[i] = key(&rows[i])
+	}
+
+	var existing []T
+	if err := tx.Where(column+" IN ?", names).Find(&existing).Error; err != nil {
+		return nil, 0, err
+	}
+	stored := make(map[string]uuid.UUID, len(existing))
+	for i := range existing {
+		id, name := key(&existing[i])
+		stored[name] = *id
+	}
+
+	var fresh []T
+	for i := range rows {
+		id, name := key(&rows[i])
+		if storedID, ok := stored[name]; ok {
+			ids[*id] = storedID
+			continue
+		}
+		ids[*id] = *id
+		fresh = append(fresh, rows[i])
+	}
+
+	n, err := insertNew(tx, fresh)
+	return ids, n, err
+}
+
+// insertNew inserts rows in batches, skipping any that already exist
+func insertNew[T any](tx *gorm.DB, rows []T) (int64, error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(rows, batchSize)
+	return result.RowsAffected, result.Error
+}
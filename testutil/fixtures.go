@@ -0,0 +1,117 @@
+package testutil
+
+import (
+	"backend/auth"
+	"backend/interfaces"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password is the password of every user created by CreateUser
+const Password = "correct-horse"
+
+// passwordHash is computed once at the cheapest cost to keep tests fast
+var passwordHash = func() string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(Password), bcrypt.MinCost)
+	if err != nil {
+		panic(err)
+	}
+	return string(hash)
+}()
+
+// CreateUser inserts a student with the given username and Password
+func (s *Server) CreateUser(t testing.TB, username string) interfaces.User {
+	t.Helper()
+	return s.createUser(t, username, interfaces.RoleStudent)
+}
+
+// CreateAdmin inserts an admin with the given username and Password
+func (s *Server) CreateAdmin(t testing.TB, username string) interfaces.User {
+	t.Helper()
+	return s.createUser(t, username, interfaces.RoleAdmin)
+}
+
+func (s *Server) createUser(t testing.TB, username, role string) interfaces.User {
+	t.Helper()
+	user := interfaces.User{
+		Username:     username,
+		Email:        fmt.Sprintf("%s@example.edu", username),
+		PasswordHash: passwordHash,
+		Role:         role,
+	}
+	s.create(t, &user)
+	return user
+}
+
+// Token returns a valid token for user without going through /login
+func (s *Server) Token(t testing.TB, user interfaces.User) string {
+	t.Helper()
+	token, err := auth.CreateToken(user.ID)
+	if err != nil {
+		t.Fatalf("creating token: %v", err)
+	}
+	return token
+}
+
+// Category returns one of the default categories by name
+func (s *Server) Category(t testing.TB, name string) interfaces.Category {
+	t.Helper()
+	var category interfaces.Category
+	if err := s.DB.First(&category, "name = ?", name).Error; err != nil {
+		t.Fatalf("finding category %q: %v", name, err)
+	}
+	return category
+}
+
+// Tag returns one of the default tags by name
+func (s *Server) Tag(t testing.TB, name string) interfaces.Tag {
+	t.Helper()
+	var tag interfaces.Tag
+	if err := s.DB.First(&tag, "name = ?", name).Error; err != nil {
+		t.Fatalf("finding tag %q: %v", name, err)
+	}
+	return tag
+}
+
+// CreatePost inserts a post by author in category
+func (s *Server) CreatePost(t testing.TB, author interfaces.User, category interfaces.Category, title string) interfaces.Post {
+	t.Helper()
+	post := interfaces.Post{
+		Title:      title,
+		Content:    "Content of " + title,
+		AuthorID:   author.ID,
+		CategoryID: category.ID,
+		CreatedAt:  time.Now(),
+	}
+	s.create(t, &post)
+	return post
+}
+
+// TagPost attaches tag to post
+func (s *Server) TagPost(t testing.TB, post interfaces.Post, tag interfaces.Tag) {
+	t.Helper()
+	s.create(t, &interfaces.PostTag{PostID: post.ID, TagID: tag.ID})
+}
+
+// CreateComment inserts a comment by author on post
+func (s *Server) CreateComment(t testing.TB, author interfaces.User, post interfaces.Post, content string) interfaces.Comment {
+	t.Helper()
+	comment := interfaces.Comment{
+		Content:   content,
+		PostID:    post.ID,
+		AuthorID:  author.ID,
+		CreatedAt: time.Now(),
+	}
+	s.create(t, &comment)
+	return comment
+}
+
+func (s *Server) create(t testing.TB, value interface{}) {
+	t.Helper()
+	if err := s.DB.Create(value).Error; err != nil {
+		t.Fatalf("creating %T: %v", value, err)
+	}
+}
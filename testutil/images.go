@@ -0,0 +1,108 @@
+package testutil
+
+import (
+	"backend/db"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Images is an in-memory db.ImageStore. Set Err to make every call fail.
+type Images struct {
+	mu      sync.Mutex
+	uploads map[string][]byte
+	created map[string]time.Time
+	Err     error
+}
+
+func NewImages() *Images {
+	return &Images{uploads: map[string][]byte{}, created: map[string]time.Time{}}
+}
+
+// URL is the address UploadImage returns for username's avatar, shaped
+// like a Cloudinary delivery URL
+func (s *Images) URL(username string) string {
+	return fmt.Sprintf("https://images.test/demo/image/upload/v1/%s.png", publicID(username))
+}
+
+// publicID is the ID username's avatar is listed under
+func publicID(username string) string {
+	return fmt.Sprintf("avatars/%s_avatar", username)
+}
+
+func (s *Images) UploadImage(ctx context.Context, file *multipart.FileHeader, username string) (string, error) {
+	if s.Err != nil {
+		return "", s.Err
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads[username] = data
+	s.created[username] = time.Now()
+	return s.URL(username), nil
+}
+
+func (s *Images) DeleteImage(ctx context.Context, username string) error {
+	if s.Err != nil {
+		return s.Err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.uploads, username)
+	delete(s.created, username)
+	return nil
+}
+
+func (s *Images) DeleteImageByID(ctx context.Context, id string) error {
+	username, hasPrefix := strings.CutPrefix(id, "avatars/")
+	username, hasSuffix := strings.CutSuffix(username, "_avatar")
+	if !hasPrefix || !hasSuffix {
+		return fmt.Errorf("no image %q", id)
+	}
+	return s.DeleteImage(ctx, username)
+}
+
+func (s *Images) ListImages(ctx context.Context) ([]db.StoredImage, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := make([]db.StoredImage, 0, len(s.uploads))
+	for username := range s.uploads {
+		stored = append(stored, db.StoredImage{PublicID: publicID(username), CreatedAt: s.created[username]})
+	}
+	return stored, nil
+}
+
+// Backdate makes username's avatar look uploaded age ago
+func (s *Images) Backdate(username string, age time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.created[username] = time.Now().Add(-age)
+}
+
+// Get returns the bytes stored for username's avatar
+func (s *Images) Get(username string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.uploads[username]
+	return data, ok
+}
@@ -0,0 +1,78 @@
+package testutil
+
+import (
+	"backend/config"
+	"backend/db"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// OnPostgres makes NewServer use a store on the Postgres database named by
+// TEST_DATABASE_URL, for tests of behaviour SQLite can only approximate,
+// such as full-text search. It has to run inside the test, which is skipped
+// when the variable is unset.
+//
+//	srv := testutil.NewServer(t, testutil.OnPostgres(t))
+func OnPostgres(t testing.TB) func(*config.Config) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	return func(cfg *config.Config) { cfg.Database.URL = dsn }
+}
+
+// NewPostgresStore opens a schema of its own in the Postgres database at dsn,
+// so tests sharing the database start empty. The schema is dropped when t
+// finishes.
+func NewPostgresStore(t testing.TB, dsn string) *gorm.DB {
+	t.Helper()
+
+	admin, err := db.Open(dsn)
+	if err != nil {
+		t.Fatalf("opening test database: %v", err)
+	}
+	admin.Logger = logger.Default.LogMode(logger.Silent)
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("creating test schema: %v", err)
+	}
+
+	conn, err := db.Open(withSearchPath(dsn, schema))
+	if err != nil {
+		t.Fatalf("opening test store: %v", err)
+	}
+	conn.Logger = logger.Default.LogMode(logger.Silent)
+
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+		if err := admin.Exec("DROP SCHEMA " + schema + " CASCADE").Error; err != nil {
+			t.Errorf("dropping test schema: %v", err)
+		}
+		if sqlDB, err := admin.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return conn
+}
+
+// withSearchPath sets the schema connections to dsn use, in either the URL
+// or the keyword form
+func withSearchPath(dsn, schema string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		query := u.Query()
+		query.Set("search_path", schema)
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	return fmt.Sprintf("%s search_path=%s", dsn, schema)
+}
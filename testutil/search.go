@@ -0,0 +1,201 @@
+package testutil
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	gosqlite "github.com/glebarez/go-sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+)
+
+// The test store stands in for the Postgres full-text search functions the
+// search query uses, so the query itself runs in tests. A tsvector is
+// encoded as "lexeme:weight " pairs, which keeps || working as
+// concatenation, and a tsquery as its lexemes, all of which must match.
+// Lexemes are lowercase words, without the stemming and stop words of the
+// english configuration.
+var (
+	registerSearch sync.Once
+	errSearch      error
+)
+
+// tsWeights are what ts_rank gives a matched lexeme by default for each
+// weight
+var tsWeights = map[string]float64{"A": 1.0, "B": 0.4, "C": 0.2, "D": 0.1}
+
+// registerSearchFunctions adds the full-text functions to the SQLite driver.
+// They apply to every connection opened afterwards, and the driver refuses a
+// name twice, so it only runs once.
+func registerSearchFunctions() error {
+	registerSearch.Do(func() {
+		for name, fn := range map[string]struct {
+			args int32
+			fn   func(args []driver.Value) (driver.Value, error)
+		}{
+			"to_tsvector":          {2, toTSVector},
+			"setweight":            {2, setWeight},
+			"websearch_to_tsquery": {2, websearchToTSQuery},
+			"match":                {2, tsMatch},
+			"ts_rank":              {2, tsRank},
+			"ts_headline":          {4, tsHeadline},
+		} {
+			call := fn.fn
+			err := gosqlite.RegisterDeterministicScalarFunction(name, fn.args, func(_ *gosqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+				return call(args)
+			})
+			if err != nil {
+				errSearch = fmt.Errorf("registering %s: %w", name, err)
+				return
+			}
+		}
+	})
+	return errSearch
+}
+
+// tsQueryTable is a tsquery the search selects from like a table, which
+// SQLite only allows for tables and subqueries
+var tsQueryTable = regexp.MustCompile(`(websearch_to_tsquery\([^()]*\)) AS (\w+)`)
+
+// searchSyntax rewrites the Postgres the search query uses: @@ becomes
+// SQLite's MATCH, which calls the match function with its operands
+// reversed, and a tsquery selected from becomes a one row subquery. The
+// query is built first so subqueries are rewritten too.
+func searchSyntax(tx *gorm.DB) {
+	if tx.Error != nil {
+		return
+	}
+	callbacks.BuildQuerySQL(tx)
+	if sql := tx.Statement.SQL.String(); strings.Contains(sql, "@@") {
+		sql = strings.ReplaceAll(sql, "@@", "MATCH")
+		sql = tsQueryTable.ReplaceAllString(sql, "(SELECT $1 AS $2) AS $2")
+		tx.Statement.SQL.Reset()
+		tx.Statement.SQL.WriteString(sql)
+	}
+}
+
+func text(v driver.Value) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// lexemes splits s into lowercase words
+func lexemes(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// tsVector parses an encoded tsvector into its lexemes and their weights
+func tsVector(v driver.Value) map[string][]string {
+	vector := map[string][]string{}
+	for _, entry := range strings.Fields(text(v)) {
+		lexeme, weight, _ := strings.Cut(entry, ":")
+		vector[lexeme] = append(vector[lexeme], weight)
+	}
+	return vector
+}
+
+// to_tsvector(config, text)
+func toTSVector(args []driver.Value) (driver.Value, error) {
+	var vector strings.Builder
+	for _, lexeme := range lexemes(text(args[1])) {
+		vector.WriteString(lexeme + ":D ")
+	}
+	return vector.String(), nil
+}
+
+// setweight(vector, weight)
+func setWeight(args []driver.Value) (driver.Value, error) {
+	var vector strings.Builder
+	for _, entry := range strings.Fields(text(args[0])) {
+		lexeme, _, _ := strings.Cut(entry, ":")
+		vector.WriteString(lexeme + ":" + text(args[1]) + " ")
+	}
+	return vector.String(), nil
+}
+
+// websearch_to_tsquery(config, text)
+func websearchToTSQuery(args []driver.Value) (driver.Value, error) {
+	return strings.Join(lexemes(text(args[1])), " "), nil
+}
+
+// match(query, vector), which is vector MATCH query
+func tsMatch(args []driver.Value) (driver.Value, error) {
+	terms := lexemes(text(args[0]))
+	vector := tsVector(args[1])
+	for _, term := range terms {
+		if _, ok := vector[term]; !ok {
+			return false, nil
+		}
+	}
+	return len(terms) > 0, nil
+}
+
+// ts_rank(vector, query) sums the weights of every matched lexeme
+func tsRank(args []driver.Value) (driver.Value, error) {
+	vector := tsVector(args[0])
+	rank := 0.0
+	for _, term := range lexemes(text(args[1])) {
+		for _, weight := range vector[term] {
+			rank += tsWeights[weight]
+		}
+	}
+	return rank, nil
+}
+
+var headlineOption = regexp.MustCompile(`(\w+)=("[^"]*"|[^,\s]*)`)
+
+// ts_headline(config, text, query, options) marks the matched words with
+// StartSel and StopSel and cuts up to MaxWords words from the first match
+func tsHeadline(args []driver.Value) (driver.Value, error) {
+	options := map[string]string{"StartSel": "<b>", "StopSel": "</b>", "MaxWords": "35"}
+	for _, option := range headlineOption.FindAllStringSubmatch(text(args[3]), -1) {
+		options[option[1]] = strings.Trim(option[2], `"`)
+	}
+	maxWords, err := strconv.Atoi(options["MaxWords"])
+	if err != nil {
+		return nil, fmt.Errorf("ts_headline MaxWords: %w", err)
+	}
+	terms := map[string]bool{}
+	for _, term := range lexemes(text(args[2])) {
+		terms[term] = true
+	}
+
+	words := strings.Fields(text(args[1]))
+	start := -1
+	for i, word := range words {
+		marked := word
+		for _, lexeme := range lexemes(word) {
+			if terms[lexeme] {
+				marked = markWord(marked, lexeme, options["StartSel"], options["StopSel"])
+			}
+		}
+		if marked != word && start < 0 {
+			start = i
+		}
+		words[i] = marked
+	}
+	start = max(0, min(start, len(words)-maxWords))
+	end := min(len(words), start+maxWords)
+	return strings.Join(words[start:end], " "), nil
+}
+
+// markWord wraps the first occurrence of lexeme in word, in any case
+func markWord(word, lexeme, startSel, stopSel string) string {
+	i := strings.Index(strings.ToLower(word), lexeme)
+	if i < 0 {
+		return word
+	}
+	return word[:i] + startSel + word[i:i+len(lexeme)] + stopSel + word[i+len(lexeme):]
+}
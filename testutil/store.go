@@ -0,0 +1,94 @@
+package testutil
+
+import (
+	"backend/db"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sqliteUUID is a SQLite expression producing a random version 4 UUID in the
+// same text form uuid.UUID writes, standing in for Postgres gen_random_uuid()
+const sqliteUUID = `(lower(hex(randomblob(4))) || '-' || lower(hex(randomblob(2))) || '-4' ||
+	substr(lower(hex(randomblob(2))), 2) || '-' || substr('89ab', 1 + (abs(random()) % 4), 1) ||
+	substr(lower(hex(randomblob(2))), 2) || '-' || lower(hex(randomblob(6))))`
+
+// NewStore opens an empty SQLite database in a temporary directory. It is
+// a stand-in for Postgres: the models migrate unchanged, GORM translates
+// unique violations the same way and the full-text search query runs on
+// rough copies of the Postgres functions. The database is closed when t
+// finishes.
+func NewStore(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	if err := registerSearchFunctions(); err != nil {
+		t.Fatalf("registering search functions: %v", err)
+	}
+	dsn := filepath.Join(t.TempDir(), "studenthub.db") + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	conn, err := gorm.Open(sqliteDialector{sqlite.Open(dsn).(*sqlite.Dialector)}, &gorm.Config{
+		TranslateError: true,
+		Logger:         logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("opening test store: %v", err)
+	}
+
+	// The models use Postgres column definitions. SQLite lacks
+	// gen_random_uuid(), only scans columns declared as datetime into
+	// time.Time and has a second precision current_timestamp, so CREATE TABLE
+	// statements are rewritten on the way in.
+	portable := strings.NewReplacer(
+		"gen_random_uuid()", sqliteUUID,
+		"timestamp with time zone", "datetime",
+		"DEFAULT current_timestamp", "DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now'))",
+	)
+	err = conn.Callback().Raw().Before("gorm:raw").Register("testutil:portable_ddl", func(tx *gorm.DB) {
+		sql := tx.Statement.SQL.String()
+		if strings.HasPrefix(sql, "CREATE TABLE") {
+			tx.Statement.SQL.Reset()
+			tx.Statement.SQL.WriteString(portable.Replace(sql))
+		}
+	})
+	if err == nil {
+		err = conn.Callback().Query().Before("gorm:query").Register("testutil:search_syntax", searchSyntax)
+	}
+	if err == nil {
+		err = conn.Callback().Row().Before("gorm:row").Register("testutil:search_syntax", searchSyntax)
+	}
+	if err != nil {
+		t.Fatalf("registering test store callback: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if sqlDB, err := conn.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return conn
+}
+
+// sqliteDialector keeps the driver error behind translated ones, as the
+// Postgres dialector in package db does. Unique violations are reported as
+// Postgres would, naming the constraint the way GORM does, so handlers can
+// tell which one failed.
+type sqliteDialector struct {
+	*sqlite.Dialector
+}
+
+func (d sqliteDialector) Translate(err error) error {
+	translated := d.Dialector.Translate(err)
+	if errors.Is(translated, gorm.ErrDuplicatedKey) {
+		// SQLite says "UNIQUE constraint failed: users.email"
+		if _, columns, ok := strings.Cut(err.Error(), "UNIQUE constraint failed: "); ok {
+			table, column, _ := strings.Cut(strings.Fields(columns)[0], ".")
+			err = &pgconn.PgError{Code: "23505", ConstraintName: "uni_" + table + "_" + strings.TrimSuffix(column, ",")}
+		}
+	}
+	return db.KeepCause(translated, err)
+}
@@ -0,0 +1,301 @@
+// Package testutil runs the real router against a throwaway store so HTTP
+// tests can exercise handlers end to end.
+//
+//	srv := testutil.NewServer(t)
+//	alice := srv.CreateUser(t, "alice")
+//	res := srv.Do(t, "GET", "/users/"+alice.ID.String(), nil, testutil.WithToken(srv.Token(t, alice)))
+//	res.ExpectStatus(t, http.StatusOK)
+//
+// Servers share the db package globals, so tests using them must not run in
+// parallel.
+package testutil
+
+import (
+	"backend/auth"
+	"backend/config"
+	"backend/db"
+	"backend/events"
+	"backend/jobs"
+	"backend/problem"
+	"backend/router"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// Server is the router wired to a test store and fake image storage
+type Server struct {
+	Handler http.Handler
+	Config  *config.Config
+	DB      *gorm.DB
+	Images  *Images
+}
+
+// NewServer builds the router from config.Default, adjusted by the given
+// functions, with a migrated store holding only the default categories and
+// tags. The store is SQLite unless the adjusted config has a database URL.
+func NewServer(t testing.TB, adjust ...func(*config.Config)) *Server {
+	t.Helper()
+
+	gin.SetMode(gin.TestMode)
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	cfg := config.Default()
+	cfg.Auth.JWTSecret = "test-secret"
+	cfg.Jobs.Backend = "memory"
+	for _, fn := range adjust {
+		fn(cfg)
+	}
+	auth.Configure(cfg.Auth)
+
+	var store *gorm.DB
+	if cfg.Database.URL != "" {
+		store = NewPostgresStore(t, cfg.Database.URL)
+	} else {
+		store = NewStore(t)
+	}
+	if err := db.Use(store, cfg); err != nil {
+		t.Fatalf("using test store: %v", err)
+	}
+	images := NewImages()
+	db.SetImageStore(images)
+	t.Cleanup(func() {
+		db.DB = nil
+		db.SetImageStore(nil)
+	})
+
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("migrating test store: %v", err)
+	}
+	if err := db.SeedDefaults(); err != nil {
+		t.Fatalf("seeding test store: %v", err)
+	}
+
+	return &Server{
+		Handler: router.New(cfg),
+		Config:  cfg,
+		DB:      store,
+		Images:  images,
+	}
+}
+
+// RunJobs runs background jobs until none is ready and returns how many
+// attempts ran. Retries only count as ready once their backoff has passed.
+func (s *Server) RunJobs(t testing.TB) int {
+	t.Helper()
+	pool := jobs.NewPool(db.Queue(), s.Config.Jobs)
+	attempts := 0
+	for {
+		ran, err := pool.RunNext(context.Background())
+		if err != nil {
+			t.Fatalf("running jobs: %v", err)
+		}
+		if !ran {
+			return attempts
+		}
+		attempts++
+	}
+}
+
+// DispatchEvents hands outbox events to their subscribers until none is
+// ready and returns how many dispatches ran
+func (s *Server) DispatchEvents(t testing.TB) int {
+	t.Helper()
+	dispatcher := events.NewDispatcher(s.DB, s.Config.Events, events.Default)
+	dispatched := 0
+	for {
+		ran, err := dispatcher.DispatchNext(context.Background())
+		if err != nil {
+			t.Fatalf("dispatching events: %v", err)
+		}
+		if !ran {
+			return dispatched
+		}
+		dispatched++
+	}
+}
+
+// Option changes a request before it is sent
+type Option func(*http.Request)
+
+// WithToken authenticates with an Authorization bearer header
+func WithToken(token string) Option {
+	return func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// WithCookie authenticates with the token cookie, as browsers do
+func WithCookie(token string) Option {
+	return func(r *http.Request) {
+		r.AddCookie(&http.Cookie{Name: "token", Value: token})
+	}
+}
+
+// WithHeader sets a request header
+func WithHeader(key, value string) Option {
+	return func(r *http.Request) {
+		r.Header.Set(key, value)
+	}
+}
+
+// WithRemoteAddr sends the request from another client address
+func WithRemoteAddr(addr string) Option {
+	return func(r *http.Request) {
+		r.RemoteAddr = addr
+	}
+}
+
+// Do sends a request through the router. A string or []byte body is sent
+// as is; anything else non-nil is encoded as JSON.
+func (s *Server) Do(t testing.TB, method, path string, body interface{}, opts ...Option) *Response {
+	t.Helper()
+
+	var reader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = bytes.NewBufferString(b)
+	case []byte:
+		reader = bytes.NewReader(b)
+	default:
+		encoded, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("encoding request body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if reader != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return s.send(req, opts)
+}
+
+// File is one part of a multipart upload
+type File struct {
+	Field       string
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// Upload sends a multipart form with the given fields and files
+func (s *Server) Upload(t testing.TB, path string, fields map[string]string, files []File, opts ...Option) *Response {
+	t.Helper()
+
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	for name, value := range fields {
+		if err := form.WriteField(name, value); err != nil {
+			t.Fatalf("writing form field: %v", err)
+		}
+	}
+	for _, file := range files {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="`+file.Field+`"; filename="`+file.Name+`"`)
+		header.Set("Content-Type", file.ContentType)
+		part, err := form.CreatePart(header)
+		if err != nil {
+			t.Fatalf("creating form file: %v", err)
+		}
+		part.Write(file.Data)
+	}
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, path, &buf)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return s.send(req, opts)
+}
+
+func (s *Server) send(req *http.Request, opts []Option) *Response {
+	for _, opt := range opts {
+		opt(req)
+	}
+	recorder := httptest.NewRecorder()
+	s.Handler.ServeHTTP(recorder, req)
+	return &Response{recorder}
+}
+
+// Response is a recorded router response
+type Response struct {
+	*httptest.ResponseRecorder
+}
+
+// ExpectStatus fails the test unless the response has the given status
+func (r *Response) ExpectStatus(t testing.TB, status int) *Response {
+	t.Helper()
+	if r.Code != status {
+		t.Fatalf("status = %d, want %d; body: %s", r.Code, status, r.Body.String())
+	}
+	return r
+}
+
+// Decode unmarshals the JSON body into v
+func (r *Response) Decode(t testing.TB, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(r.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding response %q: %v", r.Body.String(), err)
+	}
+}
+
+// Problem is the decoded form of a problem+json response
+type Problem struct {
+	Type      string `json:"type"`
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Detail    string `json:"detail"`
+	RequestID string `json:"request_id"`
+	Errors    []struct {
+		Field string `json:"field"`
+		Code  string `json:"code"`
+	} `json:"errors"`
+}
+
+// ExpectProblem fails the test unless the response is a problem with the
+// given status and code, and returns it for further checks
+func (r *Response) ExpectProblem(t testing.TB, status int, code string) Problem {
+	t.Helper()
+	r.ExpectStatus(t, status)
+	if contentType := r.Header().Get("Content-Type"); !strings.HasPrefix(contentType, problem.ContentType) {
+		t.Fatalf("Content-Type = %q, want %s", contentType, problem.ContentType)
+	}
+
+	var p Problem
+	r.Decode(t, &p)
+	if p.Code != code {
+		t.Fatalf("problem code = %q, want %q; body: %s", p.Code, code, r.Body.String())
+	}
+	return p
+}
+
+// Fields lists the invalid fields of a validation problem
+func (p Problem) Fields() []string {
+	fields := make([]string, 0, len(p.Errors))
+	for _, e := range p.Errors {
+		fields = append(fields, e.Field)
+	}
+	return fields
+}
+
+// Cookie returns the named cookie set by the response
+func (r *Response) Cookie(name string) (*http.Cookie, bool) {
+	for _, cookie := range r.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie, true
+		}
+	}
+	return nil, false
+}
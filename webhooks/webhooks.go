@@ -0,0 +1,172 @@
+// Package webhooks signs event notifications and posts them to subscriber
+// URLs. Which events go where, and retrying failed deliveries, is up to
+// the caller.
+package webhooks
+
+import (
+	"backend/config"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Headers sent with every delivery
+const (
+	SignatureHeader = "X-StudentHub-Signature"
+	EventHeader     = "X-StudentHub-Event"
+	DeliveryHeader  = "X-StudentHub-Delivery"
+)
+
+// Errors returned by Verify and Send
+var (
+	ErrBadSignature   = errors.New("webhook signature does not match")
+	ErrStaleSignature = errors.New("webhook signature is too old")
+	ErrPrivateAddress = errors.New("webhook URL resolves to a private address")
+)
+
+// NewSecret generates a random signing secret
+func NewSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// Sign returns the signature header for body sent at t:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">". Signing the
+// timestamp lets receivers reject old deliveries replayed by a third party.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac(secret, ts, body))
+}
+
+// Verify checks a signature header made by Sign, rejecting it once it is
+// more than tolerance old. It is what a receiver written in Go would run.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var ts string
+	var sig []byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			ts = value
+		case "v1":
+			sig, _ = hex.DecodeString(value)
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == nil {
+		return ErrBadSignature
+	}
+	if !hmac.Equal(sig, mac(secret, ts, body)) {
+		return ErrBadSignature
+	}
+	if now.Sub(time.Unix(unix, 0)) > tolerance {
+		return ErrStaleSignature
+	}
+	return nil
+}
+
+func mac(secret, ts string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(ts))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// Request is one delivery to send
+type Request struct {
+	URL        string
+	Secret     string
+	Event      string
+	DeliveryID uuid.UUID
+	Body       []byte
+}
+
+// Response is what the receiver answered
+type Response struct {
+	Status int
+	Body   string // The first maxResponseBody bytes
+}
+
+// maxResponseBody caps how much of a receiver's answer is kept
+const maxResponseBody = 1024
+
+// Sender posts deliveries
+type Sender struct {
+	client *http.Client
+}
+
+// NewSender creates a sender with cfg's timeout. Unless cfg allows
+// private addresses, it refuses to connect to loopback, private and
+// link-local addresses, so a webhook can't be pointed at internal services.
+func NewSender(cfg config.WebhooksConfig) *Sender {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || private(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+	return &Sender{client: &http.Client{
+		Timeout: cfg.Timeout,
+		// Proxies would connect on our behalf, past the address check
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: cfg.Timeout},
+		// A redirect could lead anywhere, so it counts as a failure
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}}
+}
+
+func private(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast()
+}
+
+// Send posts req, signed with its secret. Any answer outside 2xx is an
+// error, returned along with the response.
+func (s *Sender) Send(ctx context.Context, req Request) (Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, req.URL, bytes.NewReader(req.Body))
+	if err != nil {
+		return Response{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("User-Agent", "StudentHub-Webhooks/1.0")
+	httpReq.Header.Set(EventHeader, req.Event)
+	httpReq.Header.Set(DeliveryHeader, req.DeliveryID.String())
+	httpReq.Header.Set(SignatureHeader, Sign(req.Secret, time.Now(), req.Body))
+
+	res, err := s.client.Do(httpReq)
+	if err != nil {
+		return Response{}, err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxResponseBody))
+
+	response := Response{Status: res.StatusCode, Body: string(body)}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return response, fmt.Errorf("receiver answered %d", res.StatusCode)
+	}
+	return response, nil
+}
@@ -0,0 +1,80 @@
+package webhooks_test
+
+import (
+	"backend/config"
+	"backend/webhooks"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	body := []byte(`{"type":"post.created"}`)
+	now := time.Unix(1_800_000_000, 0)
+	header := webhooks.Sign("secret", now, body)
+
+	if err := webhooks.Verify("secret", header, body, 5*time.Minute, now.Add(time.Minute)); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	for name, tc := range map[string]struct {
+		secret, header string
+		body           []byte
+		want           error
+	}{
+		"wrong secret":      {"other", header, body, webhooks.ErrBadSignature},
+		"tampered body":     {"secret", header, []byte(`{"type":"post.deleted"}`), webhooks.ErrBadSignature},
+		"missing signature": {"secret", "t=1800000000", body, webhooks.ErrBadSignature},
+		"garbage":           {"secret", "nonsense", body, webhooks.ErrBadSignature},
+	} {
+		if err := webhooks.Verify(tc.secret, tc.header, tc.body, 5*time.Minute, now); !errors.Is(err, tc.want) {
+			t.Errorf("%s: Verify = %v, want %v", name, err, tc.want)
+		}
+	}
+	if err := webhooks.Verify("secret", header, body, 5*time.Minute, now.Add(time.Hour)); !errors.Is(err, webhooks.ErrStaleSignature) {
+		t.Errorf("hour old signature: Verify = %v, want ErrStaleSignature", err)
+	}
+}
+
+func TestSend(t *testing.T) {
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := http.StatusNoContent
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhooks.Verify(secret, r.Header.Get(webhooks.SignatureHeader), body, time.Minute, time.Now()); err != nil {
+			t.Errorf("receiver: %v", err)
+		}
+		if r.Header.Get(webhooks.EventHeader) != "post.created" {
+			t.Errorf("event header = %q", r.Header.Get(webhooks.EventHeader))
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, "noted")
+	}))
+	defer receiver.Close()
+
+	req := webhooks.Request{URL: receiver.URL, Secret: secret, Event: "post.created", DeliveryID: uuid.New(), Body: []byte(`{}`)}
+	sender := webhooks.NewSender(config.WebhooksConfig{Timeout: time.Second, AllowPrivate: true})
+	if _, err := sender.Send(context.Background(), req); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	status = http.StatusServiceUnavailable
+	res, err := sender.Send(context.Background(), req)
+	if err == nil || res.Status != http.StatusServiceUnavailable || res.Body != "noted" {
+		t.Errorf("Send to a failing receiver = %+v, %v", res, err)
+	}
+
+	// The receiver listens on loopback, which is off limits by default
+	guarded := webhooks.NewSender(config.WebhooksConfig{Timeout: time.Second})
+	if _, err := guarded.Send(context.Background(), req); !errors.Is(err, webhooks.ErrPrivateAddress) {
+		t.Errorf("Send to loopback = %v, want ErrPrivateAddress", err)
+	}
+}